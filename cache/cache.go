@@ -0,0 +1,86 @@
+// Package cache keeps encoded values for a while, in process or in Redis,
+// so that hot reads spare the database.
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// ErrMiss is returned by Cache.Get for keys holding no value.
+var ErrMiss = errors.New("cache miss")
+
+// Cache stores values under keys. Implementations are safe for concurrent
+// use.
+type Cache interface {
+	// Get returns the value stored under key, or ErrMiss.
+	Get(ctx context.Context, key string) ([]byte, error)
+	// Set stores value under key for ttl, or until evicted when ttl is
+	// zero.
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// Delete removes the value stored under key, if any.
+	Delete(ctx context.Context, key string) error
+}
+
+// Config selects and tunes the cache.
+type Config struct {
+	// URL is the address of a Redis server, such as redis://localhost:6379/0.
+	// The cache is kept in process when it is empty.
+	URL string
+	// Size bounds the number of entries of the in-process cache.
+	Size int
+	// TTL bounds the time a value is served from the cache.
+	TTL time.Duration
+	// MaxAge is the time clients and shared caches may reuse public
+	// responses without revalidating them. Zero makes them revalidate every
+	// time, which their ETag keeps cheap.
+	MaxAge time.Duration
+}
+
+var DefaultConfig = Config{
+	Size: 10000,
+	TTL:  time.Minute,
+}
+
+// ConfigFromEnv reads CACHE_URL, CACHE_SIZE, CACHE_TTL and CACHE_MAX_AGE
+// (durations), falling back to DefaultConfig for the unset ones.
+func ConfigFromEnv() (Config, error) {
+	config := DefaultConfig
+	config.URL = os.Getenv("CACHE_URL")
+	if v := os.Getenv("CACHE_SIZE"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return config, fmt.Errorf("CACHE_SIZE: %v", err)
+		}
+		config.Size = n
+	}
+	for name, d := range map[string]*time.Duration{"CACHE_TTL": &config.TTL, "CACHE_MAX_AGE": &config.MaxAge} {
+		if v := os.Getenv(name); v != "" {
+			parsed, err := time.ParseDuration(v)
+			if err != nil {
+				return config, fmt.Errorf("%s: %v", name, err)
+			}
+			*d = parsed
+		}
+	}
+	return config, nil
+}
+
+// New returns the cache selected by config: Redis when config.URL is set,
+// an in-process LRU otherwise.
+func New(config Config) (Cache, error) {
+	if config.URL == "" {
+		return NewLRUWithConfig(LRUConfig{Size: config.Size}), nil
+	}
+	opts, err := redis.ParseURL(config.URL)
+	if err != nil {
+		return nil, fmt.Errorf("CACHE_URL: %v", err)
+	}
+	return NewRedis(redis.NewClient(opts)), nil
+}
@@ -0,0 +1,162 @@
+package cache_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/sumitalp/productcatalog/cache"
+)
+
+// testCache checks the behaviour shared by the implementations; advance
+// moves their clock forward.
+func testCache(t *testing.T, c cache.Cache, advance func(time.Duration)) {
+	ctx := context.Background()
+	_, err := c.Get(ctx, "a")
+	assert.Equal(t, cache.ErrMiss, err)
+
+	require.NoError(t, c.Set(ctx, "a", []byte("1"), time.Minute))
+	require.NoError(t, c.Set(ctx, "b", []byte("2"), 0))
+	b, err := c.Get(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, "1", string(b))
+
+	require.NoError(t, c.Set(ctx, "a", []byte("3"), time.Minute))
+	b, err = c.Get(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, "3", string(b))
+
+	advance(2 * time.Minute)
+	_, err = c.Get(ctx, "a")
+	assert.Equal(t, cache.ErrMiss, err, "expired")
+	b, err = c.Get(ctx, "b")
+	require.NoError(t, err, "no ttl")
+	assert.Equal(t, "2", string(b))
+
+	require.NoError(t, c.Delete(ctx, "b"))
+	require.NoError(t, c.Delete(ctx, "b"))
+	_, err = c.Get(ctx, "b")
+	assert.Equal(t, cache.ErrMiss, err)
+}
+
+func TestLRU(t *testing.T) {
+	now := time.Now()
+	c := cache.NewLRUWithConfig(cache.LRUConfig{Size: 2, Now: func() time.Time { return now }})
+	testCache(t, c, func(d time.Duration) { now = now.Add(d) })
+
+	ctx := context.Background()
+	require.NoError(t, c.Set(ctx, "a", []byte("1"), 0))
+	require.NoError(t, c.Set(ctx, "b", []byte("2"), 0))
+	_, err := c.Get(ctx, "a")
+	require.NoError(t, err)
+	require.NoError(t, c.Set(ctx, "c", []byte("3"), 0))
+	assert.Equal(t, 2, c.Len())
+	_, err = c.Get(ctx, "b")
+	assert.Equal(t, cache.ErrMiss, err, "least recently used")
+	_, err = c.Get(ctx, "a")
+	assert.NoError(t, err)
+}
+
+func TestRedis(t *testing.T) {
+	s := miniredis.NewMiniRedis()
+	require.NoError(t, s.Start())
+	defer s.Close()
+	c := cache.NewRedis(redis.NewClient(&redis.Options{Addr: s.Addr()}))
+	defer c.Close()
+	assert.NoError(t, c.Ping(context.Background()))
+	testCache(t, c, s.FastForward)
+
+	s.Close()
+	_, err := c.Get(context.Background(), "a")
+	assert.Error(t, err)
+	assert.NotEqual(t, cache.ErrMiss, err)
+}
+
+func TestNew(t *testing.T) {
+	c, err := cache.New(cache.Config{})
+	require.NoError(t, err)
+	assert.IsType(t, &cache.LRU{}, c)
+	c, err = cache.New(cache.Config{URL: "redis://localhost:6379/1"})
+	require.NoError(t, err)
+	assert.IsType(t, &cache.Redis{}, c)
+	_, err = cache.New(cache.Config{URL: "localhost"})
+	assert.Error(t, err)
+}
+
+type value struct {
+	Name  string
+	Count int
+}
+
+func TestLoaderFetch(t *testing.T) {
+	ctx := context.Background()
+	l := cache.NewLoader(cache.NewLRU(), time.Minute)
+	var loads int32
+	load := func() (interface{}, error) {
+		atomic.AddInt32(&loads, 1)
+		return value{Name: "a", Count: 1}, nil
+	}
+
+	var v value
+	require.NoError(t, l.Fetch(ctx, "k", &v, load))
+	assert.Equal(t, value{Name: "a", Count: 1}, v)
+	v = value{}
+	require.NoError(t, l.Fetch(ctx, "k", &v, load))
+	assert.Equal(t, value{Name: "a", Count: 1}, v)
+	assert.Equal(t, int32(1), loads)
+
+	err := l.Fetch(ctx, "failing", &v, func() (interface{}, error) { return nil, errors.New("boom") })
+	assert.EqualError(t, err, "boom")
+	require.NoError(t, l.Fetch(ctx, "failing", &v, load), "failures are not cached")
+}
+
+func TestLoaderStampede(t *testing.T) {
+	l := cache.NewLoader(cache.NewLRU(), time.Minute)
+	var loads int32
+	release := make(chan struct{})
+	load := func() (interface{}, error) {
+		atomic.AddInt32(&loads, 1)
+		<-release
+		return value{Name: "a"}, nil
+	}
+
+	var wg sync.WaitGroup
+	values := make([]value, 10)
+	for i := range values {
+		wg.Add(1)
+		go func(v *value) {
+			defer wg.Done()
+			assert.NoError(t, l.Fetch(context.Background(), "k", v, load))
+		}(&values[i])
+	}
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&loads) == 1 }, time.Second, time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int32(1), loads)
+	for _, v := range values {
+		assert.Equal(t, "a", v.Name)
+	}
+}
+
+func TestLoaderBypassesFailingCache(t *testing.T) {
+	s := miniredis.NewMiniRedis()
+	require.NoError(t, s.Start())
+	c := cache.NewRedis(redis.NewClient(&redis.Options{Addr: s.Addr(), MaxRetries: -1}))
+	defer c.Close()
+	s.Close()
+
+	l := cache.NewLoader(c, time.Minute)
+	var v value
+	require.NoError(t, l.Fetch(context.Background(), "k", &v, func() (interface{}, error) {
+		return value{Name: "a"}, nil
+	}))
+	assert.Equal(t, "a", v.Name)
+}
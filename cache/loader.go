@@ -0,0 +1,80 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"time"
+
+	"github.com/labstack/gommon/log"
+	"golang.org/x/sync/singleflight"
+)
+
+// Loader reads values through a Cache, loading and storing the missing
+// ones. Concurrent reads of a missing key share a single load, so that an
+// expired hot entry does not send every request to the database at once.
+//
+// Values are gob encoded: they keep the fields hidden from JSON, and
+// callers get copies of their own.
+type Loader struct {
+	cache Cache
+	ttl   time.Duration
+	group singleflight.Group
+}
+
+func NewLoader(c Cache, ttl time.Duration) *Loader {
+	return &Loader{
+		cache: c,
+		ttl:   ttl,
+	}
+}
+
+// Fetch decodes into v, a pointer, the value stored under key, or the one
+// load returns, which it stores. A failing cache is logged and bypassed.
+func (l *Loader) Fetch(ctx context.Context, key string, v interface{}, load func() (interface{}, error)) error {
+	b, err := l.cache.Get(ctx, key)
+	if err == nil {
+		if err = decode(b, v); err == nil {
+			return nil
+		}
+	}
+	if err != ErrMiss {
+		log.Warnj(log.JSON{"message": "cache read failed", "key": key, "error": err.Error()})
+	}
+	shared, err, _ := l.group.Do(key, func() (interface{}, error) {
+		b, err := encode(load)
+		if err != nil {
+			return nil, err
+		}
+		if err := l.cache.Set(ctx, key, b, l.ttl); err != nil {
+			log.Warnj(log.JSON{"message": "cache write failed", "key": key, "error": err.Error()})
+		}
+		return b, nil
+	})
+	if err != nil && ctx.Err() == nil && (errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)) {
+		// The load shared with another caller was bound to its context,
+		// which is done; ours is not.
+		shared, err = encode(load)
+	}
+	if err != nil {
+		return err
+	}
+	return decode(shared.([]byte), v)
+}
+
+func encode(load func() (interface{}, error)) ([]byte, error) {
+	value, err := load()
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(value); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decode(b []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(b)).Decode(v)
+}
@@ -0,0 +1,110 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// LRUConfig tunes an LRU.
+type LRUConfig struct {
+	// Size bounds the number of entries; the least recently used one is
+	// evicted to make room.
+	Size int
+	Now  func() time.Time
+}
+
+var DefaultLRUConfig = LRUConfig{
+	Size: 10000,
+	Now:  time.Now,
+}
+
+// LRU is a Cache kept in process.
+type LRU struct {
+	config LRUConfig
+
+	mu      sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type lruEntry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+func NewLRU() *LRU {
+	return NewLRUWithConfig(DefaultLRUConfig)
+}
+
+func NewLRUWithConfig(config LRUConfig) *LRU {
+	if config.Size <= 0 {
+		config.Size = DefaultLRUConfig.Size
+	}
+	if config.Now == nil {
+		config.Now = DefaultLRUConfig.Now
+	}
+	return &LRU{
+		config:  config,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+func (c *LRU) Get(ctx context.Context, key string) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, ErrMiss
+	}
+	e := el.Value.(*lruEntry)
+	if !e.expires.IsZero() && !c.config.Now().Before(e.expires) {
+		c.remove(el)
+		return nil, ErrMiss
+	}
+	c.order.MoveToFront(el)
+	return e.value, nil
+}
+
+func (c *LRU) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e := &lruEntry{key: key, value: value}
+	if ttl > 0 {
+		e.expires = c.config.Now().Add(ttl)
+	}
+	if el, ok := c.entries[key]; ok {
+		el.Value = e
+		c.order.MoveToFront(el)
+		return nil
+	}
+	c.entries[key] = c.order.PushFront(e)
+	for c.order.Len() > c.config.Size {
+		c.remove(c.order.Back())
+	}
+	return nil
+}
+
+func (c *LRU) Delete(ctx context.Context, key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		c.remove(el)
+	}
+	return nil
+}
+
+// Len returns the number of entries, expired ones included.
+func (c *LRU) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+func (c *LRU) remove(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.entries, el.Value.(*lruEntry).key)
+}
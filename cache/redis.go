@@ -0,0 +1,46 @@
+package cache
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Redis is a Cache kept in a Redis server, shared by every instance of the
+// service.
+type Redis struct {
+	client redis.UniversalClient
+}
+
+func NewRedis(client redis.UniversalClient) *Redis {
+	return &Redis{
+		client: client,
+	}
+}
+
+func (c *Redis) Get(ctx context.Context, key string) ([]byte, error) {
+	b, err := c.client.Get(ctx, key).Bytes()
+	if err == redis.Nil {
+		return nil, ErrMiss
+	}
+	return b, err
+}
+
+func (c *Redis) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return c.client.Set(ctx, key, value, ttl).Err()
+}
+
+func (c *Redis) Delete(ctx context.Context, key string) error {
+	return c.client.Del(ctx, key).Err()
+}
+
+// Ping reports whether the server answers.
+func (c *Redis) Ping(ctx context.Context) error {
+	return c.client.Ping(ctx).Err()
+}
+
+// Close closes the connections to the server.
+func (c *Redis) Close() error {
+	return c.client.Close()
+}
@@ -0,0 +1,170 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/jinzhu/gorm"
+)
+
+// settingsKey stores the options a *gorm.DB was opened with so they can be
+// carried over to the context bound copies made by WithContext.
+const settingsKey = "productcatalog:settings"
+
+// contextKey stores the context a *gorm.DB was bound to by WithContext.
+const contextKey = "productcatalog:context"
+
+type settings struct {
+	observe QueryObserver
+	log     *statementLog
+}
+
+type txKey struct{}
+
+// ContextWithTx returns a copy of ctx carrying the transaction tx. Every
+// *gorm.DB derived from ctx by WithContext runs inside tx.
+func ContextWithTx(ctx context.Context, tx *gorm.DB) context.Context {
+	return context.WithValue(ctx, txKey{}, tx)
+}
+
+// TxFromContext returns the transaction carried by ctx, if any.
+func TxFromContext(ctx context.Context) (*gorm.DB, bool) {
+	if ctx == nil {
+		return nil, false
+	}
+	tx, ok := ctx.Value(txKey{}).(*gorm.DB)
+	return tx, ok
+}
+
+// InTransaction reports whether statements issued through d run inside a
+// transaction.
+func InTransaction(d *gorm.DB) bool {
+	_, ok := d.CommonDB().(interface {
+		Commit() error
+		Rollback() error
+	})
+	return ok
+}
+
+// WithContext returns a *gorm.DB whose statements run with ctx, so they are
+// interrupted once ctx is cancelled or its deadline expires. gorm v1 has no
+// notion of a context, so the returned DB wraps the underlying connection
+// or transaction instead. When ctx carries a transaction the returned DB
+// joins it rather than using d.
+func WithContext(ctx context.Context, d *gorm.DB) *gorm.DB {
+	if tx, ok := TxFromContext(ctx); ok {
+		d = tx
+	}
+	if ctx == nil {
+		return d
+	}
+	if ctx.Done() == nil {
+		// There is nothing to interrupt, but the spans of the statements
+		// still descend from the span ctx carries.
+		return d.Set(contextKey, ctx)
+	}
+	var conn gorm.SQLCommon
+	switch c := d.CommonDB().(type) {
+	case *sql.DB:
+		conn = &contextDB{db: c, ctx: ctx}
+	case *contextDB:
+		conn = &contextDB{db: c.db, ctx: ctx}
+	case *sql.Tx:
+		conn = &contextTx{tx: c, ctx: ctx}
+	case *contextTx:
+		conn = &contextTx{tx: c.tx, ctx: ctx}
+	default:
+		return d
+	}
+	cd, err := gorm.Open(d.Dialect().GetName(), conn)
+	if err != nil {
+		return d
+	}
+	s, _ := d.Get(settingsKey)
+	applySettings(cd, s)
+	cd.InstantSet(contextKey, ctx)
+	return cd
+}
+
+// Begin starts a transaction on d. Unlike d.Begin, the transaction and the
+// statements issued through it stay bound to the context d was bound to.
+func Begin(d *gorm.DB) *gorm.DB {
+	tx := d.Begin()
+	if tx.Error != nil {
+		return tx
+	}
+	if ctx, ok := d.Get(contextKey); ok {
+		return WithContext(ctx.(context.Context), tx)
+	}
+	return tx
+}
+
+// applySettings silences gorm's own logger, which Log replaces, and
+// carries the settings v over to d.
+func applySettings(d *gorm.DB, v interface{}) {
+	d.LogMode(false)
+	s, ok := v.(settings)
+	if !ok {
+		return
+	}
+	d.InstantSet(settingsKey, s)
+}
+
+type contextDB struct {
+	db  *sql.DB
+	ctx context.Context
+}
+
+func (c *contextDB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return c.db.ExecContext(c.ctx, query, args...)
+}
+
+func (c *contextDB) Prepare(query string) (*sql.Stmt, error) {
+	return c.db.PrepareContext(c.ctx, query)
+}
+
+func (c *contextDB) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return c.db.QueryContext(c.ctx, query, args...)
+}
+
+func (c *contextDB) QueryRow(query string, args ...interface{}) *sql.Row {
+	return c.db.QueryRowContext(c.ctx, query, args...)
+}
+
+func (c *contextDB) Begin() (*sql.Tx, error) {
+	return c.db.BeginTx(c.ctx, nil)
+}
+
+// BeginTx ignores ctx: gorm always passes context.Background.
+func (c *contextDB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	return c.db.BeginTx(c.ctx, opts)
+}
+
+type contextTx struct {
+	tx  *sql.Tx
+	ctx context.Context
+}
+
+func (c *contextTx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return c.tx.ExecContext(c.ctx, query, args...)
+}
+
+func (c *contextTx) Prepare(query string) (*sql.Stmt, error) {
+	return c.tx.PrepareContext(c.ctx, query)
+}
+
+func (c *contextTx) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return c.tx.QueryContext(c.ctx, query, args...)
+}
+
+func (c *contextTx) QueryRow(query string, args ...interface{}) *sql.Row {
+	return c.tx.QueryRowContext(c.ctx, query, args...)
+}
+
+func (c *contextTx) Commit() error {
+	return c.tx.Commit()
+}
+
+func (c *contextTx) Rollback() error {
+	return c.tx.Rollback()
+}
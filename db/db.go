@@ -1,46 +1,89 @@
 package db
 
 import (
+	"context"
 	"fmt"
-	"os"
+	"strings"
 
 	"github.com/jinzhu/gorm"
 	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"github.com/labstack/gommon/log"
 	"github.com/sumitalp/productcatalog/models"
 )
 
 func New() *gorm.DB {
-	db, err := gorm.Open("sqlite3", "./adcash.db")
+	db, err := Open("./adcash.db")
 	if err != nil {
-		fmt.Println("storage err: ", err)
+		log.Errorj(log.JSON{"message": "opening storage", "error": err.Error()})
 	}
-	db.DB().SetMaxIdleConns(3)
-	db.LogMode(true)
 	return db
 }
 
-func TestDB() *gorm.DB {
-	db, err := gorm.Open("sqlite3", "./../adcash_test.db")
+// Open opens the SQLite database at path. Its statements are not logged
+// until Log is called.
+func Open(path string) (*gorm.DB, error) {
+	db, err := gorm.Open("sqlite3", path)
 	if err != nil {
-		fmt.Println("storage err: ", err)
+		return db, err
 	}
 	db.DB().SetMaxIdleConns(3)
-	db.LogMode(false)
-	return db
+	applySettings(db, settings{})
+	return db, nil
 }
 
-func DropTestDB() error {
-	if err := os.Remove("./../adcash_test.db"); err != nil {
-		return err
-	}
-	return nil
+// schema lists the models stored in the database.
+var schema = []interface{}{
+	&models.User{},
+	&models.Product{},
+	&models.Category{},
+	&models.IdempotencyKey{},
+	&models.WebhookSubscription{},
+	&models.WebhookDelivery{},
+	&models.OutboxEvent{},
+	&models.UserToken{},
+	&models.RecoveryCode{},
 }
 
-//TODO: err check
+// TODO: err check
 func AutoMigrate(db *gorm.DB) {
-	db.AutoMigrate(
-		&models.User{},
-		&models.Product{},
-		&models.Category{},
-	)
+	db.AutoMigrate(schema...)
+}
+
+// Ping checks that the database answers.
+func Ping(ctx context.Context, db *gorm.DB) error {
+	return db.DB().PingContext(ctx)
+}
+
+// PendingMigrations lists the tables and columns of the models which
+// AutoMigrate has not created yet.
+func PendingMigrations(db *gorm.DB) []string {
+	var pending []string
+	dialect := db.Dialect()
+	for _, m := range schema {
+		scope := db.NewScope(m)
+		table := scope.TableName()
+		if !dialect.HasTable(table) {
+			pending = append(pending, "table "+table)
+			continue
+		}
+		for _, f := range scope.GetModelStruct().StructFields {
+			if f.IsNormal && !f.IsIgnored && !dialect.HasColumn(table, f.DBName) {
+				pending = append(pending, "column "+table+"."+f.DBName)
+			}
+			if r := f.Relationship; r != nil && r.JoinTableHandler != nil {
+				if join := r.JoinTableHandler.Table(db); !dialect.HasTable(join) {
+					pending = append(pending, "table "+join)
+				}
+			}
+		}
+	}
+	return pending
+}
+
+// CheckMigrations fails while PendingMigrations reports some.
+func CheckMigrations(db *gorm.DB) error {
+	if pending := PendingMigrations(db); len(pending) > 0 {
+		return fmt.Errorf("pending migrations: %s", strings.Join(pending, ", "))
+	}
+	return nil
 }
@@ -0,0 +1,33 @@
+package db
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPendingMigrations(t *testing.T) {
+	dir, err := ioutil.TempDir("", "productcatalog")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	d, err := Open(filepath.Join(dir, "test.db"))
+	require.NoError(t, err)
+	defer d.Close()
+
+	assert.NoError(t, Ping(context.Background(), d))
+	assert.Contains(t, PendingMigrations(d), "table users")
+	assert.Error(t, CheckMigrations(d))
+
+	AutoMigrate(d)
+	assert.Empty(t, PendingMigrations(d))
+	assert.NoError(t, CheckMigrations(d))
+
+	require.NoError(t, d.DropTable("product_categories").Error)
+	require.NoError(t, d.Exec(`ALTER TABLE "users" RENAME COLUMN "bio" TO "about"`).Error)
+	assert.EqualError(t, CheckMigrations(d), "pending migrations: column users.bio, table product_categories")
+}
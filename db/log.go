@@ -0,0 +1,83 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/labstack/gommon/log"
+	"github.com/sumitalp/productcatalog/logging"
+)
+
+// Logger is the part of the loggers of gommon/log, and of echo.Logger,
+// statements are logged to.
+type Logger interface {
+	Debugj(log.JSON)
+	Warnj(log.JSON)
+}
+
+type statementLog struct {
+	logger Logger
+	all    bool
+	slow   time.Duration
+}
+
+// Log logs the statements issued through d, and through the copies of d
+// made afterwards by WithContext and Begin, to logger: all of them at
+// debug level when config.SQL is set, and those taking config.SlowQuery or
+// longer as warnings. Lines carry the request ID of the context the DB is
+// bound to. Statements are logged without the values bound to them, and
+// with their literals replaced, so that passwords and tokens stay out of
+// the logs.
+func Log(d *gorm.DB, logger Logger, config logging.Config) {
+	v, _ := d.Get(settingsKey)
+	s, _ := v.(settings)
+	s.log = &statementLog{logger: logger, all: config.SQL, slow: config.SlowQuery}
+	applySettings(d, s)
+}
+
+func init() {
+	for _, op := range operations {
+		op.processor().After(op.statement).Register("productcatalog:log", statementLogger(op.name))
+	}
+}
+
+func statementLogger(operation string) func(*gorm.Scope) {
+	return func(scope *gorm.Scope) {
+		l := settingsOf(scope).log
+		if l == nil {
+			return
+		}
+		started, ok := scope.InstanceGet(startedKey)
+		if !ok {
+			return
+		}
+		d := time.Since(started.(time.Time))
+		slow := l.slow > 0 && d >= l.slow
+		if !slow && !l.all {
+			return
+		}
+		var ctx context.Context
+		if v, ok := scope.Get(contextKey); ok {
+			ctx = v.(context.Context)
+		}
+		fields := log.JSON{
+			"message":     "sql",
+			"operation":   operation,
+			"table":       scope.TableName(),
+			"sql":         Sanitize(scope.SQL),
+			"duration_ms": float64(d.Microseconds()) / 1000,
+			"rows":        scope.DB().RowsAffected,
+		}
+		if err := scope.DB().Error; err != nil && !gorm.IsRecordNotFoundError(err) {
+			fields["error"] = err.Error()
+		}
+		fields = logging.Fields(ctx, fields)
+		if slow {
+			fields["message"] = "slow sql"
+			l.logger.Warnj(fields)
+		} else {
+			l.logger.Debugj(fields)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package db
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/labstack/gommon/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/sumitalp/productcatalog/logging"
+	"github.com/sumitalp/productcatalog/models"
+)
+
+func lines(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	var lines []map[string]interface{}
+	s := bufio.NewScanner(buf)
+	for s.Scan() {
+		var l map[string]interface{}
+		require.NoError(t, json.Unmarshal(s.Bytes(), &l))
+		lines = append(lines, l)
+	}
+	return lines
+}
+
+func TestLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "productcatalog")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	d, err := Open(filepath.Join(dir, "test.db"))
+	require.NoError(t, err)
+	defer d.Close()
+	AutoMigrate(d)
+
+	var buf bytes.Buffer
+	logger := log.New("test")
+	logger.SetOutput(&buf)
+	logger.SetLevel(log.DEBUG)
+	Log(d, logger, logging.Config{SQL: true})
+
+	ctx, cancel := context.WithCancel(logging.WithRequestID(context.Background(), "req-1"))
+	defer cancel()
+	u := &models.User{Username: "alice", Email: "alice@email.io", Password: "hunter2"}
+	require.NoError(t, WithContext(ctx, d).Create(u).Error)
+	require.NoError(t, WithContext(ctx, d).Where("username = 'alice'").First(&models.User{}).Error)
+
+	logged := lines(t, &buf)
+	require.Len(t, logged, 2)
+	assert.Equal(t, "DEBUG", logged[0]["level"])
+	assert.Equal(t, "create", logged[0]["operation"])
+	assert.Equal(t, "users", logged[0]["table"])
+	assert.Equal(t, "req-1", logged[0]["request_id"])
+	assert.Contains(t, logged[1]["sql"], "username = ?")
+	assert.NotContains(t, buf.String(), "hunter2")
+
+	// Without SQL only the slow statements are logged, as warnings.
+	buf.Reset()
+	Log(d, logger, logging.Config{SlowQuery: time.Hour})
+	require.NoError(t, d.First(&models.User{}).Error)
+	assert.Empty(t, buf.String())
+	Log(d, logger, logging.Config{SlowQuery: time.Nanosecond})
+	require.NoError(t, d.First(&models.User{}).Error)
+	logged = lines(t, &buf)
+	require.Len(t, logged, 1)
+	assert.Equal(t, "WARN", logged[0]["level"])
+	assert.Equal(t, "slow sql", logged[0]["message"])
+}
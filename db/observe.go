@@ -0,0 +1,78 @@
+package db
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// startedKey stores the time the statement of a scope was started at.
+const startedKey = "productcatalog:started"
+
+// QueryObserver is told about every statement issued through gorm: the
+// kind of operation (create, query, update, delete or row_query), the
+// table, how long it took and the error it failed with, if any.
+type QueryObserver func(operation, table string, d time.Duration, err error)
+
+// Observe reports the statements issued through d, and through the copies
+// of d made afterwards by WithContext and Begin, to observe.
+func Observe(d *gorm.DB, observe QueryObserver) {
+	v, _ := d.Get(settingsKey)
+	s, _ := v.(settings)
+	s.observe = observe
+	applySettings(d, s)
+}
+
+// operations are the kinds of statements issued by gorm, with the
+// callbacks issuing them.
+var operations = []struct {
+	name      string
+	processor func() *gorm.CallbackProcessor
+	statement string
+}{
+	{"create", gorm.DefaultCallback.Create, "gorm:create"},
+	{"query", gorm.DefaultCallback.Query, "gorm:query"},
+	{"update", gorm.DefaultCallback.Update, "gorm:update"},
+	{"delete", gorm.DefaultCallback.Delete, "gorm:delete"},
+	{"row_query", gorm.DefaultCallback.RowQuery, "gorm:row_query"},
+}
+
+// The timing callbacks are registered on gorm's default callbacks, shared
+// by every *gorm.DB including those opened by WithContext, and do nothing
+// unless Observe or Log was called.
+func init() {
+	for _, op := range operations {
+		op.processor().Before(op.statement).Register("productcatalog:start_timer", startTimer)
+		op.processor().After(op.statement).Register("productcatalog:observe", observer(op.name))
+	}
+}
+
+func startTimer(scope *gorm.Scope) {
+	if s := settingsOf(scope); s.observe != nil || s.log != nil {
+		scope.InstanceSet(startedKey, time.Now())
+	}
+}
+
+func observer(operation string) func(*gorm.Scope) {
+	return func(scope *gorm.Scope) {
+		observe := settingsOf(scope).observe
+		if observe == nil {
+			return
+		}
+		started, ok := scope.InstanceGet(startedKey)
+		if !ok {
+			return
+		}
+		err := scope.DB().Error
+		if gorm.IsRecordNotFoundError(err) {
+			err = nil
+		}
+		observe(operation, scope.TableName(), time.Since(started.(time.Time)), err)
+	}
+}
+
+func settingsOf(scope *gorm.Scope) settings {
+	v, _ := scope.Get(settingsKey)
+	s, _ := v.(settings)
+	return s
+}
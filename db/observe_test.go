@@ -0,0 +1,51 @@
+package db
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/sumitalp/productcatalog/models"
+)
+
+func TestObserve(t *testing.T) {
+	dir, err := ioutil.TempDir("", "productcatalog")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	d, err := Open(filepath.Join(dir, "test.db"))
+	require.NoError(t, err)
+	defer d.Close()
+	AutoMigrate(d)
+
+	var mu sync.Mutex
+	var seen []string
+	Observe(d, func(operation, table string, _ time.Duration, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		assert.NoError(t, err)
+		seen = append(seen, operation+" "+table)
+	})
+
+	// Statements issued through context bound copies are observed too.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cd := WithContext(ctx, d)
+	require.NoError(t, cd.Create(&models.Category{Category: "books"}).Error)
+	var c models.Category
+	require.NoError(t, cd.Where("category = ?", "books").First(&c).Error)
+	var n int
+	require.NoError(t, d.Model(&models.Category{}).Count(&n).Error)
+	// Missing records are not failures.
+	assert.True(t, d.Where("category = ?", "music").First(&models.Category{}).RecordNotFound())
+
+	require.NotEmpty(t, seen)
+	assert.Equal(t, "create categories", seen[0])
+	assert.Contains(t, seen, "query categories")
+	assert.Contains(t, seen, "row_query categories")
+}
@@ -0,0 +1,73 @@
+package db
+
+import (
+	"context"
+	"regexp"
+
+	"github.com/jinzhu/gorm"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/sumitalp/productcatalog/db"
+
+// spanKey stores the span of the statement of a scope.
+const spanKey = "productcatalog:span"
+
+// The span callbacks are registered on gorm's default callbacks like the
+// timing ones. They trace the statements issued through a *gorm.DB bound
+// by WithContext to a context carrying a span, as children of that span.
+func init() {
+	for _, op := range operations {
+		op.processor().Before(op.statement).Register("productcatalog:start_span", startSpan(op.name))
+		op.processor().After(op.statement).Register("productcatalog:end_span", endSpan)
+	}
+}
+
+func startSpan(operation string) func(*gorm.Scope) {
+	return func(scope *gorm.Scope) {
+		v, ok := scope.Get(contextKey)
+		if !ok {
+			return
+		}
+		ctx := v.(context.Context)
+		if !trace.SpanContextFromContext(ctx).IsValid() {
+			return
+		}
+		table := scope.TableName()
+		_, span := otel.Tracer(tracerName).Start(ctx, "db."+operation+" "+table,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(
+				semconv.DBSystemSqlite,
+				semconv.DBOperationKey.String(operation),
+				semconv.DBSQLTableKey.String(table),
+			),
+		)
+		scope.InstanceSet(spanKey, span)
+	}
+}
+
+func endSpan(scope *gorm.Scope) {
+	v, ok := scope.InstanceGet(spanKey)
+	if !ok {
+		return
+	}
+	span := v.(trace.Span)
+	span.SetAttributes(semconv.DBStatementKey.String(Sanitize(scope.SQL)))
+	if err := scope.DB().Error; err != nil && !gorm.IsRecordNotFoundError(err) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+var literals = regexp.MustCompile(`'(?:[^']|'')*'|\b\d+(?:\.\d+)?\b`)
+
+// Sanitize replaces the string and number literals of the SQL statement
+// stmt by placeholders, so that traces do not leak the values written in
+// queries. The values gorm binds to placeholders are never part of stmt.
+func Sanitize(stmt string) string {
+	return literals.ReplaceAllString(stmt, "?")
+}
@@ -0,0 +1,61 @@
+package db
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/sumitalp/productcatalog/models"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestSpans(t *testing.T) {
+	dir, err := ioutil.TempDir("", "productcatalog")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	d, err := Open(filepath.Join(dir, "test.db"))
+	require.NoError(t, err)
+	defer d.Close()
+	AutoMigrate(d)
+
+	sr := tracetest.NewSpanRecorder()
+	tp := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr)))
+	defer otel.SetTracerProvider(tp)
+
+	// Statements without a span in their context are not traced.
+	require.NoError(t, d.Create(&models.Category{Category: "books"}).Error)
+	assert.Empty(t, sr.Ended())
+
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "request")
+	var c models.Category
+	require.NoError(t, WithContext(ctx, d).Where("category = 'books' AND id > 0").First(&c).Error)
+	parent.End()
+
+	spans := sr.Ended()
+	require.Len(t, spans, 2)
+	s := spans[0]
+	assert.Equal(t, "db.query categories", s.Name())
+	assert.Equal(t, parent.SpanContext().SpanID(), s.Parent().SpanID())
+	var statement string
+	for _, kv := range s.Attributes() {
+		if kv.Key == attribute.Key("db.statement") {
+			statement = kv.Value.AsString()
+		}
+	}
+	assert.Contains(t, statement, "category = ? AND id > ?")
+	assert.NotContains(t, statement, "books")
+}
+
+func TestSanitize(t *testing.T) {
+	assert.Equal(t,
+		`SELECT * FROM "t1" WHERE (name = ? AND quote = ? AND n IN (?, ?)) LIMIT ?`,
+		Sanitize(`SELECT * FROM "t1" WHERE (name = 'alice' AND quote = 'it''s' AND n IN (1, 2.5)) LIMIT 10`))
+}
@@ -0,0 +1,94 @@
+package event
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// PublisherFunc adapts a function to the Publisher interface.
+type PublisherFunc func(ctx context.Context, e *Event) error
+
+func (f PublisherFunc) Publish(ctx context.Context, e *Event) error {
+	return f(ctx, e)
+}
+
+// Bus hands events to named, in-process subscribers.
+type Bus struct {
+	mu          sync.RWMutex
+	names       []string
+	subscribers map[string]Publisher
+}
+
+func NewBus() *Bus {
+	return &Bus{
+		subscribers: make(map[string]Publisher),
+	}
+}
+
+// Subscribe registers p under name, which must be unique and stable: the
+// outbox records which subscribers handled an event by name.
+func (b *Bus) Subscribe(name string, p Publisher) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subscribers[name]; ok {
+		panic(fmt.Sprintf("event: subscriber %q registered twice", name))
+	}
+	b.names = append(b.names, name)
+	b.subscribers[name] = p
+}
+
+// Subscribers returns the names of the subscribers in registration order.
+func (b *Bus) Subscribers() []string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return append([]string(nil), b.names...)
+}
+
+// Deliver hands e to the subscriber registered under name.
+func (b *Bus) Deliver(ctx context.Context, name string, e *Event) error {
+	b.mu.RLock()
+	p, ok := b.subscribers[name]
+	b.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("event: no subscriber %q", name)
+	}
+	return p.Publish(ctx, e)
+}
+
+// Publish hands e to every subscriber, in registration order, and stops at
+// the first error.
+func (b *Bus) Publish(ctx context.Context, e *Event) error {
+	for _, name := range b.Subscribers() {
+		if err := b.Deliver(ctx, name, e); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// Unmarshal decodes a JSON encoded event, leaving its data as a
+// json.RawMessage for Decode.
+func Unmarshal(b []byte) (*Event, error) {
+	var data json.RawMessage
+	e := &Event{Data: &data}
+	if err := json.Unmarshal(b, e); err != nil {
+		return nil, err
+	}
+	e.Data = data
+	return e, nil
+}
+
+// Decode unmarshals the data of e into v. Events read back from storage
+// carry their data as JSON.
+func (e *Event) Decode(v interface{}) error {
+	raw, ok := e.Data.(json.RawMessage)
+	if !ok {
+		var err error
+		if raw, err = json.Marshal(e.Data); err != nil {
+			return err
+		}
+	}
+	return json.Unmarshal(raw, v)
+}
@@ -0,0 +1,110 @@
+// Package event defines the change events the catalog emits when products
+// and categories are written, for consumers such as webhooks.
+package event
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/sumitalp/productcatalog/models"
+)
+
+// Event types. Their names must never change, since subscribers filter on
+// them.
+const (
+	ProductCreated  = "product.created"
+	ProductUpdated  = "product.updated"
+	ProductDeleted  = "product.deleted"
+	CategoryCreated = "category.created"
+	CategoryUpdated = "category.updated"
+	CategoryDeleted = "category.deleted"
+)
+
+// Types lists every event type.
+var Types = []string{
+	ProductCreated, ProductUpdated, ProductDeleted,
+	CategoryCreated, CategoryUpdated, CategoryDeleted,
+}
+
+// Event is one change of the catalog. Data holds the state of the resource
+// after the change, or before it for deletions.
+type Event struct {
+	ID         string      `json:"id"`
+	Type       string      `json:"type"`
+	OccurredAt time.Time   `json:"occurredAt"`
+	Data       interface{} `json:"data"`
+}
+
+// New returns an event of the given type with a random id.
+func New(typ string, data interface{}) *Event {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return &Event{
+		ID:         hex.EncodeToString(b),
+		Type:       typ,
+		OccurredAt: time.Now().UTC(),
+		Data:       data,
+	}
+}
+
+// Publisher accepts the events of the catalog. Publish is called inside
+// the unit of work of the change, with its context, so publishers which
+// store events through repositories bound to ctx only keep those of
+// committed changes.
+type Publisher interface {
+	Publish(ctx context.Context, e *Event) error
+}
+
+// Product is the data of product events.
+type Product struct {
+	Slug         string    `json:"slug"`
+	Title        string    `json:"title"`
+	Description  string    `json:"description"`
+	Image        string    `json:"image"`
+	CategoryList []string  `json:"categoryList"`
+	Owner        string    `json:"owner"`
+	Version      uint      `json:"version"`
+	CreatedAt    time.Time `json:"createdAt"`
+	UpdatedAt    time.Time `json:"updatedAt"`
+}
+
+func NewProduct(p *models.Product) *Product {
+	d := &Product{
+		Slug:         p.Slug,
+		Title:        p.Title,
+		Description:  p.Description,
+		Image:        p.Image,
+		CategoryList: make([]string, 0, len(p.Categories)),
+		Owner:        p.Owner.Username,
+		Version:      p.Version,
+		CreatedAt:    p.CreatedAt,
+		UpdatedAt:    p.UpdatedAt,
+	}
+	for _, c := range p.Categories {
+		d.CategoryList = append(d.CategoryList, c.Category)
+	}
+	return d
+}
+
+// Category is the data of category events.
+type Category struct {
+	ID          uint      `json:"id"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	Version     uint      `json:"version"`
+	CreatedAt   time.Time `json:"createdAt"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+func NewCategory(c *models.Category) *Category {
+	return &Category{
+		ID:          c.ID,
+		Title:       c.Category,
+		Description: c.Description,
+		Version:     c.Version,
+		CreatedAt:   c.CreatedAt,
+		UpdatedAt:   c.UpdatedAt,
+	}
+}
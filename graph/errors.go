@@ -0,0 +1,53 @@
+package graph
+
+import (
+	"net/http"
+
+	"github.com/sumitalp/productcatalog/utils"
+)
+
+// resolverError reports a failed field with the problem code and status
+// the REST API would have answered with, in the error extensions.
+type resolverError struct {
+	problem *utils.Error
+}
+
+func newResolverError(err error, st *requestState) error {
+	if err == nil {
+		return nil
+	}
+	p := utils.NewError(err)
+	if p.Code == utils.CodeValidationFailed && st.translate != nil {
+		p = st.translate(err)
+	}
+	if p.Status >= http.StatusInternalServerError {
+		st.logError(err)
+	}
+	return &resolverError{problem: p}
+}
+
+func (e *resolverError) Error() string {
+	if e.problem.Detail != "" && e.problem.Status < http.StatusInternalServerError {
+		return e.problem.Detail
+	}
+	return e.problem.Title
+}
+
+func (e *resolverError) Unwrap() error {
+	return e.problem
+}
+
+func (e *resolverError) Extensions() map[string]interface{} {
+	ext := map[string]interface{}{
+		"code":   e.problem.Code,
+		"status": e.problem.Status,
+	}
+	if len(e.problem.Errors) > 0 {
+		ext["errors"] = e.problem.Errors
+	}
+	return ext
+}
+
+func unauthorized() error {
+	return utils.NewProblem(http.StatusUnauthorized, utils.CodeUnauthorized, "authentication required")
+}
@@ -0,0 +1,303 @@
+package graph
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/sumitalp/productcatalog/models"
+	"github.com/sumitalp/productcatalog/product"
+	"github.com/sumitalp/productcatalog/ratelimit"
+	"github.com/sumitalp/productcatalog/repository/memory"
+	"github.com/sumitalp/productcatalog/router"
+	"github.com/sumitalp/productcatalog/user"
+	"github.com/sumitalp/productcatalog/utils"
+)
+
+// countingProducts counts the batched product lookups made through it.
+type countingProducts struct {
+	product.RepositoryInterface
+	byCategory *int32
+	byOwner    *int32
+}
+
+func (r *countingProducts) WithContext(ctx context.Context) product.RepositoryInterface {
+	return &countingProducts{r.RepositoryInterface.WithContext(ctx), r.byCategory, r.byOwner}
+}
+
+func (r *countingProducts) ListByCategoryIDs(ids []uint, limit int) (map[uint][]models.Product, error) {
+	atomic.AddInt32(r.byCategory, 1)
+	return r.RepositoryInterface.ListByCategoryIDs(ids, limit)
+}
+
+func (r *countingProducts) ListByOwnerIDs(ids []uint, limit int) (map[uint][]models.Product, error) {
+	atomic.AddInt32(r.byOwner, 1)
+	return r.RepositoryInterface.ListByOwnerIDs(ids, limit)
+}
+
+type fixture struct {
+	e          *echo.Echo
+	byCategory int32
+	byOwner    int32
+}
+
+func setup(t *testing.T, options ...func(*Handler)) *fixture {
+	f := &fixture{e: router.New()}
+	s := memory.NewStore()
+	us := memory.NewUserRepository(s)
+	as := &countingProducts{memory.NewProductRepository(s), &f.byCategory, &f.byOwner}
+	tx := memory.NewUnitOfWork(s)
+
+	for _, name := range []string{"user1", "user2"} {
+		require.NoError(t, us.Create(&models.User{Username: name, Email: name + "@email.io", Password: "hash"}))
+	}
+	for _, p := range []struct {
+		slug       string
+		owner      uint
+		categories []string
+	}{
+		{"p1", 1, []string{"books"}},
+		{"p2", 2, []string{"books", "music"}},
+		{"p3", 1, []string{"music"}},
+		{"p4", 2, []string{"films"}},
+	} {
+		m := &models.Product{Slug: p.slug, Title: p.slug + " title", Description: p.slug + " description", OwnerID: p.owner}
+		for _, c := range p.categories {
+			m.Categories = append(m.Categories, models.Category{Category: c})
+		}
+		require.NoError(t, as.CreateProduct(m))
+	}
+
+	h := NewHandler(user.NewService(us, tx), product.NewService(as, tx))
+	for _, option := range options {
+		option(h)
+	}
+	h.Register(f.e.Group("/graphql"))
+	return f
+}
+
+type response struct {
+	Data   map[string]json.RawMessage `json:"data"`
+	Errors []struct {
+		Message    string                 `json:"message"`
+		Path       []interface{}          `json:"path"`
+		Extensions map[string]interface{} `json:"extensions"`
+	} `json:"errors"`
+}
+
+func (f *fixture) exec(t *testing.T, userID uint, query string, variables map[string]interface{}) response {
+	body, _ := json.Marshal(map[string]interface{}{"query": query, "variables": variables})
+	req := httptest.NewRequest(echo.POST, "/graphql", strings.NewReader(string(body)))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	if userID != 0 {
+		req.Header.Set(echo.HeaderAuthorization, "Token "+utils.GenerateJWT(userID, 0))
+	}
+	rec := httptest.NewRecorder()
+	f.e.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var res response
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+	return res
+}
+
+func TestQueryProductsNested(t *testing.T) {
+	t.Parallel()
+	f := setup(t)
+	res := f.exec(t, 0, `{
+		products(category: "books") {
+			totalCount
+			nodes { slug owner { username email } categories { title } }
+		}
+	}`, nil)
+	require.Empty(t, res.Errors)
+	assert.JSONEq(t, `{
+		"totalCount": 2,
+		"nodes": [
+			{"slug": "p2", "owner": {"username": "user2", "email": null}, "categories": [{"title": "books"}, {"title": "music"}]},
+			{"slug": "p1", "owner": {"username": "user1", "email": null}, "categories": [{"title": "books"}]}
+		]
+	}`, string(res.Data["products"]))
+
+	res = f.exec(t, 0, `{ products(owner: "user2", limit: 1) { totalCount nodes { slug } } }`, nil)
+	assert.JSONEq(t, `{"totalCount": 2, "nodes": [{"slug": "p4"}]}`, string(res.Data["products"]))
+
+	res = f.exec(t, 0, `{ products(search: "P3 TITLE") { nodes { slug } } }`, nil)
+	assert.JSONEq(t, `{"nodes": [{"slug": "p3"}]}`, string(res.Data["products"]))
+
+	res = f.exec(t, 0, `{ product(slug: "missing") { slug } }`, nil)
+	assert.Empty(t, res.Errors)
+	assert.JSONEq(t, `null`, string(res.Data["product"]))
+}
+
+func TestQueryBatchesNestedLists(t *testing.T) {
+	t.Parallel()
+	f := setup(t)
+	res := f.exec(t, 0, `{
+		categories { nodes { title products { slug owner { username products(limit: 1) { slug } } } } }
+	}`, nil)
+	require.Empty(t, res.Errors)
+	assert.JSONEq(t, `{"nodes": [
+		{"title": "films", "products": [{"slug": "p4", "owner": {"username": "user2", "products": [{"slug": "p4"}]}}]},
+		{"title": "music", "products": [
+			{"slug": "p3", "owner": {"username": "user1", "products": [{"slug": "p3"}]}},
+			{"slug": "p2", "owner": {"username": "user2", "products": [{"slug": "p4"}]}}
+		]},
+		{"title": "books", "products": [
+			{"slug": "p2", "owner": {"username": "user2", "products": [{"slug": "p4"}]}},
+			{"slug": "p1", "owner": {"username": "user1", "products": [{"slug": "p3"}]}}
+		]}
+	]}`, string(res.Data["categories"]))
+	// One lookup for the products of the three categories and one for the
+	// products of their owners.
+	assert.EqualValues(t, 1, atomic.LoadInt32(&f.byCategory))
+	assert.EqualValues(t, 1, atomic.LoadInt32(&f.byOwner))
+}
+
+func TestQueryMe(t *testing.T) {
+	t.Parallel()
+	f := setup(t)
+	res := f.exec(t, 1, `{ me { username email } }`, nil)
+	assert.JSONEq(t, `{"username": "user1", "email": "user1@email.io"}`, string(res.Data["me"]))
+
+	res = f.exec(t, 0, `{ me { username } }`, nil)
+	assert.JSONEq(t, `null`, string(res.Data["me"]))
+}
+
+func TestMutationsRequireAuthentication(t *testing.T) {
+	t.Parallel()
+	f := setup(t)
+	res := f.exec(t, 0, `mutation { createCategory(input: {title: "toys"}) { id } }`, nil)
+	if assert.Len(t, res.Errors, 1) {
+		assert.Equal(t, utils.CodeUnauthorized, res.Errors[0].Extensions["code"])
+		assert.EqualValues(t, http.StatusUnauthorized, res.Errors[0].Extensions["status"])
+	}
+}
+
+func TestProductMutations(t *testing.T) {
+	t.Parallel()
+	f := setup(t)
+	res := f.exec(t, 1, `mutation($input: CreateProductInput!) {
+		createProduct(input: $input) { slug version owner { username } categories { title } }
+	}`, map[string]interface{}{"input": map[string]interface{}{
+		"title": "New Product", "description": "d", "categories": []string{"toys"},
+	}})
+	require.Empty(t, res.Errors)
+	assert.JSONEq(t, `{"slug": "new-product", "version": 1, "owner": {"username": "user1"}, "categories": [{"title": "toys"}]}`,
+		string(res.Data["createProduct"]))
+
+	res = f.exec(t, 1, `mutation {
+		updateProduct(slug: "new-product", version: 1, input: {description: "changed"}) { slug description version categories { title } }
+	}`, nil)
+	require.Empty(t, res.Errors)
+	assert.JSONEq(t, `{"slug": "new-product", "description": "changed", "version": 2, "categories": [{"title": "toys"}]}`,
+		string(res.Data["updateProduct"]))
+
+	res = f.exec(t, 1, `mutation { updateProduct(slug: "new-product", version: 1, input: {image: "x"}) { slug } }`, nil)
+	if assert.Len(t, res.Errors, 1) {
+		assert.Equal(t, utils.CodePrecondition, res.Errors[0].Extensions["code"])
+	}
+
+	// Products of someone else are not found, as in the REST API.
+	res = f.exec(t, 2, `mutation { deleteProduct(slug: "new-product") }`, nil)
+	if assert.Len(t, res.Errors, 1) {
+		assert.Equal(t, utils.CodeNotFound, res.Errors[0].Extensions["code"])
+	}
+
+	res = f.exec(t, 1, `mutation { deleteProduct(slug: "new-product", version: 2) }`, nil)
+	require.Empty(t, res.Errors)
+	assert.JSONEq(t, `true`, string(res.Data["deleteProduct"]))
+}
+
+func TestProductMutationValidation(t *testing.T) {
+	t.Parallel()
+	f := setup(t)
+	res := f.exec(t, 1, `mutation { updateProduct(slug: "p1", input: {title: ""}) { slug } }`, nil)
+	if assert.Len(t, res.Errors, 1) {
+		ext := res.Errors[0].Extensions
+		assert.Equal(t, utils.CodeValidationFailed, ext["code"])
+		errs, _ := ext["errors"].([]interface{})
+		if assert.Len(t, errs, 1) {
+			assert.Equal(t, "title", errs[0].(map[string]interface{})["field"])
+		}
+	}
+}
+
+func TestCategoryMutations(t *testing.T) {
+	t.Parallel()
+	f := setup(t)
+	res := f.exec(t, 2, `mutation { createCategory(input: {title: "toys"}) { id title version } }`, nil)
+	require.Empty(t, res.Errors)
+	var c struct {
+		ID string `json:"id"`
+	}
+	require.NoError(t, json.Unmarshal(res.Data["createCategory"], &c))
+
+	res = f.exec(t, 2, `mutation($id: ID!) { updateCategory(id: $id, input: {description: "fun"}) { title description version } }`,
+		map[string]interface{}{"id": c.ID})
+	require.Empty(t, res.Errors)
+	assert.JSONEq(t, `{"title": "toys", "description": "fun", "version": 2}`, string(res.Data["updateCategory"]))
+
+	res = f.exec(t, 2, `mutation($id: ID!) { deleteCategory(id: $id) }`, map[string]interface{}{"id": c.ID})
+	require.Empty(t, res.Errors)
+
+	res = f.exec(t, 2, `query($id: ID!) { category(id: $id) { title } }`, map[string]interface{}{"id": c.ID})
+	assert.JSONEq(t, `null`, string(res.Data["category"]))
+}
+
+func TestMutationsRateLimited(t *testing.T) {
+	t.Parallel()
+	limiter := ratelimit.NewLimiter(ratelimit.Policy{Limit: 1, Period: time.Minute})
+	f := setup(t, func(h *Handler) { h.LimitWrites(limiter) })
+	post := func(userID uint, query string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(map[string]interface{}{"query": query})
+		req := httptest.NewRequest(echo.POST, "/graphql", strings.NewReader(string(body)))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set(echo.HeaderAuthorization, "Token "+utils.GenerateJWT(userID, 0))
+		rec := httptest.NewRecorder()
+		f.e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := post(1, `mutation { createCategory(input: {title: "toys"}) { id } }`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "0", rec.Header().Get("RateLimit-Remaining"))
+	rec = post(1, `mutation { createCategory(input: {title: "games"}) { id } }`)
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.NotEmpty(t, rec.Header().Get("Retry-After"))
+
+	// Queries are not counted, and other users have a quota of their own.
+	rec = post(1, `{ me { username } }`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, rec.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, http.StatusOK, post(2, `mutation { createCategory(input: {title: "games"}) { id } }`).Code)
+}
+
+func TestIsMutation(t *testing.T) {
+	for _, tt := range []struct {
+		query, operationName string
+		want                 bool
+	}{
+		{`{ me { username } }`, "", false},
+		{`query { me { username } }`, "", false},
+		{`mutation { deleteCategory(id: 1) }`, "", true},
+		{` # comment
+		  mutation M($id: ID!) @x { deleteCategory(id: $id) }`, "", true},
+		{`query Q { me { username } } mutation M { deleteCategory(id: 1) }`, "Q", false},
+		{`query Q { me { username } } mutation M { deleteCategory(id: 1) }`, "M", true},
+		{`query Q($t: String = "mutation {") { products(category: $t) { title } }`, "", false},
+		{`query Q { products(category: """ } mutation """) { title } }`, "", false},
+		{`fragment mutation on Product { title } { products { ...mutation } }`, "", false},
+		{`# mutation { deleteCategory(id: 1) }
+		  { me { username } }`, "", false},
+	} {
+		assert.Equal(t, tt.want, isMutation(tt.query, tt.operationName), tt.query)
+	}
+}
@@ -0,0 +1,156 @@
+package graph
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	ut "github.com/go-playground/universal-translator"
+	graphql "github.com/graph-gophers/graphql-go"
+	"github.com/labstack/echo/v4"
+	"github.com/sumitalp/productcatalog/logging"
+	"github.com/sumitalp/productcatalog/product"
+	"github.com/sumitalp/productcatalog/ratelimit"
+	"github.com/sumitalp/productcatalog/router/middleware"
+	"github.com/sumitalp/productcatalog/user"
+	"github.com/sumitalp/productcatalog/utils"
+	"gopkg.in/go-playground/validator.v9"
+)
+
+const (
+	requestTimeout = 10 * time.Second
+	maxDepth       = 8
+)
+
+type Handler struct {
+	resolver     *Resolver
+	schema       *graphql.Schema
+	writeLimiter *ratelimit.Limiter
+}
+
+func NewHandler(us *user.Service, ps *product.Service) *Handler {
+	r := &Resolver{
+		userService:    us,
+		productService: ps,
+	}
+	return &Handler{
+		resolver: r,
+		schema:   graphql.MustParseSchema(schema, r, graphql.MaxDepth(maxDepth)),
+	}
+}
+
+// LimitWrites counts the requests carrying a mutation against l, per user,
+// as the REST API counts its writes. Queries are not limited.
+func (h *Handler) LimitWrites(l *ratelimit.Limiter) {
+	h.writeLimiter = l
+}
+
+// Register serves the GraphQL endpoint on g. Authentication is optional
+// there; mutations check for it themselves.
+func (h *Handler) Register(g *echo.Group) {
+	jwtMiddleware := middleware.JWTWithConfig(middleware.JWTConfig{
+		Skipper: func(c echo.Context) bool {
+			return true
+		},
+		SigningKey: utils.JWTSecret,
+		Validator:  h.resolver.userService.TokenValid,
+	})
+	m := []echo.MiddlewareFunc{jwtMiddleware, decodeRequest}
+	if h.writeLimiter != nil {
+		m = append(m, middleware.RateLimitWithConfig(middleware.RateLimitConfig{
+			Skipper: func(c echo.Context) bool {
+				req := c.Get(requestContextKey).(*request)
+				return !isMutation(req.Query, req.OperationName)
+			},
+			Limiter: h.writeLimiter,
+			Key:     middleware.KeyByUser,
+		}))
+	}
+	g.POST("", h.Serve, append(m, middleware.Timeout(requestTimeout))...)
+}
+
+// requestContextKey stores the decoded request in the echo context.
+const requestContextKey = "graphql.request"
+
+type request struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// decodeRequest decodes the GraphQL request in the body, for the
+// middleware following it to look at.
+func decodeRequest(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var req request
+		if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+			return utils.BadRequest("malformed GraphQL request")
+		}
+		if req.Query == "" {
+			return utils.BadRequest("missing GraphQL query")
+		}
+		c.Set(requestContextKey, &req)
+		return next(c)
+	}
+}
+
+// Serve executes the GraphQL request decoded by decodeRequest. Failures of
+// single fields are reported in the errors of the response, which is
+// always sent with status 200.
+func (h *Handler) Serve(c echo.Context) error {
+	req := c.Get(requestContextKey).(*request)
+	ctx := withRequestState(c.Request().Context(), newRequestState(c, h.resolver))
+	res := h.schema.Exec(ctx, req.Query, req.OperationName, req.Variables)
+	return c.JSON(http.StatusOK, res)
+}
+
+// requestState carries what resolvers need to know about the HTTP request
+// they serve.
+type requestState struct {
+	userID    uint
+	validate  func(i interface{}) error
+	translate func(err error) *utils.Error
+	logError  func(err error)
+
+	productsByCategory *productLoader
+	productsByOwner    *productLoader
+}
+
+type translator interface {
+	Translator(acceptLanguage string) ut.Translator
+}
+
+func newRequestState(c echo.Context, r *Resolver) *requestState {
+	st := &requestState{
+		validate: c.Validate,
+		logError: func(err error) {
+			c.Logger().Errorj(logging.ErrorFields(c.Request().Context(), "graphql resolver failed", err))
+		},
+	}
+	st.productsByCategory = newProductLoader(primingFetch(st, r.productService.ListByCategories))
+	st.productsByOwner = newProductLoader(primingFetch(st, r.productService.ListByOwners))
+	st.userID, _ = c.Get("user").(uint)
+	if v, ok := c.Echo().Validator.(translator); ok {
+		trans := v.Translator(c.Request().Header.Get("Accept-Language"))
+		st.translate = func(err error) *utils.Error {
+			var ve validator.ValidationErrors
+			if !errors.As(err, &ve) {
+				return utils.NewError(err)
+			}
+			return utils.NewValidatorError(ve, trans)
+		}
+	}
+	return st
+}
+
+type requestStateKey struct{}
+
+func withRequestState(ctx context.Context, st *requestState) context.Context {
+	return context.WithValue(ctx, requestStateKey{}, st)
+}
+
+func stateFrom(ctx context.Context) *requestState {
+	return ctx.Value(requestStateKey{}).(*requestState)
+}
@@ -0,0 +1,91 @@
+package graph
+
+import (
+	"context"
+	"sync"
+
+	"github.com/sumitalp/productcatalog/models"
+)
+
+// productLoader batches the product lookups of one request, DataLoader
+// style. The resolvers returning categories or users prime the loader with
+// their ids once they complete, before the fields below them run; the
+// first lookup then fetches every key primed so far with a single call.
+// Each key is fetched at most once per limit and request.
+type productLoader struct {
+	fetch fetchFunc
+
+	mu     sync.Mutex
+	primed []uint
+	cache  map[loaderKey]*productBatch
+}
+
+type loaderKey struct {
+	id    uint
+	limit int
+}
+
+type productBatch struct {
+	done     chan struct{}
+	products map[uint][]models.Product
+	err      error
+}
+
+// fetchFunc returns the newest limit products of each of ids.
+type fetchFunc func(ctx context.Context, ids []uint, limit int) (map[uint][]models.Product, error)
+
+func newProductLoader(fetch fetchFunc) *productLoader {
+	return &productLoader{
+		fetch: fetch,
+		cache: make(map[loaderKey]*productBatch),
+	}
+}
+
+// prime announces that the products of ids are about to be loaded.
+func (l *productLoader) prime(ids ...uint) {
+	l.mu.Lock()
+	l.primed = append(l.primed, ids...)
+	l.mu.Unlock()
+}
+
+// load returns the newest limit products of id, fetching them along with
+// those of the primed keys not fetched yet for limit.
+func (l *productLoader) load(ctx context.Context, id uint, limit int) ([]models.Product, error) {
+	l.mu.Lock()
+	b, ok := l.cache[loaderKey{id, limit}]
+	if !ok {
+		b = &productBatch{done: make(chan struct{})}
+		var ids []uint
+		for _, key := range append([]uint{id}, l.primed...) {
+			if _, ok := l.cache[loaderKey{key, limit}]; !ok {
+				l.cache[loaderKey{key, limit}] = b
+				ids = append(ids, key)
+			}
+		}
+		l.mu.Unlock()
+		b.products, b.err = l.fetch(ctx, ids, limit)
+		close(b.done)
+	} else {
+		l.mu.Unlock()
+	}
+
+	select {
+	case <-b.done:
+		return b.products[id], b.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// primingFetch wraps fetch to prime the loaders of st with the owners and
+// categories of the products it returns, before the resolvers waiting on
+// them go on to ask for theirs.
+func primingFetch(st *requestState, fetch fetchFunc) fetchFunc {
+	return func(ctx context.Context, ids []uint, limit int) (map[uint][]models.Product, error) {
+		products, err := fetch(ctx, ids, limit)
+		for _, list := range products {
+			primeProducts(st, list)
+		}
+		return products, err
+	}
+}
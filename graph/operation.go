@@ -0,0 +1,101 @@
+package graph
+
+// isMutation reports whether the operation of query selected by
+// operationName is a mutation. Without a name the document must hold a
+// single operation, so any mutation in it counts. Only the top level of
+// the document is scanned: strings and comments are skipped and anything
+// nested in braces, brackets or parentheses is ignored.
+func isMutation(query, operationName string) bool {
+	var (
+		depth int
+		// definition is the keyword of the definition at the top level,
+		// empty before it starts.
+		definition string
+		// named is set once the name of the definition has been read, or
+		// it turned out not to have one.
+		named bool
+	)
+	for i := 0; i < len(query); {
+		ch := query[i]
+		switch {
+		case ch == '#':
+			for i < len(query) && query[i] != '\n' && query[i] != '\r' {
+				i++
+			}
+		case ch == '"':
+			i = skipString(query, i)
+		case ch == '{' || ch == '[' || ch == '(':
+			if depth == 0 {
+				named = true
+				// A selection set alone is a query.
+				if definition == "" {
+					definition = "query"
+				}
+			}
+			depth++
+			i++
+		case ch == '}' || ch == ']' || ch == ')':
+			depth--
+			if depth == 0 && ch == '}' {
+				definition, named = "", false
+			}
+			i++
+		case isNameStart(ch):
+			start := i
+			for i < len(query) && isNameContinue(query[i]) {
+				i++
+			}
+			if depth != 0 {
+				break
+			}
+			name := query[start:i]
+			switch {
+			case definition == "":
+				definition = name
+				if name == "mutation" && operationName == "" {
+					return true
+				}
+			case !named:
+				named = true
+				if definition == "mutation" && name == operationName {
+					return true
+				}
+			}
+		default:
+			i++
+		}
+	}
+	return false
+}
+
+// skipString returns the index following the string starting at i, a block
+// string if it opens with three quotes.
+func skipString(query string, i int) int {
+	if len(query) >= i+3 && query[i:i+3] == `"""` {
+		for i += 3; i < len(query); i++ {
+			if query[i] == '\\' && len(query) >= i+4 && query[i+1:i+4] == `"""` {
+				i += 3
+			} else if len(query) >= i+3 && query[i:i+3] == `"""` {
+				return i + 3
+			}
+		}
+		return i
+	}
+	for i++; i < len(query); i++ {
+		switch query[i] {
+		case '\\':
+			i++
+		case '"', '\n':
+			return i + 1
+		}
+	}
+	return i
+}
+
+func isNameStart(ch byte) bool {
+	return ch == '_' || ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z'
+}
+
+func isNameContinue(ch byte) bool {
+	return isNameStart(ch) || ch >= '0' && ch <= '9'
+}
@@ -0,0 +1,298 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	graphql "github.com/graph-gophers/graphql-go"
+	"github.com/sumitalp/productcatalog/models"
+	"github.com/sumitalp/productcatalog/product"
+	"github.com/sumitalp/productcatalog/user"
+	"github.com/sumitalp/productcatalog/utils"
+)
+
+// Resolver is the root resolver of the schema.
+type Resolver struct {
+	userService    *user.Service
+	productService *product.Service
+}
+
+// Queries
+
+func (r *Resolver) Product(ctx context.Context, args struct{ Slug string }) (*productResolver, error) {
+	p, err := r.productService.Get(ctx, args.Slug)
+	if errors.Is(err, utils.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, newResolverError(err, stateFrom(ctx))
+	}
+	return &productResolver{p: p}, nil
+}
+
+func (r *Resolver) Products(ctx context.Context, args struct {
+	Category *string
+	Owner    *string
+	Search   *string
+	Offset   int32
+	Limit    int32
+}) (*productConnection, error) {
+	products, count, err := r.productService.List(ctx, product.ListFilter{
+		Category: stringValue(args.Category),
+		Owner:    stringValue(args.Owner),
+		Search:   stringValue(args.Search),
+		Offset:   int(args.Offset),
+		Limit:    int(args.Limit),
+	})
+	if err != nil {
+		return nil, newResolverError(err, stateFrom(ctx))
+	}
+	return &productConnection{products: products, count: count}, nil
+}
+
+func (r *Resolver) Category(ctx context.Context, args struct{ ID graphql.ID }) (*categoryResolver, error) {
+	id, err := categoryID(args.ID)
+	if err != nil {
+		return nil, newResolverError(err, stateFrom(ctx))
+	}
+	c, err := r.productService.GetCategory(ctx, id)
+	if errors.Is(err, utils.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, newResolverError(err, stateFrom(ctx))
+	}
+	return &categoryResolver{c: c}, nil
+}
+
+func (r *Resolver) Categories(ctx context.Context, args struct {
+	Offset int32
+	Limit  int32
+}) (*categoryConnection, error) {
+	categories, count, err := r.productService.ListCategories(ctx, int(args.Offset), int(args.Limit))
+	if err != nil {
+		return nil, newResolverError(err, stateFrom(ctx))
+	}
+	return &categoryConnection{categories: categories, count: count}, nil
+}
+
+func (r *Resolver) Me(ctx context.Context) (*userResolver, error) {
+	st := stateFrom(ctx)
+	if st.userID == 0 {
+		return nil, nil
+	}
+	u, err := r.userService.Get(ctx, st.userID)
+	if err != nil {
+		return nil, newResolverError(err, st)
+	}
+	return &userResolver{u: u}, nil
+}
+
+// Mutations
+
+type createProductInput struct {
+	Title       string    `json:"title" validate:"required"`
+	Description string    `json:"description" validate:"required"`
+	Image       *string   `json:"image"`
+	Categories  *[]string `json:"categories" validate:"omitempty,dive,required"`
+}
+
+type updateProductInput struct {
+	Title       *string   `json:"title" validate:"omitempty,min=1"`
+	Description *string   `json:"description"`
+	Image       *string   `json:"image"`
+	Categories  *[]string `json:"categories" validate:"omitempty,dive,required"`
+}
+
+type createCategoryInput struct {
+	Title       string  `json:"title" validate:"required"`
+	Description *string `json:"description"`
+}
+
+type updateCategoryInput struct {
+	Title       *string `json:"title" validate:"omitempty,min=1"`
+	Description *string `json:"description"`
+}
+
+func (r *Resolver) CreateProduct(ctx context.Context, args struct{ Input createProductInput }) (*productResolver, error) {
+	st := stateFrom(ctx)
+	if st.userID == 0 {
+		return nil, newResolverError(unauthorized(), st)
+	}
+	if err := st.validate(&args.Input); err != nil {
+		return nil, newResolverError(err, st)
+	}
+	p := &models.Product{
+		Title:       args.Input.Title,
+		Description: args.Input.Description,
+		Image:       stringValue(args.Input.Image),
+		Categories:  categories(args.Input.Categories),
+	}
+	if err := r.productService.Create(ctx, st.userID, p); err != nil {
+		return nil, newResolverError(err, st)
+	}
+	return &productResolver{p: p}, nil
+}
+
+func (r *Resolver) UpdateProduct(ctx context.Context, args struct {
+	Slug    string
+	Version *int32
+	Input   updateProductInput
+}) (*productResolver, error) {
+	st := stateFrom(ctx)
+	if st.userID == 0 {
+		return nil, newResolverError(unauthorized(), st)
+	}
+	if err := st.validate(&args.Input); err != nil {
+		return nil, newResolverError(err, st)
+	}
+	p, err := r.productService.Update(ctx, st.userID, args.Slug, func(p *models.Product) error {
+		if err := checkVersion(p.ModelBase, args.Version); err != nil {
+			return err
+		}
+		in := args.Input
+		if in.Title != nil {
+			p.Title = *in.Title
+		}
+		if in.Description != nil {
+			p.Description = *in.Description
+		}
+		if in.Image != nil {
+			p.Image = *in.Image
+		}
+		if in.Categories != nil {
+			p.Categories = categories(in.Categories)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, newResolverError(err, st)
+	}
+	return &productResolver{p: p}, nil
+}
+
+func (r *Resolver) DeleteProduct(ctx context.Context, args struct {
+	Slug    string
+	Version *int32
+}) (bool, error) {
+	st := stateFrom(ctx)
+	if st.userID == 0 {
+		return false, newResolverError(unauthorized(), st)
+	}
+	err := r.productService.Delete(ctx, st.userID, args.Slug, func(p *models.Product) error {
+		return checkVersion(p.ModelBase, args.Version)
+	})
+	if err != nil {
+		return false, newResolverError(err, st)
+	}
+	return true, nil
+}
+
+func (r *Resolver) CreateCategory(ctx context.Context, args struct{ Input createCategoryInput }) (*categoryResolver, error) {
+	st := stateFrom(ctx)
+	if st.userID == 0 {
+		return nil, newResolverError(unauthorized(), st)
+	}
+	if err := st.validate(&args.Input); err != nil {
+		return nil, newResolverError(err, st)
+	}
+	c := &models.Category{
+		Category:    args.Input.Title,
+		Description: stringValue(args.Input.Description),
+	}
+	if err := r.productService.CreateCategory(ctx, c); err != nil {
+		return nil, newResolverError(err, st)
+	}
+	return &categoryResolver{c: c}, nil
+}
+
+func (r *Resolver) UpdateCategory(ctx context.Context, args struct {
+	ID      graphql.ID
+	Version *int32
+	Input   updateCategoryInput
+}) (*categoryResolver, error) {
+	st := stateFrom(ctx)
+	if st.userID == 0 {
+		return nil, newResolverError(unauthorized(), st)
+	}
+	if err := st.validate(&args.Input); err != nil {
+		return nil, newResolverError(err, st)
+	}
+	id, err := categoryID(args.ID)
+	if err != nil {
+		return nil, newResolverError(err, st)
+	}
+	c, err := r.productService.UpdateCategory(ctx, id, func(c *models.Category) error {
+		if err := checkVersion(c.ModelBase, args.Version); err != nil {
+			return err
+		}
+		if args.Input.Title != nil {
+			c.Category = *args.Input.Title
+		}
+		if args.Input.Description != nil {
+			c.Description = *args.Input.Description
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, newResolverError(err, st)
+	}
+	return &categoryResolver{c: c}, nil
+}
+
+func (r *Resolver) DeleteCategory(ctx context.Context, args struct {
+	ID      graphql.ID
+	Version *int32
+}) (bool, error) {
+	st := stateFrom(ctx)
+	if st.userID == 0 {
+		return false, newResolverError(unauthorized(), st)
+	}
+	id, err := categoryID(args.ID)
+	if err != nil {
+		return false, newResolverError(err, st)
+	}
+	err = r.productService.DeleteCategory(ctx, id, func(c *models.Category) error {
+		return checkVersion(c.ModelBase, args.Version)
+	})
+	if err != nil {
+		return false, newResolverError(err, st)
+	}
+	return true, nil
+}
+
+// checkVersion fails with utils.ErrStale when version is set and is not
+// the version of m.
+func checkVersion(m models.ModelBase, version *int32) error {
+	if version != nil && uint(*version) != m.Version {
+		return utils.ErrStale
+	}
+	return nil
+}
+
+func categoryID(id graphql.ID) (uint, error) {
+	n, err := strconv.ParseUint(string(id), 10, 32)
+	if err != nil {
+		return 0, utils.BadRequest("invalid category id")
+	}
+	return uint(n), nil
+}
+
+func categories(names *[]string) []models.Category {
+	if names == nil {
+		return nil
+	}
+	categories := make([]models.Category, 0, len(*names))
+	for _, name := range *names {
+		categories = append(categories, models.Category{Category: name})
+	}
+	return categories
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -0,0 +1,102 @@
+// Package graph serves the catalog over GraphQL. It sits next to the REST
+// handlers and goes through the same services, so the catalog rules and the
+// authorization rules are shared by both.
+package graph
+
+const schema = `
+schema {
+	query: Query
+	mutation: Mutation
+}
+
+scalar Time
+
+type Query {
+	product(slug: String!): Product
+	# Products narrowed to a category, an owner or a search query over titles
+	# and descriptions, in that order of precedence.
+	products(category: String, owner: String, search: String, offset: Int = 0, limit: Int = 20): ProductConnection!
+	category(id: ID!): Category
+	categories(offset: Int = 0, limit: Int = 20): CategoryConnection!
+	# The authenticated user.
+	me: User
+}
+
+# Every mutation requires authentication. Products can only be changed by
+# their owner. Passing version makes a change fail when the record has been
+# changed since that version was read.
+type Mutation {
+	createProduct(input: CreateProductInput!): Product!
+	updateProduct(slug: String!, version: Int, input: UpdateProductInput!): Product!
+	deleteProduct(slug: String!, version: Int): Boolean!
+	createCategory(input: CreateCategoryInput!): Category!
+	updateCategory(id: ID!, version: Int, input: UpdateCategoryInput!): Category!
+	deleteCategory(id: ID!, version: Int): Boolean!
+}
+
+type Product {
+	slug: String!
+	title: String!
+	description: String!
+	image: String!
+	version: Int!
+	createdAt: Time!
+	updatedAt: Time!
+	owner: User!
+	categories: [Category!]!
+}
+
+type Category {
+	id: ID!
+	title: String!
+	description: String!
+	version: Int!
+	createdAt: Time!
+	updatedAt: Time!
+	products(limit: Int = 20): [Product!]!
+}
+
+type User {
+	username: String!
+	# Only visible to the user themselves.
+	email: String
+	bio: String
+	image: String
+	products(limit: Int = 20): [Product!]!
+}
+
+type ProductConnection {
+	nodes: [Product!]!
+	totalCount: Int!
+}
+
+type CategoryConnection {
+	nodes: [Category!]!
+	totalCount: Int!
+}
+
+input CreateProductInput {
+	title: String!
+	description: String!
+	image: String
+	categories: [String!]
+}
+
+# Fields left out keep their value.
+input UpdateProductInput {
+	title: String
+	description: String
+	image: String
+	categories: [String!]
+}
+
+input CreateCategoryInput {
+	title: String!
+	description: String
+}
+
+input UpdateCategoryInput {
+	title: String
+	description: String
+}
+`
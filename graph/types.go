@@ -0,0 +1,185 @@
+package graph
+
+import (
+	"context"
+	"strconv"
+
+	graphql "github.com/graph-gophers/graphql-go"
+	"github.com/sumitalp/productcatalog/models"
+)
+
+type productResolver struct {
+	p *models.Product
+}
+
+func (r *productResolver) Slug() string {
+	return r.p.Slug
+}
+
+func (r *productResolver) Title() string {
+	return r.p.Title
+}
+
+func (r *productResolver) Description() string {
+	return r.p.Description
+}
+
+func (r *productResolver) Image() string {
+	return r.p.Image
+}
+
+func (r *productResolver) Version() int32 {
+	return int32(r.p.Version)
+}
+
+func (r *productResolver) CreatedAt() graphql.Time {
+	return graphql.Time{Time: r.p.CreatedAt}
+}
+
+func (r *productResolver) UpdatedAt() graphql.Time {
+	return graphql.Time{Time: r.p.UpdatedAt}
+}
+
+// Owner and Categories are loaded with the product by every repository
+// query, so they cost no extra round trip.
+func (r *productResolver) Owner() *userResolver {
+	return &userResolver{u: &r.p.Owner}
+}
+
+func (r *productResolver) Categories(ctx context.Context) []*categoryResolver {
+	primeCategories(stateFrom(ctx), r.p.Categories)
+	categories := make([]*categoryResolver, 0, len(r.p.Categories))
+	for i := range r.p.Categories {
+		categories = append(categories, &categoryResolver{c: &r.p.Categories[i]})
+	}
+	return categories
+}
+
+type categoryResolver struct {
+	c *models.Category
+}
+
+func (r *categoryResolver) ID() graphql.ID {
+	return graphql.ID(strconv.FormatUint(uint64(r.c.ID), 10))
+}
+
+func (r *categoryResolver) Title() string {
+	return r.c.Category
+}
+
+func (r *categoryResolver) Description() string {
+	return r.c.Description
+}
+
+func (r *categoryResolver) Version() int32 {
+	return int32(r.c.Version)
+}
+
+func (r *categoryResolver) CreatedAt() graphql.Time {
+	return graphql.Time{Time: r.c.CreatedAt}
+}
+
+func (r *categoryResolver) UpdatedAt() graphql.Time {
+	return graphql.Time{Time: r.c.UpdatedAt}
+}
+
+func (r *categoryResolver) Products(ctx context.Context, args struct{ Limit int32 }) ([]*productResolver, error) {
+	st := stateFrom(ctx)
+	products, err := st.productsByCategory.load(ctx, r.c.ID, int(args.Limit))
+	if err != nil {
+		return nil, newResolverError(err, st)
+	}
+	return productResolvers(products), nil
+}
+
+type userResolver struct {
+	u *models.User
+}
+
+func (r *userResolver) Username() string {
+	return r.u.Username
+}
+
+func (r *userResolver) Email(ctx context.Context) *string {
+	if stateFrom(ctx).userID != r.u.ID {
+		return nil
+	}
+	return &r.u.Email
+}
+
+func (r *userResolver) Bio() *string {
+	return r.u.Bio
+}
+
+func (r *userResolver) Image() *string {
+	return r.u.Image
+}
+
+func (r *userResolver) Products(ctx context.Context, args struct{ Limit int32 }) ([]*productResolver, error) {
+	st := stateFrom(ctx)
+	products, err := st.productsByOwner.load(ctx, r.u.ID, int(args.Limit))
+	if err != nil {
+		return nil, newResolverError(err, st)
+	}
+	return productResolvers(products), nil
+}
+
+type productConnection struct {
+	products []models.Product
+	count    int
+}
+
+func (r *productConnection) Nodes(ctx context.Context) []*productResolver {
+	primeProducts(stateFrom(ctx), r.products)
+	return productResolvers(r.products)
+}
+
+func (r *productConnection) TotalCount() int32 {
+	return int32(r.count)
+}
+
+type categoryConnection struct {
+	categories []models.Category
+	count      int
+}
+
+func (r *categoryConnection) Nodes(ctx context.Context) []*categoryResolver {
+	primeCategories(stateFrom(ctx), r.categories)
+	categories := make([]*categoryResolver, 0, len(r.categories))
+	for i := range r.categories {
+		categories = append(categories, &categoryResolver{c: &r.categories[i]})
+	}
+	return categories
+}
+
+func (r *categoryConnection) TotalCount() int32 {
+	return int32(r.count)
+}
+
+func productResolvers(products []models.Product) []*productResolver {
+	resolvers := make([]*productResolver, 0, len(products))
+	for i := range products {
+		resolvers = append(resolvers, &productResolver{p: &products[i]})
+	}
+	return resolvers
+}
+
+// primeProducts primes the loaders with the owners and categories of
+// products, whose own products are likely to be asked next for all of them.
+func primeProducts(st *requestState, products []models.Product) {
+	owners := make([]uint, 0, len(products))
+	for i := range products {
+		owners = append(owners, products[i].OwnerID)
+		primeCategories(st, products[i].Categories)
+	}
+	st.productsByOwner.prime(owners...)
+}
+
+// primeCategories primes the loader of the products of categories.
+func primeCategories(st *requestState, categories []models.Category) {
+	ids := make([]uint, 0, len(categories))
+	for _, c := range categories {
+		ids = append(ids, c.ID)
+	}
+	st.productsByCategory.prime(ids...)
+}
@@ -0,0 +1,203 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sumitalp/productcatalog/router/middleware"
+	"github.com/sumitalp/productcatalog/utils"
+)
+
+// batchRequestHeaders are passed on from a batch request to each of its
+// operations, unless the operation sets them itself.
+var batchRequestHeaders = []string{
+	echo.HeaderAuthorization,
+	echo.HeaderAccept,
+	"Accept-Language",
+}
+
+// batchResponseHeaders are reported with the result of each operation.
+var batchResponseHeaders = []string{
+	echo.HeaderContentType,
+	echo.HeaderLocation,
+	"Content-Language",
+	HeaderETag,
+	middleware.HeaderIdempotentReplayed,
+	middleware.HeaderRateLimitLimit,
+	middleware.HeaderRateLimitRemaining,
+	middleware.HeaderRateLimitReset,
+	middleware.HeaderRateLimitPolicy,
+	middleware.HeaderRetryAfter,
+}
+
+// errBatchAborted rolls back a transactional batch after an operation
+// failed.
+var errBatchAborted = errors.New("batch operation failed")
+
+// Batch runs a list of operations through the router, each as if it had
+// been sent on its own with the credentials of the batch request, and
+// reports their statuses and bodies in order.
+//
+// In transactional mode the operations share one unit of work: the first
+// failing operation (any status of 400 or above) rolls back the ones before
+// it and the remaining ones are not run. Operations may carry their own
+// Idempotency-Key, which is then only kept if the batch commits.
+func (h *Handler) Batch(c echo.Context) error {
+	req := &batchRequest{}
+	if err := req.bind(c); err != nil {
+		return err
+	}
+	for i, op := range req.Operations {
+		target := strings.SplitN(op.Path, "?", 2)[0]
+		if path.Clean(target) == c.Path() {
+			return utils.BadRequest(fmt.Sprintf("operations[%d]: batches cannot be nested", i))
+		}
+	}
+
+	res := &batchResponse{
+		Transactional: req.Transactional,
+		Results:       make([]*batchResult, len(req.Operations)),
+	}
+	ctx := c.Request().Context()
+	if !req.Transactional {
+		for i, op := range req.Operations {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			res.Results[i] = dispatch(c, ctx, op)
+		}
+		return c.JSON(http.StatusOK, res)
+	}
+
+	err := h.unitOfWork.Do(ctx, func(ctx context.Context) error {
+		for i, op := range req.Operations {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			res.Results[i] = dispatch(c, ctx, op)
+			if res.Results[i].Status >= http.StatusBadRequest {
+				return errBatchAborted
+			}
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errBatchAborted) {
+		return err
+	}
+	committed := err == nil
+	res.Committed = &committed
+	for i, r := range res.Results {
+		if r == nil {
+			res.Results[i] = skippedResult()
+		}
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+// dispatch serves op through the router of c with ctx as request context.
+func dispatch(c echo.Context, ctx context.Context, op *batchOperation) *batchResult {
+	var body io.Reader
+	if len(op.Body) > 0 {
+		body = bytes.NewReader(op.Body)
+	}
+	r, err := http.NewRequest(op.Method, op.Path, body)
+	if err != nil {
+		return problemResult(utils.BadRequest(err.Error()))
+	}
+	r = r.WithContext(ctx)
+	r.RemoteAddr = c.Request().RemoteAddr
+	for _, k := range batchRequestHeaders {
+		if v := c.Request().Header.Get(k); v != "" {
+			r.Header.Set(k, v)
+		}
+	}
+	if body != nil {
+		r.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	}
+	for k, v := range op.Headers {
+		r.Header.Set(k, v)
+	}
+	// Operations are rate limited by the address of the batch request,
+	// which they must not override.
+	for _, k := range []string{echo.HeaderXForwardedFor, echo.HeaderXRealIP} {
+		r.Header.Del(k)
+		if v, ok := c.Request().Header[k]; ok {
+			r.Header[k] = v
+		}
+	}
+
+	w := &batchResponseWriter{header: make(http.Header)}
+	c.Echo().ServeHTTP(w, r)
+
+	res := &batchResult{Status: w.status, Body: rawBody(w.body.Bytes())}
+	if res.Status == 0 {
+		res.Status = http.StatusOK
+	}
+	for _, k := range batchResponseHeaders {
+		if v := w.header.Get(k); v != "" {
+			if res.Headers == nil {
+				res.Headers = make(map[string]string)
+			}
+			res.Headers[k] = v
+		}
+	}
+	return res
+}
+
+func skippedResult() *batchResult {
+	return problemResult(utils.NewProblem(http.StatusFailedDependency, utils.CodeFailedDependency,
+		"not run because an earlier operation failed"))
+}
+
+func problemResult(p *utils.Error) *batchResult {
+	b, _ := json.Marshal(p)
+	return &batchResult{
+		Status:  p.Status,
+		Headers: map[string]string{echo.HeaderContentType: utils.MIMEApplicationProblemJSON},
+		Body:    b,
+	}
+}
+
+// rawBody embeds a JSON response body as is and any other one as a string.
+func rawBody(b []byte) json.RawMessage {
+	if len(b) == 0 {
+		return nil
+	}
+	if json.Valid(b) {
+		return bytes.TrimSpace(b)
+	}
+	s, _ := json.Marshal(string(b))
+	return s
+}
+
+// batchResponseWriter buffers the response to one batch operation.
+type batchResponseWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *batchResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *batchResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *batchResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(b)
+}
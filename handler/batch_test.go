@@ -0,0 +1,158 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/sumitalp/productcatalog/router/middleware"
+	"github.com/sumitalp/productcatalog/utils"
+)
+
+// serveBatch sends a batch request through the routes of h as user1.
+func serveBatch(t *testing.T, h *Handler, e *echo.Echo, reqJSON string) (*httptest.ResponseRecorder, batchResponse) {
+	h.Register(e.Group("/api"))
+	req := httptest.NewRequest(echo.POST, "/api/batch", strings.NewReader(reqJSON))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set(echo.HeaderAuthorization, authHeader(utils.GenerateJWT(1, 0)))
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	var res batchResponse
+	if rec.Code == http.StatusOK {
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+	}
+	return rec, res
+}
+
+func statuses(res batchResponse) []int {
+	s := make([]int, 0, len(res.Results))
+	for _, r := range res.Results {
+		s = append(s, r.Status)
+	}
+	return s
+}
+
+func TestBatchCaseSuccess(t *testing.T) {
+	t.Parallel()
+	h, e := setup(t)
+	rec, res := serveBatch(t, h, e, `{"operations":[
+		{"method":"POST","path":"/api/products","body":{"product":{"title":"batch product","description":"d","categoryList":["category1"]}}},
+		{"method":"GET","path":"/api/products/batch-product"},
+		{"method":"GET","path":"/api/products/missing"},
+		{"method":"PATCH","path":"/api/categories/1","headers":{"Content-Type":"application/merge-patch+json"},"body":{"category":{"description":"patched"}}}
+	]}`)
+	if assert.Equal(t, http.StatusOK, rec.Code) {
+		assert.False(t, res.Transactional)
+		assert.Nil(t, res.Committed)
+		assert.Equal(t, []int{http.StatusCreated, http.StatusOK, http.StatusNotFound, http.StatusOK}, statuses(res))
+		assert.Contains(t, string(res.Results[1].Body), `"slug":"batch-product"`)
+		assert.NotEmpty(t, res.Results[1].Headers[HeaderETag])
+		assert.Equal(t, utils.MIMEApplicationProblemJSON, res.Results[2].Headers[echo.HeaderContentType])
+		assert.Contains(t, string(res.Results[3].Body), `"description":"patched"`)
+	}
+}
+
+func TestBatchCaseTransactionalCommit(t *testing.T) {
+	t.Parallel()
+	h, e := setup(t)
+	rec, res := serveBatch(t, h, e, `{"transactional":true,"operations":[
+		{"method":"POST","path":"/api/categories","body":{"category":{"title":"category3"}}},
+		{"method":"POST","path":"/api/products","body":{"product":{"title":"batch product","description":"d","categoryList":["category3"]}}}
+	]}`)
+	if assert.Equal(t, http.StatusOK, rec.Code) {
+		assert.True(t, res.Transactional)
+		if assert.NotNil(t, res.Committed) {
+			assert.True(t, *res.Committed)
+		}
+		assert.Equal(t, []int{http.StatusCreated, http.StatusCreated}, statuses(res))
+	}
+	assert.Equal(t, http.StatusOK, getProduct(t, h, e, "batch-product", "").Code)
+}
+
+func TestBatchCaseTransactionalRollback(t *testing.T) {
+	t.Parallel()
+	h, e := setup(t)
+	rec, res := serveBatch(t, h, e, `{"transactional":true,"operations":[
+		{"method":"POST","path":"/api/products","body":{"product":{"title":"batch product","description":"d"}}},
+		{"method":"DELETE","path":"/api/products/missing"},
+		{"method":"DELETE","path":"/api/products/product1-slug"}
+	]}`)
+	if assert.Equal(t, http.StatusOK, rec.Code) {
+		if assert.NotNil(t, res.Committed) {
+			assert.False(t, *res.Committed)
+		}
+		assert.Equal(t, []int{http.StatusCreated, http.StatusNotFound, http.StatusFailedDependency}, statuses(res))
+		assert.Contains(t, string(res.Results[2].Body), utils.CodeFailedDependency)
+	}
+
+	req := httptest.NewRequest(echo.GET, "/api/products/batch-product", nil)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Equal(t, http.StatusOK, getProduct(t, h, e, "product1-slug", "").Code)
+}
+
+// TestBatchCaseTransactionalIdempotencyKeys runs on SQLite, where claiming
+// keys outside the transaction of the batch would wait on its write lock.
+func TestBatchCaseTransactionalIdempotencyKeys(t *testing.T) {
+	t.Parallel()
+	h, e := setupSQLite(t)
+	start := time.Now()
+	rec, res := serveBatch(t, h, e, `{"transactional":true,"operations":[
+		{"method":"POST","path":"/api/categories","headers":{"Idempotency-Key":"k1"},"body":{"category":{"title":"category3"}}},
+		{"method":"POST","path":"/api/products","headers":{"Idempotency-Key":"k2"},"body":{"product":{"title":"batch product","description":"d"}}}
+	]}`)
+	if assert.Equal(t, http.StatusOK, rec.Code) {
+		if assert.NotNil(t, res.Committed) {
+			assert.True(t, *res.Committed)
+		}
+		assert.Equal(t, []int{http.StatusCreated, http.StatusCreated}, statuses(res))
+	}
+	assert.Less(t, int64(time.Since(start)), int64(time.Second))
+
+	// The keys committed with the batch, while those of a rolled back
+	// batch are gone with it.
+	_, res = serveBatch(t, h, e, `{"transactional":true,"operations":[
+		{"method":"POST","path":"/api/categories","headers":{"Idempotency-Key":"k1"},"body":{"category":{"title":"category3"}}},
+		{"method":"POST","path":"/api/categories","headers":{"Idempotency-Key":"k3"},"body":{"category":{"title":"category4"}}},
+		{"method":"DELETE","path":"/api/products/missing"}
+	]}`)
+	assert.Equal(t, []int{http.StatusCreated, http.StatusCreated, http.StatusNotFound}, statuses(res))
+	assert.Equal(t, "true", res.Results[0].Headers[middleware.HeaderIdempotentReplayed])
+	assert.Empty(t, res.Results[1].Headers[middleware.HeaderIdempotentReplayed])
+
+	_, res = serveBatch(t, h, e, `{"operations":[
+		{"method":"POST","path":"/api/categories","headers":{"Idempotency-Key":"k3"},"body":{"category":{"title":"category4"}}}
+	]}`)
+	if assert.Equal(t, []int{http.StatusCreated}, statuses(res)) {
+		assert.Empty(t, res.Results[0].Headers[middleware.HeaderIdempotentReplayed])
+	}
+}
+
+func TestBatchCaseInvalid(t *testing.T) {
+	t.Parallel()
+	h, e := setup(t)
+	rec, _ := serveBatch(t, h, e, `{"operations":[{"method":"POST","path":"/api/batch/"}]}`)
+	if assert.Equal(t, http.StatusBadRequest, rec.Code) {
+		assert.Equal(t, utils.CodeBadRequest, problemResponse(t, rec).Code)
+	}
+
+	_, e = setup(t)
+	rec, _ = serveBatch(t, h, e, `{"operations":[{"method":"TRACE","path":"api/products"}]}`)
+	if assert.Equal(t, http.StatusUnprocessableEntity, rec.Code) {
+		p := problemResponse(t, rec)
+		if assert.Len(t, p.Errors, 2) {
+			assert.Equal(t, "operations[0].method", p.Errors[0].Field)
+			assert.Equal(t, "operations[0].path", p.Errors[1].Field)
+		}
+	}
+
+	_, e = setup(t)
+	rec, _ = serveBatch(t, h, e, `{"operations":[]}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+}
@@ -0,0 +1,119 @@
+package handler
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sumitalp/productcatalog/models"
+	"github.com/sumitalp/productcatalog/utils"
+)
+
+const (
+	HeaderETag        = "ETag"
+	HeaderIfMatch     = "If-Match"
+	HeaderIfNoneMatch = "If-None-Match"
+
+	HeaderCacheControl = "Cache-Control"
+)
+
+// versionETag identifies one version of a stored record. The id is part of
+// the tag so a record recreated under the same URL gets a new one.
+func versionETag(m models.ModelBase) string {
+	return fmt.Sprintf(`"%d-%d"`, m.ID, m.Version)
+}
+
+// productETag is versionETag for a product, extended with a digest of the
+// categories and owner embedded in its representation, which change
+// without touching the product row.
+func productETag(p *models.Product) string {
+	h := sha1.New()
+	for _, c := range p.Categories {
+		fmt.Fprintf(h, "c%d-%d;", c.ID, c.Version)
+	}
+	fmt.Fprintf(h, "u%d-%d", p.Owner.ID, p.Owner.UpdatedAt.UnixNano())
+	return fmt.Sprintf(`"%d-%d-%s"`, p.ID, p.Version, hex.EncodeToString(h.Sum(nil)[:8]))
+}
+
+// checkIfMatch enforces the If-Match precondition of a write against the
+// current ETag of the resource. Without the header the write is allowed
+// unless the handler requires it.
+func (h *Handler) checkIfMatch(c echo.Context, etag string) error {
+	header := c.Request().Header.Get(HeaderIfMatch)
+	if header == "" {
+		if h.requireIfMatch {
+			return utils.NewProblem(http.StatusPreconditionRequired, utils.CodePreconditionReq, "updates require an If-Match header")
+		}
+		return nil
+	}
+	if !etagMatches(header, etag, false) {
+		return utils.ErrStale
+	}
+	return nil
+}
+
+// etagMatches reports whether etag is listed in an If-Match or
+// If-None-Match header value. Weak comparison ignores the W/ prefix.
+func etagMatches(header, etag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if weak {
+			candidate = strings.TrimPrefix(candidate, "W/")
+			etag = strings.TrimPrefix(etag, "W/")
+		}
+		if candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonWithETag writes v as JSON tagged with etag, or an empty 304 response
+// when the client already holds that representation.
+func jsonWithETag(c echo.Context, status int, v interface{}, etag string) error {
+	c.Response().Header().Set(HeaderETag, etag)
+	if etagMatches(c.Request().Header.Get(HeaderIfNoneMatch), etag, true) {
+		return c.NoContent(http.StatusNotModified)
+	}
+	return c.JSON(status, v)
+}
+
+// jsonWithContentETag is jsonWithETag for responses without a version of
+// their own, such as listings: the tag is derived from the body.
+func jsonWithContentETag(c echo.Context, status int, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	sum := sha1.Sum(b)
+	etag := `W/"` + hex.EncodeToString(sum[:]) + `"`
+	c.Response().Header().Set(HeaderETag, etag)
+	if etagMatches(c.Request().Header.Get(HeaderIfNoneMatch), etag, true) {
+		return c.NoContent(http.StatusNotModified)
+	}
+	return c.JSONBlob(status, b)
+}
+
+// setCacheControl lets clients and shared caches reuse the response to an
+// anonymous read for h.cacheMaxAge, revalidating it with its ETag after.
+// Responses to authenticated requests are kept out of shared caches.
+func (h *Handler) setCacheControl(c echo.Context) {
+	header := c.Response().Header()
+	header.Add(echo.HeaderVary, echo.HeaderAuthorization)
+	switch {
+	case c.Request().Header.Get(echo.HeaderAuthorization) != "":
+		header.Set(HeaderCacheControl, "private, no-cache")
+	case h.cacheMaxAge < time.Second:
+		header.Set(HeaderCacheControl, "public, no-cache")
+	default:
+		header.Set(HeaderCacheControl, fmt.Sprintf("public, max-age=%d", int(h.cacheMaxAge/time.Second)))
+	}
+}
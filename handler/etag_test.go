@@ -0,0 +1,259 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/sumitalp/productcatalog/router/middleware"
+	"github.com/sumitalp/productcatalog/utils"
+)
+
+func getProduct(t *testing.T, h *Handler, e *echo.Echo, slug, ifNoneMatch string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(echo.GET, "/api/products/"+slug, nil)
+	if ifNoneMatch != "" {
+		req.Header.Set(HeaderIfNoneMatch, ifNoneMatch)
+	}
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetPath("/api/products/:slug")
+	c.SetParamNames("slug")
+	c.SetParamValues(slug)
+	assert.NoError(t, h.GetProduct(c))
+	return rec
+}
+
+func updateProduct(h *Handler, e *echo.Echo, slug, reqJSON, ifMatch string) (*httptest.ResponseRecorder, echo.Context, error) {
+	req := httptest.NewRequest(echo.PUT, "/api/products/"+slug, strings.NewReader(reqJSON))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set(echo.HeaderAuthorization, authHeader(utils.GenerateJWT(1, 0)))
+	if ifMatch != "" {
+		req.Header.Set(HeaderIfMatch, ifMatch)
+	}
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetPath("/api/products/:slug")
+	c.SetParamNames("slug")
+	c.SetParamValues(slug)
+	err := middleware.JWT(utils.JWTSecret)(func(echo.Context) error {
+		return h.UpdateProduct(c)
+	})(c)
+	return rec, c, err
+}
+
+func TestGetProductETag(t *testing.T) {
+	t.Parallel()
+	h, e := setup(t)
+	rec := getProduct(t, h, e, "product1-slug", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	etag := rec.Header().Get(HeaderETag)
+	assert.NotEmpty(t, etag)
+
+	rec = getProduct(t, h, e, "product1-slug", etag)
+	assert.Equal(t, http.StatusNotModified, rec.Code)
+	assert.Empty(t, rec.Body.Bytes())
+	assert.Equal(t, etag, rec.Header().Get(HeaderETag))
+
+	rec = getProduct(t, h, e, "product1-slug", `"0-0"`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestGetProductETagCoversCategories(t *testing.T) {
+	t.Parallel()
+	h, e := setup(t)
+	etag := getProduct(t, h, e, "product1-slug", "").Header().Get(HeaderETag)
+
+	rec := patch(e, h.PatchCategory, MIMEApplicationMergePatchJSON,
+		`{"category":{"title":"renamed"}}`, "id", "1")
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = getProduct(t, h, e, "product1-slug", etag)
+	if assert.Equal(t, http.StatusOK, rec.Code) {
+		assert.NotEqual(t, etag, rec.Header().Get(HeaderETag))
+		assert.Contains(t, responseMap(rec.Body.Bytes(), "product")["categoryList"], "renamed")
+	}
+}
+
+func TestListProductsNotModified(t *testing.T) {
+	t.Parallel()
+	h, e := setup(t)
+	list := func(ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(echo.GET, "/api/products", nil)
+		if ifNoneMatch != "" {
+			req.Header.Set(HeaderIfNoneMatch, ifNoneMatch)
+		}
+		rec := httptest.NewRecorder()
+		assert.NoError(t, h.Products(e.NewContext(req, rec)))
+		return rec
+	}
+	rec := list("")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	etag := rec.Header().Get(HeaderETag)
+	assert.True(t, strings.HasPrefix(etag, `W/"`))
+
+	rec = list(etag)
+	assert.Equal(t, http.StatusNotModified, rec.Code)
+	assert.Empty(t, rec.Body.Bytes())
+
+	_, _, err := updateProduct(h, e, "product1-slug", `{"product":{"description":"changed"}}`, "")
+	assert.NoError(t, err)
+	rec = list(etag)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.NotEqual(t, etag, rec.Header().Get(HeaderETag))
+}
+
+func TestUpdateProductIfMatch(t *testing.T) {
+	t.Parallel()
+	h, e := setup(t)
+	etag := getProduct(t, h, e, "product1-slug", "").Header().Get(HeaderETag)
+
+	rec, _, err := updateProduct(h, e, "product1-slug", `{"product":{"description":"first"}}`, etag)
+	assert.NoError(t, err)
+	if assert.Equal(t, http.StatusOK, rec.Code) {
+		assert.NotEqual(t, etag, rec.Header().Get(HeaderETag))
+	}
+
+	// The update renamed the slug after the title.
+	rec, c, err := updateProduct(h, e, "product1-title", `{"product":{"description":"second"}}`, etag)
+	if assert.Error(t, err) {
+		e.HTTPErrorHandler(err, c)
+	}
+	if assert.Equal(t, http.StatusPreconditionFailed, rec.Code) {
+		p := problemResponse(t, rec)
+		assert.Equal(t, utils.CodePrecondition, p.Code)
+	}
+	rec = getProduct(t, h, e, "product1-title", "")
+	assert.Equal(t, "first", responseMap(rec.Body.Bytes(), "product")["description"])
+}
+
+func TestUpdateProductIfMatchRequired(t *testing.T) {
+	t.Parallel()
+	h, e := setup(t)
+	h.RequireIfMatch(true)
+
+	rec, c, err := updateProduct(h, e, "product1-slug", `{"product":{"description":"changed"}}`, "")
+	if assert.Error(t, err) {
+		e.HTTPErrorHandler(err, c)
+	}
+	if assert.Equal(t, http.StatusPreconditionRequired, rec.Code) {
+		p := problemResponse(t, rec)
+		assert.Equal(t, utils.CodePreconditionReq, p.Code)
+	}
+
+	rec, _, err = updateProduct(h, e, "product1-slug", `{"product":{"description":"changed"}}`, "*")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestDeleteProductIfMatchStale(t *testing.T) {
+	t.Parallel()
+	h, e := setup(t)
+	req := httptest.NewRequest(echo.DELETE, "/api/products/product1-slug", nil)
+	req.Header.Set(echo.HeaderAuthorization, authHeader(utils.GenerateJWT(1, 0)))
+	req.Header.Set(HeaderIfMatch, `"1-99"`)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetPath("/api/products/:slug")
+	c.SetParamNames("slug")
+	c.SetParamValues("product1-slug")
+	err := middleware.JWT(utils.JWTSecret)(func(echo.Context) error {
+		return h.DeleteProduct(c)
+	})(c)
+	if assert.Error(t, err) {
+		e.HTTPErrorHandler(err, c)
+	}
+	assert.Equal(t, http.StatusPreconditionFailed, rec.Code)
+	assert.Equal(t, http.StatusOK, getProduct(t, h, e, "product1-slug", "").Code)
+}
+
+func TestGetCategoryETag(t *testing.T) {
+	t.Parallel()
+	h, e := setup(t)
+	get := func(ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(echo.GET, "/api/categories/1", nil)
+		if ifNoneMatch != "" {
+			req.Header.Set(HeaderIfNoneMatch, ifNoneMatch)
+		}
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetPath("/api/categories/:id")
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+		assert.NoError(t, h.GetCategory(c))
+		return rec
+	}
+	rec := get("")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, `"1-1"`, rec.Header().Get(HeaderETag))
+	assert.Equal(t, http.StatusNotModified, get(`W/"1-1"`).Code)
+}
+
+func TestCacheControl(t *testing.T) {
+	t.Parallel()
+	h, e := setup(t)
+	rec := getProduct(t, h, e, "product1-slug", "")
+	assert.Equal(t, "public, no-cache", rec.Header().Get(HeaderCacheControl))
+	assert.Equal(t, echo.HeaderAuthorization, rec.Header().Get(echo.HeaderVary))
+
+	h.CacheMaxAge(90 * time.Second)
+	rec = getProduct(t, h, e, "product1-slug", rec.Header().Get(HeaderETag))
+	assert.Equal(t, http.StatusNotModified, rec.Code)
+	assert.Equal(t, "public, max-age=90", rec.Header().Get(HeaderCacheControl))
+
+	req := httptest.NewRequest(echo.GET, "/api/products", nil)
+	req.Header.Set(echo.HeaderAuthorization, authHeader(utils.GenerateJWT(1, 0)))
+	rec = httptest.NewRecorder()
+	assert.NoError(t, h.Products(e.NewContext(req, rec)))
+	assert.Equal(t, "private, no-cache", rec.Header().Get(HeaderCacheControl))
+}
+
+func TestEtagMatches(t *testing.T) {
+	assert.True(t, etagMatches(`"a", "b"`, `"b"`, false))
+	assert.True(t, etagMatches(`*`, `"b"`, false))
+	assert.False(t, etagMatches(`W/"b"`, `"b"`, false))
+	assert.True(t, etagMatches(`W/"b"`, `"b"`, true))
+	assert.False(t, etagMatches(``, `"b"`, true))
+}
+
+func TestProductETagOnSQLite(t *testing.T) {
+	t.Parallel()
+	h, e := setupSQLite(t)
+	h.Register(e.Group("/api"))
+	send := func(method, path, body, ifMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		if method == echo.PATCH {
+			req.Header.Set(echo.HeaderContentType, MIMEApplicationMergePatchJSON)
+		}
+		req.Header.Set(echo.HeaderAuthorization, authHeader(utils.GenerateJWT(1, 0)))
+		if ifMatch != "" {
+			req.Header.Set(HeaderIfMatch, ifMatch)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := send(echo.POST, "/api/products", `{"product":{"title":"Fresh","description":"d","categoryList":["category1","new"]}}`, "")
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	created := rec.Header().Get(HeaderETag)
+	rec = send(echo.GET, "/api/products/fresh", "", "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	etag := rec.Header().Get(HeaderETag)
+	assert.Equal(t, etag, created, "the created product is tagged as it reads")
+
+	rec = send(echo.PUT, "/api/products/fresh", `{"product":{"description":"changed"}}`, etag)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	updated := rec.Header().Get(HeaderETag)
+	assert.NotEqual(t, etag, updated)
+	assert.Equal(t, updated, send(echo.GET, "/api/products/fresh", "", "").Header().Get(HeaderETag))
+	rec = send(echo.PATCH, "/api/products/fresh", `{"product":{"description":"again"}}`, updated)
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	rec = send(echo.PUT, "/api/products/fresh", `{"product":{"description":"stale"}}`, etag)
+	assert.Equal(t, http.StatusPreconditionFailed, rec.Code)
+}
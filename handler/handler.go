@@ -1,18 +1,64 @@
 package handler
 
 import (
+	"time"
+
+	"github.com/sumitalp/productcatalog/idempotency"
 	"github.com/sumitalp/productcatalog/product"
+	"github.com/sumitalp/productcatalog/ratelimit"
+	"github.com/sumitalp/productcatalog/stream"
+	"github.com/sumitalp/productcatalog/uow"
 	"github.com/sumitalp/productcatalog/user"
+	"github.com/sumitalp/productcatalog/webhook"
 )
 
 type Handler struct {
-	userStore    user.RepositoryInterface
-	productStore product.RepositoryInterface
+	userService     *user.Service
+	productService  *product.Service
+	webhookService  *webhook.Service
+	streamBroker    *stream.Broker
+	idempotencyKeys idempotency.RepositoryInterface
+	unitOfWork      uow.UnitOfWork
+	requireIfMatch  bool
+	cacheMaxAge     time.Duration
+	rateLimits      ratelimit.Config
+	writeLimiter    *ratelimit.Limiter
 }
 
-func NewHandler(ur user.RepositoryInterface, pr product.RepositoryInterface) *Handler {
+func NewHandler(us *user.Service, ps *product.Service, ws *webhook.Service, sb *stream.Broker, is idempotency.RepositoryInterface, tx uow.UnitOfWork) *Handler {
 	return &Handler{
-		userStore:    ur,
-		productStore: pr,
+		userService:     us,
+		productService:  ps,
+		webhookService:  ws,
+		streamBroker:    sb,
+		idempotencyKeys: is,
+		unitOfWork:      tx,
+		rateLimits:      ratelimit.DefaultConfig,
 	}
 }
+
+// RequireIfMatch makes updates and deletions of products and categories
+// fail with 428 Precondition Required unless they carry an If-Match header.
+func (h *Handler) RequireIfMatch(require bool) {
+	h.requireIfMatch = require
+}
+
+// CacheMaxAge lets clients and shared caches reuse the anonymous reads of
+// products and categories for d without revalidating them. They revalidate
+// every time by default.
+func (h *Handler) CacheMaxAge(d time.Duration) {
+	h.cacheMaxAge = d
+}
+
+// RateLimit replaces the policies limiting logins, sign ups and writes,
+// applied by Register.
+func (h *Handler) RateLimit(config ratelimit.Config) {
+	h.rateLimits = config
+}
+
+// LimitWrites counts the writes against l rather than a limiter of their
+// own built from the write policy, so that they share their quota with the
+// writes of the other APIs given l.
+func (h *Handler) LimitWrites(l *ratelimit.Limiter) {
+	h.writeLimiter = l
+}
@@ -1,57 +1,80 @@
 package handler
 
 import (
-	"log"
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"testing"
 
-	"encoding/json"
-
-	"github.com/jinzhu/gorm"
-	_ "github.com/jinzhu/gorm/dialects/sqlite"
 	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
 	"github.com/sumitalp/productcatalog/db"
+	"github.com/sumitalp/productcatalog/event"
 	"github.com/sumitalp/productcatalog/models"
 	"github.com/sumitalp/productcatalog/product"
 	"github.com/sumitalp/productcatalog/repository"
+	"github.com/sumitalp/productcatalog/repository/memory"
 	"github.com/sumitalp/productcatalog/router"
+	"github.com/sumitalp/productcatalog/stream"
 	"github.com/sumitalp/productcatalog/user"
+	"github.com/sumitalp/productcatalog/utils"
+	"github.com/sumitalp/productcatalog/webhook"
 )
 
-var (
-	d  *gorm.DB
-	us user.RepositoryInterface
-	as product.RepositoryInterface
-	h  *Handler
-	e  *echo.Echo
-)
-
-func TestMain(m *testing.M) {
-	setup()
-	code := m.Run()
-	tearDown()
-	os.Exit(code)
-}
-
 func authHeader(token string) string {
 	return "Token " + token
 }
 
-func setup() {
-	d = db.TestDB()
-	db.AutoMigrate(d)
-	us = repository.NewUserRepository(d)
-	as = repository.NewProductRepository(d)
-	h = NewHandler(us, as)
-	e = router.New()
-	loadFixtures()
+// setup returns a handler backed by its own in-memory store loaded with
+// the fixtures, so tests can run in parallel.
+func setup(t *testing.T) (*Handler, *echo.Echo) {
+	s := memory.NewStore()
+	us := memory.NewUserRepository(s)
+	as := memory.NewProductRepository(s)
+	tx := memory.NewUnitOfWork(s)
+	if err := loadFixtures(us, as); err != nil {
+		t.Fatal(err)
+	}
+	is := memory.NewIdempotencyRepository(s)
+	ps := product.NewService(as, tx)
+	// The webhook receivers of the tests listen on the loopback interface.
+	ws := webhook.NewServiceWithConfig(memory.NewWebhookRepository(s), tx, webhook.Config{AllowPrivateNetworks: true})
+	sb := stream.NewBroker()
+	// Events are published synchronously, without an outbox.
+	bus := event.NewBus()
+	bus.Subscribe("webhooks", ws)
+	bus.Subscribe("stream", sb)
+	ps.PublishTo(bus)
+	return NewHandler(user.NewService(us, tx), ps, ws, sb, is, tx), router.New()
 }
 
-func tearDown() {
-	_ = d.Close()
-	if err := db.DropTestDB(); err != nil {
-		log.Fatal(err)
+// setupSQLite is setup backed by the gorm repositories on a fresh SQLite
+// database, for the tests depending on its transactions and locking.
+func setupSQLite(t *testing.T) (*Handler, *echo.Echo) {
+	dir, err := ioutil.TempDir("", "productcatalog")
+	if err != nil {
+		t.Fatal(err)
 	}
+	d, err := db.Open(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		d.Close()
+		os.RemoveAll(dir)
+	})
+	db.AutoMigrate(d)
+	us := repository.NewUserRepository(d)
+	as := repository.NewProductRepository(d)
+	tx := repository.NewUnitOfWork(d)
+	if err := loadFixtures(us, as); err != nil {
+		t.Fatal(err)
+	}
+	ps := product.NewService(as, tx)
+	ws := webhook.NewService(repository.NewWebhookRepository(d), tx)
+	return NewHandler(user.NewService(us, tx), ps, ws, stream.NewBroker(), repository.NewIdempotencyRepository(d), tx), router.New()
 }
 
 func responseMap(b []byte, key string) map[string]interface{} {
@@ -60,7 +83,14 @@ func responseMap(b []byte, key string) map[string]interface{} {
 	return m[key].(map[string]interface{})
 }
 
-func loadFixtures() error {
+func problemResponse(t *testing.T, rec *httptest.ResponseRecorder) utils.Error {
+	assert.Equal(t, utils.MIMEApplicationProblemJSON, rec.Header().Get(echo.HeaderContentType))
+	var p utils.Error
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &p))
+	return p
+}
+
+func loadFixtures(us user.RepositoryInterface, as product.RepositoryInterface) error {
 	u1bio := "user1 bio"
 	u1image := "http://realworld.io/user1.jpg"
 	u1 := models.User{
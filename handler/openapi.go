@@ -0,0 +1,349 @@
+package handler
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sumitalp/productcatalog/openapi"
+	"github.com/sumitalp/productcatalog/router/middleware"
+	"github.com/sumitalp/productcatalog/utils"
+)
+
+// route documents one of the routes of Register. The OpenAPI document is
+// built from this table and a test checks that it lists every route.
+type route struct {
+	method string
+	// path is relative to the group given to Register, in echo syntax.
+	path    string
+	id      string
+	tag     string
+	summary string
+
+	auth    bool
+	query   []*openapi.Parameter
+	headers []*openapi.Parameter
+	// body is the request document; patch routes accept merge patches and
+	// JSON patches of it instead.
+	body  interface{}
+	patch bool
+
+	status   int
+	response interface{}
+	// other documents the other successful responses, by status.
+	other map[int]interface{}
+	// contentType of the response, JSON by default.
+	contentType string
+
+	// etag routes answer with an ETag and honour If-None-Match, ifMatch
+	// routes honour If-Match and idempotent ones Idempotency-Key.
+	// cacheable routes answer with Cache-Control, and rateLimited ones
+	// with the RateLimit-* headers.
+	etag        bool
+	ifMatch     bool
+	idempotent  bool
+	cacheable   bool
+	rateLimited bool
+}
+
+// jsonPatchOperation is an RFC 6902 operation, as accepted by patch routes.
+type jsonPatchOperation struct {
+	Op    string      `json:"op" validate:"required,oneof=add remove replace move copy test"`
+	Path  string      `json:"path" validate:"required"`
+	From  string      `json:"from,omitempty"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+var pageParameters = []*openapi.Parameter{
+	{Name: "offset", In: "query", Schema: &openapi.Schema{Type: "integer", Minimum: new(float64)}},
+	{Name: "limit", In: "query", Description: "Defaults to 20.", Schema: &openapi.Schema{Type: "integer", Minimum: new(float64)}},
+}
+
+var routes = []route{
+	{method: echo.POST, path: "/users", id: "signUp", tag: "users", summary: "Register a user",
+		body: userRegisterRequest{}, status: http.StatusCreated, response: userResponse{}, rateLimited: true},
+	{method: echo.POST, path: "/users/login", id: "login", tag: "users", summary: "Log in and get a token, or a challenge for a code",
+		body: userLoginRequest{}, status: http.StatusOK, response: userResponse{}, other: map[int]interface{}{http.StatusAccepted: loginChallengeResponse{}},
+		rateLimited: true},
+	{method: echo.POST, path: "/users/login/2fa", id: "loginTwoFactor", tag: "users", summary: "Answer a login challenge with a code and get a token",
+		body: userLoginTwoFactorRequest{}, status: http.StatusOK, response: userResponse{}, rateLimited: true},
+	{method: echo.POST, path: "/users/2fa", id: "enrollRequiredTwoFactor", tag: "users", summary: "Get a TOTP secret, for users who must enable two-factor authentication to log in",
+		body: userLoginRequest{}, status: http.StatusOK, response: twoFactorEnrollmentResponse{}, rateLimited: true},
+	{method: echo.POST, path: "/users/2fa/enable", id: "enableRequiredTwoFactor", tag: "users", summary: "Enable two-factor authentication, for users who must to log in",
+		body: userEnableRequiredTwoFactorRequest{}, status: http.StatusOK, response: recoveryCodesResponse{}, rateLimited: true},
+	{method: echo.POST, path: "/users/password/forgot", id: "forgotPassword", tag: "users", summary: "Mail a password reset link, if the account exists",
+		body: userForgotPasswordRequest{}, status: http.StatusAccepted, response: resultResponse{}, idempotent: true, rateLimited: true},
+	{method: echo.POST, path: "/users/password/reset", id: "resetPassword", tag: "users", summary: "Set a new password with a mailed token",
+		body: userResetPasswordRequest{}, status: http.StatusOK, response: resultResponse{}, idempotent: true, rateLimited: true},
+	{method: echo.POST, path: "/users/verify", id: "verifyEmail", tag: "users", summary: "Verify an email address with a mailed token",
+		body: userVerifyEmailRequest{}, status: http.StatusOK, response: resultResponse{}, idempotent: true, rateLimited: true},
+	{method: echo.GET, path: "/user", id: "getCurrentUser", tag: "users", summary: "Get the current user",
+		auth: true, status: http.StatusOK, response: userResponse{}},
+	{method: echo.PUT, path: "/user", id: "updateCurrentUser", tag: "users", summary: "Replace the current user",
+		auth: true, body: userUpdateRequest{}, status: http.StatusOK, response: userResponse{}, rateLimited: true},
+	{method: echo.PATCH, path: "/user", id: "patchCurrentUser", tag: "users", summary: "Patch the current user",
+		auth: true, body: userUpdateRequest{}, patch: true, status: http.StatusOK, response: userResponse{}, rateLimited: true},
+	{method: echo.POST, path: "/user/verification", id: "resendVerification", tag: "users", summary: "Mail a new link to verify the email address",
+		auth: true, status: http.StatusAccepted, response: resultResponse{}, idempotent: true, rateLimited: true},
+	{method: echo.POST, path: "/user/2fa", id: "enrollTwoFactor", tag: "users", summary: "Get a new TOTP secret for an authenticator app",
+		auth: true, status: http.StatusOK, response: twoFactorEnrollmentResponse{}, rateLimited: true},
+	{method: echo.POST, path: "/user/2fa/enable", id: "enableTwoFactor", tag: "users", summary: "Enable two-factor authentication with a code of the app",
+		auth: true, body: userTwoFactorCodeRequest{}, status: http.StatusOK, response: recoveryCodesResponse{}, rateLimited: true},
+	{method: echo.POST, path: "/user/2fa/disable", id: "disableTwoFactor", tag: "users", summary: "Disable two-factor authentication",
+		auth: true, body: userDisableTwoFactorRequest{}, status: http.StatusOK, response: resultResponse{}, idempotent: true, rateLimited: true},
+	{method: echo.POST, path: "/user/2fa/recovery-codes", id: "regenerateRecoveryCodes", tag: "users", summary: "Replace the recovery codes",
+		auth: true, body: userTwoFactorCodeRequest{}, status: http.StatusOK, response: recoveryCodesResponse{}, rateLimited: true},
+
+	{method: echo.POST, path: "/categories", id: "createCategory", tag: "categories", summary: "Create a category",
+		auth: true, body: categoryCreateRequest{}, status: http.StatusCreated, response: singleCategoryResponse{}, etag: true, idempotent: true, rateLimited: true},
+	{method: echo.GET, path: "/categories", id: "listCategories", tag: "categories", summary: "List categories",
+		query: pageParameters, status: http.StatusOK, response: categoryListResponse{}, etag: true, cacheable: true},
+	{method: echo.GET, path: "/categories/:id", id: "getCategory", tag: "categories", summary: "Get a category",
+		status: http.StatusOK, response: singleCategoryResponse{}, etag: true, cacheable: true},
+	{method: echo.PUT, path: "/categories/:id", id: "updateCategory", tag: "categories", summary: "Replace a category",
+		auth: true, body: categoryUpdateRequest{}, status: http.StatusOK, response: singleCategoryResponse{}, etag: true, ifMatch: true, rateLimited: true},
+	{method: echo.PATCH, path: "/categories/:id", id: "patchCategory", tag: "categories", summary: "Patch a category",
+		auth: true, body: categoryUpdateRequest{}, patch: true, status: http.StatusOK, response: singleCategoryResponse{}, etag: true, ifMatch: true, rateLimited: true},
+	{method: echo.DELETE, path: "/categories/:id", id: "deleteCategory", tag: "categories", summary: "Delete a category",
+		auth: true, status: http.StatusOK, response: resultResponse{}, ifMatch: true, rateLimited: true},
+
+	{method: echo.POST, path: "/products", id: "createProduct", tag: "products", summary: "Create a product",
+		auth: true, body: productCreateRequest{}, status: http.StatusCreated, response: singleProductResponse{}, etag: true, idempotent: true, rateLimited: true},
+	{method: echo.GET, path: "/products", id: "listProducts", tag: "products", summary: "List products, newest first",
+		query: append([]*openapi.Parameter{
+			{Name: "category", In: "query", Description: "Only list the products of this category.", Schema: &openapi.Schema{Type: "string"}},
+			{Name: "owner", In: "query", Description: "Only list the products of this user.", Schema: &openapi.Schema{Type: "string"}},
+			{Name: "search", In: "query", Description: "Only list the products whose title or description contains this text.", Schema: &openapi.Schema{Type: "string"}},
+		}, pageParameters...),
+		status: http.StatusOK, response: productListResponse{}, etag: true, cacheable: true},
+	{method: echo.GET, path: "/products/:slug", id: "getProduct", tag: "products", summary: "Get a product",
+		status: http.StatusOK, response: singleProductResponse{}, etag: true, cacheable: true},
+	{method: echo.PUT, path: "/products/:slug", id: "updateProduct", tag: "products", summary: "Replace a product",
+		auth: true, body: productUpdateRequest{}, status: http.StatusOK, response: singleProductResponse{}, etag: true, ifMatch: true, rateLimited: true},
+	{method: echo.PATCH, path: "/products/:slug", id: "patchProduct", tag: "products", summary: "Patch a product",
+		auth: true, body: productUpdateRequest{}, patch: true, status: http.StatusOK, response: singleProductResponse{}, etag: true, ifMatch: true, rateLimited: true},
+	{method: echo.DELETE, path: "/products/:slug", id: "deleteProduct", tag: "products", summary: "Delete a product",
+		auth: true, status: http.StatusOK, response: resultResponse{}, ifMatch: true, rateLimited: true},
+
+	{method: echo.POST, path: "/webhooks", id: "createWebhook", tag: "webhooks", summary: "Subscribe a URL to catalog events",
+		auth: true, body: webhookCreateRequest{}, status: http.StatusCreated, response: singleWebhookResponse{}, idempotent: true, rateLimited: true},
+	{method: echo.GET, path: "/webhooks", id: "listWebhooks", tag: "webhooks", summary: "List the webhooks of the current user",
+		auth: true, query: pageParameters, status: http.StatusOK, response: webhookListResponse{}},
+	{method: echo.GET, path: "/webhooks/:id", id: "getWebhook", tag: "webhooks", summary: "Get a webhook",
+		auth: true, status: http.StatusOK, response: singleWebhookResponse{}},
+	{method: echo.PUT, path: "/webhooks/:id", id: "updateWebhook", tag: "webhooks", summary: "Replace a webhook",
+		auth: true, body: webhookUpdateRequest{}, status: http.StatusOK, response: singleWebhookResponse{}, rateLimited: true},
+	{method: echo.DELETE, path: "/webhooks/:id", id: "deleteWebhook", tag: "webhooks", summary: "Delete a webhook and its delivery log",
+		auth: true, status: http.StatusOK, response: resultResponse{}, rateLimited: true},
+	{method: echo.GET, path: "/webhooks/:id/deliveries", id: "listWebhookDeliveries", tag: "webhooks", summary: "List the deliveries of a webhook, newest first",
+		auth: true, query: pageParameters, status: http.StatusOK, response: deliveryListResponse{}},
+	{method: echo.POST, path: "/webhooks/:id/deliveries/:deliveryID/replay", id: "replayWebhookDelivery", tag: "webhooks", summary: "Send a past delivery again",
+		auth: true, status: http.StatusAccepted, response: singleDeliveryResponse{}, idempotent: true, rateLimited: true},
+
+	{method: echo.GET, path: "/stream", id: "streamChanges", tag: "stream", summary: "Follow product and category changes as Server-Sent Events",
+		auth: true,
+		query: []*openapi.Parameter{
+			{Name: "category", In: "query", Description: "Only stream the events of this category and of its products.", Schema: &openapi.Schema{Type: "string"}},
+			{Name: "owner", In: "query", Description: "Only stream the events of the products of this user.", Schema: &openapi.Schema{Type: "string"}},
+			{Name: "token", In: "query", Description: "The token, for clients which cannot set the Authorization header.", Schema: &openapi.Schema{Type: "string"}},
+		},
+		headers: []*openapi.Parameter{headerParameter(HeaderLastEventID,
+			"Resume after the event with this id. A reset event is sent when the missed events are no longer known.")},
+		status: http.StatusOK, contentType: MIMETextEventStream},
+
+	{method: echo.POST, path: "/batch", id: "batch", tag: "batch", summary: "Run several operations in one request",
+		body: batchRequest{}, status: http.StatusOK, response: batchResponse{}, idempotent: true},
+
+	{method: echo.GET, path: "/openapi.json", id: "getOpenAPI", tag: "docs", summary: "Get this document",
+		status: http.StatusOK, response: map[string]interface{}{}, etag: true},
+	{method: echo.GET, path: "/docs", id: "getDocs", tag: "docs", summary: "Browse this document",
+		status: http.StatusOK, contentType: echo.MIMETextHTMLCharsetUTF8},
+}
+
+var pathParameters = map[string]*openapi.Parameter{
+	"id":         {Name: "id", In: "path", Required: true, Schema: &openapi.Schema{Type: "integer", Minimum: new(float64)}},
+	"deliveryID": {Name: "deliveryID", In: "path", Required: true, Schema: &openapi.Schema{Type: "integer", Minimum: new(float64)}},
+	"slug":       {Name: "slug", In: "path", Required: true, Schema: &openapi.Schema{Type: "string"}},
+}
+
+var echoParam = regexp.MustCompile(`:(\w+)`)
+
+// openAPIPath turns an echo path into an OpenAPI one: /products/:slug
+// becomes /products/{slug}.
+func openAPIPath(path string) string {
+	return echoParam.ReplaceAllString(path, "{$1}")
+}
+
+// newOpenAPIDocument describes the routes of Register, served from
+// serverURL.
+func newOpenAPIDocument(serverURL string) *openapi.Document {
+	d := openapi.New(openapi.Info{
+		Title:   "Product Catalog API",
+		Version: "1.0.0",
+		Description: "Errors are RFC 7807 problem documents whose code member is stable. " +
+			"Validation errors list every rejected field.",
+	})
+	d.Servers = []openapi.Server{{URL: serverURL}}
+	d.Components.SecuritySchemes["token"] = &openapi.SecurityScheme{
+		Type:        "apiKey",
+		In:          "header",
+		Name:        echo.HeaderAuthorization,
+		Description: `"Token <jwt>", with the token returned by login or sign up.`,
+	}
+	problem := &openapi.Response{
+		Content: map[string]*openapi.MediaType{
+			utils.MIMEApplicationProblemJSON: {Schema: d.Schema(utils.Error{})},
+		},
+	}
+	seen := make(map[string]bool)
+	for _, r := range routes {
+		if !seen[r.tag] {
+			seen[r.tag] = true
+			d.Tags = append(d.Tags, openapi.Tag{Name: r.tag})
+		}
+		d.AddOperation(r.method, openAPIPath(r.path), r.operation(d, problem))
+	}
+	return d
+}
+
+func (r *route) operation(d *openapi.Document, problem *openapi.Response) *openapi.Operation {
+	op := &openapi.Operation{
+		OperationID: r.id,
+		Summary:     r.summary,
+		Tags:        []string{r.tag},
+		Responses:   make(map[string]*openapi.Response),
+	}
+	failures := []int{}
+	for _, m := range echoParam.FindAllStringSubmatch(r.path, -1) {
+		op.Parameters = append(op.Parameters, pathParameters[m[1]])
+		failures = append(failures, http.StatusNotFound)
+	}
+	op.Parameters = append(op.Parameters, r.query...)
+	op.Parameters = append(op.Parameters, r.headers...)
+
+	success := &openapi.Response{Description: http.StatusText(r.status)}
+	switch {
+	case r.contentType != "":
+		success.Content = map[string]*openapi.MediaType{r.contentType: {Schema: &openapi.Schema{Type: "string"}}}
+	case r.response != nil:
+		success.Content = map[string]*openapi.MediaType{echo.MIMEApplicationJSON: {Schema: d.Schema(r.response)}}
+	}
+	op.Responses[strconv.Itoa(r.status)] = success
+	for status, response := range r.other {
+		op.Responses[strconv.Itoa(status)] = &openapi.Response{
+			Description: http.StatusText(status),
+			Content:     map[string]*openapi.MediaType{echo.MIMEApplicationJSON: {Schema: d.Schema(response)}},
+		}
+	}
+
+	if r.auth {
+		op.Security = []map[string][]string{{"token": {}}}
+		failures = append(failures, http.StatusUnauthorized, http.StatusForbidden)
+	}
+	if r.body != nil {
+		op.RequestBody = &openapi.RequestBody{Required: true, Content: map[string]*openapi.MediaType{}}
+		schema := d.Schema(r.body)
+		if r.patch {
+			op.RequestBody.Content[MIMEApplicationMergePatchJSON] = &openapi.MediaType{Schema: schema}
+			op.RequestBody.Content[MIMEApplicationJSONPatchJSON] = &openapi.MediaType{Schema: d.Schema([]jsonPatchOperation{})}
+			failures = append(failures, http.StatusConflict, http.StatusUnsupportedMediaType)
+		} else {
+			op.RequestBody.Content[echo.MIMEApplicationJSON] = &openapi.MediaType{Schema: schema}
+		}
+		failures = append(failures, http.StatusBadRequest, http.StatusUnprocessableEntity)
+	}
+	if r.etag {
+		success.Headers = map[string]*openapi.Header{
+			HeaderETag: {Schema: &openapi.Schema{Type: "string"}},
+		}
+		if r.method == echo.GET {
+			op.Parameters = append(op.Parameters, headerParameter(HeaderIfNoneMatch,
+				"Answer 304 Not Modified when the ETag of the response is one of these."))
+			op.Responses[strconv.Itoa(http.StatusNotModified)] = &openapi.Response{Description: http.StatusText(http.StatusNotModified)}
+		}
+	}
+	if r.cacheable {
+		success.Headers[HeaderCacheControl] = &openapi.Header{
+			Description: "Public for anonymous requests, private otherwise.",
+			Schema:      &openapi.Schema{Type: "string"},
+		}
+	}
+	if r.ifMatch {
+		op.Parameters = append(op.Parameters, headerParameter(HeaderIfMatch,
+			"Only apply the change to the version of the resource with this ETag."))
+		failures = append(failures, http.StatusPreconditionFailed, http.StatusPreconditionRequired)
+	}
+	if r.idempotent {
+		op.Parameters = append(op.Parameters, headerParameter(middleware.HeaderIdempotencyKey,
+			"Replay the response of an earlier request sent with the same key instead of running it again."))
+		if success.Headers == nil {
+			success.Headers = make(map[string]*openapi.Header)
+		}
+		success.Headers[middleware.HeaderIdempotentReplayed] = &openapi.Header{
+			Description: "Set when the response is a replay.",
+			Schema:      &openapi.Schema{Type: "boolean"},
+		}
+		failures = append(failures, http.StatusConflict, http.StatusUnprocessableEntity)
+	}
+
+	if r.rateLimited {
+		if success.Headers == nil {
+			success.Headers = make(map[string]*openapi.Header)
+		}
+		for _, h := range []string{middleware.HeaderRateLimitLimit, middleware.HeaderRateLimitRemaining, middleware.HeaderRateLimitReset} {
+			success.Headers[h] = &openapi.Header{Schema: &openapi.Schema{Type: "integer"}}
+		}
+		success.Headers[middleware.HeaderRateLimitPolicy] = &openapi.Header{Schema: &openapi.Schema{Type: "string"}}
+		failures = append(failures, http.StatusTooManyRequests)
+	}
+
+	for _, status := range failures {
+		resp := *problem
+		resp.Description = http.StatusText(status)
+		if status == http.StatusTooManyRequests {
+			resp.Headers = map[string]*openapi.Header{
+				middleware.HeaderRetryAfter: {Description: "Seconds to wait before retrying.", Schema: &openapi.Schema{Type: "integer"}},
+			}
+		}
+		op.Responses[strconv.Itoa(status)] = &resp
+	}
+	resp := *problem
+	resp.Description = "Any other error"
+	op.Responses["default"] = &resp
+	return op
+}
+
+func headerParameter(name, description string) *openapi.Parameter {
+	return &openapi.Parameter{Name: name, In: "header", Description: description, Schema: &openapi.Schema{Type: "string"}}
+}
+
+// OpenAPI serves the OpenAPI document of the API.
+func (h *Handler) OpenAPI(c echo.Context) error {
+	serverURL := strings.TrimSuffix(c.Path(), "/openapi.json")
+	return jsonWithContentETag(c, http.StatusOK, newOpenAPIDocument(serverURL))
+}
+
+// SwaggerUI serves a page to browse the OpenAPI document, which loads
+// Swagger UI from a CDN.
+func (h *Handler) SwaggerUI(c echo.Context) error {
+	return c.HTML(http.StatusOK, swaggerUIPage)
+}
+
+const swaggerUIPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>Product Catalog API</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js" crossorigin></script>
+  <script>
+    window.ui = SwaggerUIBundle({url: "openapi.json", dom_id: "#swagger-ui"});
+  </script>
+</body>
+</html>
+`
@@ -0,0 +1,114 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/sumitalp/productcatalog/openapi"
+)
+
+// TestOpenAPIMatchesRoutes fails when a route is added to or removed from
+// Register without updating the routes table, or the other way round.
+func TestOpenAPIMatchesRoutes(t *testing.T) {
+	t.Parallel()
+	h, e := setup(t)
+	h.Register(e.Group("/api"))
+
+	var registered []string
+	for _, r := range e.Routes() {
+		// Groups with middleware add catch-all routes of their own.
+		if !strings.HasPrefix(r.Path, "/api/") || strings.HasSuffix(r.Path, "/*") {
+			continue
+		}
+		if strings.HasPrefix(r.Name, "github.com/labstack/echo") {
+			continue
+		}
+		registered = append(registered, r.Method+" "+openAPIPath(strings.TrimPrefix(r.Path, "/api")))
+	}
+	sort.Strings(registered)
+
+	var documented []string
+	for path, item := range newOpenAPIDocument("/api").Paths {
+		for method := range *item {
+			documented = append(documented, strings.ToUpper(method)+" "+path)
+		}
+	}
+	sort.Strings(documented)
+
+	assert.Equal(t, registered, documented)
+}
+
+func TestServeOpenAPI(t *testing.T) {
+	t.Parallel()
+	h, e := setup(t)
+	h.Register(e.Group("/api"))
+	req := httptest.NewRequest(echo.GET, "/api/openapi.json", nil)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.NotEmpty(t, rec.Header().Get(HeaderETag))
+
+	var d openapi.Document
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &d))
+	assert.Equal(t, openapi.Version, d.OpenAPI)
+	if assert.Len(t, d.Servers, 1) {
+		assert.Equal(t, "/api", d.Servers[0].URL)
+	}
+
+	op := (*d.Paths["/products/{slug}"])["put"]
+	if assert.NotNil(t, op) {
+		assert.Equal(t, []map[string][]string{{"token": {}}}, op.Security)
+		assert.Contains(t, op.Responses, "412")
+		assert.Contains(t, op.Responses, "422")
+		assert.Equal(t, "#/components/schemas/ProductUpdateRequest",
+			op.RequestBody.Content[echo.MIMEApplicationJSON].Schema.Ref)
+	}
+	op = (*d.Paths["/products"])["get"]
+	if assert.NotNil(t, op) {
+		assert.Empty(t, op.Security)
+		assert.Contains(t, op.Responses, "304")
+	}
+
+	req = httptest.NewRequest(echo.GET, "/api/docs", nil)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `url: "openapi.json"`)
+}
+
+func TestOpenAPISchemaConstraints(t *testing.T) {
+	t.Parallel()
+	d := newOpenAPIDocument("/api")
+	schemas := d.Components.Schemas
+
+	user := schemas["UserRegisterRequest"].Properties["user"]
+	assert.ElementsMatch(t, []string{"username", "email", "password"}, user.Required)
+	assert.Equal(t, "email", user.Properties["email"].Format)
+
+	product := schemas["ProductCreateRequest"].Properties["product"]
+	assert.ElementsMatch(t, []string{"title", "description"}, product.Required)
+	assert.Equal(t, "array", product.Properties["categoryList"].Type)
+
+	update := schemas["ProductUpdateRequest"].Properties["product"]
+	if assert.NotNil(t, update.Properties["categoriesList"].Items.MinLength) {
+		assert.Equal(t, 1, *update.Properties["categoriesList"].Items.MinLength)
+	}
+
+	ops := schemas["BatchRequest"].Properties["operations"]
+	assert.Equal(t, 1, *ops.MinItems)
+	assert.Equal(t, 100, *ops.MaxItems)
+	op := schemas["BatchOperation"]
+	assert.Equal(t, []interface{}{"GET", "POST", "PUT", "PATCH", "DELETE"}, op.Properties["method"].Enum)
+	assert.Equal(t, "^/", op.Properties["path"].Pattern)
+
+	assert.True(t, schemas["ProductResponse"].Properties["owner"].Properties["bio"].Nullable)
+	assert.Equal(t, "date-time", schemas["ProductResponse"].Properties["createdAt"].Format)
+	assert.Contains(t, schemas, "Error")
+}
@@ -0,0 +1,71 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"reflect"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/labstack/echo/v4"
+	"github.com/sumitalp/productcatalog/utils"
+)
+
+// Media types accepted by PATCH routes.
+const (
+	MIMEApplicationMergePatchJSON = "application/merge-patch+json"
+	MIMEApplicationJSONPatchJSON  = "application/json-patch+json"
+)
+
+// patchRequest applies the body of a PATCH request to doc, an update
+// request already populated from the stored record, and validates the
+// result. The body is either an RFC 7396 merge patch or an RFC 6902 JSON
+// patch of doc's JSON form, so only the members it mentions change.
+func patchRequest(c echo.Context, doc interface{}) error {
+	mediaType, _, _ := mime.ParseMediaType(c.Request().Header.Get(echo.HeaderContentType))
+	if mediaType != MIMEApplicationMergePatchJSON && mediaType != MIMEApplicationJSONPatchJSON {
+		return utils.NewProblem(http.StatusUnsupportedMediaType, utils.CodeUnsupportedMedia,
+			fmt.Sprintf("PATCH requires %s or %s", MIMEApplicationMergePatchJSON, MIMEApplicationJSONPatchJSON))
+	}
+	body, err := ioutil.ReadAll(c.Request().Body)
+	if err != nil {
+		return err
+	}
+	original, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+
+	var patched []byte
+	if mediaType == MIMEApplicationMergePatchJSON {
+		if !json.Valid(body) {
+			return utils.BadRequest("malformed merge patch")
+		}
+		if patched, err = jsonpatch.MergePatch(original, body); err != nil {
+			return utils.BadRequest(fmt.Sprintf("malformed merge patch: %v", err))
+		}
+	} else {
+		patch, err := jsonpatch.DecodePatch(body)
+		if err != nil {
+			return utils.BadRequest(fmt.Sprintf("malformed JSON patch: %v", err))
+		}
+		if patched, err = patch.Apply(original); err != nil {
+			return utils.NewProblem(http.StatusConflict, utils.CodeConflict,
+				fmt.Sprintf("JSON patch cannot be applied: %v", err))
+		}
+	}
+
+	// Members removed by the patch must not keep their stored value, so
+	// decode into a zeroed document.
+	v := reflect.ValueOf(doc).Elem()
+	v.Set(reflect.Zero(v.Type()))
+	dec := json.NewDecoder(bytes.NewReader(patched))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(doc); err != nil {
+		return utils.BadRequest(fmt.Sprintf("patched document is invalid: %v", err))
+	}
+	return c.Validate(doc)
+}
@@ -0,0 +1,152 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/sumitalp/productcatalog/router/middleware"
+	"github.com/sumitalp/productcatalog/utils"
+)
+
+// patch calls handler with a PATCH request for the fixture user1 and
+// renders any returned error like the router would.
+func patch(e *echo.Echo, handler echo.HandlerFunc, contentType, body, param, value string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(echo.PATCH, "/", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, contentType)
+	req.Header.Set(echo.HeaderAuthorization, authHeader(utils.GenerateJWT(1, 0)))
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	if param != "" {
+		c.SetParamNames(param)
+		c.SetParamValues(value)
+	}
+	err := middleware.JWT(utils.JWTSecret)(handler)(c)
+	if err != nil {
+		e.HTTPErrorHandler(err, c)
+	}
+	return rec
+}
+
+func TestPatchProductMergePatch(t *testing.T) {
+	t.Parallel()
+	h, e := setup(t)
+	rec := patch(e, h.PatchProduct, MIMEApplicationMergePatchJSON,
+		`{"product":{"image":"http://example.com/p1.png"}}`, "slug", "product1-slug")
+	if assert.Equal(t, http.StatusOK, rec.Code) {
+		m := responseMap(rec.Body.Bytes(), "product")
+		assert.Equal(t, "http://example.com/p1.png", m["image"])
+		assert.Equal(t, "product1 title", m["title"])
+		assert.Equal(t, "product1 description", m["description"])
+		assert.ElementsMatch(t, []interface{}{"category1", "category2"}, m["categoryList"])
+	}
+}
+
+func TestPatchProductJSONPatch(t *testing.T) {
+	t.Parallel()
+	h, e := setup(t)
+	rec := patch(e, h.PatchProduct, MIMEApplicationJSONPatchJSON, `[
+		{"op":"test","path":"/product/title","value":"product1 title"},
+		{"op":"remove","path":"/product/categoriesList/0"},
+		{"op":"add","path":"/product/categoriesList/-","value":"category3"}
+	]`, "slug", "product1-slug")
+	if assert.Equal(t, http.StatusOK, rec.Code) {
+		m := responseMap(rec.Body.Bytes(), "product")
+		assert.Equal(t, "product1 description", m["description"])
+		assert.Len(t, m["categoryList"], 2)
+		assert.Contains(t, m["categoryList"], "category3")
+	}
+}
+
+func TestPatchProductClearsFields(t *testing.T) {
+	t.Parallel()
+	h, e := setup(t)
+	rec := patch(e, h.PatchProduct, MIMEApplicationMergePatchJSON,
+		`{"product":{"image":"http://example.com/p1.png"}}`, "slug", "product1-slug")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	// Saving derives the slug from the title.
+	slug := responseMap(rec.Body.Bytes(), "product")["slug"].(string)
+
+	rec = patch(e, h.PatchProduct, MIMEApplicationMergePatchJSON,
+		`{"product":{"image":null}}`, "slug", slug)
+	if assert.Equal(t, http.StatusOK, rec.Code) {
+		assert.Equal(t, "", responseMap(rec.Body.Bytes(), "product")["image"])
+	}
+
+	rec = patch(e, h.PatchProduct, MIMEApplicationJSONPatchJSON,
+		`[{"op":"remove","path":"/product/description"}]`, "slug", slug)
+	if assert.Equal(t, http.StatusOK, rec.Code) {
+		m := responseMap(rec.Body.Bytes(), "product")
+		assert.Equal(t, "", m["description"])
+		assert.Equal(t, "product1 title", m["title"])
+	}
+}
+
+func TestPatchProductCaseInvalid(t *testing.T) {
+	t.Parallel()
+	h, e := setup(t)
+	rec := patch(e, h.PatchProduct, MIMEApplicationMergePatchJSON,
+		`{"product":{"title":null}}`, "slug", "product1-slug")
+	if assert.Equal(t, http.StatusUnprocessableEntity, rec.Code) {
+		p := problemResponse(t, rec)
+		if assert.Len(t, p.Errors, 1) {
+			assert.Equal(t, "product.title", p.Errors[0].Field)
+			assert.Equal(t, "required", p.Errors[0].Code)
+		}
+	}
+
+	rec = patch(e, h.PatchProduct, MIMEApplicationJSONPatchJSON,
+		`[{"op":"test","path":"/product/title","value":"other"}]`, "slug", "product1-slug")
+	if assert.Equal(t, http.StatusConflict, rec.Code) {
+		assert.Equal(t, utils.CodeConflict, problemResponse(t, rec).Code)
+	}
+
+	rec = patch(e, h.PatchProduct, MIMEApplicationMergePatchJSON,
+		`{"product":{"price":10}}`, "slug", "product1-slug")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = patch(e, h.PatchProduct, MIMEApplicationJSONPatchJSON, `{"op":"add"}`, "slug", "product1-slug")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestPatchProductCaseUnsupportedMediaType(t *testing.T) {
+	t.Parallel()
+	h, e := setup(t)
+	rec := patch(e, h.PatchProduct, echo.MIMEApplicationJSON,
+		`{"product":{"title":"x"}}`, "slug", "product1-slug")
+	if assert.Equal(t, http.StatusUnsupportedMediaType, rec.Code) {
+		assert.Equal(t, utils.CodeUnsupportedMedia, problemResponse(t, rec).Code)
+	}
+}
+
+func TestPatchCategoryMergePatch(t *testing.T) {
+	t.Parallel()
+	h, e := setup(t)
+	rec := patch(e, h.PatchCategory, MIMEApplicationMergePatchJSON+"; charset=utf-8",
+		`{"category":{"description":"changed"}}`, "id", "1")
+	if assert.Equal(t, http.StatusOK, rec.Code) {
+		m := responseMap(rec.Body.Bytes(), "category")
+		assert.Equal(t, "category1", m["title"])
+		assert.Equal(t, "changed", m["description"])
+	}
+}
+
+func TestPatchUserMergePatch(t *testing.T) {
+	t.Parallel()
+	h, e := setup(t)
+	rec := patch(e, h.PatchUser, MIMEApplicationMergePatchJSON, `{"user":{"bio":"new bio"}}`, "", "")
+	if assert.Equal(t, http.StatusOK, rec.Code) {
+		m := responseMap(rec.Body.Bytes(), "user")
+		assert.Equal(t, "user1", m["username"])
+		assert.Equal(t, "user1@email.io", m["email"])
+		assert.Equal(t, "new bio", m["bio"])
+		assert.Equal(t, "http://realworld.io/user1.jpg", m["image"])
+	}
+
+	rec = patch(e, h.PatchUser, MIMEApplicationJSONPatchJSON,
+		`[{"op":"replace","path":"/user/email","value":"not-an-email"}]`, "", "")
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+}
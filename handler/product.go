@@ -1,31 +1,25 @@
 package handler
 
 import (
-	"errors"
 	"net/http"
 	"strconv"
 
 	"github.com/labstack/echo/v4"
 	"github.com/sumitalp/productcatalog/models"
+	"github.com/sumitalp/productcatalog/product"
 	"github.com/sumitalp/productcatalog/utils"
 )
 
 func (h *Handler) GetProduct(c echo.Context) error {
-	slug := c.Param("slug")
-	a, err := h.productStore.GetBySlug(slug)
+	a, err := h.productService.Get(c.Request().Context(), c.Param("slug"))
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, utils.NewError(err))
+		return err
 	}
-	if a == nil {
-		return c.JSON(http.StatusNotFound, utils.NotFound())
-	}
-	return c.JSON(http.StatusOK, newProductResponse(c, a))
+	h.setCacheControl(c)
+	return jsonWithETag(c, http.StatusOK, newProductResponse(c, a), productETag(a))
 }
 
 func (h *Handler) Products(c echo.Context) error {
-	category := c.QueryParam("category")
-	owner := c.QueryParam("owner")
-
 	offset, err := strconv.Atoi(c.QueryParam("offset"))
 	if err != nil {
 		offset = 0
@@ -34,92 +28,88 @@ func (h *Handler) Products(c echo.Context) error {
 	if err != nil {
 		limit = 20
 	}
-	var products []models.Product
-	var count int
-	if category != "" {
-		products, count, err = h.productStore.ListByCategory(category, offset, limit)
-		if err != nil {
-			return c.JSON(http.StatusInternalServerError, nil)
-		}
-	} else if owner != "" {
-		products, count, err = h.productStore.ListByOwner(owner, offset, limit)
-		if err != nil {
-			return c.JSON(http.StatusInternalServerError, nil)
-		}
-	} else {
-		products, count, err = h.productStore.List(offset, limit)
-		if err != nil {
-			return c.JSON(http.StatusInternalServerError, nil)
-		}
+	products, count, err := h.productService.List(c.Request().Context(), product.ListFilter{
+		Category: c.QueryParam("category"),
+		Owner:    c.QueryParam("owner"),
+		Search:   c.QueryParam("search"),
+		Offset:   offset,
+		Limit:    limit,
+	})
+	if err != nil {
+		return err
 	}
-	return c.JSON(http.StatusOK, newProductListResponse(h.userStore, userIDFromToken(c), products, count))
+	h.setCacheControl(c)
+	return jsonWithContentETag(c, http.StatusOK, newProductListResponse(userIDFromToken(c), products, count))
 }
 
 func (h *Handler) CreateProduct(c echo.Context) error {
 	var a models.Product
 	req := &productCreateRequest{}
 	if err := req.bind(c, &a); err != nil {
-		return c.JSON(http.StatusUnprocessableEntity, utils.NewError(err))
+		return err
 	}
-	a.OwnerID = userIDFromToken(c)
-	err := h.productStore.CreateProduct(&a)
-	if err != nil {
-		return c.JSON(http.StatusUnprocessableEntity, utils.NewError(err))
+	if err := h.productService.Create(c.Request().Context(), userIDFromToken(c), &a); err != nil {
+		return err
 	}
-
+	c.Response().Header().Set(HeaderETag, productETag(&a))
 	return c.JSON(http.StatusCreated, newProductResponse(c, &a))
 }
 
 func (h *Handler) UpdateProduct(c echo.Context) error {
-	slug := c.Param("slug")
-	a, err := h.productStore.GetUserProductBySlug(userIDFromToken(c), slug)
+	a, err := h.productService.Update(c.Request().Context(), userIDFromToken(c), c.Param("slug"), func(a *models.Product) error {
+		if err := h.checkIfMatch(c, productETag(a)); err != nil {
+			return err
+		}
+		req := &productUpdateRequest{}
+		req.populate(a)
+		return req.bind(c, a)
+	})
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, utils.NewError(err))
-	}
-	if a == nil {
-		return c.JSON(http.StatusNotFound, utils.NotFound())
-	}
-	req := &productUpdateRequest{}
-	req.populate(a)
-	if err := req.bind(c, a); err != nil {
-		return c.JSON(http.StatusUnprocessableEntity, utils.NewError(err))
-	}
-	if err = h.productStore.UpdateProduct(a, req.Product.Categories); err != nil {
-		return c.JSON(http.StatusInternalServerError, utils.NewError(err))
+		return err
 	}
+	c.Response().Header().Set(HeaderETag, productETag(a))
 	return c.JSON(http.StatusOK, newProductResponse(c, a))
 }
 
-func (h *Handler) DeleteProduct(c echo.Context) error {
-	slug := c.Param("slug")
-	a, err := h.productStore.GetUserProductBySlug(userIDFromToken(c), slug)
+// PatchProduct applies a merge patch or JSON patch of the product update
+// document to the product with the given slug.
+func (h *Handler) PatchProduct(c echo.Context) error {
+	a, err := h.productService.Update(c.Request().Context(), userIDFromToken(c), c.Param("slug"), func(a *models.Product) error {
+		if err := h.checkIfMatch(c, productETag(a)); err != nil {
+			return err
+		}
+		req := &productUpdateRequest{}
+		req.populate(a)
+		return req.patch(c, a)
+	})
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, utils.NewError(err))
+		return err
 	}
-	if a == nil {
-		return c.JSON(http.StatusNotFound, utils.NotFound())
-	}
-	err = h.productStore.DeleteProduct(a)
+	c.Response().Header().Set(HeaderETag, productETag(a))
+	return c.JSON(http.StatusOK, newProductResponse(c, a))
+}
+
+func (h *Handler) DeleteProduct(c echo.Context) error {
+	err := h.productService.Delete(c.Request().Context(), userIDFromToken(c), c.Param("slug"), func(a *models.Product) error {
+		return h.checkIfMatch(c, productETag(a))
+	})
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, utils.NewError(err))
+		return err
 	}
-	return c.JSON(http.StatusOK, map[string]interface{}{"result": "ok"})
+	return c.JSON(http.StatusOK, newResultResponse())
 }
 
 func (h *Handler) GetCategory(c echo.Context) error {
-	categoryID64, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	categoryID, err := categoryIDParam(c)
 	if err != nil {
-		return c.JSON(http.StatusBadRequest, utils.NewError(errors.New("Invalid ID.")))
+		return err
 	}
-	categoryID := uint(categoryID64)
-	a, err := h.productStore.GetCategoryByID(categoryID)
+	a, err := h.productService.GetCategory(c.Request().Context(), categoryID)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, utils.NewError(err))
-	}
-	if a == nil {
-		return c.JSON(http.StatusNotFound, utils.NotFound())
+		return err
 	}
-	return c.JSON(http.StatusOK, newCategoryResponse(c, a))
+	h.setCacheControl(c)
+	return jsonWithETag(c, http.StatusOK, newCategoryResponse(c, a), versionETag(a.ModelBase))
 }
 
 func (h *Handler) Categories(c echo.Context) error {
@@ -131,72 +121,87 @@ func (h *Handler) Categories(c echo.Context) error {
 	if err != nil {
 		limit = 20
 	}
-	var categories []models.Category
-	var count int
-
-	categories, count, err = h.productStore.ListCategories(offset, limit)
+	categories, count, err := h.productService.ListCategories(c.Request().Context(), offset, limit)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, nil)
+		return err
 	}
-	return c.JSON(http.StatusOK, newCategoryListResponse(h.userStore, userIDFromToken(c), categories, count))
+	h.setCacheControl(c)
+	return jsonWithContentETag(c, http.StatusOK, newCategoryListResponse(userIDFromToken(c), categories, count))
 }
 
 func (h *Handler) CreateCategory(c echo.Context) error {
 	var a models.Category
 	req := &categoryCreateRequest{}
 	if err := req.bind(c, &a); err != nil {
-		return c.JSON(http.StatusUnprocessableEntity, utils.NewError(err))
+		return err
 	}
-
-	err := h.productStore.CreateCategory(&a)
-	if err != nil {
-		return c.JSON(http.StatusUnprocessableEntity, utils.NewError(err))
+	if err := h.productService.CreateCategory(c.Request().Context(), &a); err != nil {
+		return err
 	}
-
+	c.Response().Header().Set(HeaderETag, versionETag(a.ModelBase))
 	return c.JSON(http.StatusCreated, newCategoryResponse(c, &a))
 }
 
 func (h *Handler) UpdateCategory(c echo.Context) error {
-	categoryID64, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	categoryID, err := categoryIDParam(c)
 	if err != nil {
-		return c.JSON(http.StatusBadRequest, utils.NewError(errors.New("Invalid ID.")))
+		return err
 	}
-	categoryID := uint(categoryID64)
-	a, err := h.productStore.GetCategoryByID(categoryID)
+	a, err := h.productService.UpdateCategory(c.Request().Context(), categoryID, func(a *models.Category) error {
+		if err := h.checkIfMatch(c, versionETag(a.ModelBase)); err != nil {
+			return err
+		}
+		req := &categoryUpdateRequest{}
+		req.populate(a)
+		return req.bind(c, a)
+	})
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, utils.NewError(err))
+		return err
 	}
-	if a == nil {
-		return c.JSON(http.StatusNotFound, utils.NotFound())
-	}
-	req := &categoryUpdateRequest{}
-	req.populate(a)
-	if err := req.bind(c, a); err != nil {
-		return c.JSON(http.StatusUnprocessableEntity, utils.NewError(err))
+	c.Response().Header().Set(HeaderETag, versionETag(a.ModelBase))
+	return c.JSON(http.StatusOK, newCategoryResponse(c, a))
+}
+
+// PatchCategory applies a merge patch or JSON patch of the category update
+// document to the category with the given id.
+func (h *Handler) PatchCategory(c echo.Context) error {
+	categoryID, err := categoryIDParam(c)
+	if err != nil {
+		return err
 	}
-	if err = h.productStore.UpdateCategory(a); err != nil {
-		return c.JSON(http.StatusInternalServerError, utils.NewError(err))
+	a, err := h.productService.UpdateCategory(c.Request().Context(), categoryID, func(a *models.Category) error {
+		if err := h.checkIfMatch(c, versionETag(a.ModelBase)); err != nil {
+			return err
+		}
+		req := &categoryUpdateRequest{}
+		req.populate(a)
+		return req.patch(c, a)
+	})
+	if err != nil {
+		return err
 	}
+	c.Response().Header().Set(HeaderETag, versionETag(a.ModelBase))
 	return c.JSON(http.StatusOK, newCategoryResponse(c, a))
 }
 
 func (h *Handler) DeleteCategory(c echo.Context) error {
-	categoryID64, err := strconv.ParseUint(c.Param("id"), 10, 64)
-	
+	categoryID, err := categoryIDParam(c)
 	if err != nil {
-		return c.JSON(http.StatusBadRequest, utils.NewError(errors.New("Invalid ID.")))
+		return err
 	}
-	categoryID := uint(categoryID64)
-	a, err := h.productStore.GetCategoryByID(categoryID)
+	err = h.productService.DeleteCategory(c.Request().Context(), categoryID, func(a *models.Category) error {
+		return h.checkIfMatch(c, versionETag(a.ModelBase))
+	})
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, utils.NewError(err))
+		return err
 	}
-	if a == nil {
-		return c.JSON(http.StatusNotFound, utils.NotFound())
-	}
-	err = h.productStore.DeleteCategory(a)
+	return c.JSON(http.StatusOK, newResultResponse())
+}
+
+func categoryIDParam(c echo.Context) (uint, error) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, utils.NewError(err))
+		return 0, utils.BadRequest("Invalid ID.")
 	}
-	return c.JSON(http.StatusOK, map[string]interface{}{"result": "ok"})
-}
\ No newline at end of file
+	return uint(id), nil
+}
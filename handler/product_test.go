@@ -1,11 +1,13 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/labstack/echo/v4"
 	"github.com/stretchr/testify/assert"
@@ -14,19 +16,18 @@ import (
 	"github.com/sumitalp/productcatalog/utils"
 )
 
-
-// Product Test cases
+// Product Test cases. The writes run against the gorm repositories, whose
+// transactions and preloading the memory ones only imitate.
 func TestListProductsCaseSuccess(t *testing.T) {
-	tearDown()
-	setup()
+	t.Parallel()
+	h, e := setup(t)
 
-	e := router.New()
 	req := httptest.NewRequest(echo.GET, "/api/products", nil)
 	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
-	
+
 	rec := httptest.NewRecorder()
 	c := e.NewContext(req, rec)
-	
+
 	assert.NoError(t, h.Products(c))
 	if assert.Equal(t, http.StatusOK, rec.Code) {
 		var aa productListResponse
@@ -37,8 +38,8 @@ func TestListProductsCaseSuccess(t *testing.T) {
 }
 
 func TestGetProductCaseSuccess(t *testing.T) {
-	tearDown()
-	setup()
+	t.Parallel()
+	h, e := setup(t)
 	req := httptest.NewRequest(echo.GET, "/api/products/:slug", nil)
 	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
 	rec := httptest.NewRecorder()
@@ -48,7 +49,7 @@ func TestGetProductCaseSuccess(t *testing.T) {
 	c.SetParamValues("product1-slug")
 
 	assert.NoError(t, h.GetProduct(c))
-	
+
 	if assert.Equal(t, http.StatusOK, rec.Code) {
 		var a singleProductResponse
 		err := json.Unmarshal(rec.Body.Bytes(), &a)
@@ -58,15 +59,15 @@ func TestGetProductCaseSuccess(t *testing.T) {
 }
 
 func TestCreateProductCaseSuccess(t *testing.T) {
-	tearDown()
-	setup()
+	t.Parallel()
+	h, e := setupSQLite(t)
 	var (
 		reqJSON = `{"product":{"title":"product2", "description":"product2",  "categoryList":["category1","category2"]}}`
 	)
 	jwtMiddleware := middleware.JWT(utils.JWTSecret)
 	req := httptest.NewRequest(echo.POST, "/api/products", strings.NewReader(reqJSON))
 	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
-	req.Header.Set(echo.HeaderAuthorization, authHeader(utils.GenerateJWT(1)))
+	req.Header.Set(echo.HeaderAuthorization, authHeader(utils.GenerateJWT(1, 0)))
 	rec := httptest.NewRecorder()
 	c := e.NewContext(req, rec)
 	err := jwtMiddleware(func(context echo.Context) error {
@@ -82,20 +83,20 @@ func TestCreateProductCaseSuccess(t *testing.T) {
 		assert.Equal(t, "product2", a.Product.Description)
 		assert.Equal(t, "product2", a.Product.Title)
 		assert.Equal(t, "user1", a.Product.Owner.Username)
-		assert.Equal(t, 2, len(a.Product.CategoryList))
+		assert.ElementsMatch(t, []string{"category1", "category2"}, a.Product.CategoryList)
 	}
 }
 
 func TestUpdateProductCaseSuccess(t *testing.T) {
-	tearDown()
-	setup()
+	t.Parallel()
+	h, e := setupSQLite(t)
 	var (
-		reqJSON = `{"product":{"title":"product1 part 2", "categoryList":["category3"]}}`
+		reqJSON = `{"product":{"title":"product1 part 2", "categoriesList":["category3"]}}`
 	)
 	jwtMiddleware := middleware.JWT(utils.JWTSecret)
 	req := httptest.NewRequest(echo.PUT, "/api/products/:slug", strings.NewReader(reqJSON))
 	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
-	req.Header.Set(echo.HeaderAuthorization, authHeader(utils.GenerateJWT(1)))
+	req.Header.Set(echo.HeaderAuthorization, authHeader(utils.GenerateJWT(1, 0)))
 	rec := httptest.NewRecorder()
 	c := e.NewContext(req, rec)
 	c.SetPath("/api/products/:slug")
@@ -112,16 +113,18 @@ func TestUpdateProductCaseSuccess(t *testing.T) {
 		assert.NoError(t, err)
 		assert.Equal(t, "product1 part 2", a.Product.Title)
 		assert.Equal(t, "product1-part-2", a.Product.Slug)
+		assert.Equal(t, "user1", a.Product.Owner.Username)
+		assert.Equal(t, []string{"category3"}, a.Product.CategoryList)
 	}
 }
 
 func TestDeleteProductCaseSuccess(t *testing.T) {
-	tearDown()
-	setup()
+	t.Parallel()
+	h, e := setupSQLite(t)
 	jwtMiddleware := middleware.JWT(utils.JWTSecret)
 	req := httptest.NewRequest(echo.DELETE, "/api/products/:slug", nil)
 	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
-	req.Header.Set(echo.HeaderAuthorization, authHeader(utils.GenerateJWT(1)))
+	req.Header.Set(echo.HeaderAuthorization, authHeader(utils.GenerateJWT(1, 0)))
 	rec := httptest.NewRecorder()
 	c := e.NewContext(req, rec)
 	c.SetPath("/api/products/:slug")
@@ -134,18 +137,16 @@ func TestDeleteProductCaseSuccess(t *testing.T) {
 	assert.Equal(t, http.StatusOK, rec.Code)
 }
 
-
 // Category Test cases
 func TestListCategoriesCaseSuccess(t *testing.T) {
-	tearDown()
-	setup()
+	t.Parallel()
+	h, e := setup(t)
 
-	e := router.New()
 	req := httptest.NewRequest(echo.GET, "/api/categories", nil)
 	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
 	rec := httptest.NewRecorder()
 	c := e.NewContext(req, rec)
-	
+
 	assert.NoError(t, h.Categories(c))
 	if assert.Equal(t, http.StatusOK, rec.Code) {
 		var aa categoryListResponse
@@ -156,8 +157,8 @@ func TestListCategoriesCaseSuccess(t *testing.T) {
 }
 
 func TestGetCategoryCaseSuccess(t *testing.T) {
-	tearDown()
-	setup()
+	t.Parallel()
+	h, e := setup(t)
 	req := httptest.NewRequest(echo.GET, "/api/categories/:id", nil)
 	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
 	rec := httptest.NewRecorder()
@@ -167,7 +168,7 @@ func TestGetCategoryCaseSuccess(t *testing.T) {
 	c.SetParamValues("1")
 
 	assert.NoError(t, h.GetCategory(c))
-	
+
 	if assert.Equal(t, http.StatusOK, rec.Code) {
 		var a singleCategoryResponse
 		err := json.Unmarshal(rec.Body.Bytes(), &a)
@@ -177,15 +178,15 @@ func TestGetCategoryCaseSuccess(t *testing.T) {
 }
 
 func TestCreateCategoryCaseSuccess(t *testing.T) {
-	tearDown()
-	setup()
+	t.Parallel()
+	h, e := setupSQLite(t)
 	var (
 		reqJSON = `{"category":{"title":"category3", "description":"category3"}}`
 	)
 	jwtMiddleware := middleware.JWT(utils.JWTSecret)
 	req := httptest.NewRequest(echo.POST, "/api/categories", strings.NewReader(reqJSON))
 	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
-	req.Header.Set(echo.HeaderAuthorization, authHeader(utils.GenerateJWT(1)))
+	req.Header.Set(echo.HeaderAuthorization, authHeader(utils.GenerateJWT(1, 0)))
 	rec := httptest.NewRecorder()
 	c := e.NewContext(req, rec)
 	err := jwtMiddleware(func(context echo.Context) error {
@@ -203,15 +204,15 @@ func TestCreateCategoryCaseSuccess(t *testing.T) {
 }
 
 func TestUpdateCategoryCaseSuccess(t *testing.T) {
-	tearDown()
-	setup()
+	t.Parallel()
+	h, e := setupSQLite(t)
 	var (
 		reqJSON = `{"category":{"title":"category1 part 2"}}`
 	)
 	jwtMiddleware := middleware.JWT(utils.JWTSecret)
 	req := httptest.NewRequest(echo.PUT, "/api/categories/:id", strings.NewReader(reqJSON))
 	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
-	req.Header.Set(echo.HeaderAuthorization, authHeader(utils.GenerateJWT(1)))
+	req.Header.Set(echo.HeaderAuthorization, authHeader(utils.GenerateJWT(1, 0)))
 	rec := httptest.NewRecorder()
 	c := e.NewContext(req, rec)
 	c.SetPath("/api/categories/:id")
@@ -231,12 +232,12 @@ func TestUpdateCategoryCaseSuccess(t *testing.T) {
 }
 
 func TestDeleteCategoryCaseSuccess(t *testing.T) {
-	tearDown()
-	setup()
+	t.Parallel()
+	h, e := setupSQLite(t)
 	jwtMiddleware := middleware.JWT(utils.JWTSecret)
 	req := httptest.NewRequest(echo.DELETE, "/api/categories/:id", nil)
 	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
-	req.Header.Set(echo.HeaderAuthorization, authHeader(utils.GenerateJWT(1)))
+	req.Header.Set(echo.HeaderAuthorization, authHeader(utils.GenerateJWT(1, 0)))
 	rec := httptest.NewRecorder()
 	c := e.NewContext(req, rec)
 	c.SetPath("/api/categories/:id")
@@ -248,3 +249,135 @@ func TestDeleteCategoryCaseSuccess(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, http.StatusOK, rec.Code)
 }
+
+func TestGetProductCaseTimeout(t *testing.T) {
+	t.Parallel()
+	h, e := setup(t)
+	ctx, cancel := context.WithTimeout(context.Background(), -time.Second)
+	defer cancel()
+	req := httptest.NewRequest(echo.GET, "/api/products/:slug", nil).WithContext(ctx)
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetPath("/api/products/:slug")
+	c.SetParamNames("slug")
+	c.SetParamValues("product1-slug")
+
+	err := h.GetProduct(c)
+	if assert.Error(t, err) {
+		e.HTTPErrorHandler(err, c)
+	}
+	if assert.Equal(t, http.StatusGatewayTimeout, rec.Code) {
+		p := problemResponse(t, rec)
+		assert.Equal(t, utils.CodeTimeout, p.Code)
+	}
+}
+
+func TestListProductsCaseClientClosed(t *testing.T) {
+	t.Parallel()
+	h, e := setup(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := httptest.NewRequest(echo.GET, "/api/products", nil).WithContext(ctx)
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+
+	err := h.Products(c)
+	if assert.Error(t, err) {
+		e.HTTPErrorHandler(err, c)
+	}
+	if assert.Equal(t, utils.StatusClientClosedRequest, rec.Code) {
+		p := problemResponse(t, rec)
+		assert.Equal(t, utils.CodeClientClosed, p.Code)
+	}
+}
+
+func TestTimeoutMiddleware(t *testing.T) {
+	t.Parallel()
+	e := router.New()
+	req := httptest.NewRequest(echo.GET, "/api/products", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	err := middleware.Timeout(time.Millisecond)(func(c echo.Context) error {
+		<-c.Request().Context().Done()
+		return nil
+	})(c)
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestListProductsByCategoryCaseSuccess(t *testing.T) {
+	t.Parallel()
+	h, e := setup(t)
+	req := httptest.NewRequest(echo.GET, "/api/products?category=category1", nil)
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+
+	assert.NoError(t, h.Products(c))
+	if assert.Equal(t, http.StatusOK, rec.Code) {
+		var aa productListResponse
+		err := json.Unmarshal(rec.Body.Bytes(), &aa)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, aa.ProductsCount)
+	}
+}
+
+func TestListProductsBySearchCaseSuccess(t *testing.T) {
+	t.Parallel()
+	h, e := setup(t)
+	req := httptest.NewRequest(echo.GET, "/api/products?search=PRODUCT2", nil)
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+
+	assert.NoError(t, h.Products(c))
+	if assert.Equal(t, http.StatusOK, rec.Code) {
+		var aa productListResponse
+		err := json.Unmarshal(rec.Body.Bytes(), &aa)
+		assert.NoError(t, err)
+		if assert.Equal(t, 1, aa.ProductsCount) {
+			assert.Equal(t, "product2 title", aa.Products[0].Title)
+		}
+	}
+}
+
+func TestUpdateProductCaseNotOwner(t *testing.T) {
+	t.Parallel()
+	h, e := setup(t)
+	var (
+		reqJSON = `{"product":{"title":"stolen"}}`
+	)
+	jwtMiddleware := middleware.JWT(utils.JWTSecret)
+	req := httptest.NewRequest(echo.PUT, "/api/products/:slug", strings.NewReader(reqJSON))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set(echo.HeaderAuthorization, authHeader(utils.GenerateJWT(2, 0)))
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetPath("/api/products/:slug")
+	c.SetParamNames("slug")
+	c.SetParamValues("product1-slug")
+	err := jwtMiddleware(func(context echo.Context) error {
+		return h.UpdateProduct(c)
+	})(c)
+	if assert.Error(t, err) {
+		e.HTTPErrorHandler(err, c)
+	}
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestUpdateProductKeepsOmittedFields(t *testing.T) {
+	t.Parallel()
+	h, e := setup(t)
+	rec := patch(e, h.PatchProduct, MIMEApplicationMergePatchJSON,
+		`{"product":{"image":"http://example.com/p1.png"}}`, "slug", "product1-slug")
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	_, _, err := updateProduct(h, e, "product1-title", `{"product":{"description":"put"}}`, "")
+	assert.NoError(t, err)
+	rec = getProduct(t, h, e, "product1-title", "")
+	m := responseMap(rec.Body.Bytes(), "product")
+	assert.Equal(t, "put", m["description"])
+	assert.Equal(t, "http://example.com/p1.png", m["image"])
+	assert.ElementsMatch(t, []interface{}{"category1", "category2"}, m["categoryList"])
+}
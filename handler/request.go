@@ -1,7 +1,9 @@
 package handler
 
 import (
-	"github.com/gosimple/slug"
+	"encoding/json"
+	"strings"
+
 	"github.com/labstack/echo/v4"
 	"github.com/sumitalp/productcatalog/models"
 )
@@ -23,19 +25,14 @@ func (r *userRegisterRequest) bind(c echo.Context, u *models.User) error {
 	}
 	u.Username = r.User.Username
 	u.Email = r.User.Email
-	h, err := u.HashPassword(r.User.Password)
-	if err != nil {
-		return err
-	}
-	u.Password = h
 	return nil
 }
 
 type userUpdateRequest struct {
 	User struct {
-		Username string `json:"username" xml:"username"`
-		Email    string `json:"email" validate:"email" xml:"email"`
-		Password string `json:"password" xml:"password"`
+		Username string `json:"username" validate:"required" xml:"username"`
+		Email    string `json:"email" validate:"required,email" xml:"email"`
+		Password string `json:"password" validate:"required" xml:"password"`
 		Bio      string `json:"bio" xml:"bio"`
 		Image    string `json:"image" xml:"image"`
 	} `json:"user" xml:"user"`
@@ -64,18 +61,24 @@ func (r *userUpdateRequest) bind(c echo.Context, u *models.User) error {
 	if err := c.Validate(r); err != nil {
 		return err
 	}
+	r.apply(u)
+	return nil
+}
+
+func (r *userUpdateRequest) patch(c echo.Context, u *models.User) error {
+	if err := patchRequest(c, r); err != nil {
+		return err
+	}
+	r.apply(u)
+	return nil
+}
+
+func (r *userUpdateRequest) apply(u *models.User) {
 	u.Username = r.User.Username
 	u.Email = r.User.Email
-	if r.User.Password != u.Password {
-		h, err := u.HashPassword(r.User.Password)
-		if err != nil {
-			return err
-		}
-		u.Password = h
-	}
+	u.Password = r.User.Password
 	u.Bio = &r.User.Bio
 	u.Image = &r.User.Image
-	return nil
 }
 
 type userLoginRequest struct {
@@ -95,13 +98,113 @@ func (r *userLoginRequest) bind(c echo.Context) error {
 	return nil
 }
 
+type userForgotPasswordRequest struct {
+	User struct {
+		Email string `json:"email" validate:"required,email" xml:"email"`
+	} `json:"user" xml:"user"`
+}
+
+func (r *userForgotPasswordRequest) bind(c echo.Context) error {
+	if err := c.Bind(r); err != nil {
+		return err
+	}
+	return c.Validate(r)
+}
+
+type userResetPasswordRequest struct {
+	User struct {
+		// Token is the one of the link mailed by forgot password.
+		Token    string `json:"token" validate:"required" xml:"token"`
+		Password string `json:"password" validate:"required" xml:"password"`
+	} `json:"user" xml:"user"`
+}
+
+func (r *userResetPasswordRequest) bind(c echo.Context) error {
+	if err := c.Bind(r); err != nil {
+		return err
+	}
+	return c.Validate(r)
+}
+
+type userVerifyEmailRequest struct {
+	User struct {
+		// Token is the one of the link mailed on sign up or email change.
+		Token string `json:"token" validate:"required" xml:"token"`
+	} `json:"user" xml:"user"`
+}
+
+func (r *userVerifyEmailRequest) bind(c echo.Context) error {
+	if err := c.Bind(r); err != nil {
+		return err
+	}
+	return c.Validate(r)
+}
+
+type userLoginTwoFactorRequest struct {
+	User struct {
+		// Challenge is the one answered by login.
+		Challenge string `json:"challenge" validate:"required" xml:"challenge"`
+		// Code is a code of the authenticator app or a recovery code.
+		Code string `json:"code" validate:"required" xml:"code"`
+	} `json:"user" xml:"user"`
+}
+
+func (r *userLoginTwoFactorRequest) bind(c echo.Context) error {
+	if err := c.Bind(r); err != nil {
+		return err
+	}
+	return c.Validate(r)
+}
+
+type userEnableRequiredTwoFactorRequest struct {
+	User struct {
+		Email    string `json:"email" validate:"required,email" xml:"email"`
+		Password string `json:"password" validate:"required" xml:"password"`
+		Code     string `json:"code" validate:"required" xml:"code"`
+	} `json:"user" xml:"user"`
+}
+
+func (r *userEnableRequiredTwoFactorRequest) bind(c echo.Context) error {
+	if err := c.Bind(r); err != nil {
+		return err
+	}
+	return c.Validate(r)
+}
+
+type userTwoFactorCodeRequest struct {
+	User struct {
+		Code string `json:"code" validate:"required" xml:"code"`
+	} `json:"user" xml:"user"`
+}
+
+func (r *userTwoFactorCodeRequest) bind(c echo.Context) error {
+	if err := c.Bind(r); err != nil {
+		return err
+	}
+	return c.Validate(r)
+}
+
+type userDisableTwoFactorRequest struct {
+	User struct {
+		Password string `json:"password" validate:"required" xml:"password"`
+		Code     string `json:"code" validate:"required" xml:"code"`
+	} `json:"user" xml:"user"`
+}
+
+func (r *userDisableTwoFactorRequest) bind(c echo.Context) error {
+	if err := c.Bind(r); err != nil {
+		return err
+	}
+	return c.Validate(r)
+}
+
 // Product
 type productCreateRequest struct {
 	Product struct {
 		Title       string   `json:"title" validate:"required" xml:"title"`
 		Description string   `json:"description" validate:"required" xml:"description"`
-		Image        string   `json:"image" xml:"image"`
-		Categories        []string `json:"categoryList, omitempty" xml:"categories>category"`
+		Image       string   `json:"image" xml:"image"`
+		Categories  []string `json:"categoryList,omitempty" xml:"categories>category"`
 	} `json:"product" xml:"product"`
 }
 
@@ -113,7 +216,6 @@ func (r *productCreateRequest) bind(c echo.Context, a *models.Product) error {
 		return err
 	}
 	a.Title = r.Product.Title
-	a.Slug = slug.Make(r.Product.Title)
 	a.Description = r.Product.Description
 	a.Image = r.Product.Image
 	if r.Product.Categories != nil {
@@ -126,16 +228,21 @@ func (r *productCreateRequest) bind(c echo.Context, a *models.Product) error {
 
 type productUpdateRequest struct {
 	Product struct {
-		Title       string   `json:"title" xml:"title"`
+		Title       string   `json:"title" validate:"required" xml:"title"`
 		Description string   `json:"description" xml:"description"`
-		Image        string   `json:"image" xml:"image"`
-		Categories        []string `json:"categoriesList" xml:"categories>category"`
+		Image       string   `json:"image" xml:"image"`
+		Categories  []string `json:"categoriesList" validate:"dive,required" xml:"categories>category"`
 	} `json:"product" xml:"product"`
 }
 
 func (r *productUpdateRequest) populate(a *models.Product) {
 	r.Product.Title = a.Title
 	r.Product.Description = a.Description
+	r.Product.Image = a.Image
+	r.Product.Categories = make([]string, 0, len(a.Categories))
+	for _, t := range a.Categories {
+		r.Product.Categories = append(r.Product.Categories, t.Category)
+	}
 }
 
 func (r *productUpdateRequest) bind(c echo.Context, a *models.Product) error {
@@ -145,18 +252,33 @@ func (r *productUpdateRequest) bind(c echo.Context, a *models.Product) error {
 	if err := c.Validate(r); err != nil {
 		return err
 	}
+	r.apply(a)
+	return nil
+}
+
+func (r *productUpdateRequest) patch(c echo.Context, a *models.Product) error {
+	if err := patchRequest(c, r); err != nil {
+		return err
+	}
+	r.apply(a)
+	return nil
+}
+
+func (r *productUpdateRequest) apply(a *models.Product) {
 	a.Title = r.Product.Title
-	a.Slug = slug.Make(a.Title)
 	a.Description = r.Product.Description
 	a.Image = r.Product.Image
-	return nil
+	a.Categories = make([]models.Category, 0, len(r.Product.Categories))
+	for _, t := range r.Product.Categories {
+		a.Categories = append(a.Categories, models.Category{Category: t})
+	}
 }
 
 // Category
 type categoryCreateRequest struct {
 	Category struct {
-		Title       string   `json:"title" validate:"required" xml:"title"`
-		Description string   `json:"description" xml:"description"`
+		Title       string `json:"title" validate:"required" xml:"title"`
+		Description string `json:"description" xml:"description"`
 	} `json:"category" xml:"category"`
 }
 
@@ -175,8 +297,8 @@ func (r *categoryCreateRequest) bind(c echo.Context, a *models.Category) error {
 
 type categoryUpdateRequest struct {
 	Category struct {
-		Title       string   `json:"title" xml:"title"`
-		Description string   `json:"description" xml:"description"`
+		Title       string `json:"title" validate:"required" xml:"title"`
+		Description string `json:"description" xml:"description"`
 	} `json:"category" xml:"category"`
 }
 
@@ -192,7 +314,90 @@ func (r *categoryUpdateRequest) bind(c echo.Context, a *models.Category) error {
 	if err := c.Validate(r); err != nil {
 		return err
 	}
+	r.apply(a)
+	return nil
+}
+
+func (r *categoryUpdateRequest) patch(c echo.Context, a *models.Category) error {
+	if err := patchRequest(c, r); err != nil {
+		return err
+	}
+	r.apply(a)
+	return nil
+}
+
+func (r *categoryUpdateRequest) apply(a *models.Category) {
 	a.Category = r.Category.Title
 	a.Description = r.Category.Description
+}
+
+// Webhook
+type webhookCreateRequest struct {
+	Webhook struct {
+		URL    string   `json:"url" validate:"required,url" xml:"url"`
+		Events []string `json:"events" validate:"required,min=1,dive,oneof=* product.* category.* product.created product.updated product.deleted category.created category.updated category.deleted" xml:"events>event"`
+		// Secret is generated when left empty.
+		Secret string `json:"secret,omitempty" validate:"omitempty,min=16" xml:"secret,omitempty"`
+	} `json:"webhook" xml:"webhook"`
+}
+
+func (r *webhookCreateRequest) bind(c echo.Context, s *models.WebhookSubscription) error {
+	if err := c.Bind(r); err != nil {
+		return err
+	}
+	if err := c.Validate(r); err != nil {
+		return err
+	}
+	s.URL = r.Webhook.URL
+	s.Events = strings.Join(r.Webhook.Events, " ")
+	s.Secret = r.Webhook.Secret
 	return nil
-}
\ No newline at end of file
+}
+
+type webhookUpdateRequest struct {
+	Webhook struct {
+		URL    string   `json:"url" validate:"required,url" xml:"url"`
+		Events []string `json:"events" validate:"required,min=1,dive,oneof=* product.* category.* product.created product.updated product.deleted category.created category.updated category.deleted" xml:"events>event"`
+		// Active re-enables a disabled webhook when set.
+		Active bool `json:"active" xml:"active"`
+	} `json:"webhook" xml:"webhook"`
+}
+
+func (r *webhookUpdateRequest) populate(s *models.WebhookSubscription) {
+	r.Webhook.URL = s.URL
+	r.Webhook.Events = s.EventTypes()
+	r.Webhook.Active = s.Active
+}
+
+func (r *webhookUpdateRequest) bind(c echo.Context, s *models.WebhookSubscription) error {
+	if err := c.Bind(r); err != nil {
+		return err
+	}
+	if err := c.Validate(r); err != nil {
+		return err
+	}
+	s.URL = r.Webhook.URL
+	s.Events = strings.Join(r.Webhook.Events, " ")
+	s.Active = r.Webhook.Active
+	return nil
+}
+
+// Batch
+type batchRequest struct {
+	Transactional bool              `json:"transactional" xml:"transactional"`
+	Operations    []*batchOperation `json:"operations" validate:"required,min=1,max=100,dive" xml:"operations>operation"`
+}
+
+type batchOperation struct {
+	Method  string            `json:"method" validate:"required,oneof=GET POST PUT PATCH DELETE" xml:"method"`
+	Path    string            `json:"path" validate:"required,startswith=/" xml:"path"`
+	Headers map[string]string `json:"headers,omitempty" xml:"-"`
+	Body    json.RawMessage   `json:"body,omitempty" xml:"-"`
+}
+
+func (r *batchRequest) bind(c echo.Context) error {
+	if err := c.Bind(r); err != nil {
+		return err
+	}
+	return c.Validate(r)
+}
@@ -1,20 +1,24 @@
 package handler
 
 import (
+	"encoding/json"
 	"time"
+
 	"github.com/labstack/echo/v4"
 	"github.com/sumitalp/productcatalog/models"
-	"github.com/sumitalp/productcatalog/utils"
 	"github.com/sumitalp/productcatalog/user"
+	"github.com/sumitalp/productcatalog/utils"
 )
 
 type userResponse struct {
 	User struct {
-		Username string  `json:"username" xml:"username"`
-		Email    string  `json:"email" xml:"email"`
-		Bio      *string `json:"bio" xml:"bio"`
-		Image    *string `json:"image" xml:"image"`
-		Token    string  `json:"token" xml:"token"`
+		Username         string  `json:"username" xml:"username"`
+		Email            string  `json:"email" xml:"email"`
+		EmailVerified    bool    `json:"emailVerified" xml:"emailVerified"`
+		TwoFactorEnabled bool    `json:"twoFactorEnabled" xml:"twoFactorEnabled"`
+		Bio              *string `json:"bio" xml:"bio"`
+		Image            *string `json:"image" xml:"image"`
+		Token            string  `json:"token" xml:"token"`
 	} `json:"user" xml:"user"`
 }
 
@@ -22,24 +26,68 @@ func newUserResponse(u *models.User) *userResponse {
 	r := new(userResponse)
 	r.User.Username = u.Username
 	r.User.Email = u.Email
+	r.User.EmailVerified = u.EmailVerifiedAt != nil
+	r.User.TwoFactorEnabled = u.TwoFactorEnabled()
 	r.User.Bio = u.Bio
 	r.User.Image = u.Image
-	r.User.Token = utils.GenerateJWT(u.ID)
+	r.User.Token = utils.GenerateJWT(u.ID, u.TokenGeneration)
+	return r
+}
+
+// loginChallengeResponse answers the login of a user who must also give a
+// code.
+type loginChallengeResponse struct {
+	Challenge struct {
+		Token string `json:"token" xml:"token"`
+		// ExpiresIn is the number of seconds the challenge is valid for.
+		ExpiresIn int `json:"expiresIn" xml:"expiresIn"`
+	} `json:"challenge" xml:"challenge"`
+}
+
+func newLoginChallengeResponse(token string) *loginChallengeResponse {
+	r := new(loginChallengeResponse)
+	r.Challenge.Token = token
+	r.Challenge.ExpiresIn = int(user.LoginChallengeTTL / time.Second)
+	return r
+}
+
+type twoFactorEnrollmentResponse struct {
+	TwoFactor struct {
+		Secret string `json:"secret" xml:"secret"`
+		// URI is the otpauth URI of the secret, to show as a QR code.
+		URI string `json:"uri" xml:"uri"`
+	} `json:"twoFactor" xml:"twoFactor"`
+}
+
+func newTwoFactorEnrollmentResponse(secret, uri string) *twoFactorEnrollmentResponse {
+	r := new(twoFactorEnrollmentResponse)
+	r.TwoFactor.Secret = secret
+	r.TwoFactor.URI = uri
 	return r
 }
 
+// recoveryCodesResponse lists recovery codes. They are only ever shown
+// once.
+type recoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recoveryCodes" xml:"recoveryCodes>code"`
+}
+
+func newRecoveryCodesResponse(codes []string) *recoveryCodesResponse {
+	return &recoveryCodesResponse{RecoveryCodes: codes}
+}
+
 type productResponse struct {
-	Slug           string    `json:"slug" xml:"slug"`
-	Title          string    `json:"title" xml:"title"`
-	Description    string    `json:"description" xml:"description"`
-	Image           string    `json:"image" xml:"image"`
-	CategoryList        []string  `json:"categoryList" xml:"categories>category"`
-	CreatedAt      time.Time `json:"createdAt" xml:"createdAt"`
-	UpdatedAt      time.Time `json:"updatedAt" xml:"updatedAt"`
-	Owner         struct {
-		Username  string  `json:"username" xml:"username"`
-		Bio       *string `json:"bio" xml:"bio"`
-		Image     *string `json:"image" xml:"image"`
+	Slug         string    `json:"slug" xml:"slug"`
+	Title        string    `json:"title" xml:"title"`
+	Description  string    `json:"description" xml:"description"`
+	Image        string    `json:"image" xml:"image"`
+	CategoryList []string  `json:"categoryList" xml:"categories>category"`
+	CreatedAt    time.Time `json:"createdAt" xml:"createdAt"`
+	UpdatedAt    time.Time `json:"updatedAt" xml:"updatedAt"`
+	Owner        struct {
+		Username string  `json:"username" xml:"username"`
+		Bio      *string `json:"bio" xml:"bio"`
+		Image    *string `json:"image" xml:"image"`
 	} `json:"owner" xml:"owner"`
 }
 
@@ -70,7 +118,7 @@ func newProductResponse(c echo.Context, a *models.Product) *singleProductRespons
 	return &singleProductResponse{ar}
 }
 
-func newProductListResponse(us user.RepositoryInterface, userID uint, products []models.Product, count int) *productListResponse {
+func newProductListResponse(userID uint, products []models.Product, count int) *productListResponse {
 	r := new(productListResponse)
 	r.Products = make([]*productResponse, 0)
 	for _, a := range products {
@@ -98,11 +146,11 @@ func newProductListResponse(us user.RepositoryInterface, userID uint, products [
 
 // Category
 type categoryResponse struct {
-	ID			   uint      `json:"id" xml:"id"`
-	Title          string    `json:"title" xml:"title"`
-	Description    string    `json:"description" xml:"description"`
-	CreatedAt      time.Time `json:"createdAt" xml:"createdAt"`
-	UpdatedAt      time.Time `json:"updatedAt" xml:"updatedAt"`
+	ID          uint      `json:"id" xml:"id"`
+	Title       string    `json:"title" xml:"title"`
+	Description string    `json:"description" xml:"description"`
+	CreatedAt   time.Time `json:"createdAt" xml:"createdAt"`
+	UpdatedAt   time.Time `json:"updatedAt" xml:"updatedAt"`
 }
 
 type singleCategoryResponse struct {
@@ -111,7 +159,7 @@ type singleCategoryResponse struct {
 
 type categoryListResponse struct {
 	Categories      []*categoryResponse `json:"categories" xml:"categories>category"`
-	CategoriesCount int                `json:"categoriesCount" xml:"categoriesCount"`
+	CategoriesCount int                 `json:"categoriesCount" xml:"categoriesCount"`
 }
 
 func newCategoryResponse(c echo.Context, a *models.Category) *singleCategoryResponse {
@@ -125,7 +173,7 @@ func newCategoryResponse(c echo.Context, a *models.Category) *singleCategoryResp
 	return &singleCategoryResponse{ar}
 }
 
-func newCategoryListResponse(us user.RepositoryInterface, userID uint, categories []models.Category, count int) *categoryListResponse {
+func newCategoryListResponse(userID uint, categories []models.Category, count int) *categoryListResponse {
 	r := new(categoryListResponse)
 	r.Categories = make([]*categoryResponse, 0)
 	for _, a := range categories {
@@ -142,3 +190,133 @@ func newCategoryListResponse(us user.RepositoryInterface, userID uint, categorie
 	return r
 }
 
+// Webhook
+type webhookResponse struct {
+	ID     uint     `json:"id" xml:"id"`
+	URL    string   `json:"url" xml:"url"`
+	Events []string `json:"events" xml:"events>event"`
+	// Secret is only shown when the webhook is created.
+	Secret              string     `json:"secret,omitempty" xml:"secret,omitempty"`
+	Active              bool       `json:"active" xml:"active"`
+	ConsecutiveFailures int        `json:"consecutiveFailures" xml:"consecutiveFailures"`
+	DisabledAt          *time.Time `json:"disabledAt" xml:"disabledAt"`
+	CreatedAt           time.Time  `json:"createdAt" xml:"createdAt"`
+	UpdatedAt           time.Time  `json:"updatedAt" xml:"updatedAt"`
+}
+
+type singleWebhookResponse struct {
+	Webhook *webhookResponse `json:"webhook" xml:"webhook"`
+}
+
+type webhookListResponse struct {
+	Webhooks      []*webhookResponse `json:"webhooks" xml:"webhooks>webhook"`
+	WebhooksCount int                `json:"webhooksCount" xml:"webhooksCount"`
+}
+
+func newWebhook(s *models.WebhookSubscription) *webhookResponse {
+	return &webhookResponse{
+		ID:                  s.ID,
+		URL:                 s.URL,
+		Events:              s.EventTypes(),
+		Active:              s.Active,
+		ConsecutiveFailures: s.ConsecutiveFailures,
+		DisabledAt:          s.DisabledAt,
+		CreatedAt:           s.CreatedAt,
+		UpdatedAt:           s.UpdatedAt,
+	}
+}
+
+func newWebhookResponse(s *models.WebhookSubscription) *singleWebhookResponse {
+	return &singleWebhookResponse{newWebhook(s)}
+}
+
+func newWebhookListResponse(subscriptions []models.WebhookSubscription, count int) *webhookListResponse {
+	r := new(webhookListResponse)
+	r.Webhooks = make([]*webhookResponse, 0)
+	for i := range subscriptions {
+		r.Webhooks = append(r.Webhooks, newWebhook(&subscriptions[i]))
+	}
+	r.WebhooksCount = count
+	return r
+}
+
+type deliveryResponse struct {
+	ID        uint   `json:"id" xml:"id"`
+	EventID   string `json:"eventId" xml:"eventId"`
+	EventType string `json:"eventType" xml:"eventType"`
+	Status    string `json:"status" xml:"status"`
+	Attempts  int    `json:"attempts" xml:"attempts"`
+	// NextAttemptAt is only set while the delivery is pending.
+	NextAttemptAt  *time.Time `json:"nextAttemptAt" xml:"nextAttemptAt"`
+	LastStatusCode int        `json:"lastStatusCode,omitempty" xml:"lastStatusCode,omitempty"`
+	LastError      string     `json:"lastError,omitempty" xml:"lastError,omitempty"`
+	DeliveredAt    *time.Time `json:"deliveredAt" xml:"deliveredAt"`
+	ReplayOf       *uint      `json:"replayOf" xml:"replayOf"`
+	CreatedAt      time.Time  `json:"createdAt" xml:"createdAt"`
+}
+
+type singleDeliveryResponse struct {
+	Delivery *deliveryResponse `json:"delivery" xml:"delivery"`
+}
+
+type deliveryListResponse struct {
+	Deliveries      []*deliveryResponse `json:"deliveries" xml:"deliveries>delivery"`
+	DeliveriesCount int                 `json:"deliveriesCount" xml:"deliveriesCount"`
+}
+
+func newDelivery(d *models.WebhookDelivery) *deliveryResponse {
+	r := &deliveryResponse{
+		ID:             d.ID,
+		EventID:        d.EventID,
+		EventType:      d.EventType,
+		Status:         d.Status,
+		Attempts:       d.Attempts,
+		LastStatusCode: d.LastStatusCode,
+		LastError:      d.LastError,
+		DeliveredAt:    d.DeliveredAt,
+		ReplayOf:       d.ReplayOf,
+		CreatedAt:      d.CreatedAt,
+	}
+	if d.Status == models.DeliveryPending {
+		next := d.NextAttemptAt
+		r.NextAttemptAt = &next
+	}
+	return r
+}
+
+func newDeliveryResponse(d *models.WebhookDelivery) *singleDeliveryResponse {
+	return &singleDeliveryResponse{newDelivery(d)}
+}
+
+func newDeliveryListResponse(deliveries []models.WebhookDelivery, count int) *deliveryListResponse {
+	r := new(deliveryListResponse)
+	r.Deliveries = make([]*deliveryResponse, 0)
+	for i := range deliveries {
+		r.Deliveries = append(r.Deliveries, newDelivery(&deliveries[i]))
+	}
+	r.DeliveriesCount = count
+	return r
+}
+
+// resultResponse acknowledges a request which has nothing else to report,
+// such as a deletion.
+type resultResponse struct {
+	Result string `json:"result" xml:"result"`
+}
+
+func newResultResponse() *resultResponse {
+	return &resultResponse{Result: "ok"}
+}
+
+type batchResponse struct {
+	Transactional bool `json:"transactional" xml:"transactional"`
+	// Committed is only reported in transactional mode.
+	Committed *bool          `json:"committed,omitempty" xml:"committed,omitempty"`
+	Results   []*batchResult `json:"results" xml:"results>result"`
+}
+
+type batchResult struct {
+	Status  int               `json:"status" xml:"status"`
+	Headers map[string]string `json:"headers,omitempty" xml:"-"`
+	Body    json.RawMessage   `json:"body,omitempty" xml:"-"`
+}
@@ -1,20 +1,71 @@
 package handler
 
 import (
+	"time"
+
 	"github.com/labstack/echo/v4"
+	"github.com/sumitalp/productcatalog/ratelimit"
 	"github.com/sumitalp/productcatalog/router/middleware"
 	"github.com/sumitalp/productcatalog/utils"
 )
 
+// Deadlines applied to the request context of each route. Reads are
+// expected to be quick; writes may wait on the SQLite write lock.
+const (
+	readTimeout  = 5 * time.Second
+	writeTimeout = 10 * time.Second
+	authTimeout  = 10 * time.Second
+	batchTimeout = 60 * time.Second
+)
+
 func (h *Handler) Register(v1 *echo.Group) {
-	jwtMiddleware := middleware.JWT(utils.JWTSecret)
+	// Tokens are revoked when their user changes their password.
+	jwtMiddleware := middleware.JWTWithConfig(middleware.JWTConfig{
+		SigningKey: utils.JWTSecret,
+		Validator:  h.userService.TokenValid,
+	})
+	read := middleware.Timeout(readTimeout)
+	write := middleware.Timeout(writeTimeout)
+	idempotent := middleware.Idempotency(h.idempotencyKeys)
+
+	// Guests are limited by address and users by id. Writes share one
+	// quota per user, whatever the resource; each operation of a batch
+	// counts against the quota of its route. Logins and the uses of mailed
+	// tokens share a quota, as do the requests sending emails.
+	signUpLimit := middleware.RateLimit(h.rateLimits.SignUp, middleware.KeyByIP)
+	loginLimit := middleware.RateLimit(h.rateLimits.Login, middleware.KeyByIP)
+	writeLimiter := h.writeLimiter
+	if writeLimiter == nil {
+		writeLimiter = ratelimit.NewLimiter(h.rateLimits.Write)
+	}
+	writeLimit := middleware.RateLimitWithConfig(middleware.RateLimitConfig{Limiter: writeLimiter, Key: middleware.KeyByUser})
+	mailLimit := middleware.RateLimit(h.rateLimits.Mail, middleware.KeyByUser)
+
+	// Every POST route is idempotent except those answering with
+	// credentials: tokens, TOTP secrets and recovery codes must not be
+	// stored to be replayed, least of all for guests, who share one key
+	// space.
 	guestUsers := v1.Group("/users")
-	guestUsers.POST("", h.SignUp)
-	guestUsers.POST("/login", h.Login)
+	guestUsers.POST("", h.SignUp, signUpLimit, middleware.Timeout(authTimeout))
+	guestUsers.POST("/login", h.Login, loginLimit, middleware.Timeout(authTimeout))
+	guestUsers.POST("/login/2fa", h.LoginTwoFactor, loginLimit, middleware.Timeout(authTimeout))
+	// Users who must enable two-factor authentication cannot log in to do
+	// so: they authenticate with their password on these.
+	guestUsers.POST("/2fa", h.EnrollRequiredTwoFactor, loginLimit, middleware.Timeout(authTimeout))
+	guestUsers.POST("/2fa/enable", h.EnableRequiredTwoFactor, loginLimit, middleware.Timeout(authTimeout))
+	guestUsers.POST("/password/forgot", h.ForgotPassword, mailLimit, middleware.Timeout(authTimeout), idempotent)
+	guestUsers.POST("/password/reset", h.ResetPassword, loginLimit, middleware.Timeout(authTimeout), idempotent)
+	guestUsers.POST("/verify", h.VerifyEmail, loginLimit, middleware.Timeout(authTimeout), idempotent)
 
 	user := v1.Group("/user", jwtMiddleware)
-	user.GET("", h.CurrentUser)
-	user.PUT("", h.UpdateUser)
+	user.GET("", h.CurrentUser, read)
+	user.PUT("", h.UpdateUser, writeLimit, write)
+	user.PATCH("", h.PatchUser, writeLimit, write)
+	user.POST("/verification", h.ResendVerification, mailLimit, write, idempotent)
+	user.POST("/2fa", h.EnrollTwoFactor, writeLimit, write)
+	user.POST("/2fa/enable", h.EnableTwoFactor, writeLimit, write)
+	user.POST("/2fa/disable", h.DisableTwoFactor, writeLimit, write, idempotent)
+	user.POST("/2fa/recovery-codes", h.RegenerateRecoveryCodes, writeLimit, write)
 
 	categories := v1.Group("/categories", middleware.JWTWithConfig(
 		middleware.JWTConfig{
@@ -25,13 +76,18 @@ func (h *Handler) Register(v1 *echo.Group) {
 				return false
 			},
 			SigningKey: utils.JWTSecret,
+			Validator:  h.userService.TokenValid,
 		},
 	))
-	categories.POST("", h.CreateCategory)
-	categories.GET("", h.Categories)
-	categories.GET("/:slug", h.GetCategory)
-	categories.PUT("/:slug", h.UpdateCategory)
-	categories.DELETE("/:slug", h.DeleteCategory)
+	categories.POST("", h.CreateCategory, writeLimit, write, idempotent)
+	categories.GET("", h.Categories, read)
+	// Categories are addressed by their numeric id, which is what the
+	// handlers read; the routes used to name the parameter :slug, which
+	// left it empty and answered 400 to every request.
+	categories.GET("/:id", h.GetCategory, read)
+	categories.PUT("/:id", h.UpdateCategory, writeLimit, write)
+	categories.PATCH("/:id", h.PatchCategory, writeLimit, write)
+	categories.DELETE("/:id", h.DeleteCategory, writeLimit, write)
 
 	products := v1.Group("/products", middleware.JWTWithConfig(
 		middleware.JWTConfig{
@@ -42,11 +98,41 @@ func (h *Handler) Register(v1 *echo.Group) {
 				return false
 			},
 			SigningKey: utils.JWTSecret,
+			Validator:  h.userService.TokenValid,
 		},
 	))
-	products.POST("", h.CreateProduct)
-	products.GET("", h.Products)
-	products.GET("/:slug", h.GetProduct)
-	products.PUT("/:slug", h.UpdateProduct)
-	products.DELETE("/:slug", h.DeleteProduct)
+	products.POST("", h.CreateProduct, writeLimit, write, idempotent)
+	products.GET("", h.Products, read)
+	products.GET("/:slug", h.GetProduct, read)
+	products.PUT("/:slug", h.UpdateProduct, writeLimit, write)
+	products.PATCH("/:slug", h.PatchProduct, writeLimit, write)
+	products.DELETE("/:slug", h.DeleteProduct, writeLimit, write)
+
+	webhooks := v1.Group("/webhooks", jwtMiddleware)
+	webhooks.POST("", h.CreateWebhook, writeLimit, write, idempotent)
+	webhooks.GET("", h.Webhooks, read)
+	webhooks.GET("/:id", h.GetWebhook, read)
+	webhooks.PUT("/:id", h.UpdateWebhook, writeLimit, write)
+	webhooks.DELETE("/:id", h.DeleteWebhook, writeLimit, write)
+	webhooks.GET("/:id/deliveries", h.WebhookDeliveries, read)
+	webhooks.POST("/:id/deliveries/:deliveryID/replay", h.ReplayWebhookDelivery, writeLimit, write, idempotent)
+
+	// The stream has no timeout: it lasts until the client leaves or its
+	// token expires or is revoked. EventSource cannot set headers, so the
+	// token may be passed as a query parameter too.
+	v1.GET("/stream", h.Stream, middleware.JWTWithConfig(
+		middleware.JWTConfig{
+			SigningKey: utils.JWTSecret,
+			Validator:  h.userService.TokenValid,
+			QueryParam: "token",
+		},
+	))
+
+	// Operations of a batch authenticate on their own, with the
+	// Authorization header of the batch request.
+	v1.POST("/batch", h.Batch, middleware.Timeout(batchTimeout), idempotent)
+
+	// Every route above must be described in the routes table of openapi.go.
+	v1.GET("/openapi.json", h.OpenAPI, read)
+	v1.GET("/docs", h.SwaggerUI, read)
 }
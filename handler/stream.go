@@ -0,0 +1,111 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sumitalp/productcatalog/router/middleware"
+	"github.com/sumitalp/productcatalog/stream"
+)
+
+const (
+	MIMETextEventStream = "text/event-stream"
+	HeaderLastEventID   = "Last-Event-ID"
+)
+
+// Timings of the event stream. heartbeatInterval is a variable for tests.
+var heartbeatInterval = 15 * time.Second
+
+const streamRetry = 3 * time.Second
+
+// Stream serves the changes of products and categories as Server-Sent
+// Events, filtered by the category and owner query parameters. Clients
+// reconnecting with a Last-Event-ID header first get the events they
+// missed; when those are no longer known they get a reset event instead,
+// after which they should reload what they display. The stream ends when
+// the token of the request expires, or within a heartbeat of its
+// revocation: the token is checked again with every heartbeat.
+func (h *Handler) Stream(c echo.Context) error {
+	filter := stream.Filter{
+		Category: c.QueryParam("category"),
+		Owner:    c.QueryParam("owner"),
+	}
+	sub, backlog, complete := h.streamBroker.Subscribe(filter, c.Request().Header.Get(HeaderLastEventID))
+	defer sub.Close()
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, MIMETextEventStream)
+	res.Header().Set("Cache-Control", "no-cache")
+	// Keep proxies such as nginx from buffering the stream.
+	res.Header().Set("X-Accel-Buffering", "no")
+	res.WriteHeader(http.StatusOK)
+	if _, err := fmt.Fprintf(res, "retry: %d\n\n", streamRetry/time.Millisecond); err != nil {
+		return nil
+	}
+	if !complete {
+		if err := writeSSE(res, sub.Start, "reset", []byte(`{}`)); err != nil {
+			return nil
+		}
+	}
+	for _, m := range backlog {
+		if err := writeMessage(res, m); err != nil {
+			return nil
+		}
+	}
+	res.Flush()
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+	token, _ := middleware.RequestToken(c)
+	var expired <-chan time.Time
+	if token != nil && !token.Expiry.IsZero() {
+		timer := time.NewTimer(time.Until(token.Expiry))
+		defer timer.Stop()
+		expired = timer.C
+	}
+	for {
+		select {
+		case <-c.Request().Context().Done():
+			return nil
+		case <-expired:
+			return nil
+		case m, ok := <-sub.C:
+			if !ok {
+				// Dropped for falling behind, or the broker closed for
+				// shutdown: the client reconnects and resumes from the
+				// log, or from the start on another process.
+				return nil
+			}
+			if err := writeMessage(res, m); err != nil {
+				return nil
+			}
+		case <-heartbeat.C:
+			if token != nil && token.Validate(c.Request().Context(), h.userService.TokenValid) != nil {
+				return nil
+			}
+			if _, err := io.WriteString(res, ": heartbeat\n\n"); err != nil {
+				return nil
+			}
+		}
+		res.Flush()
+	}
+}
+
+func writeMessage(w io.Writer, m *stream.Message) error {
+	data, err := json.Marshal(m.Event)
+	if err != nil {
+		return err
+	}
+	return writeSSE(w, m.ID, m.Event.Type, data)
+}
+
+// writeSSE writes one event. data must hold no newline, which is the case
+// of JSON encoded by encoding/json.
+func writeSSE(w io.Writer, id, typ string, data []byte) error {
+	_, err := fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", id, typ, data)
+	return err
+}
@@ -0,0 +1,182 @@
+package handler
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/sumitalp/productcatalog/event"
+	"github.com/sumitalp/productcatalog/utils"
+)
+
+// sseEvent is one event read from a stream. Comments are reported with an
+// empty type.
+type sseEvent struct {
+	id, typ, data, comment string
+}
+
+// openStream connects to the stream of the server running e with the given
+// query and headers and returns its events as they arrive.
+func openStream(t *testing.T, srv *httptest.Server, query string, header http.Header) (*http.Response, <-chan sseEvent) {
+	req, err := http.NewRequest(echo.GET, srv.URL+"/api/stream"+query, nil)
+	require.NoError(t, err)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	events := make(chan sseEvent, 10)
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		close(events)
+		return res, events
+	}
+	go func() {
+		defer close(events)
+		var e sseEvent
+		scanner := bufio.NewScanner(res.Body)
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case line == "":
+				if e != (sseEvent{}) {
+					events <- e
+				}
+				e = sseEvent{}
+			case strings.HasPrefix(line, ":"):
+				e.comment = strings.TrimSpace(line[1:])
+			case strings.HasPrefix(line, "id: "):
+				e.id = line[4:]
+			case strings.HasPrefix(line, "event: "):
+				e.typ = line[7:]
+			case strings.HasPrefix(line, "data: "):
+				e.data = line[6:]
+			}
+		}
+	}()
+	t.Cleanup(func() { res.Body.Close() })
+	return res, events
+}
+
+// next returns the next event of the given type, skipping the others.
+func next(t *testing.T, events <-chan sseEvent, typ string) sseEvent {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case e, ok := <-events:
+			require.True(t, ok, "stream ended")
+			if e.typ == typ {
+				return e
+			}
+		case <-timeout:
+			t.Fatalf("no %s event", typ)
+		}
+	}
+}
+
+func TestStream(t *testing.T) {
+	t.Parallel()
+	h, e := setup(t)
+	h.Register(e.Group("/api"))
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+
+	res, events := openStream(t, srv, "?category=category1&token="+utils.GenerateJWT(1, 0), nil)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, MIMETextEventStream, res.Header.Get(echo.HeaderContentType))
+
+	// Changes outside of the category are filtered out.
+	rec := serveAs(h, e, 1, echo.POST, "/api/products", `{"product":{"title":"Elsewhere","description":"d","categoryList":["category2"]}}`)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	rec = serveAs(h, e, 1, echo.POST, "/api/products", `{"product":{"title":"Streamed","description":"d","categoryList":["category1"]}}`)
+	require.Equal(t, http.StatusCreated, rec.Code)
+
+	created := next(t, events, event.ProductCreated)
+	assert.NotEmpty(t, created.id)
+	var got event.Event
+	require.NoError(t, json.Unmarshal([]byte(created.data), &got))
+	var data event.Product
+	require.NoError(t, got.Decode(&data))
+	assert.Equal(t, "streamed", data.Slug)
+
+	rec = serveAs(h, e, 1, echo.DELETE, "/api/products/streamed", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	deleted := next(t, events, event.ProductDeleted)
+
+	// Reconnecting clients get what they missed.
+	header := http.Header{}
+	header.Set(echo.HeaderAuthorization, authHeader(utils.GenerateJWT(1, 0)))
+	header.Set(HeaderLastEventID, created.id)
+	_, resumed := openStream(t, srv, "?category=category1", header)
+	assert.Equal(t, deleted.id, next(t, resumed, event.ProductDeleted).id)
+
+	// Unknown ids get a reset.
+	header.Set(HeaderLastEventID, "unknown-1")
+	_, reset := openStream(t, srv, "", header)
+	assert.Equal(t, deleted.id, next(t, reset, "reset").id)
+}
+
+func TestStreamAuthorization(t *testing.T) {
+	t.Parallel()
+	h, e := setup(t)
+	h.Register(e.Group("/api"))
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+
+	res, _ := openStream(t, srv, "", nil)
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+	res, _ = openStream(t, srv, "?token=invalid", nil)
+	assert.Equal(t, http.StatusForbidden, res.StatusCode)
+}
+
+func TestStreamHeartbeat(t *testing.T) {
+	interval := heartbeatInterval
+	heartbeatInterval = 10 * time.Millisecond
+	defer func() { heartbeatInterval = interval }()
+	h, e := setup(t)
+	h.Register(e.Group("/api"))
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+
+	_, events := openStream(t, srv, "?token="+utils.GenerateJWT(1, 0), nil)
+	select {
+	case ev := <-events:
+		assert.Equal(t, "heartbeat", ev.comment)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no heartbeat")
+	}
+}
+
+func TestStreamEndsWhenTokenRevoked(t *testing.T) {
+	interval := heartbeatInterval
+	heartbeatInterval = 10 * time.Millisecond
+	defer func() { heartbeatInterval = interval }()
+	h, e := setup(t)
+	h.Register(e.Group("/api"))
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+
+	_, events := openStream(t, srv, "?token="+utils.GenerateJWT(1, 0), nil)
+	assert.Equal(t, "heartbeat", next(t, events, "").comment)
+
+	rec := serveAs(h, e, 1, echo.PUT, "/api/user", `{"user":{"username":"user1","email":"user1@email.io","password":"changed"}}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case _, ok := <-events:
+			if !ok {
+				return
+			}
+		case <-timeout:
+			t.Fatal("stream still open after the password change")
+		}
+	}
+}
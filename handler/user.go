@@ -5,17 +5,16 @@ import (
 
 	"github.com/labstack/echo/v4"
 	"github.com/sumitalp/productcatalog/models"
-	"github.com/sumitalp/productcatalog/utils"
 )
 
 func (h *Handler) SignUp(c echo.Context) error {
 	var u models.User
 	req := &userRegisterRequest{}
 	if err := req.bind(c, &u); err != nil {
-		return c.JSON(http.StatusUnprocessableEntity, utils.NewError(err))
+		return err
 	}
-	if err := h.userStore.Create(&u); err != nil {
-		return c.JSON(http.StatusUnprocessableEntity, utils.NewError(err))
+	if err := h.userService.SignUp(c.Request().Context(), &u, req.User.Password); err != nil {
+		return err
 	}
 	return c.JSON(http.StatusCreated, newUserResponse(&u))
 }
@@ -23,49 +22,180 @@ func (h *Handler) SignUp(c echo.Context) error {
 func (h *Handler) Login(c echo.Context) error {
 	req := &userLoginRequest{}
 	if err := req.bind(c); err != nil {
-		return c.JSON(http.StatusUnprocessableEntity, utils.NewError(err))
+		return err
 	}
-	u, err := h.userStore.GetByEmail(req.User.Email)
+	u, challenge, err := h.userService.Login(c.Request().Context(), req.User.Email, req.User.Password)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, utils.NewError(err))
+		return err
 	}
-	if u == nil {
-		return c.JSON(http.StatusForbidden, utils.AccessForbidden())
+	if challenge != "" {
+		return c.JSON(http.StatusAccepted, newLoginChallengeResponse(challenge))
 	}
-	if !u.CheckPassword(req.User.Password) {
-		return c.JSON(http.StatusForbidden, utils.AccessForbidden())
+	return c.JSON(http.StatusOK, newUserResponse(u))
+}
+
+// LoginTwoFactor completes a login answered with a challenge.
+func (h *Handler) LoginTwoFactor(c echo.Context) error {
+	req := &userLoginTwoFactorRequest{}
+	if err := req.bind(c); err != nil {
+		return err
+	}
+	u, err := h.userService.LoginTwoFactor(c.Request().Context(), req.User.Challenge, req.User.Code)
+	if err != nil {
+		return err
 	}
 	return c.JSON(http.StatusOK, newUserResponse(u))
 }
 
 func (h *Handler) CurrentUser(c echo.Context) error {
-	u, err := h.userStore.GetByID(userIDFromToken(c))
+	u, err := h.userService.Get(c.Request().Context(), userIDFromToken(c))
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, utils.NewError(err))
-	}
-	if u == nil {
-		return c.JSON(http.StatusNotFound, utils.NotFound())
+		return err
 	}
 	return c.JSON(http.StatusOK, newUserResponse(u))
 }
 
 func (h *Handler) UpdateUser(c echo.Context) error {
-	u, err := h.userStore.GetByID(userIDFromToken(c))
+	u, err := h.userService.Update(c.Request().Context(), userIDFromToken(c), func(u *models.User) error {
+		req := newUserUpdateRequest()
+		req.populate(u)
+		return req.bind(c, u)
+	})
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, utils.NewError(err))
+		return err
 	}
-	if u == nil {
-		return c.JSON(http.StatusNotFound, utils.NotFound())
+	return c.JSON(http.StatusOK, newUserResponse(u))
+}
+
+// PatchUser applies a merge patch or JSON patch of the user update
+// document to the current user.
+func (h *Handler) PatchUser(c echo.Context) error {
+	u, err := h.userService.Update(c.Request().Context(), userIDFromToken(c), func(u *models.User) error {
+		req := newUserUpdateRequest()
+		req.populate(u)
+		return req.patch(c, u)
+	})
+	if err != nil {
+		return err
 	}
-	req := newUserUpdateRequest()
-	req.populate(u)
-	if err := req.bind(c, u); err != nil {
-		return c.JSON(http.StatusUnprocessableEntity, utils.NewError(err))
+	return c.JSON(http.StatusOK, newUserResponse(u))
+}
+
+// ForgotPassword mails a password reset link to the account with the
+// given email address. It answers the same whether the account exists or
+// not.
+func (h *Handler) ForgotPassword(c echo.Context) error {
+	req := &userForgotPasswordRequest{}
+	if err := req.bind(c); err != nil {
+		return err
 	}
-	if err := h.userStore.Update(u); err != nil {
-		return c.JSON(http.StatusUnprocessableEntity, utils.NewError(err))
+	if err := h.userService.ForgotPassword(c.Request().Context(), req.User.Email); err != nil {
+		return err
 	}
-	return c.JSON(http.StatusOK, newUserResponse(u))
+	return c.JSON(http.StatusAccepted, newResultResponse())
+}
+
+func (h *Handler) ResetPassword(c echo.Context) error {
+	req := &userResetPasswordRequest{}
+	if err := req.bind(c); err != nil {
+		return err
+	}
+	if err := h.userService.ResetPassword(c.Request().Context(), req.User.Token, req.User.Password); err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, newResultResponse())
+}
+
+func (h *Handler) VerifyEmail(c echo.Context) error {
+	req := &userVerifyEmailRequest{}
+	if err := req.bind(c); err != nil {
+		return err
+	}
+	if err := h.userService.VerifyEmail(c.Request().Context(), req.User.Token); err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, newResultResponse())
+}
+
+// ResendVerification mails the current user a new link to verify their
+// email address.
+func (h *Handler) ResendVerification(c echo.Context) error {
+	if err := h.userService.ResendVerification(c.Request().Context(), userIDFromToken(c)); err != nil {
+		return err
+	}
+	return c.JSON(http.StatusAccepted, newResultResponse())
+}
+
+// EnrollTwoFactor gives the current user a TOTP secret to add to their
+// authenticator app, usually by scanning its URI as a QR code.
+func (h *Handler) EnrollTwoFactor(c echo.Context) error {
+	secret, uri, err := h.userService.EnrollTwoFactor(c.Request().Context(), userIDFromToken(c))
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, newTwoFactorEnrollmentResponse(secret, uri))
+}
+
+func (h *Handler) EnableTwoFactor(c echo.Context) error {
+	req := &userTwoFactorCodeRequest{}
+	if err := req.bind(c); err != nil {
+		return err
+	}
+	codes, err := h.userService.EnableTwoFactor(c.Request().Context(), userIDFromToken(c), req.User.Code)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, newRecoveryCodesResponse(codes))
+}
+
+// EnrollRequiredTwoFactor is EnrollTwoFactor for the users who cannot log
+// in before they enable two-factor authentication, authenticated by their
+// email address and password.
+func (h *Handler) EnrollRequiredTwoFactor(c echo.Context) error {
+	req := &userLoginRequest{}
+	if err := req.bind(c); err != nil {
+		return err
+	}
+	secret, uri, err := h.userService.EnrollRequiredTwoFactor(c.Request().Context(), req.User.Email, req.User.Password)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, newTwoFactorEnrollmentResponse(secret, uri))
+}
+
+func (h *Handler) EnableRequiredTwoFactor(c echo.Context) error {
+	req := &userEnableRequiredTwoFactorRequest{}
+	if err := req.bind(c); err != nil {
+		return err
+	}
+	codes, err := h.userService.EnableRequiredTwoFactor(c.Request().Context(), req.User.Email, req.User.Password, req.User.Code)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, newRecoveryCodesResponse(codes))
+}
+
+func (h *Handler) DisableTwoFactor(c echo.Context) error {
+	req := &userDisableTwoFactorRequest{}
+	if err := req.bind(c); err != nil {
+		return err
+	}
+	if err := h.userService.DisableTwoFactor(c.Request().Context(), userIDFromToken(c), req.User.Password, req.User.Code); err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, newResultResponse())
+}
+
+func (h *Handler) RegenerateRecoveryCodes(c echo.Context) error {
+	req := &userTwoFactorCodeRequest{}
+	if err := req.bind(c); err != nil {
+		return err
+	}
+	codes, err := h.userService.RegenerateRecoveryCodes(c.Request().Context(), userIDFromToken(c), req.User.Code)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, newRecoveryCodesResponse(codes))
 }
 
 func userIDFromToken(c echo.Context) uint {
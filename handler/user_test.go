@@ -1,20 +1,31 @@
 package handler
 
 import (
+	"context"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/labstack/echo/v4"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/sumitalp/productcatalog/mail"
+	"github.com/sumitalp/productcatalog/models"
+	"github.com/sumitalp/productcatalog/ratelimit"
 	"github.com/sumitalp/productcatalog/router/middleware"
+	"github.com/sumitalp/productcatalog/totp"
 	"github.com/sumitalp/productcatalog/utils"
 )
 
 func TestSignUpCaseSuccess(t *testing.T) {
-	tearDown()
-	setup()
+	t.Parallel()
+	h, e := setup(t)
 	var (
 		reqJSON = `{"user":{"username":"alice","email":"alice@email.io","password":"secret"}}`
 	)
@@ -34,8 +45,8 @@ func TestSignUpCaseSuccess(t *testing.T) {
 }
 
 func TestLoginCaseSuccess(t *testing.T) {
-	tearDown()
-	setup()
+	t.Parallel()
+	h, e := setup(t)
 	var (
 		reqJSON = `{"user":{"email":"user1@email.io","password":"secret"}}`
 	)
@@ -53,8 +64,8 @@ func TestLoginCaseSuccess(t *testing.T) {
 }
 
 func TestLoginCaseFailed(t *testing.T) {
-	tearDown()
-	setup()
+	t.Parallel()
+	h, e := setup(t)
 	var (
 		reqJSON = `{"user":{"email":"userx@email.io","password":"secret"}}`
 	)
@@ -62,17 +73,82 @@ func TestLoginCaseFailed(t *testing.T) {
 	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
 	rec := httptest.NewRecorder()
 	c := e.NewContext(req, rec)
-	assert.NoError(t, h.Login(c))
-	assert.Equal(t, http.StatusForbidden, rec.Code)
+	err := h.Login(c)
+	if assert.Error(t, err) {
+		e.HTTPErrorHandler(err, c)
+	}
+	if assert.Equal(t, http.StatusForbidden, rec.Code) {
+		p := problemResponse(t, rec)
+		assert.Equal(t, utils.CodeAccessForbidden, p.Code)
+	}
+}
+
+func login(e *echo.Echo, email, password string) *httptest.ResponseRecorder {
+	reqJSON := `{"user":{"email":"` + email + `","password":"` + password + `"}}`
+	req := httptest.NewRequest(echo.POST, "/api/users/login", strings.NewReader(reqJSON))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestLoginLockout(t *testing.T) {
+	t.Parallel()
+	h, e := setup(t)
+	h.Register(e.Group("/api"))
+	now := time.Now()
+	h.userService.LimitLogins(ratelimit.NewLockoutWithConfig(ratelimit.LockoutConfig{
+		FreeAttempts: 1,
+		Delay:        2 * time.Second,
+		Threshold:    3,
+		Duration:     time.Minute,
+		Now:          func() time.Time { return now },
+	}))
+
+	assert.Equal(t, http.StatusForbidden, login(e, "user1@email.io", "wrong").Code)
+	assert.Equal(t, http.StatusForbidden, login(e, "user1@email.io", "wrong").Code)
+	rec := login(e, "USER1@email.io", "secret")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code, "delayed after failures")
+	assert.Equal(t, "2", rec.Header().Get(middleware.HeaderRetryAfter))
+	assert.Equal(t, utils.CodeTooManyRequests, problemResponse(t, rec).Code)
+
+	now = now.Add(2 * time.Second)
+	assert.Equal(t, http.StatusForbidden, login(e, "user1@email.io", "wrong").Code)
+	rec = login(e, "user1@email.io", "secret")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code, "locked out")
+	assert.Equal(t, "60", rec.Header().Get(middleware.HeaderRetryAfter))
+	assert.Equal(t, http.StatusOK, login(e, "user2@email.io", "secret").Code, "other accounts are not")
+
+	now = now.Add(time.Minute)
+	assert.Equal(t, http.StatusOK, login(e, "user1@email.io", "secret").Code)
+}
+
+func TestLoginRateLimit(t *testing.T) {
+	t.Parallel()
+	h, e := setup(t)
+	h.RateLimit(ratelimit.Config{Login: ratelimit.Policy{Limit: 2, Period: time.Minute}})
+	h.Register(e.Group("/api"))
+
+	rec := login(e, "user1@email.io", "secret")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "1", rec.Header().Get(middleware.HeaderRateLimitRemaining))
+	assert.Equal(t, http.StatusForbidden, login(e, "user2@email.io", "wrong").Code)
+	rec = login(e, "user2@email.io", "secret")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	// Half a period for the next token, less the time spent hashing
+	// passwords, which is long under the race detector.
+	retry, err := strconv.Atoi(rec.Header().Get(middleware.HeaderRetryAfter))
+	assert.NoError(t, err)
+	assert.True(t, retry > 0 && retry <= 30, "Retry-After: %d", retry)
 }
 
 func TestCurrentUserCaseSuccess(t *testing.T) {
-	tearDown()
-	setup()
+	t.Parallel()
+	h, e := setup(t)
 	jwtMiddleware := middleware.JWT(utils.JWTSecret)
 	req := httptest.NewRequest(echo.GET, "/api/users/login", nil)
 	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
-	req.Header.Set(echo.HeaderAuthorization, authHeader(utils.GenerateJWT(1)))
+	req.Header.Set(echo.HeaderAuthorization, authHeader(utils.GenerateJWT(1, 0)))
 	rec := httptest.NewRecorder()
 	c := e.NewContext(req, rec)
 	err := jwtMiddleware(func(context echo.Context) error {
@@ -88,31 +164,109 @@ func TestCurrentUserCaseSuccess(t *testing.T) {
 }
 
 func TestCurrentUserCaseInvalid(t *testing.T) {
-	tearDown()
-	setup()
+	t.Parallel()
+	h, e := setup(t)
 	jwtMiddleware := middleware.JWT(utils.JWTSecret)
 	req := httptest.NewRequest(echo.GET, "/api/users/login", nil)
 	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
-	req.Header.Set(echo.HeaderAuthorization, authHeader(utils.GenerateJWT(100)))
+	req.Header.Set(echo.HeaderAuthorization, authHeader(utils.GenerateJWT(100, 0)))
 	rec := httptest.NewRecorder()
 	c := e.NewContext(req, rec)
 	err := jwtMiddleware(func(context echo.Context) error {
 		return h.CurrentUser(c)
 	})(c)
-	assert.NoError(t, err)
-	assert.Equal(t, http.StatusNotFound, rec.Code)
+	if assert.Error(t, err) {
+		e.HTTPErrorHandler(err, c)
+	}
+	if assert.Equal(t, http.StatusNotFound, rec.Code) {
+		p := problemResponse(t, rec)
+		assert.Equal(t, utils.CodeNotFound, p.Code)
+		assert.Equal(t, http.StatusNotFound, p.Status)
+	}
+}
+
+func TestSignUpCaseDuplicate(t *testing.T) {
+	t.Parallel()
+	h, e := setup(t)
+	var (
+		reqJSON = `{"user":{"username":"user1","email":"user1@email.io","password":"secret"}}`
+	)
+	req := httptest.NewRequest(echo.POST, "/api/users", strings.NewReader(reqJSON))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	err := h.SignUp(c)
+	if assert.Error(t, err) {
+		e.HTTPErrorHandler(err, c)
+	}
+	if assert.Equal(t, http.StatusConflict, rec.Code) {
+		p := problemResponse(t, rec)
+		assert.Equal(t, utils.CodeConflict, p.Code)
+	}
+}
+
+func TestSignUpCaseInvalid(t *testing.T) {
+	t.Parallel()
+	h, e := setup(t)
+	var (
+		reqJSON = `{"user":{"username":"alice","email":"not-an-email"}}`
+	)
+	req := httptest.NewRequest(echo.POST, "/api/users", strings.NewReader(reqJSON))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	err := h.SignUp(c)
+	if assert.Error(t, err) {
+		e.HTTPErrorHandler(err, c)
+	}
+	if assert.Equal(t, http.StatusUnprocessableEntity, rec.Code) {
+		p := problemResponse(t, rec)
+		assert.Equal(t, utils.CodeValidationFailed, p.Code)
+		if assert.Len(t, p.Errors, 2) {
+			assert.Equal(t, "user.email", p.Errors[0].Field)
+			assert.Equal(t, "email", p.Errors[0].Code)
+			assert.Equal(t, "email must be a valid email address", p.Errors[0].Message)
+			assert.Equal(t, "user.password", p.Errors[1].Field)
+			assert.Equal(t, "required", p.Errors[1].Code)
+		}
+	}
+}
+
+func TestSignUpCaseInvalidTranslated(t *testing.T) {
+	t.Parallel()
+	h, e := setup(t)
+	var (
+		reqJSON = `{"user":{"username":"alice","email":"alice@email.io"}}`
+	)
+	req := httptest.NewRequest(echo.POST, "/api/users", strings.NewReader(reqJSON))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set("Accept-Language", "fr-FR, en;q=0.5")
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	err := h.SignUp(c)
+	if assert.Error(t, err) {
+		e.HTTPErrorHandler(err, c)
+	}
+	if assert.Equal(t, http.StatusUnprocessableEntity, rec.Code) {
+		assert.Equal(t, "fr", rec.Header().Get("Content-Language"))
+		p := problemResponse(t, rec)
+		if assert.Len(t, p.Errors, 1) {
+			assert.Equal(t, "user.password", p.Errors[0].Field)
+			assert.Equal(t, "password est un champ obligatoire", p.Errors[0].Message)
+		}
+	}
 }
 
 func TestUpdateUserEmail(t *testing.T) {
-	tearDown()
-	setup()
+	t.Parallel()
+	h, e := setup(t)
 	var (
 		user1UpdateReq = `{"user":{"email":"user1@user1.me"}}`
 	)
 	jwtMiddleware := middleware.JWT(utils.JWTSecret)
 	req := httptest.NewRequest(echo.PATCH, "/api/user", strings.NewReader(user1UpdateReq))
 	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
-	req.Header.Set(echo.HeaderAuthorization, authHeader(utils.GenerateJWT(1)))
+	req.Header.Set(echo.HeaderAuthorization, authHeader(utils.GenerateJWT(1, 0)))
 	rec := httptest.NewRecorder()
 	c := e.NewContext(req, rec)
 	err := jwtMiddleware(func(context echo.Context) error {
@@ -128,15 +282,15 @@ func TestUpdateUserEmail(t *testing.T) {
 }
 
 func TestUpdateUserMultipleFields(t *testing.T) {
-	tearDown()
-	setup()
+	t.Parallel()
+	h, e := setup(t)
 	var (
 		user1UpdateReq = `{"user":{"username":"user11","email":"user11@user11.me","bio":"user11 bio"}}`
 	)
 	jwtMiddleware := middleware.JWT(utils.JWTSecret)
 	req := httptest.NewRequest(echo.PUT, "/api/user", strings.NewReader(user1UpdateReq))
 	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
-	req.Header.Set(echo.HeaderAuthorization, authHeader(utils.GenerateJWT(1)))
+	req.Header.Set(echo.HeaderAuthorization, authHeader(utils.GenerateJWT(1, 0)))
 	rec := httptest.NewRecorder()
 	c := e.NewContext(req, rec)
 	err := jwtMiddleware(func(context echo.Context) error {
@@ -151,3 +305,271 @@ func TestUpdateUserMultipleFields(t *testing.T) {
 		assert.NotEmpty(t, m["token"])
 	}
 }
+
+// mailbox records the messages sent by the user service.
+type mailbox struct {
+	mu   sync.Mutex
+	sent []mail.Message
+}
+
+func (m *mailbox) Send(ctx context.Context, msg mail.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+var tokenLink = regexp.MustCompile(`(/[a-z-]+)\?token=([A-Za-z0-9_-]+)`)
+
+// last returns the number of messages sent, and the page and token linked
+// by the last one.
+func (m *mailbox) last(t *testing.T, to string) (int, string, string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	require.NotEmpty(t, m.sent)
+	msg := m.sent[len(m.sent)-1]
+	assert.Equal(t, to, msg.To)
+	match := tokenLink.FindStringSubmatch(msg.Text)
+	require.NotNil(t, match, msg.Text)
+	assert.Contains(t, msg.HTML, match[0])
+	return len(m.sent), match[1], match[2]
+}
+
+func TestPasswordReset(t *testing.T) {
+	t.Parallel()
+	h, e := setup(t)
+	box := &mailbox{}
+	h.userService.MailWith(box, "http://front.example")
+	h.Register(e.Group("/api"))
+
+	forgot := func(email string) {
+		rec := serveAs(h, e, 0, echo.POST, "/api/users/password/forgot", `{"user":{"email":"`+email+`"}}`)
+		require.Equal(t, http.StatusAccepted, rec.Code, rec.Body.String())
+	}
+	reset := func(token, password string) *httptest.ResponseRecorder {
+		return serveAs(h, e, 0, echo.POST, "/api/users/password/reset", `{"user":{"token":"`+token+`","password":"`+password+`"}}`)
+	}
+
+	forgot("nobody@email.io")
+	assert.Empty(t, box.sent, "unknown addresses get the same answer and no mail")
+
+	forgot("user1@email.io")
+	n, page, first := box.last(t, "user1@email.io")
+	assert.Equal(t, 1, n)
+	assert.Equal(t, "/reset-password", page)
+	forgot("user1@email.io")
+	_, _, token := box.last(t, "user1@email.io")
+
+	rec := reset(first, "changed")
+	assert.Equal(t, http.StatusBadRequest, rec.Code, "replaced by the later link")
+	assert.Equal(t, utils.CodeInvalidToken, problemResponse(t, rec).Code)
+
+	assert.Equal(t, http.StatusOK, reset(token, "changed").Code)
+	assert.Equal(t, http.StatusForbidden, login(e, "user1@email.io", "secret").Code)
+	rec = login(e, "user1@email.io", "changed")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, true, responseMap(rec.Body.Bytes(), "user")["emailVerified"], "the link proved the address")
+
+	assert.Equal(t, http.StatusBadRequest, reset(token, "again").Code, "single-use")
+	assert.Equal(t, http.StatusUnprocessableEntity, reset(token, "").Code)
+}
+
+// currentUser gets the current user with token.
+func currentUser(e *echo.Echo, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(echo.GET, "/api/user", nil)
+	req.Header.Set(echo.HeaderAuthorization, authHeader(token))
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestPasswordChangesRevokeTokens(t *testing.T) {
+	t.Parallel()
+	h, e := setup(t)
+	box := &mailbox{}
+	h.userService.MailWith(box, "http://front.example")
+	h.Register(e.Group("/api"))
+	token := func(rec *httptest.ResponseRecorder) string {
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		return responseMap(rec.Body.Bytes(), "user")["token"].(string)
+	}
+
+	stolen := token(login(e, "user1@email.io", "secret"))
+	rec := serveAs(h, e, 0, echo.POST, "/api/users/password/forgot", `{"user":{"email":"user1@email.io"}}`)
+	require.Equal(t, http.StatusAccepted, rec.Code, rec.Body.String())
+	_, _, reset := box.last(t, "user1@email.io")
+	rec = serveAs(h, e, 0, echo.POST, "/api/users/password/reset", `{"user":{"token":"`+reset+`","password":"changed"}}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, http.StatusForbidden, currentUser(e, stolen).Code, "issued before the reset")
+	fresh := token(login(e, "user1@email.io", "changed"))
+	assert.Equal(t, http.StatusOK, currentUser(e, fresh).Code)
+
+	// Replacing the user with the same password keeps the tokens; a new
+	// password revokes them all but the one answered.
+	put := func(token, password string) *httptest.ResponseRecorder {
+		body := `{"user":{"username":"user1","email":"user1@email.io","password":"` + password + `"}}`
+		req := httptest.NewRequest(echo.PUT, "/api/user", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set(echo.HeaderAuthorization, authHeader(token))
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+	other := token(login(e, "user1@email.io", "changed"))
+	token(put(fresh, "changed"))
+	assert.Equal(t, http.StatusOK, currentUser(e, other).Code)
+	answered := token(put(fresh, "again"))
+	assert.Equal(t, http.StatusForbidden, currentUser(e, fresh).Code)
+	assert.Equal(t, http.StatusForbidden, currentUser(e, other).Code)
+	assert.Equal(t, http.StatusOK, currentUser(e, answered).Code)
+}
+
+func TestEmailVerification(t *testing.T) {
+	t.Parallel()
+	h, e := setup(t)
+	box := &mailbox{}
+	h.userService.MailWith(box, "http://front.example")
+	h.Register(e.Group("/api"))
+	verify := func(token string) *httptest.ResponseRecorder {
+		return serveAs(h, e, 0, echo.POST, "/api/users/verify", `{"user":{"token":"`+token+`"}}`)
+	}
+
+	rec := serveAs(h, e, 0, echo.POST, "/api/users", `{"user":{"username":"alice","email":"alice@email.io","password":"secret"}}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	assert.Equal(t, false, responseMap(rec.Body.Bytes(), "user")["emailVerified"])
+	_, page, token := box.last(t, "alice@email.io")
+	assert.Equal(t, "/verify-email", page)
+	assert.Equal(t, http.StatusBadRequest, verify("unknown").Code)
+	assert.Equal(t, http.StatusOK, verify(token).Code)
+	assert.Equal(t, http.StatusBadRequest, verify(token).Code, "single-use")
+
+	rec = login(e, "alice@email.io", "secret")
+	assert.Equal(t, true, responseMap(rec.Body.Bytes(), "user")["emailVerified"])
+	rec = serveAs(h, e, 3, echo.POST, "/api/user/verification", "")
+	assert.Equal(t, http.StatusConflict, rec.Code, "already verified")
+
+	rec = serveAs(h, e, 3, echo.PUT, "/api/user", `{"user":{"username":"alice","email":"alice@example.com","password":"secret"}}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, false, responseMap(rec.Body.Bytes(), "user")["emailVerified"], "a new address needs verifying")
+	_, _, changed := box.last(t, "alice@example.com")
+
+	rec = serveAs(h, e, 3, echo.POST, "/api/user/verification", "")
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+	n, _, resent := box.last(t, "alice@example.com")
+	assert.Equal(t, 3, n)
+	assert.Equal(t, http.StatusBadRequest, verify(changed).Code, "replaced by the resent link")
+	assert.Equal(t, http.StatusOK, verify(resent).Code)
+}
+
+func TestTwoFactor(t *testing.T) {
+	t.Parallel()
+	h, e := setup(t)
+	h.RateLimit(ratelimit.Config{})
+	h.Register(e.Group("/api"))
+	loginTwoFactor := func(challenge, code string) *httptest.ResponseRecorder {
+		return serveAs(h, e, 0, echo.POST, "/api/users/login/2fa", `{"user":{"challenge":"`+challenge+`","code":"`+code+`"}}`)
+	}
+	challenge := func() string {
+		rec := login(e, "user1@email.io", "secret")
+		require.Equal(t, http.StatusAccepted, rec.Code, rec.Body.String())
+		m := responseMap(rec.Body.Bytes(), "challenge")
+		assert.Equal(t, float64(300), m["expiresIn"])
+		return m["token"].(string)
+	}
+	var codes recoveryCodesResponse
+
+	rec := serveAs(h, e, 1, echo.POST, "/api/user/2fa", "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	m := responseMap(rec.Body.Bytes(), "twoFactor")
+	secret := m["secret"].(string)
+	assert.Contains(t, m["uri"], "otpauth://totp/Product%20Catalog:user1@email.io?")
+	assert.Equal(t, http.StatusOK, login(e, "user1@email.io", "secret").Code, "not enabled until confirmed")
+
+	now := time.Now()
+	wrong, err := totp.Code(secret, now.Add(time.Hour))
+	require.NoError(t, err)
+	rec = serveAs(h, e, 1, echo.POST, "/api/user/2fa/enable", `{"user":{"code":"`+wrong+`"}}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, utils.CodeInvalidCode, problemResponse(t, rec).Code)
+	code, err := totp.Code(secret, now)
+	require.NoError(t, err)
+	rec = serveAs(h, e, 1, echo.POST, "/api/user/2fa/enable", `{"user":{"code":"`+code+`"}}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &codes))
+	assert.Len(t, codes.RecoveryCodes, 10)
+	assert.Equal(t, http.StatusConflict, serveAs(h, e, 1, echo.POST, "/api/user/2fa", "").Code)
+
+	c := challenge()
+	assert.Equal(t, http.StatusForbidden, loginTwoFactor(c, code).Code, "codes are not replayed")
+	assert.Equal(t, http.StatusBadRequest, loginTwoFactor(c, code).Code, "challenges are single-use")
+	next, err := totp.Code(secret, now.Add(totp.Period))
+	require.NoError(t, err)
+	rec = loginTwoFactor(challenge(), next)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, true, responseMap(rec.Body.Bytes(), "user")["twoFactorEnabled"])
+
+	recovery := strings.ToLower(codes.RecoveryCodes[0])
+	assert.Equal(t, http.StatusOK, loginTwoFactor(challenge(), recovery).Code, "recovery codes work in any case")
+	assert.Equal(t, http.StatusForbidden, loginTwoFactor(challenge(), recovery).Code, "once")
+
+	rec = serveAs(h, e, 1, echo.POST, "/api/user/2fa/recovery-codes", `{"user":{"code":"`+codes.RecoveryCodes[1]+`"}}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	old := codes.RecoveryCodes[2]
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &codes))
+	assert.Equal(t, http.StatusForbidden, loginTwoFactor(challenge(), old).Code, "replaced")
+
+	rec = serveAs(h, e, 1, echo.POST, "/api/user/2fa/disable", `{"user":{"password":"wrong","code":"`+codes.RecoveryCodes[0]+`"}}`)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	rec = serveAs(h, e, 1, echo.POST, "/api/user/2fa/disable", `{"user":{"password":"secret","code":"`+codes.RecoveryCodes[0]+`"}}`)
+	require.Equal(t, http.StatusOK, rec.Code, "the code of the failed attempt was not used: %s", rec.Body.String())
+	rec = login(e, "user1@email.io", "secret")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, false, responseMap(rec.Body.Bytes(), "user")["twoFactorEnabled"])
+}
+
+func TestTwoFactorRequired(t *testing.T) {
+	t.Parallel()
+	h, e := setup(t)
+	h.RateLimit(ratelimit.Config{})
+	h.Register(e.Group("/api"))
+	admin := &models.User{Username: "admin", Email: "admin@email.io", Role: models.RoleAdmin}
+	require.NoError(t, h.userService.SignUp(context.Background(), admin, "secret"))
+	credentials := `"email":"admin@email.io","password":"secret"`
+
+	rec := login(e, "admin@email.io", "secret")
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Equal(t, utils.CodeTwoFactorReq, problemResponse(t, rec).Code)
+	assert.Equal(t, http.StatusForbidden, currentUser(e, utils.GenerateJWT(admin.ID, 0)).Code, "earlier tokens are refused too")
+
+	rec = serveAs(h, e, 0, echo.POST, "/api/users/2fa", `{"user":{"email":"user1@email.io","password":"secret"}}`)
+	assert.Equal(t, http.StatusForbidden, rec.Code, "other users enroll with a token")
+	assert.Equal(t, utils.CodeAccessForbidden, problemResponse(t, rec).Code)
+	rec = serveAs(h, e, 0, echo.POST, "/api/users/2fa", `{"user":{"email":"admin@email.io","password":"wrong"}}`)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	rec = serveAs(h, e, 0, echo.POST, "/api/users/2fa", `{"user":{`+credentials+`}}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	secret := responseMap(rec.Body.Bytes(), "twoFactor")["secret"].(string)
+	code, err := totp.Code(secret, time.Now())
+	require.NoError(t, err)
+	rec = serveAs(h, e, 0, echo.POST, "/api/users/2fa/enable", `{"user":{`+credentials+`,"code":"`+code+`"}}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var codes recoveryCodesResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &codes))
+	assert.Equal(t, http.StatusForbidden, serveAs(h, e, 0, echo.POST, "/api/users/2fa", `{"user":{`+credentials+`}}`).Code, "enabled")
+
+	rec = login(e, "admin@email.io", "secret")
+	require.Equal(t, http.StatusAccepted, rec.Code, rec.Body.String())
+	challenge := responseMap(rec.Body.Bytes(), "challenge")["token"].(string)
+	rec = serveAs(h, e, 0, echo.POST, "/api/users/login/2fa", `{"user":{"challenge":"`+challenge+`","code":"`+codes.RecoveryCodes[0]+`"}}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	token := responseMap(rec.Body.Bytes(), "user")["token"].(string)
+	assert.Equal(t, http.StatusOK, currentUser(e, token).Code)
+
+	req := httptest.NewRequest(echo.POST, "/api/user/2fa/disable", strings.NewReader(`{"user":{"password":"secret","code":"`+codes.RecoveryCodes[1]+`"}}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set(echo.HeaderAuthorization, authHeader(token))
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Equal(t, utils.CodeTwoFactorReq, problemResponse(t, rec).Code)
+}
@@ -0,0 +1,126 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sumitalp/productcatalog/models"
+	"github.com/sumitalp/productcatalog/utils"
+)
+
+func (h *Handler) CreateWebhook(c echo.Context) error {
+	var s models.WebhookSubscription
+	req := &webhookCreateRequest{}
+	if err := req.bind(c, &s); err != nil {
+		return err
+	}
+	if err := h.webhookService.Subscribe(c.Request().Context(), userIDFromToken(c), &s); err != nil {
+		return err
+	}
+	res := newWebhookResponse(&s)
+	res.Webhook.Secret = s.Secret
+	return c.JSON(http.StatusCreated, res)
+}
+
+func (h *Handler) Webhooks(c echo.Context) error {
+	offset, err := strconv.Atoi(c.QueryParam("offset"))
+	if err != nil {
+		offset = 0
+	}
+	limit, err := strconv.Atoi(c.QueryParam("limit"))
+	if err != nil {
+		limit = 20
+	}
+	subscriptions, count, err := h.webhookService.List(c.Request().Context(), userIDFromToken(c), offset, limit)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, newWebhookListResponse(subscriptions, count))
+}
+
+func (h *Handler) GetWebhook(c echo.Context) error {
+	id, err := idParam(c, "id")
+	if err != nil {
+		return err
+	}
+	s, err := h.webhookService.Get(c.Request().Context(), userIDFromToken(c), id)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, newWebhookResponse(s))
+}
+
+func (h *Handler) UpdateWebhook(c echo.Context) error {
+	id, err := idParam(c, "id")
+	if err != nil {
+		return err
+	}
+	s, err := h.webhookService.Update(c.Request().Context(), userIDFromToken(c), id, func(s *models.WebhookSubscription) error {
+		req := &webhookUpdateRequest{}
+		req.populate(s)
+		return req.bind(c, s)
+	})
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, newWebhookResponse(s))
+}
+
+func (h *Handler) DeleteWebhook(c echo.Context) error {
+	id, err := idParam(c, "id")
+	if err != nil {
+		return err
+	}
+	if err := h.webhookService.Delete(c.Request().Context(), userIDFromToken(c), id); err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, newResultResponse())
+}
+
+// WebhookDeliveries lists the delivery log of a webhook, newest first.
+func (h *Handler) WebhookDeliveries(c echo.Context) error {
+	id, err := idParam(c, "id")
+	if err != nil {
+		return err
+	}
+	offset, err := strconv.Atoi(c.QueryParam("offset"))
+	if err != nil {
+		offset = 0
+	}
+	limit, err := strconv.Atoi(c.QueryParam("limit"))
+	if err != nil {
+		limit = 20
+	}
+	deliveries, count, err := h.webhookService.Deliveries(c.Request().Context(), userIDFromToken(c), id, offset, limit)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, newDeliveryListResponse(deliveries, count))
+}
+
+// ReplayWebhookDelivery queues the payload of a past delivery again. The
+// new delivery is sent in the background.
+func (h *Handler) ReplayWebhookDelivery(c echo.Context) error {
+	id, err := idParam(c, "id")
+	if err != nil {
+		return err
+	}
+	deliveryID, err := idParam(c, "deliveryID")
+	if err != nil {
+		return err
+	}
+	d, err := h.webhookService.Replay(c.Request().Context(), userIDFromToken(c), id, deliveryID)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusAccepted, newDeliveryResponse(d))
+}
+
+func idParam(c echo.Context, name string) (uint, error) {
+	id, err := strconv.ParseUint(c.Param(name), 10, 64)
+	if err != nil {
+		return 0, utils.BadRequest("Invalid ID.")
+	}
+	return uint(id), nil
+}
@@ -0,0 +1,158 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/sumitalp/productcatalog/event"
+	"github.com/sumitalp/productcatalog/utils"
+	"github.com/sumitalp/productcatalog/webhook"
+)
+
+// serveAs sends a request through the routes of h as the given user.
+func serveAs(h *Handler, e *echo.Echo, userID uint, method, path, body string) *httptest.ResponseRecorder {
+	var r io.Reader
+	if body != "" {
+		r = strings.NewReader(body)
+	}
+	req := httptest.NewRequest(method, path, r)
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set(echo.HeaderAuthorization, authHeader(utils.GenerateJWT(userID, 0)))
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestWebhookCRUD(t *testing.T) {
+	t.Parallel()
+	h, e := setup(t)
+	h.Register(e.Group("/api"))
+
+	rec := serveAs(h, e, 1, echo.POST, "/api/webhooks", `{"webhook":{"url":"http://example.com/hook","events":["product.*","category.deleted"]}}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	var created singleWebhookResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+	assert.Equal(t, []string{"product.*", "category.deleted"}, created.Webhook.Events)
+	assert.True(t, strings.HasPrefix(created.Webhook.Secret, "whsec_"))
+	assert.True(t, created.Webhook.Active)
+	path := fmt.Sprintf("/api/webhooks/%d", created.Webhook.ID)
+
+	// The secret is only shown on creation.
+	rec = serveAs(h, e, 1, echo.GET, path, "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var got singleWebhookResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &got))
+	assert.Empty(t, got.Webhook.Secret)
+	assert.Equal(t, "http://example.com/hook", got.Webhook.URL)
+
+	rec = serveAs(h, e, 2, echo.GET, path, "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	rec = serveAs(h, e, 2, echo.GET, "/api/webhooks", "")
+	var list webhookListResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &list))
+	assert.Zero(t, list.WebhooksCount)
+	rec = serveAs(h, e, 1, echo.GET, "/api/webhooks", "")
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &list))
+	assert.Equal(t, 1, list.WebhooksCount)
+
+	rec = serveAs(h, e, 1, echo.PUT, path, `{"webhook":{"events":["*"],"active":false}}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &got))
+	assert.Equal(t, []string{"*"}, got.Webhook.Events)
+	assert.Equal(t, "http://example.com/hook", got.Webhook.URL)
+	assert.False(t, got.Webhook.Active)
+
+	rec = serveAs(h, e, 2, echo.DELETE, path, "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	rec = serveAs(h, e, 1, echo.DELETE, path, "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = serveAs(h, e, 1, echo.GET, path, "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestCreateWebhookValidation(t *testing.T) {
+	t.Parallel()
+	h, e := setup(t)
+	h.Register(e.Group("/api"))
+
+	rec := serveAs(h, e, 1, echo.POST, "/api/webhooks", `{"webhook":{"url":"not a url","events":["product.renamed"],"secret":"short"}}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	p := problemResponse(t, rec)
+	fields := make([]string, 0)
+	for _, f := range p.Errors {
+		fields = append(fields, f.Field)
+	}
+	assert.ElementsMatch(t, []string{"webhook.url", "webhook.events[0]", "webhook.secret"}, fields)
+
+	req := httptest.NewRequest(echo.GET, "/api/webhooks", nil)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
+
+func TestWebhookDeliveriesAndReplay(t *testing.T) {
+	t.Parallel()
+	h, e := setup(t)
+	h.Register(e.Group("/api"))
+	const secret = "0123456789abcdef0123456789abcdef"
+	received := make(chan *http.Request, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if !webhook.Verify(secret, r.Header.Get(webhook.HeaderTimestamp), r.Header.Get(webhook.HeaderSignature), body, time.Minute, time.Now()) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		received <- r
+	}))
+	defer receiver.Close()
+
+	rec := serveAs(h, e, 1, echo.POST, "/api/webhooks", `{"webhook":{"url":"`+receiver.URL+`","events":["category.created"],"secret":"`+secret+`"}}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	var created singleWebhookResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+	deliveriesPath := fmt.Sprintf("/api/webhooks/%d/deliveries", created.Webhook.ID)
+
+	rec = serveAs(h, e, 1, echo.POST, "/api/categories", `{"category":{"title":"books"}}`)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	n, err := h.webhookService.DeliverDue(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	r := <-received
+	assert.Equal(t, event.CategoryCreated, r.Header.Get(webhook.HeaderEvent))
+
+	rec = serveAs(h, e, 1, echo.GET, deliveriesPath, "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var list deliveryListResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &list))
+	require.Equal(t, 1, list.DeliveriesCount)
+	d := list.Deliveries[0]
+	assert.Equal(t, "succeeded", d.Status)
+	assert.Equal(t, http.StatusOK, d.LastStatusCode)
+	assert.Nil(t, d.NextAttemptAt)
+
+	rec = serveAs(h, e, 2, echo.POST, fmt.Sprintf("%s/%d/replay", deliveriesPath, d.ID), "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	rec = serveAs(h, e, 1, echo.POST, fmt.Sprintf("%s/%d/replay", deliveriesPath, d.ID), "")
+	require.Equal(t, http.StatusAccepted, rec.Code, rec.Body.String())
+	var replay singleDeliveryResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &replay))
+	assert.Equal(t, "pending", replay.Delivery.Status)
+	if assert.NotNil(t, replay.Delivery.ReplayOf) {
+		assert.Equal(t, d.ID, *replay.Delivery.ReplayOf)
+	}
+	_, err = h.webhookService.DeliverDue(context.Background())
+	require.NoError(t, err)
+	r = <-received
+	assert.Equal(t, fmt.Sprint(replay.Delivery.ID), r.Header.Get(webhook.HeaderDelivery))
+}
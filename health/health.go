@@ -0,0 +1,289 @@
+// Package health answers the probes of the orchestrator and reports the
+// state of the service: its dependencies, its background workers and the
+// build it runs.
+package health
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Version is the version of the build, set with
+// -ldflags "-X github.com/sumitalp/productcatalog/health.Version=...".
+// The module version and VCS revision recorded by the go command are
+// reported when it is not set.
+var Version = ""
+
+// Check reports whether a dependency is usable.
+type Check func(ctx context.Context) error
+
+// Status values of the reports.
+const (
+	StatusOK          = "ok"
+	StatusFailed      = "failed"
+	StatusUnavailable = "unavailable"
+)
+
+// Config tunes a Checker.
+type Config struct {
+	// Timeout bounds each check.
+	Timeout time.Duration
+	Now     func() time.Time
+}
+
+var DefaultConfig = Config{
+	Timeout: 2 * time.Second,
+	Now:     time.Now,
+}
+
+// Checker runs the readiness checks and gathers the status report.
+type Checker struct {
+	config  Config
+	started time.Time
+
+	mu       sync.Mutex
+	draining bool
+	checks   map[string]Check
+	workers  map[string]func() WorkerStatus
+	details  map[string]func() interface{}
+}
+
+func NewChecker() *Checker {
+	return NewCheckerWithConfig(DefaultConfig)
+}
+
+func NewCheckerWithConfig(config Config) *Checker {
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultConfig.Timeout
+	}
+	if config.Now == nil {
+		config.Now = DefaultConfig.Now
+	}
+	return &Checker{
+		config:  config,
+		started: config.Now(),
+		checks:  make(map[string]Check),
+		workers: make(map[string]func() WorkerStatus),
+		details: make(map[string]func() interface{}),
+	}
+}
+
+// AddCheck makes readiness depend on check.
+func (c *Checker) AddCheck(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checks[name] = check
+}
+
+// Drain makes the service report itself unavailable from now on, so that
+// load balancers stop sending it requests while it shuts down.
+func (c *Checker) Drain() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.draining = true
+}
+
+// AddWorker reports the status of a background worker.
+func (c *Checker) AddWorker(name string, status func() WorkerStatus) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.workers[name] = status
+}
+
+// AddDetail reports the value returned by detail, such as the statistics
+// of a connection pool, in the status report.
+func (c *Checker) AddDetail(name string, detail func() interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.details[name] = detail
+}
+
+// CheckResult is the outcome of a check.
+type CheckResult struct {
+	Status     string  `json:"status"`
+	Error      string  `json:"error,omitempty"`
+	DurationMs float64 `json:"durationMs"`
+}
+
+// Readiness is the outcome of the readiness checks.
+type Readiness struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+// Ready runs the checks concurrently, each bounded by Config.Timeout.
+func (c *Checker) Ready(ctx context.Context) Readiness {
+	c.mu.Lock()
+	checks := make(map[string]Check, len(c.checks))
+	for name, check := range c.checks {
+		checks[name] = check
+	}
+	draining := c.draining
+	c.mu.Unlock()
+
+	r := Readiness{Status: StatusOK, Checks: make(map[string]CheckResult, len(checks)+1)}
+	if draining {
+		r.Status = StatusUnavailable
+		r.Checks["shutdown"] = CheckResult{Status: StatusFailed, Error: "shutting down"}
+	}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check Check) {
+			defer wg.Done()
+			res := c.run(ctx, check)
+			mu.Lock()
+			defer mu.Unlock()
+			r.Checks[name] = res
+			if res.Status != StatusOK {
+				r.Status = StatusUnavailable
+			}
+		}(name, check)
+	}
+	wg.Wait()
+	return r
+}
+
+func (c *Checker) run(ctx context.Context, check Check) CheckResult {
+	ctx, cancel := context.WithTimeout(ctx, c.config.Timeout)
+	defer cancel()
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() { done <- check(ctx) }()
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	res := CheckResult{Status: StatusOK, DurationMs: float64(time.Since(start).Microseconds()) / 1000}
+	if err != nil {
+		res.Status = StatusFailed
+		res.Error = err.Error()
+	}
+	return res
+}
+
+// Report is the detailed status of the service.
+type Report struct {
+	Readiness
+	Version   string                  `json:"version"`
+	GoVersion string                  `json:"goVersion"`
+	StartedAt time.Time               `json:"startedAt"`
+	Uptime    string                  `json:"uptime"`
+	Workers   map[string]WorkerStatus `json:"workers"`
+	Details   map[string]interface{}  `json:"details"`
+}
+
+// Report runs the checks and gathers the status of the workers and the
+// details.
+func (c *Checker) Report(ctx context.Context) Report {
+	r := Report{
+		Readiness: c.Ready(ctx),
+		Version:   version(),
+		GoVersion: runtime.Version(),
+		StartedAt: c.started,
+		Uptime:    c.config.Now().Sub(c.started).Round(time.Second).String(),
+		Workers:   make(map[string]WorkerStatus),
+		Details:   make(map[string]interface{}),
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for name, status := range c.workers {
+		r.Workers[name] = status()
+	}
+	for name, detail := range c.details {
+		r.Details[name] = detail()
+	}
+	return r
+}
+
+func version() string {
+	if Version != "" {
+		return Version
+	}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	v := info.Main.Version
+	settings := make(map[string]string)
+	for _, s := range info.Settings {
+		settings[s.Key] = s.Value
+	}
+	if rev := settings["vcs.revision"]; rev != "" {
+		v += " (" + rev
+		if settings["vcs.modified"] == "true" {
+			v += ", modified"
+		}
+		v += ")"
+	}
+	return v
+}
+
+// Register serves the probes: /healthz answers as long as the process
+// does, /readyz runs the checks and /debug/status, behind auth, reports
+// everything.
+func (c *Checker) Register(g *echo.Group, auth echo.MiddlewareFunc) {
+	g.GET("/healthz", c.Healthz)
+	g.HEAD("/healthz", c.Healthz)
+	g.GET("/readyz", c.Readyz)
+	g.HEAD("/readyz", c.Readyz)
+	g.GET("/debug/status", c.Status, auth)
+}
+
+func (c *Checker) Healthz(ctx echo.Context) error {
+	return ctx.JSON(http.StatusOK, map[string]string{"status": StatusOK})
+}
+
+// Readyz answers 503 Service Unavailable while a check fails.
+func (c *Checker) Readyz(ctx echo.Context) error {
+	r := c.Ready(ctx.Request().Context())
+	code := http.StatusOK
+	if r.Status != StatusOK {
+		code = http.StatusServiceUnavailable
+	}
+	return ctx.JSON(code, r)
+}
+
+// Status answers 200 OK whatever the status, which the report holds.
+func (c *Checker) Status(ctx echo.Context) error {
+	return ctx.JSON(http.StatusOK, c.Report(ctx.Request().Context()))
+}
+
+// PoolStats is the state of a database connection pool.
+type PoolStats struct {
+	MaxOpenConnections int    `json:"maxOpenConnections"`
+	OpenConnections    int    `json:"openConnections"`
+	InUse              int    `json:"inUse"`
+	Idle               int    `json:"idle"`
+	WaitCount          int64  `json:"waitCount"`
+	WaitDuration       string `json:"waitDuration"`
+	MaxIdleClosed      int64  `json:"maxIdleClosed"`
+	MaxLifetimeClosed  int64  `json:"maxLifetimeClosed"`
+}
+
+// DBStats returns a detail reporting the pool of d.
+func DBStats(d *sql.DB) func() interface{} {
+	return func() interface{} {
+		s := d.Stats()
+		return PoolStats{
+			MaxOpenConnections: s.MaxOpenConnections,
+			OpenConnections:    s.OpenConnections,
+			InUse:              s.InUse,
+			Idle:               s.Idle,
+			WaitCount:          s.WaitCount,
+			WaitDuration:       s.WaitDuration.String(),
+			MaxIdleClosed:      s.MaxIdleClosed,
+			MaxLifetimeClosed:  s.MaxLifetimeClosed,
+		}
+	}
+}
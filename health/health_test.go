@@ -0,0 +1,103 @@
+package health_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/sumitalp/productcatalog/health"
+)
+
+func serve(e *echo.Echo, path string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(echo.GET, path, nil)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func setup() (*health.Checker, *echo.Echo) {
+	c := health.NewCheckerWithConfig(health.Config{Timeout: 50 * time.Millisecond})
+	e := echo.New()
+	auth := func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if c.Request().Header.Get(echo.HeaderAuthorization) != "Token ok" {
+				return echo.ErrUnauthorized
+			}
+			return next(c)
+		}
+	}
+	c.Register(e.Group(""), auth)
+	return c, e
+}
+
+func TestProbes(t *testing.T) {
+	c, e := setup()
+	var dbErr error
+	c.AddCheck("database", func(ctx context.Context) error { return dbErr })
+	c.AddCheck("slow", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	assert.Equal(t, http.StatusOK, serve(e, "/healthz", nil).Code)
+
+	rec := serve(e, "/readyz", nil)
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	var r health.Readiness
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &r))
+	assert.Equal(t, health.StatusUnavailable, r.Status)
+	assert.Equal(t, health.StatusOK, r.Checks["database"].Status)
+	assert.Equal(t, health.StatusFailed, r.Checks["slow"].Status)
+	assert.Equal(t, context.DeadlineExceeded.Error(), r.Checks["slow"].Error)
+
+	c.AddCheck("slow", func(ctx context.Context) error { return nil })
+	assert.Equal(t, http.StatusOK, serve(e, "/readyz", nil).Code)
+	dbErr = errors.New("database is locked")
+	rec = serve(e, "/readyz", nil)
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Contains(t, rec.Body.String(), "database is locked")
+
+	dbErr = nil
+	c.Drain()
+	rec = serve(e, "/readyz", nil)
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Contains(t, rec.Body.String(), "shutting down")
+	assert.Equal(t, http.StatusOK, serve(e, "/healthz", nil).Code)
+}
+
+func TestStatus(t *testing.T) {
+	c, e := setup()
+	var w health.Worker
+	c.AddWorker("outbox", w.Status)
+	c.AddDetail("answer", func() interface{} { return 42 })
+	w.Start()
+	w.Ran(nil)
+	w.Ran(errors.New("boom"))
+
+	assert.Equal(t, http.StatusUnauthorized, serve(e, "/debug/status", nil).Code)
+	rec := serve(e, "/debug/status", http.Header{echo.HeaderAuthorization: {"Token ok"}})
+	require.Equal(t, http.StatusOK, rec.Code)
+	var r health.Report
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &r))
+	assert.Equal(t, health.StatusOK, r.Status)
+	assert.NotEmpty(t, r.Version)
+	assert.NotEmpty(t, r.GoVersion)
+	assert.Equal(t, float64(42), r.Details["answer"])
+	outbox := r.Workers["outbox"]
+	assert.True(t, outbox.Running)
+	assert.Equal(t, 2, outbox.Runs)
+	assert.Equal(t, "boom", outbox.LastError)
+
+	w.Stop()
+	assert.False(t, w.Status().Running)
+}
@@ -0,0 +1,60 @@
+package health
+
+import (
+	"sync"
+	"time"
+)
+
+// WorkerStatus is the state of a background worker.
+type WorkerStatus struct {
+	Running   bool       `json:"running"`
+	StartedAt *time.Time `json:"startedAt,omitempty"`
+	// Runs counts the rounds of work done since the worker started.
+	Runs        int        `json:"runs"`
+	LastRunAt   *time.Time `json:"lastRunAt,omitempty"`
+	LastError   string     `json:"lastError,omitempty"`
+	LastErrorAt *time.Time `json:"lastErrorAt,omitempty"`
+}
+
+// Worker tracks the status of a background loop. The zero value is ready
+// to use.
+type Worker struct {
+	mu     sync.Mutex
+	status WorkerStatus
+}
+
+// Start records that the loop started.
+func (w *Worker) Start() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	now := time.Now()
+	w.status = WorkerStatus{Running: true, StartedAt: &now}
+}
+
+// Stop records that the loop returned.
+func (w *Worker) Stop() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.status.Running = false
+}
+
+// Ran records a round of work of the loop, which failed with err if not
+// nil.
+func (w *Worker) Ran(err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	now := time.Now()
+	w.status.Runs++
+	w.status.LastRunAt = &now
+	if err != nil {
+		w.status.LastError = err.Error()
+		w.status.LastErrorAt = &now
+	}
+}
+
+// Status returns the recorded status.
+func (w *Worker) Status() WorkerStatus {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.status
+}
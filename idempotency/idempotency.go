@@ -0,0 +1,29 @@
+// Package idempotency defines the storage of idempotency keys, which let
+// clients retry POST requests without repeating their effect.
+package idempotency
+
+import (
+	"context"
+	"time"
+
+	"github.com/sumitalp/productcatalog/models"
+)
+
+type RepositoryInterface interface {
+	// WithContext returns a copy of the repository whose operations are
+	// bound to ctx and abort once it is done.
+	WithContext(ctx context.Context) RepositoryInterface
+
+	// Get returns the key of the given user, or nil if there is none.
+	Get(userID uint, key string) (*models.IdempotencyKey, error)
+	// Create claims a key. It fails with utils.ErrDuplicate when the user
+	// already holds the key, which makes it safe against concurrent
+	// requests racing for the same key.
+	Create(*models.IdempotencyKey) error
+	// Complete records the response of the request holding the key and
+	// its new expiry.
+	Complete(*models.IdempotencyKey) error
+	Delete(*models.IdempotencyKey) error
+	// DeleteExpired removes the keys which expired before t.
+	DeleteExpired(t time.Time) (int, error)
+}
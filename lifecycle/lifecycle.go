@@ -0,0 +1,236 @@
+// Package lifecycle starts the parts of the process in order and stops them
+// in reverse order on shutdown, so that servers drain before the workers
+// and the database they depend on go away.
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/labstack/gommon/log"
+)
+
+// Component is a part of the process managed by a Manager.
+type Component interface {
+	// Start starts the component without blocking.
+	Start(ctx context.Context) error
+	// Stop stops the component, giving up when ctx is done.
+	Stop(ctx context.Context) error
+}
+
+// Config tunes a Manager.
+type Config struct {
+	// ShutdownTimeout bounds the time all components get to stop.
+	ShutdownTimeout time.Duration
+}
+
+var DefaultConfig = Config{
+	ShutdownTimeout: 30 * time.Second,
+}
+
+type named struct {
+	name string
+	Component
+}
+
+// Manager runs components.
+type Manager struct {
+	config     Config
+	components []named
+	// failed receives the errors of components which stopped on their own.
+	failed chan error
+}
+
+func New() *Manager {
+	return NewWithConfig(DefaultConfig)
+}
+
+func NewWithConfig(config Config) *Manager {
+	if config.ShutdownTimeout <= 0 {
+		config.ShutdownTimeout = DefaultConfig.ShutdownTimeout
+	}
+	return &Manager{
+		config: config,
+		failed: make(chan error, 1),
+	}
+}
+
+// Add appends c to the components, started after and stopped before those
+// added earlier.
+func (m *Manager) Add(name string, c Component) {
+	m.components = append(m.components, named{name: name, Component: c})
+}
+
+// OnStop adds a component which only needs stopping, such as a database
+// to close.
+func (m *Manager) OnStop(name string, stop func(ctx context.Context) error) {
+	m.Add(name, &hook{stop: stop})
+}
+
+// Go adds a background worker: run is started in a goroutine and stopped
+// by cancelling its context.
+func (m *Manager) Go(name string, run func(ctx context.Context)) {
+	m.Add(name, &worker{run: run})
+}
+
+// Every adds a background job run every interval. Its failures are
+// logged.
+func (m *Manager) Every(name string, interval time.Duration, job func(ctx context.Context) error) {
+	m.Go(name, func(ctx context.Context) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := job(ctx); err != nil && ctx.Err() == nil {
+					log.Errorj(log.JSON{"message": "job failed", "job": name, "error": err.Error()})
+				}
+			}
+		}
+	})
+}
+
+// Serve adds a server: serve is started in a goroutine and stopped by
+// shutdown. The process shuts down if serve returns an error other than
+// http.ErrServerClosed before shutdown is called.
+func (m *Manager) Serve(name string, serve func() error, shutdown func(ctx context.Context) error) {
+	m.Add(name, &server{name: name, serve: serve, shutdown: shutdown, failed: m.failed})
+}
+
+// Run starts the components in order, waits until ctx is done or a server
+// fails, and stops the started components in reverse order within
+// Config.ShutdownTimeout. It returns the error which caused the shutdown,
+// if any, or else the first error met while stopping.
+func (m *Manager) Run(ctx context.Context) error {
+	started := 0
+	var err error
+	for _, c := range m.components {
+		if err = c.Start(ctx); err != nil {
+			err = fmt.Errorf("starting %s: %w", c.name, err)
+			break
+		}
+		log.Infoj(log.JSON{"message": "started", "component": c.name})
+		started++
+	}
+	if err == nil {
+		select {
+		case <-ctx.Done():
+		case err = <-m.failed:
+		}
+	}
+	log.Infoj(log.JSON{"message": "shutting down"})
+
+	stopCtx, cancel := context.WithTimeout(context.Background(), m.config.ShutdownTimeout)
+	defer cancel()
+	for i := started - 1; i >= 0; i-- {
+		c := m.components[i]
+		if serr := c.Stop(stopCtx); serr != nil {
+			log.Errorj(log.JSON{"message": "stopping failed", "component": c.name, "error": serr.Error()})
+			if err == nil {
+				err = fmt.Errorf("stopping %s: %w", c.name, serr)
+			}
+			continue
+		}
+		log.Infoj(log.JSON{"message": "stopped", "component": c.name})
+	}
+	return err
+}
+
+// SignalContext returns a context done on SIGINT or SIGTERM.
+func SignalContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+}
+
+type hook struct {
+	stop func(ctx context.Context) error
+}
+
+func (h *hook) Start(context.Context) error { return nil }
+
+func (h *hook) Stop(ctx context.Context) error { return h.stop(ctx) }
+
+type worker struct {
+	run    func(ctx context.Context)
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func (w *worker) Start(context.Context) error {
+	// The worker outlives the context of Run, which is done when the
+	// shutdown starts; it is stopped in its turn by Stop.
+	ctx, cancel := context.WithCancel(context.Background())
+	w.cancel = cancel
+	w.done = make(chan struct{})
+	go func() {
+		defer close(w.done)
+		w.run(ctx)
+	}()
+	return nil
+}
+
+func (w *worker) Stop(ctx context.Context) error {
+	w.cancel()
+	select {
+	case <-w.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+type server struct {
+	name     string
+	serve    func() error
+	shutdown func(ctx context.Context) error
+	failed   chan<- error
+
+	mu       sync.Mutex
+	stopping bool
+	done     chan struct{}
+}
+
+func (s *server) Start(context.Context) error {
+	s.done = make(chan struct{})
+	go func() {
+		defer close(s.done)
+		err := s.serve()
+		s.mu.Lock()
+		stopping := s.stopping
+		s.mu.Unlock()
+		if stopping || errors.Is(err, http.ErrServerClosed) {
+			return
+		}
+		if err == nil {
+			err = errors.New("stopped")
+		}
+		select {
+		case s.failed <- fmt.Errorf("%s: %w", s.name, err):
+		default:
+		}
+	}()
+	return nil
+}
+
+func (s *server) Stop(ctx context.Context) error {
+	s.mu.Lock()
+	s.stopping = true
+	s.mu.Unlock()
+	if err := s.shutdown(ctx); err != nil {
+		return err
+	}
+	select {
+	case <-s.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,145 @@
+package lifecycle_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/sumitalp/productcatalog/lifecycle"
+)
+
+// journal records what the components did, in order.
+type journal struct {
+	mu      sync.Mutex
+	entries []string
+}
+
+func (j *journal) add(s string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.entries = append(j.entries, s)
+}
+
+func (j *journal) get() []string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return append([]string(nil), j.entries...)
+}
+
+type component struct {
+	name     string
+	j        *journal
+	startErr error
+}
+
+func (c *component) Start(context.Context) error {
+	c.j.add("start " + c.name)
+	return c.startErr
+}
+
+func (c *component) Stop(context.Context) error {
+	c.j.add("stop " + c.name)
+	return nil
+}
+
+func TestRunStartsAndStopsInOrder(t *testing.T) {
+	j := &journal{}
+	m := lifecycle.New()
+	m.Add("db", &component{name: "db", j: j})
+	m.Go("worker", func(ctx context.Context) {
+		<-ctx.Done()
+		j.add("stop worker")
+	})
+	m.Add("server", &component{name: "server", j: j})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- m.Run(ctx) }()
+	assert.Eventually(t, func() bool { return len(j.get()) == 2 }, time.Second, time.Millisecond)
+	cancel()
+	require.NoError(t, <-done)
+	assert.Equal(t, []string{"start db", "start server", "stop server", "stop worker", "stop db"}, j.get())
+}
+
+func TestRunStopsStartedComponentsWhenStartFails(t *testing.T) {
+	j := &journal{}
+	m := lifecycle.New()
+	m.Add("db", &component{name: "db", j: j})
+	m.Add("cache", &component{name: "cache", j: j, startErr: errors.New("unreachable")})
+	m.Add("server", &component{name: "server", j: j})
+
+	err := m.Run(context.Background())
+	assert.EqualError(t, err, "starting cache: unreachable")
+	assert.Equal(t, []string{"start db", "start cache", "stop db"}, j.get())
+}
+
+func TestServe(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})}
+	m := lifecycle.New()
+	m.Serve("http", func() error { return srv.Serve(l) }, srv.Shutdown)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- m.Run(ctx) }()
+	res, err := http.Get("http://" + l.Addr().String())
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusNoContent, res.StatusCode)
+	cancel()
+	assert.NoError(t, <-done, "http.ErrServerClosed is not a failure")
+
+	// A server failing on its own shuts everything down.
+	var stopped int32
+	m = lifecycle.New()
+	m.OnStop("db", func(context.Context) error {
+		atomic.StoreInt32(&stopped, 1)
+		return nil
+	})
+	m.Serve("grpc", func() error { return errors.New("address in use") }, func(context.Context) error { return nil })
+	assert.EqualError(t, m.Run(context.Background()), "grpc: address in use")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&stopped))
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	m := lifecycle.NewWithConfig(lifecycle.Config{ShutdownTimeout: 10 * time.Millisecond})
+	block := make(chan struct{})
+	defer close(block)
+	var closed int32
+	m.OnStop("db", func(context.Context) error {
+		atomic.StoreInt32(&closed, 1)
+		return nil
+	})
+	m.Go("stuck", func(ctx context.Context) { <-block })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := m.Run(ctx)
+	assert.EqualError(t, err, "stopping stuck: context deadline exceeded")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&closed), "the other components are still stopped")
+}
+
+func TestEvery(t *testing.T) {
+	var runs int32
+	m := lifecycle.New()
+	m.Every("job", time.Millisecond, func(ctx context.Context) error {
+		atomic.AddInt32(&runs, 1)
+		return errors.New("logged")
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- m.Run(ctx) }()
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&runs) >= 3 }, time.Second, time.Millisecond)
+	cancel()
+	assert.NoError(t, <-done)
+}
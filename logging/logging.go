@@ -0,0 +1,178 @@
+// Package logging holds the conventions of the structured logs of the
+// service: levels, the request ID carried by contexts and the redaction of
+// secrets. Lines are written as JSON by the loggers of gommon/log, which
+// echo uses too.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/gommon/log"
+)
+
+// Config tunes the logs.
+type Config struct {
+	Level log.Lvl
+	// SQL logs every database statement at debug level.
+	SQL bool
+	// SlowQuery logs the statements taking at least this long as warnings.
+	// Zero disables it.
+	SlowQuery time.Duration
+}
+
+var DefaultConfig = Config{
+	Level:     log.INFO,
+	SlowQuery: 200 * time.Millisecond,
+}
+
+// ConfigFromEnv reads LOG_LEVEL (debug, info, warn, error or off),
+// LOG_SQL (a boolean) and LOG_SLOW_QUERY (a duration, 0 to disable),
+// falling back to DefaultConfig for the unset ones.
+func ConfigFromEnv() (Config, error) {
+	config := DefaultConfig
+	if v := os.Getenv("LOG_LEVEL"); v != "" {
+		l, err := ParseLevel(v)
+		if err != nil {
+			return config, err
+		}
+		config.Level = l
+	}
+	if v := os.Getenv("LOG_SQL"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return config, fmt.Errorf("LOG_SQL: %v", err)
+		}
+		config.SQL = b
+	}
+	if v := os.Getenv("LOG_SLOW_QUERY"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return config, fmt.Errorf("LOG_SLOW_QUERY: %v", err)
+		}
+		config.SlowQuery = d
+	}
+	return config, nil
+}
+
+// ParseLevel parses the name of a level.
+func ParseLevel(s string) (log.Lvl, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return log.DEBUG, nil
+	case "info":
+		return log.INFO, nil
+	case "warn", "warning":
+		return log.WARN, nil
+	case "error":
+		return log.ERROR, nil
+	case "off":
+		return log.OFF, nil
+	}
+	return 0, fmt.Errorf("unknown log level %q", s)
+}
+
+type requestIDKey struct{}
+
+// WithRequestID returns a copy of ctx carrying the request ID id.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID carried by ctx, or "".
+func RequestID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// Fields returns the fields of a log line made for ctx: j, redacted, with
+// the request ID of ctx if any.
+func Fields(ctx context.Context, j log.JSON) log.JSON {
+	j = Redact(j)
+	if id := RequestID(ctx); id != "" {
+		j["request_id"] = id
+	}
+	return j
+}
+
+// ErrorFields returns the fields of a line made for ctx reporting err.
+func ErrorFields(ctx context.Context, message string, err error) log.JSON {
+	return Fields(ctx, log.JSON{"message": message, "error": err.Error()})
+}
+
+// redacted replaces the values of secrets.
+const redacted = "REDACTED"
+
+// secret reports whether values under the key k are secrets.
+func secret(k string) bool {
+	k = strings.ToLower(k)
+	for _, s := range []string{"password", "token", "secret", "authorization"} {
+		if strings.Contains(k, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Redact returns a copy of j whose secrets, such as passwords and tokens,
+// are replaced, in nested objects too.
+func Redact(j log.JSON) log.JSON {
+	r := make(log.JSON, len(j))
+	for k, v := range j {
+		switch {
+		case secret(k):
+			v = redacted
+		case isJSON(v):
+			v = Redact(toJSON(v))
+		}
+		r[k] = v
+	}
+	return r
+}
+
+func isJSON(v interface{}) bool {
+	switch v.(type) {
+	case log.JSON, map[string]interface{}:
+		return true
+	}
+	return false
+}
+
+func toJSON(v interface{}) log.JSON {
+	if j, ok := v.(log.JSON); ok {
+		return j
+	}
+	return log.JSON(v.(map[string]interface{}))
+}
+
+// RedactURI replaces the secrets passed in the query of uri, such as the
+// token of the event stream.
+func RedactURI(uri string) string {
+	i := strings.IndexByte(uri, '?')
+	if i < 0 {
+		return uri
+	}
+	q, err := url.ParseQuery(uri[i+1:])
+	if err != nil {
+		return uri[:i]
+	}
+	changed := false
+	for k := range q {
+		if secret(k) {
+			q[k] = []string{redacted}
+			changed = true
+		}
+	}
+	if !changed {
+		return uri
+	}
+	return uri[:i+1] + q.Encode()
+}
@@ -0,0 +1,75 @@
+package logging_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/labstack/gommon/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/sumitalp/productcatalog/logging"
+)
+
+func setenv(t *testing.T, key, value string) {
+	old, ok := os.LookupEnv(key)
+	require.NoError(t, os.Setenv(key, value))
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(key, old)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+}
+
+func TestConfigFromEnv(t *testing.T) {
+	config, err := logging.ConfigFromEnv()
+	require.NoError(t, err)
+	assert.Equal(t, logging.DefaultConfig, config)
+
+	setenv(t, "LOG_LEVEL", "DEBUG")
+	setenv(t, "LOG_SQL", "true")
+	setenv(t, "LOG_SLOW_QUERY", "1s")
+	config, err = logging.ConfigFromEnv()
+	require.NoError(t, err)
+	assert.Equal(t, logging.Config{Level: log.DEBUG, SQL: true, SlowQuery: time.Second}, config)
+
+	setenv(t, "LOG_LEVEL", "loud")
+	_, err = logging.ConfigFromEnv()
+	assert.EqualError(t, err, `unknown log level "loud"`)
+}
+
+func TestFields(t *testing.T) {
+	ctx := logging.WithRequestID(context.Background(), "req-1")
+	assert.Equal(t, "req-1", logging.RequestID(ctx))
+	assert.Empty(t, logging.RequestID(context.Background()))
+
+	fields := log.JSON{
+		"message":  "signup",
+		"password": "hunter2",
+		"user": map[string]interface{}{
+			"email":         "alice@email.io",
+			"accessToken":   "abc",
+			"webhookSecret": log.JSON{"nested": true},
+		},
+	}
+	assert.Equal(t, log.JSON{
+		"message":  "signup",
+		"password": "REDACTED",
+		"user": log.JSON{
+			"email":         "alice@email.io",
+			"accessToken":   "REDACTED",
+			"webhookSecret": "REDACTED",
+		},
+		"request_id": "req-1",
+	}, logging.Fields(ctx, fields))
+	assert.Equal(t, "hunter2", fields["password"], "the fields are not modified")
+}
+
+func TestRedactURI(t *testing.T) {
+	assert.Equal(t, "/api/stream?category=books&token=REDACTED", logging.RedactURI("/api/stream?token=abc&category=books"))
+	assert.Equal(t, "/api/products?limit=5", logging.RedactURI("/api/products?limit=5"))
+	assert.Equal(t, "/api/products", logging.RedactURI("/api/products"))
+}
@@ -0,0 +1,197 @@
+// Package mail sends the emails of the service, such as address
+// verifications and password resets, over SMTP or to files and the log
+// during development.
+package mail
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Message is an email with a plain text body and an optional HTML
+// alternative.
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Mailer sends messages. Implementations are safe for concurrent use.
+type Mailer interface {
+	Send(ctx context.Context, m Message) error
+}
+
+// Config selects and tunes the mailer.
+type Config struct {
+	// SMTPAddr is the host:port of the SMTP server relaying the messages.
+	SMTPAddr     string
+	SMTPUsername string
+	SMTPPassword string
+	// Dir is the directory messages are written to when SMTPAddr is empty.
+	Dir string
+	// Log drops the messages, logging their recipient and subject, when
+	// SMTPAddr and Dir are empty. Without it New fails, so that a missing
+	// configuration does not lose emails unnoticed.
+	Log bool
+	// From is the sender of the messages.
+	From string
+	// BaseURL prefixes the links of the messages. It is the address of the
+	// front end, which calls the API with the token of the link.
+	BaseURL string
+}
+
+var DefaultConfig = Config{
+	From:    "Product Catalog <no-reply@localhost>",
+	BaseURL: "http://localhost:8585",
+}
+
+// ConfigFromEnv reads MAIL_SMTP_ADDR, MAIL_SMTP_USERNAME,
+// MAIL_SMTP_PASSWORD, MAIL_DIR, MAIL_LOG, MAIL_FROM and MAIL_BASE_URL,
+// falling back to DefaultConfig for the unset ones.
+func ConfigFromEnv() (Config, error) {
+	config := DefaultConfig
+	config.SMTPAddr = os.Getenv("MAIL_SMTP_ADDR")
+	config.SMTPUsername = os.Getenv("MAIL_SMTP_USERNAME")
+	config.SMTPPassword = os.Getenv("MAIL_SMTP_PASSWORD")
+	config.Dir = os.Getenv("MAIL_DIR")
+	if v := os.Getenv("MAIL_LOG"); v != "" {
+		l, err := strconv.ParseBool(v)
+		if err != nil {
+			return config, fmt.Errorf("MAIL_LOG: %v", err)
+		}
+		config.Log = l
+	}
+	if v := os.Getenv("MAIL_FROM"); v != "" {
+		if _, err := mail.ParseAddress(v); err != nil {
+			return config, fmt.Errorf("MAIL_FROM: %v", err)
+		}
+		config.From = v
+	}
+	if v := os.Getenv("MAIL_BASE_URL"); v != "" {
+		config.BaseURL = strings.TrimRight(v, "/")
+	}
+	return config, nil
+}
+
+// New returns the mailer selected by config: SMTP when config.SMTPAddr is
+// set, files when config.Dir is, the log when config.Log is. It fails
+// when none is.
+func New(config Config) (Mailer, error) {
+	switch {
+	case config.SMTPAddr != "":
+		return NewSMTP(SMTPConfig{
+			Addr:     config.SMTPAddr,
+			Username: config.SMTPUsername,
+			Password: config.SMTPPassword,
+			From:     config.From,
+		}), nil
+	case config.Dir != "":
+		return NewFile(config.Dir, config.From)
+	case config.Log:
+		return NewLog(), nil
+	default:
+		return nil, errors.New("mail: no mailer configured, set MAIL_SMTP_ADDR, MAIL_DIR or MAIL_LOG")
+	}
+}
+
+// encode formats m as a MIME message sent by from, with a
+// multipart/alternative body when it has an HTML part.
+func encode(from string, m Message, now time.Time) ([]byte, error) {
+	if strings.ContainsAny(m.To+m.Subject, "\r\n") {
+		return nil, errors.New("mail: line break in header")
+	}
+	var buf bytes.Buffer
+	header := textproto.MIMEHeader{}
+	header.Set("From", from)
+	header.Set("To", m.To)
+	header.Set("Subject", mime.QEncoding.Encode("utf-8", m.Subject))
+	header.Set("Date", now.Format(time.RFC1123Z))
+	header.Set("Message-Id", "<"+randomID()+"@"+domain(from)+">")
+	header.Set("Mime-Version", "1.0")
+
+	if m.HTML == "" {
+		header.Set("Content-Type", "text/plain; charset=utf-8")
+		header.Set("Content-Transfer-Encoding", "quoted-printable")
+		writeHeader(&buf, header)
+		if err := writeQuotedPrintable(&buf, m.Text); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	body := multipart.NewWriter(&buf)
+	header.Set("Content-Type", "multipart/alternative; boundary="+body.Boundary())
+	writeHeader(&buf, header)
+	for _, part := range []struct{ contentType, content string }{
+		{"text/plain; charset=utf-8", m.Text},
+		{"text/html; charset=utf-8", m.HTML},
+	} {
+		w, err := body.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := writeQuotedPrintable(w, part.content); err != nil {
+			return nil, err
+		}
+	}
+	if err := body.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// headerOrder keeps encoded messages stable and readable.
+var headerOrder = []string{"From", "To", "Subject", "Date", "Message-Id", "Mime-Version", "Content-Type", "Content-Transfer-Encoding"}
+
+func writeHeader(buf *bytes.Buffer, header textproto.MIMEHeader) {
+	for _, k := range headerOrder {
+		if v := header.Get(k); v != "" {
+			fmt.Fprintf(buf, "%s: %s\r\n", k, v)
+		}
+	}
+	buf.WriteString("\r\n")
+}
+
+func writeQuotedPrintable(w interface{ Write([]byte) (int, error) }, s string) error {
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write([]byte(strings.Replace(s, "\n", "\r\n", -1))); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+// domain returns the domain of the address from, for message ids.
+func domain(from string) string {
+	if a, err := mail.ParseAddress(from); err == nil {
+		from = a.Address
+	}
+	if i := strings.LastIndex(from, "@"); i >= 0 {
+		return from[i+1:]
+	}
+	return "localhost"
+}
+
+func randomID() string {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprint(time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,249 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/labstack/gommon/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// smtpServer is a minimal SMTP server recording the commands and messages
+// it receives.
+type smtpServer struct {
+	l net.Listener
+
+	mu       sync.Mutex
+	commands []string
+	messages []string
+}
+
+func newSMTPServer(t *testing.T) *smtpServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := &smtpServer{l: l}
+	go s.serve()
+	t.Cleanup(func() { l.Close() })
+	return s
+}
+
+func (s *smtpServer) serve() {
+	for {
+		conn, err := s.l.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(textproto.NewConn(conn))
+	}
+}
+
+func (s *smtpServer) handle(c *textproto.Conn) {
+	defer c.Close()
+	c.PrintfLine("220 localhost ESMTP")
+	for {
+		line, err := c.ReadLine()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.commands = append(s.commands, line)
+		s.mu.Unlock()
+		switch verb := strings.ToUpper(strings.Fields(line)[0]); verb {
+		case "EHLO":
+			c.PrintfLine("250-localhost")
+			c.PrintfLine("250 AUTH PLAIN")
+		case "AUTH":
+			c.PrintfLine("235 accepted")
+		case "DATA":
+			c.PrintfLine("354 go ahead")
+			data, err := c.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.messages = append(s.messages, string(data))
+			s.mu.Unlock()
+			c.PrintfLine("250 queued")
+		case "QUIT":
+			c.PrintfLine("221 bye")
+			return
+		default:
+			c.PrintfLine("250 ok")
+		}
+	}
+}
+
+func (s *smtpServer) received() ([]string, []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.commands...), append([]string(nil), s.messages...)
+}
+
+func TestSMTP(t *testing.T) {
+	s := newSMTPServer(t)
+	m := NewSMTP(SMTPConfig{Addr: s.l.Addr().String(), Username: "user", Password: "pass", From: "Catalog <no-reply@example.com>"})
+
+	msg, err := VerifyEmail.Render("alice@email.io", LinkData{Username: "alice", Link: "http://example.com/verify?token=t", ValidFor: "1 day"})
+	require.NoError(t, err)
+	require.NoError(t, m.Send(context.Background(), msg))
+
+	commands, messages := s.received()
+	assert.Contains(t, commands, "MAIL FROM:<no-reply@example.com>")
+	assert.Contains(t, commands, "RCPT TO:<alice@email.io>")
+	assert.True(t, strings.HasPrefix(commands[1], "AUTH PLAIN"), "authenticated on localhost")
+	require.Len(t, messages, 1)
+
+	parsed, err := mail.ReadMessage(strings.NewReader(messages[0]))
+	require.NoError(t, err)
+	assert.Equal(t, "alice@email.io", parsed.Header.Get("To"))
+	assert.Equal(t, "Confirm your email address", parsed.Header.Get("Subject"))
+	assert.Contains(t, parsed.Header.Get("Message-Id"), "@example.com>")
+	mediaType, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	require.NoError(t, err)
+	assert.Equal(t, "multipart/alternative", mediaType)
+
+	r := multipart.NewReader(parsed.Body, params["boundary"])
+	var types, bodies []string
+	for {
+		p, err := r.NextPart()
+		if err != nil {
+			break
+		}
+		b, err := ioutil.ReadAll(p)
+		require.NoError(t, err)
+		types = append(types, p.Header.Get("Content-Type"))
+		bodies = append(bodies, string(b))
+	}
+	assert.Equal(t, []string{"text/plain; charset=utf-8", "text/html; charset=utf-8"}, types)
+	require.Len(t, bodies, 2)
+	assert.Contains(t, bodies[0], "http://example.com/verify?token=t")
+	assert.Contains(t, bodies[1], `<a href="http://example.com/verify?token=t">`)
+}
+
+func TestSMTPUnreachable(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	l.Close()
+	m := NewSMTP(SMTPConfig{Addr: addr})
+	assert.Error(t, m.Send(context.Background(), Message{To: "alice@email.io", Subject: "s", Text: "t"}))
+}
+
+func TestEncodeRejectsHeaderInjection(t *testing.T) {
+	_, err := encode("a@example.com", Message{To: "alice@email.io\r\nBcc: eve@email.io", Subject: "s"}, time.Now())
+	assert.Error(t, err)
+}
+
+func TestFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mail")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	f, err := NewFile(filepath.Join(dir, "outbox"), "no-reply@example.com")
+	require.NoError(t, err)
+	require.NoError(t, f.Send(context.Background(), Message{To: "alice@email.io", Subject: "Hello", Text: "plain only"}))
+
+	files, err := filepath.Glob(filepath.Join(dir, "outbox", "*.eml"))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	b, err := ioutil.ReadFile(files[0])
+	require.NoError(t, err)
+	parsed, err := mail.ReadMessage(strings.NewReader(string(b)))
+	require.NoError(t, err)
+	assert.Equal(t, "text/plain; charset=utf-8", parsed.Header.Get("Content-Type"))
+	body, err := ioutil.ReadAll(parsed.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "plain only", string(body))
+}
+
+func TestNewRequiresAMailer(t *testing.T) {
+	_, err := New(DefaultConfig)
+	assert.Error(t, err)
+
+	config := DefaultConfig
+	config.Log = true
+	m, err := New(config)
+	require.NoError(t, err)
+	assert.IsType(t, &Log{}, m)
+}
+
+func TestLogOmitsText(t *testing.T) {
+	var buf bytes.Buffer
+	out := log.Output()
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(out) })
+
+	require.NoError(t, NewLog().Send(context.Background(), Message{
+		To:      "alice@email.io",
+		Subject: "Reset your password",
+		Text:    "http://example.com/reset-password?token=secret",
+		HTML:    `<a href="http://example.com/reset-password?token=secret">reset</a>`,
+	}))
+	assert.Contains(t, buf.String(), "alice@email.io")
+	assert.Contains(t, buf.String(), "Reset your password")
+	assert.NotContains(t, buf.String(), "secret")
+}
+
+func TestTemplateEscapesHTML(t *testing.T) {
+	msg, err := ResetPassword.Render("alice@email.io", LinkData{Username: "<b>alice</b>", Link: "http://example.com/reset?token=t", ValidFor: "1 hour"})
+	require.NoError(t, err)
+	assert.Equal(t, "Reset your password", msg.Subject)
+	assert.Contains(t, msg.Text, "Hello <b>alice</b>,")
+	assert.Contains(t, msg.HTML, "Hello &lt;b&gt;alice&lt;/b&gt;,")
+	assert.Contains(t, msg.HTML, "<title>Reset your password</title>")
+	assert.Contains(t, msg.HTML, "1 hour")
+}
+
+type recorder struct {
+	mu   sync.Mutex
+	sent []Message
+}
+
+func (r *recorder) Send(ctx context.Context, m Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sent = append(r.sent, m)
+	return nil
+}
+
+func (r *recorder) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.sent)
+}
+
+func TestQueue(t *testing.T) {
+	rec := &recorder{}
+	q := NewQueueWithConfig(rec, QueueConfig{Size: 2})
+	require.NoError(t, q.Send(context.Background(), Message{Subject: "1"}))
+	require.NoError(t, q.Send(context.Background(), Message{Subject: "2"}))
+	assert.Equal(t, ErrQueueFull, q.Send(context.Background(), Message{Subject: "3"}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	q.Run(ctx)
+	assert.Equal(t, 2, rec.count(), "waiting messages are sent on stop")
+
+	ctx, cancel = context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		q.Run(ctx)
+	}()
+	require.NoError(t, q.Send(context.Background(), Message{Subject: "4"}))
+	assert.Eventually(t, func() bool { return rec.count() == 3 }, time.Second, time.Millisecond)
+	cancel()
+	<-done
+}
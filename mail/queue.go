@@ -0,0 +1,99 @@
+package mail
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/labstack/gommon/log"
+)
+
+// ErrQueueFull is returned by Queue.Send when the queue holds as many
+// messages as it can.
+var ErrQueueFull = errors.New("mail queue is full")
+
+// QueueConfig tunes a Queue.
+type QueueConfig struct {
+	// Size is the number of messages waiting to be sent beyond which Send
+	// fails.
+	Size int
+	// DrainTimeout bounds the sending of the waiting messages once Run is
+	// stopped.
+	DrainTimeout time.Duration
+}
+
+var DefaultQueueConfig = QueueConfig{
+	Size:         100,
+	DrainTimeout: 5 * time.Second,
+}
+
+// Queue is a Mailer sending messages in the background with another
+// mailer, so that requests neither wait for the mail server nor reveal,
+// by their duration, whether they sent a message. Messages are kept in
+// process: those waiting are lost if the process dies.
+type Queue struct {
+	mailer   Mailer
+	config   QueueConfig
+	messages chan Message
+}
+
+func NewQueue(m Mailer) *Queue {
+	return NewQueueWithConfig(m, DefaultQueueConfig)
+}
+
+func NewQueueWithConfig(m Mailer, config QueueConfig) *Queue {
+	if config.Size <= 0 {
+		config.Size = DefaultQueueConfig.Size
+	}
+	if config.DrainTimeout <= 0 {
+		config.DrainTimeout = DefaultQueueConfig.DrainTimeout
+	}
+	return &Queue{
+		mailer:   m,
+		config:   config,
+		messages: make(chan Message, config.Size),
+	}
+}
+
+// Send queues m. It fails with ErrQueueFull rather than block.
+func (q *Queue) Send(ctx context.Context, m Message) error {
+	select {
+	case q.messages <- m:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Run sends the queued messages until ctx is done, and then those still
+// waiting within DrainTimeout. Failures are logged.
+func (q *Queue) Run(ctx context.Context) {
+	for {
+		select {
+		case m := <-q.messages:
+			q.send(ctx, m)
+		case <-ctx.Done():
+			q.drain()
+			return
+		}
+	}
+}
+
+func (q *Queue) drain() {
+	ctx, cancel := context.WithTimeout(context.Background(), q.config.DrainTimeout)
+	defer cancel()
+	for {
+		select {
+		case m := <-q.messages:
+			q.send(ctx, m)
+		default:
+			return
+		}
+	}
+}
+
+func (q *Queue) send(ctx context.Context, m Message) {
+	if err := q.mailer.Send(ctx, m); err != nil {
+		log.Errorj(log.JSON{"message": "sending mail", "subject": m.Subject, "error": err.Error()})
+	}
+}
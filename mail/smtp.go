@@ -0,0 +1,114 @@
+package mail
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"time"
+)
+
+// SMTPConfig tunes an SMTP mailer.
+type SMTPConfig struct {
+	// Addr is the host:port of the server.
+	Addr string
+	// Username and Password authenticate with PLAIN, which the client only
+	// does over TLS or to a server on localhost. Empty for none.
+	Username string
+	Password string
+	From     string
+	// Timeout bounds the sending of a message.
+	Timeout time.Duration
+	Now     func() time.Time
+}
+
+var DefaultSMTPConfig = SMTPConfig{
+	From:    DefaultConfig.From,
+	Timeout: 10 * time.Second,
+	Now:     time.Now,
+}
+
+// SMTP relays messages to an SMTP server, upgrading the connection with
+// STARTTLS when the server offers it.
+type SMTP struct {
+	config SMTPConfig
+}
+
+func NewSMTP(config SMTPConfig) *SMTP {
+	if config.From == "" {
+		config.From = DefaultSMTPConfig.From
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultSMTPConfig.Timeout
+	}
+	if config.Now == nil {
+		config.Now = DefaultSMTPConfig.Now
+	}
+	return &SMTP{config: config}
+}
+
+func (s *SMTP) Send(ctx context.Context, m Message) error {
+	data, err := encode(s.config.From, m, s.config.Now())
+	if err != nil {
+		return err
+	}
+	from, err := mail.ParseAddress(s.config.From)
+	if err != nil {
+		return err
+	}
+	to, err := mail.ParseAddress(m.To)
+	if err != nil {
+		return err
+	}
+	host, _, err := net.SplitHostPort(s.config.Addr)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.config.Timeout)
+	defer cancel()
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", s.config.Addr)
+	if err != nil {
+		return err
+	}
+	deadline, _ := ctx.Deadline()
+	if err := conn.SetDeadline(deadline); err != nil {
+		conn.Close()
+		return err
+	}
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if s.config.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", s.config.Username, s.config.Password, host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(from.Address); err != nil {
+		return err
+	}
+	if err := c.Rcpt(to.Address); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
@@ -0,0 +1,124 @@
+package mail
+
+import (
+	"bytes"
+	htmltemplate "html/template"
+	"text/template"
+)
+
+// LinkData is what the templates of the service are executed with.
+type LinkData struct {
+	Username string
+	// Link carries the token proving the user received the message.
+	Link string
+	// ValidFor describes how long the link works, such as "1 hour".
+	ValidFor string
+}
+
+// Template renders messages from a subject and a text body, both text
+// templates, and an HTML body, an html/template executed inside the
+// common layout.
+type Template struct {
+	subject *template.Template
+	text    *template.Template
+	html    *htmltemplate.Template
+}
+
+const layout = `<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.Subject}}</title></head>
+<body style="font-family: sans-serif; line-height: 1.5; color: #222;">
+{{template "content" .Data}}
+<p style="color: #888; font-size: small;">Product Catalog</p>
+</body>
+</html>
+`
+
+func NewTemplate(subject, text, html string) (*Template, error) {
+	t := &Template{}
+	var err error
+	if t.subject, err = template.New("subject").Parse(subject); err != nil {
+		return nil, err
+	}
+	if t.text, err = template.New("text").Parse(text); err != nil {
+		return nil, err
+	}
+	if t.html, err = htmltemplate.New("layout").Parse(layout); err != nil {
+		return nil, err
+	}
+	if _, err = t.html.New("content").Parse(html); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func MustTemplate(subject, text, html string) *Template {
+	t, err := NewTemplate(subject, text, html)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// Render returns the message to send to with the templates executed with
+// data.
+func (t *Template) Render(to string, data interface{}) (Message, error) {
+	m := Message{To: to}
+	var buf bytes.Buffer
+	if err := t.subject.Execute(&buf, data); err != nil {
+		return m, err
+	}
+	m.Subject = buf.String()
+	buf.Reset()
+	if err := t.text.Execute(&buf, data); err != nil {
+		return m, err
+	}
+	m.Text = buf.String()
+	buf.Reset()
+	err := t.html.Execute(&buf, struct {
+		Subject string
+		Data    interface{}
+	}{m.Subject, data})
+	if err != nil {
+		return m, err
+	}
+	m.HTML = buf.String()
+	return m, nil
+}
+
+// VerifyEmail asks a user to confirm their address.
+var VerifyEmail = MustTemplate(
+	`Confirm your email address`,
+	`Hello {{.Username}},
+
+Please confirm your email address by opening this link:
+
+{{.Link}}
+
+The link works for {{.ValidFor}}. If you did not sign up, ignore this
+message.
+`,
+	`<p>Hello {{.Username}},</p>
+<p>Please confirm your email address:</p>
+<p><a href="{{.Link}}">Confirm my email address</a></p>
+<p>The link works for {{.ValidFor}}. If you did not sign up, ignore this message.</p>
+`)
+
+// ResetPassword sends a user the link to choose a new password.
+var ResetPassword = MustTemplate(
+	`Reset your password`,
+	`Hello {{.Username}},
+
+Someone asked to reset the password of your account. Choose a new password
+by opening this link:
+
+{{.Link}}
+
+The link works for {{.ValidFor}} and only once. If you did not ask for it,
+ignore this message: your password is unchanged.
+`,
+	`<p>Hello {{.Username}},</p>
+<p>Someone asked to reset the password of your account.</p>
+<p><a href="{{.Link}}">Choose a new password</a></p>
+<p>The link works for {{.ValidFor}} and only once. If you did not ask for it, ignore this message: your password is unchanged.</p>
+`)
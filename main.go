@@ -1,22 +1,184 @@
 package main
 
 import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+
+	"github.com/sumitalp/productcatalog/cache"
 	"github.com/sumitalp/productcatalog/db"
+	"github.com/sumitalp/productcatalog/event"
+	"github.com/sumitalp/productcatalog/graph"
 	"github.com/sumitalp/productcatalog/handler"
+	"github.com/sumitalp/productcatalog/health"
+	"github.com/sumitalp/productcatalog/lifecycle"
+	"github.com/sumitalp/productcatalog/logging"
+	"github.com/sumitalp/productcatalog/mail"
+	"github.com/sumitalp/productcatalog/metrics"
+	"github.com/sumitalp/productcatalog/models"
+	"github.com/sumitalp/productcatalog/outbox"
+	"github.com/sumitalp/productcatalog/product"
+	"github.com/sumitalp/productcatalog/ratelimit"
 	"github.com/sumitalp/productcatalog/repository"
+	"github.com/sumitalp/productcatalog/repository/cached"
 	"github.com/sumitalp/productcatalog/router"
+	"github.com/sumitalp/productcatalog/router/middleware"
+	"github.com/sumitalp/productcatalog/rpc"
+	"github.com/sumitalp/productcatalog/stream"
+	"github.com/sumitalp/productcatalog/tracing"
+	"github.com/sumitalp/productcatalog/user"
+	"github.com/sumitalp/productcatalog/utils"
+	"github.com/sumitalp/productcatalog/webhook"
+	"google.golang.org/grpc"
 )
 
 func main() {
 	r := router.New()
 	v1 := r.Group("/api")
 
+	logConfig, err := logging.ConfigFromEnv()
+	if err != nil {
+		r.Logger.Fatal(err)
+	}
+	r.Logger.SetLevel(logConfig.Level)
+	log.SetLevel(logConfig.Level)
+
 	d := db.New()
+	db.Log(d, r.Logger, logConfig)
 	db.AutoMigrate(d)
 
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.ConfigFromEnv("productcatalog"))
+	if err != nil {
+		r.Logger.Fatal(err)
+	}
+	r.Use(middleware.Tracing("productcatalog"))
+
+	m := metrics.New()
+	r.Use(m.Middleware())
+	r.GET("/metrics", echo.WrapHandler(m.Handler()))
+	db.Observe(d, m.ObserveQuery)
+	m.RegisterDB(d.DB())
+
+	cacheConfig, err := cache.ConfigFromEnv()
+	if err != nil {
+		r.Logger.Fatal(err)
+	}
+	productCache, err := cache.New(cacheConfig)
+	if err != nil {
+		r.Logger.Fatal(err)
+	}
+	rateLimits, err := ratelimit.ConfigFromEnv()
+	if err != nil {
+		r.Logger.Fatal(err)
+	}
+	mailConfig, err := mail.ConfigFromEnv()
+	if err != nil {
+		r.Logger.Fatal(err)
+	}
+	mailer, err := mail.New(mailConfig)
+	if err != nil {
+		r.Logger.Fatal(err)
+	}
+	mailQueue := mail.NewQueue(mailer)
+	webhookConfig, err := webhook.ConfigFromEnv()
+	if err != nil {
+		r.Logger.Fatal(err)
+	}
+
 	us := repository.NewUserRepository(d)
-	as := repository.NewProductRepository(d)
-	h := handler.NewHandler(us, as)
+	as := cached.NewProductRepository(repository.NewProductRepository(d), productCache, cacheConfig.TTL)
+	is := repository.NewIdempotencyRepository(d)
+	ws := repository.NewWebhookRepository(d)
+	ob := repository.NewOutboxRepository(d)
+	tx := repository.NewUnitOfWork(d)
+	userService := user.NewService(us, tx)
+	productService := product.NewService(as, tx)
+	webhookService := webhook.NewServiceWithConfig(ws, tx, webhookConfig)
+	streamBroker := stream.NewBroker()
+	userService.OnSignUp(func(*models.User) { m.Signups.Inc() })
+	userService.LimitLogins(ratelimit.NewLockoutWithConfig(rateLimits.Lockout))
+	if roles, ok := user.TwoFactorRolesFromEnv(); ok {
+		userService.RequireTwoFactor(roles...)
+	}
+	userService.MailWith(mailQueue, mailConfig.BaseURL)
+	m.RegisterCatalog(productService)
+
+	// Catalog changes store their events in the outbox, in their own
+	// transaction, and the outbox hands them to the subscribers of bus.
+	bus := event.NewBus()
+	bus.Subscribe("webhooks", webhookService)
+	bus.Subscribe("stream", streamBroker)
+	events := outbox.New(ob, bus)
+	productService.PublishTo(events)
+
+	checker := health.NewChecker()
+	checker.AddCheck("database", func(ctx context.Context) error { return db.Ping(ctx, d) })
+	checker.AddCheck("migrations", func(context.Context) error { return db.CheckMigrations(d) })
+	checker.AddDetail("dbPool", health.DBStats(d.DB()))
+	checker.AddWorker("outbox", events.Status)
+	checker.AddWorker("webhooks", webhookService.Status)
+	checker.Register(r.Group(""), middleware.JWTWithConfig(middleware.JWTConfig{
+		SigningKey: utils.JWTSecret,
+		Validator:  userService.TokenValid,
+	}))
+
+	h := handler.NewHandler(userService, productService, webhookService, streamBroker, is, tx)
+	h.CacheMaxAge(cacheConfig.MaxAge)
+	// The writes of the three APIs share their quota.
+	writes := ratelimit.NewLimiter(rateLimits.Write)
+	h.RateLimit(rateLimits)
+	h.LimitWrites(writes)
 	h.Register(v1)
-	r.Logger.Fatal(r.Start("127.0.0.1:8585"))
+	gh := graph.NewHandler(userService, productService)
+	gh.LimitWrites(writes)
+	gh.Register(r.Group("/graphql"))
+
+	gs := rpc.NewServer(userService.TokenValid, grpc.ChainUnaryInterceptor(rpc.UnaryRateLimit(writes)))
+	rpc.NewCatalogServer(productService).Register(gs)
+	l, err := net.Listen("tcp", "127.0.0.1:8586")
+	if err != nil {
+		r.Logger.Fatal(err)
+	}
+
+	// Components stop in the reverse order: readiness fails first so that
+	// no new traffic comes in, open event streams are ended so that the
+	// servers can drain, and the workers stop before the database closes.
+	lc := lifecycle.New()
+	lc.OnStop("database", func(context.Context) error { return d.Close() })
+	lc.OnStop("tracing", shutdownTracing)
+	if rc, ok := productCache.(*cache.Redis); ok {
+		lc.OnStop("cache", func(context.Context) error { return rc.Close() })
+	}
+	lc.Go("outbox", events.Run)
+	lc.Go("webhooks", webhookService.Run)
+	lc.Go("mail", mailQueue.Run)
+	lc.Every("purge", time.Hour, func(ctx context.Context) error {
+		if _, err := is.WithContext(ctx).DeleteExpired(time.Now()); err != nil {
+			return err
+		}
+		if _, err := us.WithContext(ctx).DeleteExpiredTokens(time.Now()); err != nil {
+			return err
+		}
+		_, err := events.Purge(ctx, 7*24*time.Hour)
+		return err
+	})
+	lc.Serve("grpc", func() error { return gs.Serve(l) }, func(ctx context.Context) error { return rpc.Shutdown(ctx, gs) })
+	lc.Serve("http", func() error { return r.Start("127.0.0.1:8585") }, r.Shutdown)
+	lc.OnStop("stream", func(context.Context) error {
+		streamBroker.Close()
+		return nil
+	})
+	lc.OnStop("readiness", func(context.Context) error {
+		checker.Drain()
+		return nil
+	})
+
+	ctx, stop := lifecycle.SignalContext(context.Background())
+	defer stop()
+	if err := lc.Run(ctx); err != nil {
+		r.Logger.Fatal(err)
+	}
 }
@@ -0,0 +1,147 @@
+// Package metrics exposes the operational and business metrics of the
+// catalog in the Prometheus format.
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sumitalp/productcatalog/product"
+	"github.com/sumitalp/productcatalog/router/middleware"
+)
+
+const namespace = "productcatalog"
+
+// Metrics holds the collectors of the service in a registry of its own.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	queryDuration   *prometheus.HistogramVec
+	queryErrors     *prometheus.CounterVec
+
+	// Signups counts the users who signed up since the process started.
+	Signups prometheus.Counter
+}
+
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "http",
+			Name:      "requests_total",
+			Help:      "HTTP requests handled, by method, route template and status code.",
+		}, []string{"method", "route", "code"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "http",
+			Name:      "request_duration_seconds",
+			Help:      "Time taken to handle HTTP requests, by method and route template.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route"}),
+		queryDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "db",
+			Name:      "query_duration_seconds",
+			Help:      "Time taken by database statements, by operation and table.",
+			Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+		}, []string{"operation", "table"}),
+		queryErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "db",
+			Name:      "query_errors_total",
+			Help:      "Database statements which failed, by operation and table.",
+		}, []string{"operation", "table"}),
+		Signups: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "signups_total",
+			Help:      "Users who signed up.",
+		}),
+	}
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requests,
+		m.requestDuration,
+		m.queryDuration,
+		m.queryErrors,
+		m.Signups,
+	)
+	return m
+}
+
+// Handler serves the metrics to Prometheus.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// Middleware counts and times the requests by route template, so that
+// /api/products/:slug is one series whatever the slug. Requests matching
+// no route are reported with an empty route.
+func (m *Metrics) Middleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			start := time.Now()
+			middleware.RenderError(c, next(c))
+			route := middleware.Route(c)
+			method := c.Request().Method
+			m.requests.WithLabelValues(method, route, strconv.Itoa(c.Response().Status)).Inc()
+			m.requestDuration.WithLabelValues(method, route).Observe(time.Since(start).Seconds())
+			return nil
+		}
+	}
+}
+
+// ObserveQuery records a database statement. It is a db.QueryObserver.
+func (m *Metrics) ObserveQuery(operation, table string, d time.Duration, err error) {
+	m.queryDuration.WithLabelValues(operation, table).Observe(d.Seconds())
+	if err != nil {
+		m.queryErrors.WithLabelValues(operation, table).Inc()
+	}
+}
+
+// RegisterDB exposes the statistics of the connection pool of d.
+func (m *Metrics) RegisterDB(d *sql.DB) {
+	m.registry.MustRegister(collectors.NewDBStatsCollector(d, "catalog"))
+}
+
+// RegisterCatalog exposes the number of products and categories, counted
+// by ps when the metrics are scraped.
+func (m *Metrics) RegisterCatalog(ps *product.Service) {
+	m.registry.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "products",
+			Help:      "Products in the catalog.",
+		}, func() float64 {
+			_, n, err := ps.List(context.Background(), product.ListFilter{Limit: 1})
+			return count(n, err)
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "categories",
+			Help:      "Categories in the catalog.",
+		}, func() float64 {
+			_, n, err := ps.ListCategories(context.Background(), 0, 1)
+			return count(n, err)
+		}),
+	)
+}
+
+// count reports failed counts as NaN rather than as an empty catalog.
+func count(n int, err error) float64 {
+	if err != nil {
+		return math.NaN()
+	}
+	return float64(n)
+}
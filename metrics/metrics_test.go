@@ -0,0 +1,68 @@
+package metrics_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/sumitalp/productcatalog/metrics"
+	"github.com/sumitalp/productcatalog/models"
+	"github.com/sumitalp/productcatalog/product"
+	"github.com/sumitalp/productcatalog/repository/memory"
+)
+
+func scrape(t *testing.T, m *metrics.Metrics) string {
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest(echo.GET, "/metrics", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	body, err := ioutil.ReadAll(rec.Body)
+	require.NoError(t, err)
+	return string(body)
+}
+
+func TestMiddleware(t *testing.T) {
+	m := metrics.New()
+	e := echo.New()
+	e.Use(m.Middleware())
+	e.GET("/products/:slug", func(c echo.Context) error {
+		if c.Param("slug") == "missing" {
+			return echo.NewHTTPError(http.StatusNotFound)
+		}
+		return c.NoContent(http.StatusOK)
+	})
+	for _, path := range []string{"/products/a", "/products/b", "/products/missing", "/nowhere"} {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(echo.GET, path, nil))
+	}
+
+	body := scrape(t, m)
+	assert.Contains(t, body, `productcatalog_http_requests_total{code="200",method="GET",route="/products/:slug"} 2`)
+	assert.Contains(t, body, `productcatalog_http_requests_total{code="404",method="GET",route="/products/:slug"} 1`)
+	assert.Contains(t, body, `productcatalog_http_requests_total{code="404",method="GET",route=""} 1`)
+	assert.Contains(t, body, `productcatalog_http_request_duration_seconds_count{method="GET",route="/products/:slug"} 3`)
+}
+
+func TestQueriesAndCatalog(t *testing.T) {
+	m := metrics.New()
+	s := memory.NewStore()
+	ps := product.NewService(memory.NewProductRepository(s), memory.NewUnitOfWork(s))
+	m.RegisterCatalog(ps)
+	require.NoError(t, ps.CreateCategory(context.Background(), &models.Category{Category: "books"}))
+	m.ObserveQuery("query", "products", time.Millisecond, nil)
+	m.ObserveQuery("create", "products", time.Millisecond, errors.New("locked"))
+	m.Signups.Inc()
+
+	body := scrape(t, m)
+	assert.Contains(t, body, `productcatalog_db_query_duration_seconds_count{operation="query",table="products"} 1`)
+	assert.Contains(t, body, `productcatalog_db_query_errors_total{operation="create",table="products"} 1`)
+	assert.Contains(t, body, "productcatalog_products 0")
+	assert.Contains(t, body, "productcatalog_categories 1")
+	assert.Contains(t, body, "productcatalog_signups_total 1")
+}
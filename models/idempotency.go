@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// IdempotencyKey records a request sent with an Idempotency-Key header and,
+// once it completed, the response to replay when the request is retried.
+type IdempotencyKey struct {
+	ID        uint `gorm:"primary_key"`
+	CreatedAt time.Time
+	UserID    uint   `gorm:"unique_index:idx_idempotency_keys_user_key"`
+	Key       string `gorm:"unique_index:idx_idempotency_keys_user_key;not null"`
+	// Fingerprint identifies the request the key was first used with.
+	Fingerprint string `gorm:"not null"`
+	// StatusCode is zero while the request is still being processed.
+	StatusCode  int
+	ContentType string
+	Body        []byte
+	// ExpiresAt ends the claim of a request still being processed and,
+	// once it completed, the replay of its response.
+	ExpiresAt time.Time `gorm:"index"`
+}
+
+// Completed reports whether the response of the request was recorded.
+func (k *IdempotencyKey) Completed() bool {
+	return k.StatusCode != 0
+}
@@ -0,0 +1,47 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// OutboxEvent is a change event stored in the transaction of the change,
+// waiting to be handed to the subscribers of the event bus.
+type OutboxEvent struct {
+	ID         uint `gorm:"primary_key"`
+	CreatedAt  time.Time
+	EventID    string `gorm:"unique_index;not null"`
+	Type       string `gorm:"not null"`
+	OccurredAt time.Time
+	// Payload is the JSON encoded event.
+	Payload []byte
+	// Handled is a space separated list of the subscribers which handled
+	// the event, so a retry skips them.
+	Handled       string
+	Attempts      int       `gorm:"not null"`
+	NextAttemptAt time.Time `gorm:"index"`
+	LastError     string
+	DispatchedAt  *time.Time `gorm:"index"`
+	// DeadAt is set when the event was given up on, because it could not
+	// be decoded or failed too many times. It is kept for inspection but
+	// no longer dispatched.
+	DeadAt *time.Time `gorm:"index"`
+}
+
+// HandledBy reports whether the subscriber name handled e.
+func (e *OutboxEvent) HandledBy(name string) bool {
+	for _, n := range strings.Fields(e.Handled) {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// MarkHandled records that the subscriber name handled e.
+func (e *OutboxEvent) MarkHandled(name string) {
+	if e.HandledBy(name) {
+		return
+	}
+	e.Handled = strings.TrimSpace(e.Handled + " " + name)
+}
@@ -1,14 +1,17 @@
 package models
 
 import (
-	"time"
 	"github.com/jinzhu/gorm"
+	"time"
 )
 
 type ModelBase struct {
-	ID        uint       `gorm:"primary_key"`
+	ID        uint      `gorm:"primary_key"`
 	CreatedAt time.Time `json:"-"`
 	UpdatedAt time.Time `json:"-"`
+	// Version is incremented by every update and guards against lost
+	// updates: writes only apply to the version they were based on.
+	Version uint `gorm:"not null;default:1" json:"-"`
 }
 
 type Product struct {
@@ -29,4 +32,4 @@ type Category struct {
 	Products    []Product `gorm:"many2many:products;"`
 }
 
-var GormDB *gorm.DB
\ No newline at end of file
+var GormDB *gorm.DB
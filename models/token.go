@@ -0,0 +1,38 @@
+package models
+
+import "time"
+
+// Purposes of a UserToken. A token only serves the purpose it was issued
+// for.
+const (
+	TokenVerifyEmail   = "verify_email"
+	TokenResetPassword = "reset_password"
+	// TokenLoginChallenge is given on login for the password to users who
+	// must also give a code.
+	TokenLoginChallenge = "login_challenge"
+)
+
+// UserToken is a single-use secret mailed to a user to prove they own
+// their email address. Only a hash of the secret is stored.
+type UserToken struct {
+	ID        uint `gorm:"primary_key"`
+	CreatedAt time.Time
+	UserID    uint   `gorm:"index;not null"`
+	Purpose   string `gorm:"not null"`
+	Hash      string `gorm:"unique_index;not null"`
+	// Email is the address the token was mailed to. A verification token
+	// does not verify an address the user changed to since.
+	Email     string
+	ExpiresAt time.Time `gorm:"index"`
+	UsedAt    *time.Time
+}
+
+// RecoveryCode is a single-use code letting a user log in without their
+// authenticator app. Only a hash of the code is stored.
+type RecoveryCode struct {
+	ID        uint `gorm:"primary_key"`
+	CreatedAt time.Time
+	UserID    uint   `gorm:"index;not null"`
+	Hash      string `gorm:"unique_index;not null"`
+	UsedAt    *time.Time
+}
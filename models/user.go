@@ -2,11 +2,15 @@ package models
 
 import (
 	"errors"
+	"time"
 
 	"github.com/jinzhu/gorm"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// RoleAdmin is the role of the operators of the catalog.
+const RoleAdmin = "admin"
+
 type User struct {
 	gorm.Model
 	Username  string `gorm:"unique_index;not null"`
@@ -14,9 +18,30 @@ type User struct {
 	Password  string `gorm:"not null"`
 	Bio       *string
 	Image     *string
+	// Role names the privileges of the user, empty for regular users.
+	// Roles are assigned by operators, never through the API.
+	Role string
+	// EmailVerifiedAt is set once the user proved they own Email.
+	EmailVerifiedAt *time.Time
+	// TOTPSecret is shared with the authenticator app of the user. It is
+	// set on enrollment, and only used once TwoFactorEnabledAt is set too.
+	TOTPSecret         string
+	TwoFactorEnabledAt *time.Time
+	// TOTPLastStep is the last step a code was accepted for, so that codes
+	// are not replayed.
+	TOTPLastStep int64
+	// TokenGeneration is carried by the tokens issued to the user. Raising
+	// it, as changing the password does, revokes the tokens issued before.
+	TokenGeneration uint
 	// Products []Product `gorm:"many2many:products;"`
 }
 
+// TwoFactorEnabled reports whether logging in as u takes a code besides
+// the password.
+func (u *User) TwoFactorEnabled() bool {
+	return u.TwoFactorEnabledAt != nil && u.TOTPSecret != ""
+}
+
 func (u *User) HashPassword(plain string) (string, error) {
 	if len(plain) == 0 {
 		return "", errors.New("password should not be empty")
@@ -0,0 +1,70 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// WebhookSubscription asks for the catalog events matching Events to be
+// posted to URL, signed with Secret.
+type WebhookSubscription struct {
+	ID        uint `gorm:"primary_key"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	UserID    uint   `gorm:"index;not null"`
+	URL       string `gorm:"not null"`
+	Secret    string `gorm:"not null"`
+	// Events is a space separated list of event types. "product.*" stands
+	// for every product event and "*" for every event.
+	Events string `gorm:"not null"`
+	Active bool   `gorm:"not null"`
+	// ConsecutiveFailures counts the deliveries which ran out of attempts
+	// since the last successful one.
+	ConsecutiveFailures int `gorm:"not null"`
+	DisabledAt          *time.Time
+}
+
+// EventTypes returns the event types of s.
+func (s *WebhookSubscription) EventTypes() []string {
+	return strings.Fields(s.Events)
+}
+
+// Matches reports whether s asks for events of type typ.
+func (s *WebhookSubscription) Matches(typ string) bool {
+	for _, pattern := range s.EventTypes() {
+		if pattern == "*" || pattern == typ {
+			return true
+		}
+		if strings.HasSuffix(pattern, ".*") && strings.HasPrefix(typ, pattern[:len(pattern)-1]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Delivery statuses.
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+// WebhookDelivery is one event sent, or to be sent, to a subscription. It
+// stays pending while attempts are left.
+type WebhookDelivery struct {
+	ID             uint `gorm:"primary_key"`
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+	SubscriptionID uint   `gorm:"index;not null"`
+	EventID        string `gorm:"not null"`
+	EventType      string `gorm:"not null"`
+	Payload        []byte
+	Status         string    `gorm:"index;not null"`
+	Attempts       int       `gorm:"not null"`
+	NextAttemptAt  time.Time `gorm:"index"`
+	LastStatusCode int
+	LastError      string
+	DeliveredAt    *time.Time
+	// ReplayOf is the id of the delivery this one repeats, if any.
+	ReplayOf *uint
+}
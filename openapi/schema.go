@@ -0,0 +1,216 @@
+package openapi
+
+import (
+	"encoding/json"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+)
+
+// Schema returns the schema of the JSON encoding of v. Named struct types
+// are added to the components of d and referenced, anonymous ones are
+// described inline.
+//
+// Fields are named after their json tag. The rules of their validate tag
+// (go-playground/validator syntax) become constraints where OpenAPI has
+// an equivalent; rules after "dive" apply to the elements of a slice.
+func (d *Document) Schema(v interface{}) *Schema {
+	return d.schema(reflect.TypeOf(v))
+}
+
+func (d *Document) schema(t reflect.Type) *Schema {
+	switch {
+	case t == timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case t == rawMessageType:
+		// Any JSON value.
+		return &Schema{}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		s := d.schema(t.Elem())
+		if s.Ref == "" {
+			s.Nullable = true
+		}
+		return s
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &Schema{Type: "integer", Format: intFormat(t)}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		zero := 0.0
+		return &Schema{Type: "integer", Format: intFormat(t), Minimum: &zero}
+	case reflect.Float32:
+		return &Schema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &Schema{Type: "number", Format: "double"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: d.schema(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: d.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return d.object(t)
+		}
+		name := schemaName(t)
+		if _, ok := d.Components.Schemas[name]; !ok {
+			// Register the name first so recursive types terminate.
+			d.Components.Schemas[name] = &Schema{}
+			*d.Components.Schemas[name] = *d.object(t)
+		}
+		return &Schema{Ref: "#/components/schemas/" + name}
+	}
+	return &Schema{}
+}
+
+func (d *Document) object(t reflect.Type) *Schema {
+	s := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" && !f.Anonymous {
+			continue
+		}
+		name := jsonName(f)
+		if name == "-" {
+			continue
+		}
+		if f.Anonymous && name == "" {
+			embedded := d.object(indirect(f.Type))
+			for k, v := range embedded.Properties {
+				s.Properties[k] = v
+			}
+			s.Required = append(s.Required, embedded.Required...)
+			continue
+		}
+		fs := d.schema(f.Type)
+		if applyRules(fs, f.Tag.Get("validate")) {
+			s.Required = append(s.Required, name)
+		}
+		s.Properties[name] = fs
+	}
+	return s
+}
+
+// applyRules adds the constraints of a validate tag to s and reports
+// whether the field is required.
+func applyRules(s *Schema, tag string) bool {
+	if tag == "" {
+		return false
+	}
+	required := false
+	target := s
+	for _, rule := range strings.Split(tag, ",") {
+		name, param := rule, ""
+		if i := strings.Index(rule, "="); i >= 0 {
+			name, param = rule[:i], rule[i+1:]
+		}
+		switch name {
+		case "required":
+			if target == s {
+				required = true
+			} else if target.Type == "string" {
+				target.MinLength = intPtr(1)
+			}
+		case "dive":
+			if target.Items == nil {
+				return required
+			}
+			target = target.Items
+		case "email":
+			target.Format = "email"
+		case "url", "uri":
+			target.Format = "uri"
+		case "uuid":
+			target.Format = "uuid"
+		case "oneof":
+			for _, v := range strings.Fields(param) {
+				target.Enum = append(target.Enum, v)
+			}
+		case "startswith":
+			target.Pattern = "^" + regexp.QuoteMeta(param)
+		case "min", "gte":
+			setBound(target, param, true)
+		case "max", "lte":
+			setBound(target, param, false)
+		case "len":
+			setBound(target, param, true)
+			setBound(target, param, false)
+		}
+	}
+	return required
+}
+
+// setBound sets the lower or upper bound of the length, item count or
+// value of s, depending on its type.
+func setBound(s *Schema, param string, lower bool) {
+	n, err := strconv.ParseFloat(param, 64)
+	if err != nil {
+		return
+	}
+	switch s.Type {
+	case "string":
+		if lower {
+			s.MinLength = intPtr(int(n))
+		} else {
+			s.MaxLength = intPtr(int(n))
+		}
+	case "array":
+		if lower {
+			s.MinItems = intPtr(int(n))
+		} else {
+			s.MaxItems = intPtr(int(n))
+		}
+	case "integer", "number":
+		if lower {
+			s.Minimum = &n
+		} else {
+			s.Maximum = &n
+		}
+	}
+}
+
+func jsonName(f reflect.StructField) string {
+	name := strings.SplitN(f.Tag.Get("json"), ",", 2)[0]
+	if name == "" && !f.Anonymous {
+		return f.Name
+	}
+	return name
+}
+
+// schemaName exports the Go name of t: userResponse becomes UserResponse.
+func schemaName(t reflect.Type) string {
+	r := []rune(t.Name())
+	r[0] = unicode.ToUpper(r[0])
+	return string(r)
+}
+
+func intFormat(t reflect.Type) string {
+	if t.Bits() == 64 {
+		return "int64"
+	}
+	return "int32"
+}
+
+func indirect(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+func intPtr(n int) *int {
+	return &n
+}
@@ -0,0 +1,242 @@
+// Package outbox makes the side effects of catalog changes survive a crash.
+// Events are stored in the transaction of the change that raised them and
+// later handed, at least once, to the subscribers of an event bus.
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/labstack/gommon/log"
+	"github.com/sumitalp/productcatalog/event"
+	"github.com/sumitalp/productcatalog/health"
+	"github.com/sumitalp/productcatalog/models"
+	"github.com/sumitalp/productcatalog/uow"
+)
+
+type RepositoryInterface interface {
+	// WithContext returns a copy of the repository whose operations are
+	// bound to ctx and abort once it is done.
+	WithContext(ctx context.Context) RepositoryInterface
+
+	Create(*models.OutboxEvent) error
+	Update(*models.OutboxEvent) error
+	// ListPending returns up to limit events neither dispatched nor dead
+	// whose next attempt is due at t, oldest first.
+	ListPending(t time.Time, limit int) ([]models.OutboxEvent, error)
+	// DeleteDispatched removes the events dispatched before t and returns
+	// how many there were.
+	DeleteDispatched(t time.Time) (int, error)
+}
+
+// Config tunes the dispatch of events.
+type Config struct {
+	// PollInterval is how often Run looks for pending events when it is
+	// not woken up by a commit.
+	PollInterval time.Duration
+	// BatchSize is the number of events dispatched per poll.
+	BatchSize int
+	// BaseBackoff is the wait after the first failed dispatch of an event.
+	// It doubles with every further failure, up to MaxBackoff.
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+	// MaxAttempts is the number of failed dispatches after which an event
+	// is given up on. Events which cannot be decoded are given up on at
+	// once.
+	MaxAttempts int
+	// Now returns the current time.
+	Now func() time.Time
+}
+
+var DefaultConfig = Config{
+	PollInterval: time.Second,
+	BatchSize:    100,
+	BaseBackoff:  5 * time.Second,
+	MaxBackoff:   10 * time.Minute,
+	MaxAttempts:  150, // about a day
+	Now:          time.Now,
+}
+
+// Outbox is an event.Publisher storing events for dispatch to bus.
+type Outbox struct {
+	events RepositoryInterface
+	bus    *event.Bus
+	config Config
+	wake   chan struct{}
+	worker health.Worker
+}
+
+func New(events RepositoryInterface, bus *event.Bus) *Outbox {
+	return NewWithConfig(events, bus, DefaultConfig)
+}
+
+func NewWithConfig(events RepositoryInterface, bus *event.Bus, config Config) *Outbox {
+	if config.PollInterval <= 0 {
+		config.PollInterval = DefaultConfig.PollInterval
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = DefaultConfig.BatchSize
+	}
+	if config.BaseBackoff <= 0 {
+		config.BaseBackoff = DefaultConfig.BaseBackoff
+	}
+	if config.MaxBackoff <= 0 {
+		config.MaxBackoff = DefaultConfig.MaxBackoff
+	}
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = DefaultConfig.MaxAttempts
+	}
+	if config.Now == nil {
+		config.Now = DefaultConfig.Now
+	}
+	return &Outbox{
+		events: events,
+		bus:    bus,
+		config: config,
+		wake:   make(chan struct{}, 1),
+	}
+}
+
+// Publish stores e through ctx, so it is kept only if the unit of work of
+// ctx commits, and wakes Run up once it has.
+func (o *Outbox) Publish(ctx context.Context, e *event.Event) error {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	err = o.events.WithContext(ctx).Create(&models.OutboxEvent{
+		EventID:       e.ID,
+		Type:          e.Type,
+		OccurredAt:    e.OccurredAt,
+		Payload:       payload,
+		NextAttemptAt: o.config.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	uow.AfterCommit(ctx, o.notify)
+	return nil
+}
+
+func (o *Outbox) notify() {
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run dispatches pending events until ctx is done, after every commit
+// which stored some and every PollInterval.
+func (o *Outbox) Run(ctx context.Context) {
+	o.worker.Start()
+	defer o.worker.Stop()
+	ticker := time.NewTicker(o.config.PollInterval)
+	defer ticker.Stop()
+	for {
+		for {
+			n, err := o.Dispatch(ctx)
+			if ctx.Err() == nil {
+				o.worker.Ran(err)
+			}
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Errorf("outbox dispatch: %v", err)
+				}
+				break
+			}
+			if n < o.config.BatchSize {
+				break
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-o.wake:
+		}
+	}
+}
+
+// Status reports the state of the Run loop.
+func (o *Outbox) Status() health.WorkerStatus {
+	return o.worker.Status()
+}
+
+// Dispatch hands up to BatchSize pending events to the subscribers which
+// have not handled them yet and returns how many events it tried.
+// Subscribers may see an event more than once, if the process stops
+// between handling it and recording that, and events retried after a
+// failure may overtake it. They must therefore be idempotent and rely on
+// the event ids and versions rather than on ordering.
+func (o *Outbox) Dispatch(ctx context.Context) (int, error) {
+	pending, err := o.events.WithContext(ctx).ListPending(o.config.Now(), o.config.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+	for i := range pending {
+		if err := o.dispatch(ctx, &pending[i]); err != nil {
+			return i, err
+		}
+	}
+	return len(pending), nil
+}
+
+func (o *Outbox) dispatch(ctx context.Context, m *models.OutboxEvent) error {
+	var failures []string
+	e, err := event.Unmarshal(m.Payload)
+	undecodable := err != nil
+	if undecodable {
+		failures = append(failures, fmt.Sprintf("decode: %v", err))
+	} else {
+		for _, name := range o.bus.Subscribers() {
+			if m.HandledBy(name) {
+				continue
+			}
+			if err := o.bus.Deliver(ctx, name, e); err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				failures = append(failures, fmt.Sprintf("%s: %v", name, err))
+				continue
+			}
+			m.MarkHandled(name)
+		}
+	}
+
+	now := o.config.Now()
+	m.Attempts++
+	m.LastError = ""
+	switch {
+	case len(failures) == 0:
+		m.DispatchedAt = &now
+	case undecodable || m.Attempts >= o.config.MaxAttempts:
+		m.LastError = strings.Join(failures, "; ")
+		m.DeadAt = &now
+		log.Errorf("outbox: gave up on event %s after %d attempts: %s", m.EventID, m.Attempts, m.LastError)
+	default:
+		m.LastError = strings.Join(failures, "; ")
+		m.NextAttemptAt = now.Add(o.backoff(m.Attempts))
+		log.Warnf("outbox: event %s failed: %s", m.EventID, m.LastError)
+	}
+	return o.events.WithContext(ctx).Update(m)
+}
+
+// backoff returns the wait after the given number of failed dispatches.
+func (o *Outbox) backoff(attempts int) time.Duration {
+	d := o.config.BaseBackoff
+	for i := 1; i < attempts; i++ {
+		d *= 2
+		if d >= o.config.MaxBackoff {
+			return o.config.MaxBackoff
+		}
+	}
+	return d
+}
+
+// Purge removes the events dispatched more than age ago.
+func (o *Outbox) Purge(ctx context.Context, age time.Duration) (int, error) {
+	return o.events.WithContext(ctx).DeleteDispatched(o.config.Now().Add(-age))
+}
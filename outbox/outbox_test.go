@@ -0,0 +1,272 @@
+package outbox_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/labstack/gommon/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/sumitalp/productcatalog/event"
+	"github.com/sumitalp/productcatalog/models"
+	"github.com/sumitalp/productcatalog/outbox"
+	"github.com/sumitalp/productcatalog/product"
+	"github.com/sumitalp/productcatalog/repository/memory"
+	"github.com/sumitalp/productcatalog/utils"
+)
+
+// recorder is a subscriber remembering the events it handled. It fails
+// while fail is set.
+type recorder struct {
+	mu     sync.Mutex
+	events []*event.Event
+	fail   error
+}
+
+func (r *recorder) Publish(ctx context.Context, e *event.Event) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.fail != nil {
+		return r.fail
+	}
+	r.events = append(r.events, e)
+	return nil
+}
+
+func (r *recorder) types() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	types := make([]string, 0, len(r.events))
+	for _, e := range r.events {
+		types = append(types, e.Type)
+	}
+	return types
+}
+
+func (r *recorder) setFail(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.fail = err
+}
+
+type fixture struct {
+	repo     *memory.OutboxRepository
+	outbox   *outbox.Outbox
+	products *product.Service
+	search   *recorder
+	cache    *recorder
+	now      time.Time
+	owner    uint
+}
+
+func setup(t *testing.T) *fixture {
+	s := memory.NewStore()
+	tx := memory.NewUnitOfWork(s)
+	u := &models.User{Username: "alice", Email: "alice@email.io", Password: "hash"}
+	require.NoError(t, memory.NewUserRepository(s).Create(u))
+	f := &fixture{
+		repo:   memory.NewOutboxRepository(s),
+		search: &recorder{},
+		cache:  &recorder{},
+		now:    time.Now(),
+		owner:  u.ID,
+	}
+	bus := event.NewBus()
+	bus.Subscribe("search", f.search)
+	bus.Subscribe("cache", f.cache)
+	f.outbox = outbox.NewWithConfig(f.repo, bus, outbox.Config{
+		BaseBackoff: time.Minute,
+		MaxAttempts: 3,
+		Now:         func() time.Time { return f.now },
+	})
+	f.products = product.NewService(memory.NewProductRepository(s), tx)
+	f.products.PublishTo(f.outbox)
+	return f
+}
+
+func (f *fixture) dispatch(t *testing.T) int {
+	n, err := f.outbox.Dispatch(context.Background())
+	require.NoError(t, err)
+	return n
+}
+
+func (f *fixture) pending(t *testing.T) []models.OutboxEvent {
+	pending, err := f.repo.ListPending(f.now.Add(24*time.Hour), 100)
+	require.NoError(t, err)
+	return pending
+}
+
+func TestEventsAreStoredWithTheChange(t *testing.T) {
+	f := setup(t)
+	p := &models.Product{Title: "First product"}
+	require.NoError(t, f.products.Create(context.Background(), f.owner, p))
+	require.NoError(t, f.products.CreateCategory(context.Background(), &models.Category{Category: "books"}))
+
+	// Failed changes leave no event behind.
+	err := f.products.Create(context.Background(), f.owner, &models.Product{Title: "First product"})
+	assert.True(t, errors.Is(err, utils.ErrDuplicate), "%v", err)
+	_, err = f.products.Update(context.Background(), f.owner, "first-product", func(p *models.Product) error {
+		return utils.BadRequest("no")
+	})
+	assert.Error(t, err)
+
+	pending := f.pending(t)
+	require.Len(t, pending, 2)
+	assert.Equal(t, event.ProductCreated, pending[0].Type)
+	assert.Equal(t, event.CategoryCreated, pending[1].Type)
+	assert.Empty(t, f.search.types(), "nothing is dispatched before Dispatch")
+}
+
+func TestDispatch(t *testing.T) {
+	f := setup(t)
+	require.NoError(t, f.products.Create(context.Background(), f.owner, &models.Product{Title: "First product", Description: "desc"}))
+	require.NoError(t, f.products.Delete(context.Background(), f.owner, "first-product", nil))
+
+	assert.Equal(t, 2, f.dispatch(t))
+	assert.Zero(t, f.dispatch(t))
+	assert.Empty(t, f.pending(t))
+	assert.Equal(t, []string{event.ProductCreated, event.ProductDeleted}, f.search.types())
+	assert.Equal(t, []string{event.ProductCreated, event.ProductDeleted}, f.cache.types())
+
+	var data event.Product
+	require.NoError(t, f.search.events[0].Decode(&data))
+	assert.Equal(t, "first-product", data.Slug)
+	assert.Equal(t, "desc", data.Description)
+	assert.Equal(t, "alice", data.Owner)
+}
+
+func TestFailedSubscribersAreRetried(t *testing.T) {
+	f := setup(t)
+	f.cache.setFail(errors.New("cache down"))
+	require.NoError(t, f.products.CreateCategory(context.Background(), &models.Category{Category: "books"}))
+
+	assert.Equal(t, 1, f.dispatch(t))
+	pending := f.pending(t)
+	require.Len(t, pending, 1)
+	assert.Equal(t, 1, pending[0].Attempts)
+	assert.Contains(t, pending[0].LastError, "cache down")
+	assert.True(t, pending[0].HandledBy("search"))
+	assert.Equal(t, f.now.Add(time.Minute), pending[0].NextAttemptAt)
+
+	// The event is not due again before the backoff elapses.
+	f.now = f.now.Add(59 * time.Second)
+	assert.Zero(t, f.dispatch(t))
+	f.now = f.now.Add(time.Second)
+	assert.Equal(t, 1, f.dispatch(t))
+	assert.Equal(t, 2, f.pending(t)[0].Attempts)
+	assert.Equal(t, f.now.Add(2*time.Minute), f.pending(t)[0].NextAttemptAt)
+
+	f.cache.setFail(nil)
+	f.now = f.now.Add(2 * time.Minute)
+	assert.Equal(t, 1, f.dispatch(t))
+	assert.Empty(t, f.pending(t))
+	// Subscribers which already handled the event are not called again.
+	assert.Equal(t, []string{event.CategoryCreated}, f.search.types())
+	assert.Equal(t, []string{event.CategoryCreated}, f.cache.types())
+
+	n, err := f.outbox.Purge(context.Background(), 0)
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+	f.now = f.now.Add(time.Second)
+	n, err = f.outbox.Purge(context.Background(), 0)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+}
+
+// captureLog redirects the log to the returned buffer for the rest of t.
+func captureLog(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	out := log.Output()
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(out) })
+	return &buf
+}
+
+func TestFailingEventsAreGivenUp(t *testing.T) {
+	f := setup(t)
+	logs := captureLog(t)
+	f.cache.setFail(errors.New("cache down"))
+	require.NoError(t, f.products.CreateCategory(context.Background(), &models.Category{Category: "books"}))
+
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, 1, f.dispatch(t))
+		f.now = f.now.Add(time.Hour)
+	}
+	assert.Empty(t, f.pending(t), "the event is dead after MaxAttempts failures")
+	assert.Zero(t, f.dispatch(t))
+	assert.Equal(t, 1, strings.Count(logs.String(), "gave up on event"))
+	assert.Equal(t, 2, strings.Count(logs.String(), "failed: cache: cache down"))
+}
+
+func TestUndecodableEventsAreGivenUpAtOnce(t *testing.T) {
+	f := setup(t)
+	logs := captureLog(t)
+	require.NoError(t, f.repo.Create(&models.OutboxEvent{
+		EventID:       "garbled",
+		Type:          event.ProductCreated,
+		Payload:       []byte("{"),
+		NextAttemptAt: f.now,
+	}))
+
+	assert.Equal(t, 1, f.dispatch(t))
+	assert.Empty(t, f.pending(t))
+	assert.Zero(t, f.dispatch(t))
+	assert.Empty(t, f.search.types())
+	assert.Equal(t, 1, strings.Count(logs.String(), "gave up on event garbled after 1 attempts"))
+}
+
+func TestRunWakesUpOnCommit(t *testing.T) {
+	s := memory.NewStore()
+	tx := memory.NewUnitOfWork(s)
+	rec := &recorder{}
+	bus := event.NewBus()
+	bus.Subscribe("recorder", rec)
+	o := outbox.NewWithConfig(memory.NewOutboxRepository(s), bus, outbox.Config{PollInterval: time.Hour})
+	ps := product.NewService(memory.NewProductRepository(s), tx)
+	ps.PublishTo(o)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		o.Run(ctx)
+		close(done)
+	}()
+	require.NoError(t, ps.CreateCategory(context.Background(), &models.Category{Category: "books"}))
+	assert.Eventually(t, func() bool { return len(rec.types()) == 1 }, 5*time.Second, 10*time.Millisecond)
+	status := o.Status()
+	assert.True(t, status.Running)
+	assert.NotZero(t, status.Runs)
+	assert.Empty(t, status.LastError)
+	cancel()
+	<-done
+	assert.False(t, o.Status().Running)
+}
+
+func TestBus(t *testing.T) {
+	bus := event.NewBus()
+	var calls []string
+	bus.Subscribe("a", event.PublisherFunc(func(ctx context.Context, e *event.Event) error {
+		calls = append(calls, "a")
+		return nil
+	}))
+	bus.Subscribe("b", event.PublisherFunc(func(ctx context.Context, e *event.Event) error {
+		calls = append(calls, "b")
+		return errors.New("boom")
+	}))
+	bus.Subscribe("c", event.PublisherFunc(func(ctx context.Context, e *event.Event) error {
+		calls = append(calls, "c")
+		return nil
+	}))
+	assert.Equal(t, []string{"a", "b", "c"}, bus.Subscribers())
+	assert.Panics(t, func() { bus.Subscribe("a", &recorder{}) })
+
+	err := bus.Publish(context.Background(), event.New(event.ProductCreated, nil))
+	assert.EqualError(t, err, "b: boom")
+	assert.Equal(t, []string{"a", "b"}, calls)
+	assert.Error(t, bus.Deliver(context.Background(), "missing", event.New(event.ProductCreated, nil)))
+}
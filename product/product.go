@@ -1,10 +1,16 @@
 package product
 
 import (
+	"context"
+
 	"github.com/sumitalp/productcatalog/models"
 )
 
 type RepositoryInterface interface {
+	// WithContext returns a copy of the repository whose operations are
+	// bound to ctx and abort once it is done.
+	WithContext(ctx context.Context) RepositoryInterface
+
 	GetBySlug(string) (*models.Product, error)
 	GetUserProductBySlug(userID uint, slug string) (*models.Product, error)
 	CreateProduct(*models.Product) error
@@ -13,6 +19,14 @@ type RepositoryInterface interface {
 	List(offset, limit int) ([]models.Product, int, error)
 	ListByCategory(category string, offset, limit int) ([]models.Product, int, error)
 	ListByOwner(username string, offset, limit int) ([]models.Product, int, error)
+	// Search lists the products whose title or description contains query,
+	// ignoring case.
+	Search(query string, offset, limit int) ([]models.Product, int, error)
+	// ListByCategoryIDs and ListByOwnerIDs return the newest limit products
+	// of each of the given categories or owners, all of them when limit is
+	// negative, newest first, keyed by their id.
+	ListByCategoryIDs(ids []uint, limit int) (map[uint][]models.Product, error)
+	ListByOwnerIDs(ids []uint, limit int) (map[uint][]models.Product, error)
 
 	ListCategories(offset, limit int) ([]models.Category, int, error)
 	CreateCategory(*models.Category) error
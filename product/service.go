@@ -0,0 +1,235 @@
+package product
+
+import (
+	"context"
+
+	"github.com/gosimple/slug"
+	"github.com/sumitalp/productcatalog/event"
+	"github.com/sumitalp/productcatalog/models"
+	"github.com/sumitalp/productcatalog/uow"
+	"github.com/sumitalp/productcatalog/utils"
+)
+
+// Service implements the catalog rules shared by every front end.
+type Service struct {
+	products  RepositoryInterface
+	uow       uow.UnitOfWork
+	publisher event.Publisher
+}
+
+func NewService(products RepositoryInterface, uow uow.UnitOfWork) *Service {
+	return &Service{
+		products: products,
+		uow:      uow,
+	}
+}
+
+// PublishTo makes the service publish an event to p for every product and
+// category it writes. A failing Publish rolls the write back.
+func (s *Service) PublishTo(p event.Publisher) {
+	s.publisher = p
+}
+
+func (s *Service) publish(ctx context.Context, typ string, data interface{}) error {
+	if s.publisher == nil {
+		return nil
+	}
+	return s.publisher.Publish(ctx, event.New(typ, data))
+}
+
+// ListFilter narrows a product listing to a category, an owner or the
+// products matching a search query, in that order of precedence.
+type ListFilter struct {
+	Category string
+	Owner    string
+	Search   string
+	Offset   int
+	Limit    int
+}
+
+// Get returns the product with the given slug, or utils.ErrNotFound.
+func (s *Service) Get(ctx context.Context, slug string) (*models.Product, error) {
+	p, err := s.products.WithContext(ctx).GetBySlug(slug)
+	if err != nil {
+		return nil, err
+	}
+	if p == nil {
+		return nil, utils.ErrNotFound
+	}
+	return p, nil
+}
+
+// List returns a page of products matching f and the total match count.
+func (s *Service) List(ctx context.Context, f ListFilter) ([]models.Product, int, error) {
+	repo := s.products.WithContext(ctx)
+	switch {
+	case f.Category != "":
+		return repo.ListByCategory(f.Category, f.Offset, f.Limit)
+	case f.Owner != "":
+		return repo.ListByOwner(f.Owner, f.Offset, f.Limit)
+	case f.Search != "":
+		return repo.Search(f.Search, f.Offset, f.Limit)
+	default:
+		return repo.List(f.Offset, f.Limit)
+	}
+}
+
+// ListByCategories returns the newest limit products of each of the given
+// categories, keyed by category id, in one round trip.
+func (s *Service) ListByCategories(ctx context.Context, ids []uint, limit int) (map[uint][]models.Product, error) {
+	return s.products.WithContext(ctx).ListByCategoryIDs(ids, limit)
+}
+
+// ListByOwners returns the newest limit products of each of the given
+// users, keyed by user id, in one round trip.
+func (s *Service) ListByOwners(ctx context.Context, ids []uint, limit int) (map[uint][]models.Product, error) {
+	return s.products.WithContext(ctx).ListByOwnerIDs(ids, limit)
+}
+
+// Create stores p on behalf of ownerID, deriving its slug from the title.
+func (s *Service) Create(ctx context.Context, ownerID uint, p *models.Product) error {
+	p.OwnerID = ownerID
+	p.Slug = slug.Make(p.Title)
+	return s.uow.Do(ctx, func(ctx context.Context) error {
+		if err := s.products.WithContext(ctx).CreateProduct(p); err != nil {
+			return err
+		}
+		return s.publish(ctx, event.ProductCreated, event.NewProduct(p))
+	})
+}
+
+// Update loads the product with the given slug owned by userID, lets apply
+// change it and saves the result with a slug derived from the new title.
+// Categories set by apply replace the existing ones. Products owned by
+// someone else are reported as utils.ErrNotFound.
+func (s *Service) Update(ctx context.Context, userID uint, slugValue string, apply func(*models.Product) error) (*models.Product, error) {
+	var p *models.Product
+	err := s.uow.Do(ctx, func(ctx context.Context) error {
+		var err error
+		if p, err = s.owned(ctx, userID, slugValue); err != nil {
+			return err
+		}
+		if err := apply(p); err != nil {
+			return err
+		}
+		p.Slug = slug.Make(p.Title)
+		categories := make([]string, 0, len(p.Categories))
+		for _, c := range p.Categories {
+			categories = append(categories, c.Category)
+		}
+		p.Categories = nil
+		if err := s.products.WithContext(ctx).UpdateProduct(p, categories); err != nil {
+			return err
+		}
+		return s.publish(ctx, event.ProductUpdated, event.NewProduct(p))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Delete removes the product with the given slug owned by userID. When
+// check is not nil it vets the product first and its error aborts the
+// deletion.
+func (s *Service) Delete(ctx context.Context, userID uint, slug string, check func(*models.Product) error) error {
+	return s.uow.Do(ctx, func(ctx context.Context) error {
+		// Loaded with its owner, whom the deletion event names.
+		p, err := s.Get(ctx, slug)
+		if err != nil {
+			return err
+		}
+		if p.OwnerID != userID {
+			return utils.ErrNotFound
+		}
+		if check != nil {
+			if err := check(p); err != nil {
+				return err
+			}
+		}
+		if err := s.products.WithContext(ctx).DeleteProduct(p); err != nil {
+			return err
+		}
+		return s.publish(ctx, event.ProductDeleted, event.NewProduct(p))
+	})
+}
+
+func (s *Service) owned(ctx context.Context, userID uint, slug string) (*models.Product, error) {
+	p, err := s.products.WithContext(ctx).GetUserProductBySlug(userID, slug)
+	if err != nil {
+		return nil, err
+	}
+	if p == nil {
+		return nil, utils.ErrNotFound
+	}
+	return p, nil
+}
+
+// GetCategory returns the category with the given id, or utils.ErrNotFound.
+func (s *Service) GetCategory(ctx context.Context, id uint) (*models.Category, error) {
+	c, err := s.products.WithContext(ctx).GetCategoryByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if c == nil {
+		return nil, utils.ErrNotFound
+	}
+	return c, nil
+}
+
+// ListCategories returns a page of categories and the total count.
+func (s *Service) ListCategories(ctx context.Context, offset, limit int) ([]models.Category, int, error) {
+	return s.products.WithContext(ctx).ListCategories(offset, limit)
+}
+
+func (s *Service) CreateCategory(ctx context.Context, c *models.Category) error {
+	return s.uow.Do(ctx, func(ctx context.Context) error {
+		if err := s.products.WithContext(ctx).CreateCategory(c); err != nil {
+			return err
+		}
+		return s.publish(ctx, event.CategoryCreated, event.NewCategory(c))
+	})
+}
+
+// UpdateCategory loads the category with the given id, lets apply change
+// it and saves the result.
+func (s *Service) UpdateCategory(ctx context.Context, id uint, apply func(*models.Category) error) (*models.Category, error) {
+	var c *models.Category
+	err := s.uow.Do(ctx, func(ctx context.Context) error {
+		var err error
+		if c, err = s.GetCategory(ctx, id); err != nil {
+			return err
+		}
+		if err := apply(c); err != nil {
+			return err
+		}
+		if err := s.products.WithContext(ctx).UpdateCategory(c); err != nil {
+			return err
+		}
+		return s.publish(ctx, event.CategoryUpdated, event.NewCategory(c))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// DeleteCategory removes the category with the given id. When check is not
+// nil it vets the category first and its error aborts the deletion.
+func (s *Service) DeleteCategory(ctx context.Context, id uint, check func(*models.Category) error) error {
+	return s.uow.Do(ctx, func(ctx context.Context) error {
+		c, err := s.GetCategory(ctx, id)
+		if err != nil {
+			return err
+		}
+		if check != nil {
+			if err := check(c); err != nil {
+				return err
+			}
+		}
+		if err := s.products.WithContext(ctx).DeleteCategory(c); err != nil {
+			return err
+		}
+		return s.publish(ctx, event.CategoryDeleted, event.NewCategory(c))
+	})
+}
@@ -0,0 +1,147 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// LockoutConfig tunes a Lockout.
+type LockoutConfig struct {
+	// FreeAttempts is the number of failures allowed without delay, for
+	// typos.
+	FreeAttempts int
+	// Delay is the wait imposed after the first failure beyond
+	// FreeAttempts. It doubles with every further failure, up to MaxDelay.
+	Delay    time.Duration
+	MaxDelay time.Duration
+	// Threshold is the number of failures locking the key out for
+	// Duration. Zero disables the lockout, delays included.
+	Threshold int
+	// Duration is also the time after which failures are forgotten.
+	Duration time.Duration
+	Now      func() time.Time
+}
+
+var DefaultLockoutConfig = LockoutConfig{
+	FreeAttempts: 3,
+	Delay:        time.Second,
+	MaxDelay:     30 * time.Second,
+	Threshold:    10,
+	Duration:     15 * time.Minute,
+	Now:          time.Now,
+}
+
+// Lockout slows down and then refuses the attempts made under a key, such
+// as the email of an account, after repeated failures. It is kept in
+// process: every instance of the service counts on its own.
+type Lockout struct {
+	config LockoutConfig
+
+	mu       sync.Mutex
+	failures map[string]*failures
+	pruned   time.Time
+}
+
+type failures struct {
+	count int
+	last  time.Time
+}
+
+func NewLockout() *Lockout {
+	return NewLockoutWithConfig(DefaultLockoutConfig)
+}
+
+func NewLockoutWithConfig(config LockoutConfig) *Lockout {
+	if config.Delay <= 0 {
+		config.Delay = DefaultLockoutConfig.Delay
+	}
+	if config.MaxDelay < config.Delay {
+		config.MaxDelay = config.Delay
+	}
+	if config.Duration <= 0 {
+		config.Duration = DefaultLockoutConfig.Duration
+	}
+	if config.Now == nil {
+		config.Now = DefaultLockoutConfig.Now
+	}
+	return &Lockout{
+		config:   config,
+		failures: make(map[string]*failures),
+		pruned:   config.Now(),
+	}
+}
+
+// Wait returns how long attempts under key are refused for, zero when they
+// are allowed.
+func (l *Lockout) Wait(key string) time.Duration {
+	if l.config.Threshold <= 0 {
+		return 0
+	}
+	now := l.config.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	f, ok := l.failures[key]
+	if !ok {
+		return 0
+	}
+	if wait := f.last.Add(l.wait(f.count)).Sub(now); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// Fail records a failed attempt under key.
+func (l *Lockout) Fail(key string) {
+	if l.config.Threshold <= 0 {
+		return
+	}
+	now := l.config.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.prune(now)
+	f, ok := l.failures[key]
+	if !ok || now.Sub(f.last) >= l.config.Duration {
+		f = &failures{}
+		l.failures[key] = f
+	}
+	f.count++
+	f.last = now
+}
+
+// Succeed forgets the failures recorded under key.
+func (l *Lockout) Succeed(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.failures, key)
+}
+
+// wait returns the time attempts are refused for after count failures.
+func (l *Lockout) wait(count int) time.Duration {
+	if count >= l.config.Threshold {
+		return l.config.Duration
+	}
+	if count <= l.config.FreeAttempts {
+		return 0
+	}
+	d := l.config.Delay
+	for i := l.config.FreeAttempts + 1; i < count && d < l.config.MaxDelay; i++ {
+		d *= 2
+	}
+	if d > l.config.MaxDelay {
+		d = l.config.MaxDelay
+	}
+	return d
+}
+
+// prune forgets the failures older than the lockout, once per lockout.
+func (l *Lockout) prune(now time.Time) {
+	if now.Sub(l.pruned) < l.config.Duration {
+		return
+	}
+	l.pruned = now
+	for key, f := range l.failures {
+		if now.Sub(f.last) >= l.config.Duration {
+			delete(l.failures, key)
+		}
+	}
+}
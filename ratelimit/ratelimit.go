@@ -0,0 +1,222 @@
+// Package ratelimit throttles clients with token buckets and locks out
+// accounts after repeated failed logins.
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Policy allows Limit requests per Period, in bursts of up to Limit. The
+// zero Policy allows everything.
+type Policy struct {
+	Limit  int
+	Period time.Duration
+}
+
+// Enabled reports whether p limits anything.
+func (p Policy) Enabled() bool {
+	return p.Limit > 0 && p.Period > 0
+}
+
+func (p Policy) String() string {
+	if !p.Enabled() {
+		return "off"
+	}
+	return fmt.Sprintf("%d/%s", p.Limit, p.Period)
+}
+
+// ParsePolicy parses a policy written as "<limit>/<period>", such as
+// "10/1m", or "off".
+func ParsePolicy(s string) (Policy, error) {
+	if s == "off" || s == "0" {
+		return Policy{}, nil
+	}
+	i := strings.Index(s, "/")
+	if i < 0 {
+		return Policy{}, fmt.Errorf("invalid rate limit %q: want <limit>/<period>", s)
+	}
+	limit, err := strconv.Atoi(s[:i])
+	if err != nil || limit < 0 {
+		return Policy{}, fmt.Errorf("invalid rate limit %q: bad limit", s)
+	}
+	period, err := time.ParseDuration(s[i+1:])
+	if err != nil || period <= 0 {
+		return Policy{}, fmt.Errorf("invalid rate limit %q: bad period", s)
+	}
+	return Policy{Limit: limit, Period: period}, nil
+}
+
+// Config holds the policies of the service and the lockout of accounts.
+type Config struct {
+	// Login limits the login attempts of each client address.
+	Login Policy
+	// SignUp limits the accounts created from each client address.
+	SignUp Policy
+	// Write limits the writes of each user.
+	Write Policy
+	// Mail limits the password reset and verification emails requested by
+	// each client address or user.
+	Mail    Policy
+	Lockout LockoutConfig
+}
+
+var DefaultConfig = Config{
+	Login:   Policy{Limit: 10, Period: time.Minute},
+	SignUp:  Policy{Limit: 10, Period: time.Hour},
+	Write:   Policy{Limit: 60, Period: time.Minute},
+	Mail:    Policy{Limit: 5, Period: time.Hour},
+	Lockout: DefaultLockoutConfig,
+}
+
+// ConfigFromEnv reads RATE_LIMIT_LOGIN, RATE_LIMIT_SIGNUP, RATE_LIMIT_WRITE
+// and RATE_LIMIT_MAIL (policies such as "10/1m", or "off"), LOCKOUT_THRESHOLD
+// (a number of failed logins, 0 to disable) and LOCKOUT_DURATION, falling
+// back to DefaultConfig for the unset ones.
+func ConfigFromEnv() (Config, error) {
+	config := DefaultConfig
+	policies := []struct {
+		name   string
+		policy *Policy
+	}{
+		{"RATE_LIMIT_LOGIN", &config.Login},
+		{"RATE_LIMIT_SIGNUP", &config.SignUp},
+		{"RATE_LIMIT_WRITE", &config.Write},
+		{"RATE_LIMIT_MAIL", &config.Mail},
+	}
+	for _, p := range policies {
+		if v := os.Getenv(p.name); v != "" {
+			policy, err := ParsePolicy(v)
+			if err != nil {
+				return config, fmt.Errorf("%s: %v", p.name, err)
+			}
+			*p.policy = policy
+		}
+	}
+	if v := os.Getenv("LOCKOUT_THRESHOLD"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return config, fmt.Errorf("LOCKOUT_THRESHOLD: %v", err)
+		}
+		config.Lockout.Threshold = n
+	}
+	if v := os.Getenv("LOCKOUT_DURATION"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return config, fmt.Errorf("LOCKOUT_DURATION: %v", err)
+		}
+		config.Lockout.Duration = d
+	}
+	return config, nil
+}
+
+// Result is the outcome of Limiter.Allow.
+type Result struct {
+	Allowed bool
+	Limit   int
+	// Remaining is the number of requests allowed right away.
+	Remaining int
+	// Reset is the time until the bucket is full again.
+	Reset time.Duration
+	// RetryAfter is the time until the next request is allowed, zero when
+	// it is already.
+	RetryAfter time.Duration
+}
+
+// Limiter keeps a token bucket per key. It is kept in process: every
+// instance of the service limits on its own.
+type Limiter struct {
+	policy Policy
+	now    func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	pruned  time.Time
+}
+
+type bucket struct {
+	tokens float64
+	at     time.Time
+}
+
+// LimiterConfig tunes a Limiter.
+type LimiterConfig struct {
+	Policy Policy
+	Now    func() time.Time
+}
+
+func NewLimiter(p Policy) *Limiter {
+	return NewLimiterWithConfig(LimiterConfig{Policy: p})
+}
+
+func NewLimiterWithConfig(config LimiterConfig) *Limiter {
+	if config.Now == nil {
+		config.Now = time.Now
+	}
+	return &Limiter{
+		policy:  config.Policy,
+		now:     config.Now,
+		buckets: make(map[string]*bucket),
+		pruned:  config.Now(),
+	}
+}
+
+// Policy returns the policy of l.
+func (l *Limiter) Policy() Policy {
+	return l.policy
+}
+
+// Allow takes a token from the bucket of key, if there is one left.
+func (l *Limiter) Allow(key string) Result {
+	if !l.policy.Enabled() {
+		return Result{Allowed: true}
+	}
+	now := l.now()
+	limit := float64(l.policy.Limit)
+	// rate is the number of tokens added per second.
+	rate := limit / l.policy.Period.Seconds()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.prune(now)
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: limit, at: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(limit, b.tokens+now.Sub(b.at).Seconds()*rate)
+	b.at = now
+
+	r := Result{Limit: l.policy.Limit}
+	if b.tokens >= 1 {
+		b.tokens--
+		r.Allowed = true
+	} else {
+		r.RetryAfter = seconds((1 - b.tokens) / rate)
+	}
+	r.Remaining = int(b.tokens)
+	r.Reset = seconds((limit - b.tokens) / rate)
+	return r
+}
+
+// prune drops the buckets which have filled up again, once per period.
+func (l *Limiter) prune(now time.Time) {
+	if now.Sub(l.pruned) < l.policy.Period {
+		return
+	}
+	l.pruned = now
+	for key, b := range l.buckets {
+		if now.Sub(b.at) >= l.policy.Period {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
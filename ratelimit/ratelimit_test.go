@@ -0,0 +1,97 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePolicy(t *testing.T) {
+	p, err := ParsePolicy("10/1m")
+	require.NoError(t, err)
+	assert.Equal(t, Policy{Limit: 10, Period: time.Minute}, p)
+	assert.Equal(t, "10/1m0s", p.String())
+	p, err = ParsePolicy("off")
+	require.NoError(t, err)
+	assert.False(t, p.Enabled())
+	for _, s := range []string{"10", "x/1m", "10/x", "10/0s", "-1/1m"} {
+		_, err := ParsePolicy(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	now := time.Now()
+	l := NewLimiterWithConfig(LimiterConfig{
+		Policy: Policy{Limit: 3, Period: 3 * time.Second},
+		Now:    func() time.Time { return now },
+	})
+	for i := 2; i >= 0; i-- {
+		r := l.Allow("a")
+		assert.True(t, r.Allowed)
+		assert.Equal(t, 3, r.Limit)
+		assert.Equal(t, i, r.Remaining)
+	}
+	r := l.Allow("a")
+	assert.False(t, r.Allowed)
+	assert.Equal(t, 0, r.Remaining)
+	assert.Equal(t, time.Second, r.RetryAfter)
+	assert.Equal(t, 3*time.Second, r.Reset)
+	assert.True(t, l.Allow("b").Allowed, "keys have their own bucket")
+
+	now = now.Add(time.Second)
+	r = l.Allow("a")
+	assert.True(t, r.Allowed, "refilled at the rate of the policy")
+	assert.Equal(t, 0, r.Remaining)
+	assert.False(t, l.Allow("a").Allowed)
+
+	now = now.Add(time.Hour)
+	assert.Equal(t, 2, l.Allow("a").Remaining, "full again, not beyond the limit")
+	assert.Len(t, l.buckets, 1, "idle buckets are pruned")
+
+	assert.True(t, NewLimiter(Policy{}).Allow("a").Allowed)
+}
+
+func TestLockout(t *testing.T) {
+	now := time.Now()
+	l := NewLockoutWithConfig(LockoutConfig{
+		FreeAttempts: 2,
+		Delay:        time.Second,
+		MaxDelay:     3 * time.Second,
+		Threshold:    6,
+		Duration:     time.Minute,
+		Now:          func() time.Time { return now },
+	})
+	for i := 0; i < 2; i++ {
+		l.Fail("a")
+		assert.Zero(t, l.Wait("a"), "free attempt")
+	}
+	for _, want := range []time.Duration{time.Second, 2 * time.Second, 3 * time.Second} {
+		l.Fail("a")
+		assert.Equal(t, want, l.Wait("a"))
+		now = now.Add(want)
+		assert.Zero(t, l.Wait("a"))
+	}
+	assert.Zero(t, l.Wait("b"))
+
+	l.Fail("a")
+	assert.Equal(t, time.Minute, l.Wait("a"), "locked out")
+	now = now.Add(time.Minute)
+	assert.Zero(t, l.Wait("a"))
+	l.Fail("a")
+	assert.Zero(t, l.Wait("a"), "failures are forgotten after the lockout")
+
+	l.Fail("a")
+	l.Fail("a")
+	assert.NotZero(t, l.Wait("a"))
+	l.Succeed("a")
+	assert.Zero(t, l.Wait("a"))
+
+	disabled := NewLockoutWithConfig(LockoutConfig{})
+	for i := 0; i < 20; i++ {
+		disabled.Fail("a")
+	}
+	assert.Zero(t, disabled.Wait("a"))
+}
@@ -0,0 +1,240 @@
+// Package cached wraps repositories with a cache of their public reads.
+package cached
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/labstack/gommon/log"
+	"github.com/sumitalp/productcatalog/cache"
+	"github.com/sumitalp/productcatalog/models"
+	"github.com/sumitalp/productcatalog/product"
+	"github.com/sumitalp/productcatalog/uow"
+)
+
+// generationKey holds the generation of the catalog, part of the key of
+// every cached read. Writes start a new generation rather than tracking
+// which entries they affect: the entries of the previous one are never read
+// again and expire.
+const generationKey = "catalog:generation"
+
+// ProductRepository caches the product and category reads of the public
+// routes: GetBySlug, List, ListByCategory, ListByOwner, ListCategories and
+// GetCategoryByID. The other methods go straight to the wrapped
+// repository, as do all reads made inside a unit of work, which may see
+// uncommitted writes.
+//
+// Writes invalidate the cache once committed. Changes made to owners
+// through the user repository show in cached products once they expire.
+type ProductRepository struct {
+	product.RepositoryInterface
+	cache  cache.Cache
+	loader *cache.Loader
+	ctx    context.Context
+}
+
+// NewProductRepository caches the reads of repo in c for ttl.
+func NewProductRepository(repo product.RepositoryInterface, c cache.Cache, ttl time.Duration) *ProductRepository {
+	return &ProductRepository{
+		RepositoryInterface: repo,
+		cache:               c,
+		loader:              cache.NewLoader(c, ttl),
+		ctx:                 context.Background(),
+	}
+}
+
+func (r *ProductRepository) WithContext(ctx context.Context) product.RepositoryInterface {
+	return &ProductRepository{
+		RepositoryInterface: r.RepositoryInterface.WithContext(ctx),
+		cache:               r.cache,
+		loader:              r.loader,
+		ctx:                 ctx,
+	}
+}
+
+type productEntry struct {
+	Product *models.Product
+}
+
+type productPage struct {
+	Products []models.Product
+	Count    int
+}
+
+type categoryEntry struct {
+	Category *models.Category
+}
+
+type categoryPage struct {
+	Categories []models.Category
+	Count      int
+}
+
+func (r *ProductRepository) GetBySlug(slug string) (*models.Product, error) {
+	var e productEntry
+	err := r.fetch(&e, func() (interface{}, error) {
+		p, err := r.RepositoryInterface.GetBySlug(slug)
+		if p != nil {
+			scrub(p)
+		}
+		return productEntry{Product: p}, err
+	}, "product", slug)
+	return e.Product, err
+}
+
+func (r *ProductRepository) List(offset, limit int) ([]models.Product, int, error) {
+	return r.fetchProducts(func() ([]models.Product, int, error) {
+		return r.RepositoryInterface.List(offset, limit)
+	}, "products", offset, limit)
+}
+
+func (r *ProductRepository) ListByCategory(category string, offset, limit int) ([]models.Product, int, error) {
+	return r.fetchProducts(func() ([]models.Product, int, error) {
+		return r.RepositoryInterface.ListByCategory(category, offset, limit)
+	}, "products-by-category", category, offset, limit)
+}
+
+func (r *ProductRepository) ListByOwner(username string, offset, limit int) ([]models.Product, int, error) {
+	return r.fetchProducts(func() ([]models.Product, int, error) {
+		return r.RepositoryInterface.ListByOwner(username, offset, limit)
+	}, "products-by-owner", username, offset, limit)
+}
+
+func (r *ProductRepository) ListCategories(offset, limit int) ([]models.Category, int, error) {
+	var page categoryPage
+	err := r.fetch(&page, func() (interface{}, error) {
+		categories, count, err := r.RepositoryInterface.ListCategories(offset, limit)
+		return categoryPage{Categories: categories, Count: count}, err
+	}, "categories", offset, limit)
+	if err != nil {
+		return nil, 0, err
+	}
+	if page.Categories == nil {
+		page.Categories = make([]models.Category, 0)
+	}
+	return page.Categories, page.Count, nil
+}
+
+func (r *ProductRepository) GetCategoryByID(id uint) (*models.Category, error) {
+	var e categoryEntry
+	err := r.fetch(&e, func() (interface{}, error) {
+		c, err := r.RepositoryInterface.GetCategoryByID(id)
+		return categoryEntry{Category: c}, err
+	}, "category", id)
+	return e.Category, err
+}
+
+func (r *ProductRepository) CreateProduct(p *models.Product) error {
+	return r.invalidate(r.RepositoryInterface.CreateProduct(p))
+}
+
+func (r *ProductRepository) UpdateProduct(p *models.Product, categories []string) error {
+	return r.invalidate(r.RepositoryInterface.UpdateProduct(p, categories))
+}
+
+func (r *ProductRepository) DeleteProduct(p *models.Product) error {
+	return r.invalidate(r.RepositoryInterface.DeleteProduct(p))
+}
+
+func (r *ProductRepository) CreateCategory(c *models.Category) error {
+	return r.invalidate(r.RepositoryInterface.CreateCategory(c))
+}
+
+func (r *ProductRepository) UpdateCategory(c *models.Category) error {
+	return r.invalidate(r.RepositoryInterface.UpdateCategory(c))
+}
+
+func (r *ProductRepository) DeleteCategory(c *models.Category) error {
+	return r.invalidate(r.RepositoryInterface.DeleteCategory(c))
+}
+
+func (r *ProductRepository) fetchProducts(load func() ([]models.Product, int, error), key ...interface{}) ([]models.Product, int, error) {
+	var page productPage
+	err := r.fetch(&page, func() (interface{}, error) {
+		products, count, err := load()
+		for i := range products {
+			scrub(&products[i])
+		}
+		return productPage{Products: products, Count: count}, err
+	}, key...)
+	if err != nil {
+		return nil, 0, err
+	}
+	if page.Products == nil {
+		page.Products = make([]models.Product, 0)
+	}
+	return page.Products, page.Count, nil
+}
+
+// fetch decodes into v the entry of the current generation identified by
+// key, loading it if needed.
+func (r *ProductRepository) fetch(v interface{}, load func() (interface{}, error), key ...interface{}) error {
+	if uow.Active(r.ctx) {
+		return decodeLoaded(v, load)
+	}
+	generation, err := r.generation()
+	if err != nil {
+		log.Warnj(log.JSON{"message": "cache read failed", "key": generationKey, "error": err.Error()})
+		return decodeLoaded(v, load)
+	}
+	k := "catalog:" + generation
+	for _, part := range key {
+		k += fmt.Sprintf(":%q", fmt.Sprint(part))
+	}
+	return r.loader.Fetch(r.ctx, k, v, load)
+}
+
+func (r *ProductRepository) generation() (string, error) {
+	b, err := r.cache.Get(r.ctx, generationKey)
+	if err == cache.ErrMiss {
+		return newGeneration(r.ctx, r.cache)
+	}
+	return string(b), err
+}
+
+func newGeneration(ctx context.Context, c cache.Cache) (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	generation := hex.EncodeToString(b)
+	return generation, c.Set(ctx, generationKey, []byte(generation), 0)
+}
+
+// invalidate starts a new generation once the unit of work of the write
+// commits, unless the write failed.
+func (r *ProductRepository) invalidate(err error) error {
+	if err != nil {
+		return err
+	}
+	uow.AfterCommit(r.ctx, func() {
+		// The write is done whatever happens to the request now, and so
+		// must be the invalidation.
+		if _, err := newGeneration(context.Background(), r.cache); err != nil {
+			log.Errorj(log.JSON{"message": "cache invalidation failed", "error": err.Error()})
+		}
+	})
+	return nil
+}
+
+// decodeLoaded stores in v, a pointer to the type of the value load returns,
+// that value.
+func decodeLoaded(v interface{}, load func() (interface{}, error)) error {
+	value, err := load()
+	if err != nil {
+		return err
+	}
+	reflect.ValueOf(v).Elem().Set(reflect.ValueOf(value))
+	return nil
+}
+
+// scrub clears what must not leave the database, such as the password
+// hash of the owner.
+func scrub(p *models.Product) {
+	p.Owner.Password = ""
+	p.Owner.TOTPSecret = ""
+}
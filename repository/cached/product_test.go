@@ -0,0 +1,104 @@
+package cached
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/sumitalp/productcatalog/cache"
+	"github.com/sumitalp/productcatalog/models"
+	"github.com/sumitalp/productcatalog/repository/memory"
+	"github.com/sumitalp/productcatalog/repository/repositorytest"
+)
+
+func TestContract(t *testing.T) {
+	repositorytest.Run(t, func(t *testing.T) (repositorytest.Repositories, func()) {
+		s := memory.NewStore()
+		return repositorytest.Repositories{
+			Users:           memory.NewUserRepository(s),
+			Products:        NewProductRepository(memory.NewProductRepository(s), cache.NewLRU(), time.Minute),
+			IdempotencyKeys: memory.NewIdempotencyRepository(s),
+			Webhooks:        memory.NewWebhookRepository(s),
+			Outbox:          memory.NewOutboxRepository(s),
+			UnitOfWork:      memory.NewUnitOfWork(s),
+		}, func() {}
+	})
+}
+
+func TestInvalidation(t *testing.T) {
+	s := memory.NewStore()
+	inner := memory.NewProductRepository(s)
+	products := NewProductRepository(inner, cache.NewLRU(), time.Minute)
+	tx := memory.NewUnitOfWork(s)
+	owner := &models.User{Username: "alice", Email: "alice@email.io", Password: "hash"}
+	require.NoError(t, memory.NewUserRepository(s).Create(owner))
+	p := &models.Product{Slug: "p1", Title: "p1", OwnerID: owner.ID}
+	require.NoError(t, products.CreateProduct(p))
+
+	get := func() *models.Product {
+		got, err := products.GetBySlug("p1")
+		require.NoError(t, err)
+		require.NotNil(t, got)
+		return got
+	}
+	got := get()
+	assert.Equal(t, "p1", got.Title)
+	assert.Equal(t, "alice", got.Owner.Username)
+	assert.Empty(t, got.Owner.Password, "secrets are not cached")
+	list, count, err := products.List(0, 10)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+	assert.Len(t, list, 1)
+
+	// Writes bypassing the cache do not show.
+	stored, err := inner.GetUserProductBySlug(owner.ID, "p1")
+	require.NoError(t, err)
+	stored.Title = "changed"
+	require.NoError(t, inner.UpdateProduct(stored, nil))
+	assert.Equal(t, "p1", get().Title)
+
+	// Writes through it do, once committed.
+	update := func(ctx context.Context, title string) error {
+		repo := products.WithContext(ctx)
+		p, err := repo.GetUserProductBySlug(owner.ID, "p1")
+		if err != nil {
+			return err
+		}
+		p.Title = title
+		if err := repo.UpdateProduct(p, nil); err != nil {
+			return err
+		}
+		got, err := repo.GetBySlug("p1")
+		require.NoError(t, err)
+		assert.Equal(t, title, got.Title, "reads in a unit of work bypass the cache")
+		return nil
+	}
+	err = tx.Do(context.Background(), func(ctx context.Context) error {
+		if err := update(ctx, "rolled back"); err != nil {
+			return err
+		}
+		return errors.New("abort")
+	})
+	require.EqualError(t, err, "abort")
+	assert.Equal(t, "p1", get().Title)
+
+	require.NoError(t, tx.Do(context.Background(), func(ctx context.Context) error {
+		return update(ctx, "committed")
+	}))
+	assert.Equal(t, "committed", get().Title)
+
+	c := &models.Category{Category: "books"}
+	require.NoError(t, products.CreateCategory(c))
+	categories, count, err := products.ListCategories(0, 10)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+	assert.Len(t, categories, 1)
+	require.NoError(t, products.DeleteCategory(c))
+	categories, count, err = products.ListCategories(0, 10)
+	require.NoError(t, err)
+	assert.Zero(t, count)
+	assert.NotNil(t, categories)
+}
@@ -0,0 +1,21 @@
+package repository
+
+import (
+	"testing"
+
+	"github.com/sumitalp/productcatalog/repository/repositorytest"
+)
+
+func TestContract(t *testing.T) {
+	repositorytest.Run(t, func(t *testing.T) (repositorytest.Repositories, func()) {
+		d, teardown := newTestDB(t)
+		return repositorytest.Repositories{
+			Users:           NewUserRepository(d),
+			Products:        NewProductRepository(d),
+			IdempotencyKeys: NewIdempotencyRepository(d),
+			Webhooks:        NewWebhookRepository(d),
+			Outbox:          NewOutboxRepository(d),
+			UnitOfWork:      NewUnitOfWork(d),
+		}, teardown
+	})
+}
@@ -0,0 +1,23 @@
+package repository
+
+import (
+	"fmt"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/sumitalp/productcatalog/utils"
+)
+
+// translateError maps driver specific errors onto the errors exposed by
+// the repository layer so callers never depend on the storage engine.
+func translateError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if se, ok := err.(sqlite3.Error); ok {
+		switch se.ExtendedCode {
+		case sqlite3.ErrConstraintUnique, sqlite3.ErrConstraintPrimaryKey:
+			return fmt.Errorf("%w: %s", utils.ErrDuplicate, se.Error())
+		}
+	}
+	return err
+}
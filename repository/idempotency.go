@@ -0,0 +1,61 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/sumitalp/productcatalog/db"
+	"github.com/sumitalp/productcatalog/idempotency"
+	"github.com/sumitalp/productcatalog/models"
+)
+
+type IdempotencyRepository struct {
+	db *gorm.DB
+}
+
+func NewIdempotencyRepository(db *gorm.DB) *IdempotencyRepository {
+	return &IdempotencyRepository{
+		db: db,
+	}
+}
+
+func (is *IdempotencyRepository) WithContext(ctx context.Context) idempotency.RepositoryInterface {
+	return &IdempotencyRepository{
+		db: db.WithContext(ctx, is.db),
+	}
+}
+
+func (is *IdempotencyRepository) Get(userID uint, key string) (*models.IdempotencyKey, error) {
+	var m models.IdempotencyKey
+	err := is.db.Where(`user_id = ? AND "key" = ?`, userID, key).First(&m).Error
+	if err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &m, nil
+}
+
+func (is *IdempotencyRepository) Create(k *models.IdempotencyKey) error {
+	return translateError(is.db.Create(k).Error)
+}
+
+func (is *IdempotencyRepository) Complete(k *models.IdempotencyKey) error {
+	return is.db.Model(k).Updates(map[string]interface{}{
+		"status_code":  k.StatusCode,
+		"content_type": k.ContentType,
+		"body":         k.Body,
+		"expires_at":   k.ExpiresAt,
+	}).Error
+}
+
+func (is *IdempotencyRepository) Delete(k *models.IdempotencyKey) error {
+	return is.db.Delete(k).Error
+}
+
+func (is *IdempotencyRepository) DeleteExpired(t time.Time) (int, error) {
+	res := is.db.Where("expires_at < ?", t).Delete(&models.IdempotencyKey{})
+	return int(res.RowsAffected), res.Error
+}
@@ -0,0 +1,109 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/sumitalp/productcatalog/idempotency"
+	"github.com/sumitalp/productcatalog/models"
+	"github.com/sumitalp/productcatalog/utils"
+)
+
+type IdempotencyRepository struct {
+	store *Store
+	ctx   context.Context
+}
+
+func NewIdempotencyRepository(s *Store) *IdempotencyRepository {
+	return &IdempotencyRepository{
+		store: s,
+	}
+}
+
+func (is *IdempotencyRepository) WithContext(ctx context.Context) idempotency.RepositoryInterface {
+	return &IdempotencyRepository{
+		store: is.store,
+		ctx:   ctx,
+	}
+}
+
+func (is *IdempotencyRepository) Get(userID uint, key string) (*models.IdempotencyKey, error) {
+	if err := ctxErr(is.ctx); err != nil {
+		return nil, err
+	}
+	is.store.mu.RLock()
+	defer is.store.mu.RUnlock()
+	for _, k := range is.store.idempotencyKeys {
+		if k.UserID == userID && k.Key == key {
+			return copyIdempotencyKey(k), nil
+		}
+	}
+	return nil, nil
+}
+
+func (is *IdempotencyRepository) Create(k *models.IdempotencyKey) error {
+	if err := ctxErr(is.ctx); err != nil {
+		return err
+	}
+	is.store.mu.Lock()
+	defer is.store.mu.Unlock()
+	for _, m := range is.store.idempotencyKeys {
+		if m.UserID == k.UserID && m.Key == k.Key {
+			return utils.ErrDuplicate
+		}
+	}
+	is.store.lastIdempotencyKeyID++
+	k.ID = is.store.lastIdempotencyKeyID
+	k.CreatedAt = time.Now()
+	is.store.idempotencyKeys[k.ID] = *copyIdempotencyKey(*k)
+	return nil
+}
+
+func (is *IdempotencyRepository) Complete(k *models.IdempotencyKey) error {
+	if err := ctxErr(is.ctx); err != nil {
+		return err
+	}
+	is.store.mu.Lock()
+	defer is.store.mu.Unlock()
+	m, ok := is.store.idempotencyKeys[k.ID]
+	if !ok {
+		return nil
+	}
+	m.StatusCode = k.StatusCode
+	m.ContentType = k.ContentType
+	m.Body = append([]byte(nil), k.Body...)
+	m.ExpiresAt = k.ExpiresAt
+	is.store.idempotencyKeys[k.ID] = m
+	return nil
+}
+
+func (is *IdempotencyRepository) Delete(k *models.IdempotencyKey) error {
+	if err := ctxErr(is.ctx); err != nil {
+		return err
+	}
+	is.store.mu.Lock()
+	defer is.store.mu.Unlock()
+	delete(is.store.idempotencyKeys, k.ID)
+	return nil
+}
+
+func (is *IdempotencyRepository) DeleteExpired(t time.Time) (int, error) {
+	if err := ctxErr(is.ctx); err != nil {
+		return 0, err
+	}
+	is.store.mu.Lock()
+	defer is.store.mu.Unlock()
+	n := 0
+	for id, k := range is.store.idempotencyKeys {
+		if k.ExpiresAt.Before(t) {
+			delete(is.store.idempotencyKeys, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func copyIdempotencyKey(k models.IdempotencyKey) *models.IdempotencyKey {
+	k.Body = append([]byte(nil), k.Body...)
+	return &k
+}
@@ -0,0 +1,21 @@
+package memory
+
+import (
+	"testing"
+
+	"github.com/sumitalp/productcatalog/repository/repositorytest"
+)
+
+func TestContract(t *testing.T) {
+	repositorytest.Run(t, func(t *testing.T) (repositorytest.Repositories, func()) {
+		s := NewStore()
+		return repositorytest.Repositories{
+			Users:           NewUserRepository(s),
+			Products:        NewProductRepository(s),
+			IdempotencyKeys: NewIdempotencyRepository(s),
+			Webhooks:        NewWebhookRepository(s),
+			Outbox:          NewOutboxRepository(s),
+			UnitOfWork:      NewUnitOfWork(s),
+		}, func() {}
+	})
+}
@@ -0,0 +1,102 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/sumitalp/productcatalog/models"
+	"github.com/sumitalp/productcatalog/outbox"
+	"github.com/sumitalp/productcatalog/utils"
+)
+
+type OutboxRepository struct {
+	store *Store
+	ctx   context.Context
+}
+
+func NewOutboxRepository(s *Store) *OutboxRepository {
+	return &OutboxRepository{
+		store: s,
+	}
+}
+
+func (ob *OutboxRepository) WithContext(ctx context.Context) outbox.RepositoryInterface {
+	return &OutboxRepository{
+		store: ob.store,
+		ctx:   ctx,
+	}
+}
+
+func (ob *OutboxRepository) Create(e *models.OutboxEvent) error {
+	if err := ctxErr(ob.ctx); err != nil {
+		return err
+	}
+	ob.store.mu.Lock()
+	defer ob.store.mu.Unlock()
+	for _, m := range ob.store.outbox {
+		if m.EventID == e.EventID {
+			return utils.ErrDuplicate
+		}
+	}
+	ob.store.lastOutboxEventID++
+	e.ID = ob.store.lastOutboxEventID
+	e.CreatedAt = time.Now()
+	ob.store.outbox[e.ID] = *copyOutboxEvent(*e)
+	return nil
+}
+
+func (ob *OutboxRepository) Update(e *models.OutboxEvent) error {
+	if err := ctxErr(ob.ctx); err != nil {
+		return err
+	}
+	ob.store.mu.Lock()
+	defer ob.store.mu.Unlock()
+	if _, ok := ob.store.outbox[e.ID]; !ok {
+		return nil
+	}
+	ob.store.outbox[e.ID] = *copyOutboxEvent(*e)
+	return nil
+}
+
+func (ob *OutboxRepository) ListPending(t time.Time, limit int) ([]models.OutboxEvent, error) {
+	if err := ctxErr(ob.ctx); err != nil {
+		return nil, err
+	}
+	ob.store.mu.RLock()
+	defer ob.store.mu.RUnlock()
+	pending := make([]models.OutboxEvent, 0)
+	for _, e := range ob.store.outbox {
+		if e.DispatchedAt == nil && e.DeadAt == nil && !e.NextAttemptAt.After(t) {
+			pending = append(pending, *copyOutboxEvent(e))
+		}
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].ID < pending[j].ID })
+	if limit >= 0 && len(pending) > limit {
+		pending = pending[:limit]
+	}
+	return pending, nil
+}
+
+func (ob *OutboxRepository) DeleteDispatched(t time.Time) (int, error) {
+	if err := ctxErr(ob.ctx); err != nil {
+		return 0, err
+	}
+	ob.store.mu.Lock()
+	defer ob.store.mu.Unlock()
+	n := 0
+	for id, e := range ob.store.outbox {
+		if e.DispatchedAt != nil && e.DispatchedAt.Before(t) {
+			delete(ob.store.outbox, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func copyOutboxEvent(e models.OutboxEvent) *models.OutboxEvent {
+	e.Payload = append([]byte(nil), e.Payload...)
+	e.DispatchedAt = copyTime(e.DispatchedAt)
+	e.DeadAt = copyTime(e.DeadAt)
+	return &e
+}
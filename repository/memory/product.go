@@ -0,0 +1,406 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sumitalp/productcatalog/models"
+	"github.com/sumitalp/productcatalog/product"
+	"github.com/sumitalp/productcatalog/utils"
+)
+
+type ProductRepository struct {
+	store *Store
+	ctx   context.Context
+}
+
+func NewProductRepository(s *Store) *ProductRepository {
+	return &ProductRepository{
+		store: s,
+	}
+}
+
+func (as *ProductRepository) WithContext(ctx context.Context) product.RepositoryInterface {
+	return &ProductRepository{
+		store: as.store,
+		ctx:   ctx,
+	}
+}
+
+func (as *ProductRepository) GetBySlug(s string) (*models.Product, error) {
+	if err := ctxErr(as.ctx); err != nil {
+		return nil, err
+	}
+	as.store.mu.RLock()
+	defer as.store.mu.RUnlock()
+	for _, p := range as.store.products {
+		if p.Slug == s {
+			return as.preload(p), nil
+		}
+	}
+	return nil, nil
+}
+
+func (as *ProductRepository) GetUserProductBySlug(userID uint, slug string) (*models.Product, error) {
+	if err := ctxErr(as.ctx); err != nil {
+		return nil, err
+	}
+	as.store.mu.RLock()
+	defer as.store.mu.RUnlock()
+	for _, p := range as.store.products {
+		if p.Slug == slug && p.OwnerID == userID {
+			return as.preload(p), nil
+		}
+	}
+	return nil, nil
+}
+
+func (as *ProductRepository) CreateProduct(a *models.Product) error {
+	if err := ctxErr(as.ctx); err != nil {
+		return err
+	}
+	as.store.mu.Lock()
+	defer as.store.mu.Unlock()
+	if err := as.checkSlug(a); err != nil {
+		return err
+	}
+	as.store.lastProductID++
+	now := time.Now()
+	a.ID = as.store.lastProductID
+	a.Version = 1
+	a.CreatedAt = now
+	a.UpdatedAt = now
+	names := make([]string, 0, len(a.Categories))
+	for _, c := range a.Categories {
+		names = append(names, c.Category)
+	}
+	as.store.productCategories[a.ID] = as.categoryIDs(names)
+	as.store.products[a.ID] = stripAssociations(*a)
+	*a = *as.preload(as.store.products[a.ID])
+	return nil
+}
+
+// UpdateProduct saves the non-zero fields of a, replaces its categories
+// and reloads it, like the gorm implementation does. The stored product
+// must still have a.Version.
+func (as *ProductRepository) UpdateProduct(a *models.Product, categoryList []string) error {
+	if err := ctxErr(as.ctx); err != nil {
+		return err
+	}
+	as.store.mu.Lock()
+	defer as.store.mu.Unlock()
+	m, ok := as.store.products[a.ID]
+	if !ok || m.Version != a.Version {
+		return utils.ErrStale
+	}
+	if err := as.checkSlug(a); err != nil {
+		return err
+	}
+	m.Version++
+	m.Slug = a.Slug
+	m.Title = a.Title
+	m.Description = a.Description
+	m.Image = a.Image
+	m.UpdatedAt = time.Now()
+	as.store.products[a.ID] = m
+	as.store.productCategories[a.ID] = as.categoryIDs(categoryList)
+	*a = *as.preload(m)
+	return nil
+}
+
+func (as *ProductRepository) DeleteProduct(a *models.Product) error {
+	if err := ctxErr(as.ctx); err != nil {
+		return err
+	}
+	as.store.mu.Lock()
+	defer as.store.mu.Unlock()
+	if m, ok := as.store.products[a.ID]; !ok || m.Version != a.Version {
+		return utils.ErrStale
+	}
+	delete(as.store.products, a.ID)
+	delete(as.store.productCategories, a.ID)
+	return nil
+}
+
+func (as *ProductRepository) List(offset, limit int) ([]models.Product, int, error) {
+	return as.list(func(p *models.Product) bool { return true }, offset, limit)
+}
+
+func (as *ProductRepository) ListByCategory(category string, offset, limit int) ([]models.Product, int, error) {
+	var id uint
+	as.store.mu.RLock()
+	for _, c := range as.store.categories {
+		if c.Category == category {
+			id = c.ID
+		}
+	}
+	as.store.mu.RUnlock()
+	return as.list(func(p *models.Product) bool {
+		for _, c := range as.store.productCategories[p.ID] {
+			if c == id {
+				return true
+			}
+		}
+		return false
+	}, offset, limit)
+}
+
+func (as *ProductRepository) ListByOwner(username string, offset, limit int) ([]models.Product, int, error) {
+	return as.list(func(p *models.Product) bool {
+		u, ok := as.store.users[p.OwnerID]
+		return ok && u.Username == username
+	}, offset, limit)
+}
+
+func (as *ProductRepository) Search(query string, offset, limit int) ([]models.Product, int, error) {
+	query = strings.ToLower(query)
+	return as.list(func(p *models.Product) bool {
+		return strings.Contains(strings.ToLower(p.Title), query) ||
+			strings.Contains(strings.ToLower(p.Description), query)
+	}, offset, limit)
+}
+
+func (as *ProductRepository) ListByCategoryIDs(ids []uint, limit int) (map[uint][]models.Product, error) {
+	byCategory := make(map[uint][]models.Product)
+	for _, id := range ids {
+		id := id
+		products, _, err := as.list(func(p *models.Product) bool {
+			for _, c := range as.store.productCategories[p.ID] {
+				if c == id {
+					return true
+				}
+			}
+			return false
+		}, 0, limit)
+		if err != nil {
+			return nil, err
+		}
+		byCategory[id] = products
+	}
+	return byCategory, nil
+}
+
+func (as *ProductRepository) ListByOwnerIDs(ids []uint, limit int) (map[uint][]models.Product, error) {
+	byOwner := make(map[uint][]models.Product)
+	for _, id := range ids {
+		id := id
+		products, _, err := as.list(func(p *models.Product) bool {
+			return p.OwnerID == id
+		}, 0, limit)
+		if err != nil {
+			return nil, err
+		}
+		byOwner[id] = products
+	}
+	return byOwner, nil
+}
+
+// list returns the page of products matching match, newest first. match
+// runs with the store read lock held.
+func (as *ProductRepository) list(match func(*models.Product) bool, offset, limit int) ([]models.Product, int, error) {
+	if err := ctxErr(as.ctx); err != nil {
+		return nil, 0, err
+	}
+	as.store.mu.RLock()
+	defer as.store.mu.RUnlock()
+	matched := make([]models.Product, 0)
+	for _, p := range as.store.products {
+		if match(&p) {
+			matched = append(matched, p)
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		return newer(matched[i].ModelBase, matched[j].ModelBase)
+	})
+	products := make([]models.Product, 0)
+	for _, i := range pageIndexes(len(matched), offset, limit) {
+		products = append(products, *as.preload(matched[i]))
+	}
+	return products, len(matched), nil
+}
+
+func (as *ProductRepository) ListCategories(offset, limit int) ([]models.Category, int, error) {
+	if err := ctxErr(as.ctx); err != nil {
+		return nil, 0, err
+	}
+	as.store.mu.RLock()
+	defer as.store.mu.RUnlock()
+	all := make([]models.Category, 0, len(as.store.categories))
+	for _, c := range as.store.categories {
+		all = append(all, c)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return newer(all[i].ModelBase, all[j].ModelBase)
+	})
+	categories := make([]models.Category, 0)
+	for _, i := range pageIndexes(len(all), offset, limit) {
+		categories = append(categories, all[i])
+	}
+	return categories, len(all), nil
+}
+
+func (as *ProductRepository) CreateCategory(c *models.Category) error {
+	if err := ctxErr(as.ctx); err != nil {
+		return err
+	}
+	as.store.mu.Lock()
+	defer as.store.mu.Unlock()
+	if err := as.checkCategory(c); err != nil {
+		return err
+	}
+	as.createCategory(c)
+	return nil
+}
+
+func (as *ProductRepository) UpdateCategory(c *models.Category) error {
+	if err := ctxErr(as.ctx); err != nil {
+		return err
+	}
+	as.store.mu.Lock()
+	defer as.store.mu.Unlock()
+	m, ok := as.store.categories[c.ID]
+	if !ok || m.Version != c.Version {
+		return utils.ErrStale
+	}
+	if err := as.checkCategory(c); err != nil {
+		return err
+	}
+	m.Version++
+	m.Category = c.Category
+	m.Description = c.Description
+	m.UpdatedAt = time.Now()
+	as.store.categories[c.ID] = m
+	*c = m
+	return nil
+}
+
+func (as *ProductRepository) DeleteCategory(c *models.Category) error {
+	if err := ctxErr(as.ctx); err != nil {
+		return err
+	}
+	as.store.mu.Lock()
+	defer as.store.mu.Unlock()
+	if m, ok := as.store.categories[c.ID]; !ok || m.Version != c.Version {
+		return utils.ErrStale
+	}
+	delete(as.store.categories, c.ID)
+	return nil
+}
+
+func (as *ProductRepository) GetCategoryByID(id uint) (*models.Category, error) {
+	if err := ctxErr(as.ctx); err != nil {
+		return nil, err
+	}
+	as.store.mu.RLock()
+	defer as.store.mu.RUnlock()
+	c, ok := as.store.categories[id]
+	if !ok {
+		return nil, nil
+	}
+	return &c, nil
+}
+
+// The helpers below expect the caller to hold the store lock.
+
+func (as *ProductRepository) checkSlug(a *models.Product) error {
+	for _, p := range as.store.products {
+		if p.ID != a.ID && p.Slug == a.Slug {
+			return utils.ErrDuplicate
+		}
+	}
+	return nil
+}
+
+func (as *ProductRepository) checkCategory(c *models.Category) error {
+	for _, m := range as.store.categories {
+		if m.ID != c.ID && m.Category == c.Category {
+			return utils.ErrDuplicate
+		}
+	}
+	return nil
+}
+
+func (as *ProductRepository) createCategory(c *models.Category) {
+	as.store.lastCategoryID++
+	now := time.Now()
+	c.ID = as.store.lastCategoryID
+	c.Version = 1
+	c.CreatedAt = now
+	c.UpdatedAt = now
+	c.Products = nil
+	as.store.categories[c.ID] = *c
+}
+
+// categoryIDs resolves category names to ids, creating the categories
+// that do not exist yet.
+func (as *ProductRepository) categoryIDs(names []string) []uint {
+	ids := make([]uint, 0, len(names))
+	for _, name := range names {
+		var id uint
+		for _, c := range as.store.categories {
+			if c.Category == name {
+				id = c.ID
+			}
+		}
+		if id == 0 {
+			c := models.Category{Category: name}
+			as.createCategory(&c)
+			id = c.ID
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func (as *ProductRepository) owner(id uint) models.User {
+	u, ok := as.store.users[id]
+	if !ok {
+		return models.User{}
+	}
+	return *copyUser(u)
+}
+
+// preload returns p with its owner and categories filled in.
+func (as *ProductRepository) preload(p models.Product) *models.Product {
+	p.Owner = as.owner(p.OwnerID)
+	p.Categories = make([]models.Category, 0)
+	for _, id := range as.store.productCategories[p.ID] {
+		if c, ok := as.store.categories[id]; ok {
+			p.Categories = append(p.Categories, c)
+		}
+	}
+	return &p
+}
+
+func stripAssociations(p models.Product) models.Product {
+	p.Owner = models.User{}
+	p.Categories = nil
+	return p
+}
+
+// newer orders records by creation time, newest first.
+func newer(a, b models.ModelBase) bool {
+	if a.CreatedAt.Equal(b.CreatedAt) {
+		return a.ID > b.ID
+	}
+	return a.CreatedAt.After(b.CreatedAt)
+}
+
+// pageIndexes returns the indexes of the page selected by offset and limit
+// out of n items. A negative limit selects every remaining item.
+func pageIndexes(n, offset, limit int) []int {
+	if offset < 0 {
+		offset = 0
+	}
+	end := n
+	if limit >= 0 && offset+limit < n {
+		end = offset + limit
+	}
+	idx := make([]int, 0)
+	for i := offset; i < end; i++ {
+		idx = append(idx, i)
+	}
+	return idx
+}
@@ -0,0 +1,139 @@
+// Package memory implements the repository interfaces on top of plain Go
+// maps. It is meant for tests and for running the service without a
+// database; data does not survive the process.
+package memory
+
+import (
+	"context"
+	"sync"
+
+	"github.com/sumitalp/productcatalog/models"
+)
+
+// Store holds the data shared by the in-memory repositories.
+type Store struct {
+	mu   sync.RWMutex
+	txMu sync.Mutex
+
+	users             map[uint]models.User
+	products          map[uint]models.Product
+	categories        map[uint]models.Category
+	productCategories map[uint][]uint
+	idempotencyKeys   map[uint]models.IdempotencyKey
+	webhooks          map[uint]models.WebhookSubscription
+	deliveries        map[uint]models.WebhookDelivery
+	outbox            map[uint]models.OutboxEvent
+	userTokens        map[uint]models.UserToken
+	recoveryCodes     map[uint]models.RecoveryCode
+
+	lastUserID           uint
+	lastProductID        uint
+	lastCategoryID       uint
+	lastIdempotencyKeyID uint
+	lastWebhookID        uint
+	lastDeliveryID       uint
+	lastOutboxEventID    uint
+	lastUserTokenID      uint
+	lastRecoveryCodeID   uint
+}
+
+func NewStore() *Store {
+	return &Store{
+		users:             make(map[uint]models.User),
+		products:          make(map[uint]models.Product),
+		categories:        make(map[uint]models.Category),
+		productCategories: make(map[uint][]uint),
+		idempotencyKeys:   make(map[uint]models.IdempotencyKey),
+		webhooks:          make(map[uint]models.WebhookSubscription),
+		deliveries:        make(map[uint]models.WebhookDelivery),
+		outbox:            make(map[uint]models.OutboxEvent),
+		userTokens:        make(map[uint]models.UserToken),
+		recoveryCodes:     make(map[uint]models.RecoveryCode),
+	}
+}
+
+// snapshot returns a copy of the store contents. Callers hold s.mu.
+func (s *Store) snapshot() *Store {
+	c := NewStore()
+	for k, v := range s.users {
+		c.users[k] = v
+	}
+	for k, v := range s.products {
+		c.products[k] = v
+	}
+	for k, v := range s.categories {
+		c.categories[k] = v
+	}
+	for k, v := range s.productCategories {
+		c.productCategories[k] = append([]uint(nil), v...)
+	}
+	for k, v := range s.idempotencyKeys {
+		c.idempotencyKeys[k] = v
+	}
+	for k, v := range s.webhooks {
+		c.webhooks[k] = v
+	}
+	for k, v := range s.deliveries {
+		c.deliveries[k] = v
+	}
+	for k, v := range s.outbox {
+		c.outbox[k] = v
+	}
+	for k, v := range s.userTokens {
+		c.userTokens[k] = v
+	}
+	for k, v := range s.recoveryCodes {
+		c.recoveryCodes[k] = v
+	}
+	c.lastUserID = s.lastUserID
+	c.lastProductID = s.lastProductID
+	c.lastCategoryID = s.lastCategoryID
+	c.lastIdempotencyKeyID = s.lastIdempotencyKeyID
+	c.lastWebhookID = s.lastWebhookID
+	c.lastDeliveryID = s.lastDeliveryID
+	c.lastOutboxEventID = s.lastOutboxEventID
+	c.lastUserTokenID = s.lastUserTokenID
+	c.lastRecoveryCodeID = s.lastRecoveryCodeID
+	return c
+}
+
+// restore replaces the store contents with snap.
+func (s *Store) restore(snap *Store) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users = snap.users
+	s.products = snap.products
+	s.categories = snap.categories
+	s.productCategories = snap.productCategories
+	s.idempotencyKeys = snap.idempotencyKeys
+	s.webhooks = snap.webhooks
+	s.deliveries = snap.deliveries
+	s.outbox = snap.outbox
+	s.userTokens = snap.userTokens
+	s.recoveryCodes = snap.recoveryCodes
+	s.lastUserID = snap.lastUserID
+	s.lastProductID = snap.lastProductID
+	s.lastCategoryID = snap.lastCategoryID
+	s.lastIdempotencyKeyID = snap.lastIdempotencyKeyID
+	s.lastWebhookID = snap.lastWebhookID
+	s.lastDeliveryID = snap.lastDeliveryID
+	s.lastOutboxEventID = snap.lastOutboxEventID
+	s.lastUserTokenID = snap.lastUserTokenID
+	s.lastRecoveryCodeID = snap.lastRecoveryCodeID
+}
+
+// ctxErr reports why ctx is done, if it is.
+func ctxErr(ctx context.Context) error {
+	if ctx == nil {
+		return nil
+	}
+	return ctx.Err()
+}
+
+func copyString(s *string) *string {
+	if s == nil {
+		return nil
+	}
+	c := *s
+	return &c
+}
@@ -0,0 +1,54 @@
+package memory
+
+import (
+	"context"
+
+	"github.com/sumitalp/productcatalog/uow"
+)
+
+type txKey struct{}
+
+// UnitOfWork makes a group of in-memory operations atomic by restoring a
+// snapshot of the store when the group fails. Units of work are serialised
+// against each other, but writes made outside of one while it runs are
+// lost if it rolls back.
+type UnitOfWork struct {
+	store *Store
+}
+
+func NewUnitOfWork(s *Store) *UnitOfWork {
+	return &UnitOfWork{
+		store: s,
+	}
+}
+
+func (u *UnitOfWork) Do(ctx context.Context, fn func(ctx context.Context) error) error {
+	if ctx.Value(txKey{}) != nil {
+		return fn(ctx)
+	}
+	ctx, runHooks := uow.WithCommitHooks(ctx)
+	if err := u.do(ctx, fn); err != nil {
+		return err
+	}
+	runHooks()
+	return nil
+}
+
+func (u *UnitOfWork) do(ctx context.Context, fn func(ctx context.Context) error) (err error) {
+	u.store.txMu.Lock()
+	defer u.store.txMu.Unlock()
+
+	u.store.mu.RLock()
+	snap := u.store.snapshot()
+	u.store.mu.RUnlock()
+	defer func() {
+		if p := recover(); p != nil {
+			u.store.restore(snap)
+			panic(p)
+		}
+		if err != nil {
+			u.store.restore(snap)
+		}
+	}()
+	return fn(context.WithValue(ctx, txKey{}, true))
+}
@@ -0,0 +1,311 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/sumitalp/productcatalog/models"
+	"github.com/sumitalp/productcatalog/user"
+	"github.com/sumitalp/productcatalog/utils"
+)
+
+type UserRepository struct {
+	store *Store
+	ctx   context.Context
+}
+
+func NewUserRepository(s *Store) *UserRepository {
+	return &UserRepository{
+		store: s,
+	}
+}
+
+func (us *UserRepository) WithContext(ctx context.Context) user.RepositoryInterface {
+	return &UserRepository{
+		store: us.store,
+		ctx:   ctx,
+	}
+}
+
+func (us *UserRepository) GetByID(id uint) (*models.User, error) {
+	return us.find(func(u *models.User) bool { return u.ID == id })
+}
+
+func (us *UserRepository) GetByEmail(e string) (*models.User, error) {
+	return us.find(func(u *models.User) bool { return u.Email == e })
+}
+
+func (us *UserRepository) GetByUsername(username string) (*models.User, error) {
+	return us.find(func(u *models.User) bool { return u.Username == username })
+}
+
+func (us *UserRepository) find(match func(*models.User) bool) (*models.User, error) {
+	if err := ctxErr(us.ctx); err != nil {
+		return nil, err
+	}
+	us.store.mu.RLock()
+	defer us.store.mu.RUnlock()
+	for _, u := range us.store.users {
+		if match(&u) {
+			return copyUser(u), nil
+		}
+	}
+	return nil, nil
+}
+
+func (us *UserRepository) Create(u *models.User) error {
+	if err := ctxErr(us.ctx); err != nil {
+		return err
+	}
+	us.store.mu.Lock()
+	defer us.store.mu.Unlock()
+	if err := us.checkUnique(u); err != nil {
+		return err
+	}
+	us.store.lastUserID++
+	now := time.Now()
+	u.ID = us.store.lastUserID
+	u.CreatedAt = now
+	u.UpdatedAt = now
+	us.store.users[u.ID] = *copyUser(*u)
+	return nil
+}
+
+// Update saves the non-zero fields of u, like gorm's Update does.
+func (us *UserRepository) Update(u *models.User) error {
+	if err := ctxErr(us.ctx); err != nil {
+		return err
+	}
+	us.store.mu.Lock()
+	defer us.store.mu.Unlock()
+	m, ok := us.store.users[u.ID]
+	if !ok {
+		return nil
+	}
+	if err := us.checkUnique(u); err != nil {
+		return err
+	}
+	if u.Username != "" {
+		m.Username = u.Username
+	}
+	if u.Email != "" {
+		m.Email = u.Email
+	}
+	if u.Password != "" {
+		m.Password = u.Password
+	}
+	if u.Bio != nil {
+		m.Bio = copyString(u.Bio)
+	}
+	if u.Image != nil {
+		m.Image = copyString(u.Image)
+	}
+	if u.TokenGeneration != 0 {
+		m.TokenGeneration = u.TokenGeneration
+	}
+	m.UpdatedAt = time.Now()
+	u.UpdatedAt = m.UpdatedAt
+	us.store.users[u.ID] = m
+	return nil
+}
+
+func (us *UserRepository) SetEmailVerified(id uint, at *time.Time) error {
+	if err := ctxErr(us.ctx); err != nil {
+		return err
+	}
+	us.store.mu.Lock()
+	defer us.store.mu.Unlock()
+	m, ok := us.store.users[id]
+	if !ok {
+		return nil
+	}
+	m.EmailVerifiedAt = copyTime(at)
+	us.store.users[id] = m
+	return nil
+}
+
+func (us *UserRepository) CreateToken(t *models.UserToken) error {
+	if err := ctxErr(us.ctx); err != nil {
+		return err
+	}
+	us.store.mu.Lock()
+	defer us.store.mu.Unlock()
+	for _, m := range us.store.userTokens {
+		if m.Hash == t.Hash {
+			return utils.ErrDuplicate
+		}
+	}
+	us.store.lastUserTokenID++
+	t.ID = us.store.lastUserTokenID
+	t.CreatedAt = time.Now()
+	us.store.userTokens[t.ID] = *copyUserToken(*t)
+	return nil
+}
+
+func (us *UserRepository) ConsumeToken(purpose, hash string, now time.Time) (*models.UserToken, error) {
+	if err := ctxErr(us.ctx); err != nil {
+		return nil, err
+	}
+	us.store.mu.Lock()
+	defer us.store.mu.Unlock()
+	for id, t := range us.store.userTokens {
+		if t.Purpose != purpose || t.Hash != hash {
+			continue
+		}
+		if t.UsedAt != nil || !t.ExpiresAt.After(now) {
+			return nil, nil
+		}
+		t.UsedAt = &now
+		us.store.userTokens[id] = t
+		return copyUserToken(t), nil
+	}
+	return nil, nil
+}
+
+func (us *UserRepository) RevokeTokens(userID uint, purpose string) error {
+	if err := ctxErr(us.ctx); err != nil {
+		return err
+	}
+	us.store.mu.Lock()
+	defer us.store.mu.Unlock()
+	for id, t := range us.store.userTokens {
+		if t.UserID == userID && t.Purpose == purpose {
+			delete(us.store.userTokens, id)
+		}
+	}
+	return nil
+}
+
+func (us *UserRepository) DeleteExpiredTokens(before time.Time) (int, error) {
+	if err := ctxErr(us.ctx); err != nil {
+		return 0, err
+	}
+	us.store.mu.Lock()
+	defer us.store.mu.Unlock()
+	n := 0
+	for id, t := range us.store.userTokens {
+		if t.ExpiresAt.Before(before) {
+			delete(us.store.userTokens, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (us *UserRepository) SetTwoFactor(id uint, secret string, enabledAt *time.Time) error {
+	if err := ctxErr(us.ctx); err != nil {
+		return err
+	}
+	us.store.mu.Lock()
+	defer us.store.mu.Unlock()
+	m, ok := us.store.users[id]
+	if !ok {
+		return nil
+	}
+	m.TOTPSecret = secret
+	m.TwoFactorEnabledAt = copyTime(enabledAt)
+	m.TOTPLastStep = 0
+	us.store.users[id] = m
+	return nil
+}
+
+func (us *UserRepository) UseTOTPStep(id uint, step int64) (bool, error) {
+	if err := ctxErr(us.ctx); err != nil {
+		return false, err
+	}
+	us.store.mu.Lock()
+	defer us.store.mu.Unlock()
+	m, ok := us.store.users[id]
+	if !ok || m.TOTPLastStep >= step {
+		return false, nil
+	}
+	m.TOTPLastStep = step
+	us.store.users[id] = m
+	return true, nil
+}
+
+func (us *UserRepository) ReplaceRecoveryCodes(userID uint, hashes []string) error {
+	if err := ctxErr(us.ctx); err != nil {
+		return err
+	}
+	us.store.mu.Lock()
+	defer us.store.mu.Unlock()
+	for id, c := range us.store.recoveryCodes {
+		if c.UserID == userID {
+			delete(us.store.recoveryCodes, id)
+		}
+	}
+	for _, h := range hashes {
+		for _, c := range us.store.recoveryCodes {
+			if c.Hash == h {
+				return utils.ErrDuplicate
+			}
+		}
+		us.store.lastRecoveryCodeID++
+		us.store.recoveryCodes[us.store.lastRecoveryCodeID] = models.RecoveryCode{
+			ID:        us.store.lastRecoveryCodeID,
+			CreatedAt: time.Now(),
+			UserID:    userID,
+			Hash:      h,
+		}
+	}
+	return nil
+}
+
+func (us *UserRepository) UseRecoveryCode(userID uint, hash string, now time.Time) (bool, error) {
+	if err := ctxErr(us.ctx); err != nil {
+		return false, err
+	}
+	us.store.mu.Lock()
+	defer us.store.mu.Unlock()
+	for id, c := range us.store.recoveryCodes {
+		if c.UserID == userID && c.Hash == hash && c.UsedAt == nil {
+			c.UsedAt = &now
+			us.store.recoveryCodes[id] = c
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (us *UserRepository) CountRecoveryCodes(userID uint) (int, error) {
+	if err := ctxErr(us.ctx); err != nil {
+		return 0, err
+	}
+	us.store.mu.RLock()
+	defer us.store.mu.RUnlock()
+	n := 0
+	for _, c := range us.store.recoveryCodes {
+		if c.UserID == userID && c.UsedAt == nil {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// checkUnique mirrors the unique indexes of the users table. Callers hold
+// the store lock.
+func (us *UserRepository) checkUnique(u *models.User) error {
+	for _, m := range us.store.users {
+		if m.ID == u.ID {
+			continue
+		}
+		if m.Username == u.Username || m.Email == u.Email {
+			return utils.ErrDuplicate
+		}
+	}
+	return nil
+}
+
+func copyUser(u models.User) *models.User {
+	u.Bio = copyString(u.Bio)
+	u.Image = copyString(u.Image)
+	u.EmailVerifiedAt = copyTime(u.EmailVerifiedAt)
+	u.TwoFactorEnabledAt = copyTime(u.TwoFactorEnabledAt)
+	return &u
+}
+
+func copyUserToken(t models.UserToken) *models.UserToken {
+	t.UsedAt = copyTime(t.UsedAt)
+	return &t
+}
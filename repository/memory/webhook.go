@@ -0,0 +1,225 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/sumitalp/productcatalog/models"
+	"github.com/sumitalp/productcatalog/webhook"
+)
+
+type WebhookRepository struct {
+	store *Store
+	ctx   context.Context
+}
+
+func NewWebhookRepository(s *Store) *WebhookRepository {
+	return &WebhookRepository{
+		store: s,
+	}
+}
+
+func (ws *WebhookRepository) WithContext(ctx context.Context) webhook.RepositoryInterface {
+	return &WebhookRepository{
+		store: ws.store,
+		ctx:   ctx,
+	}
+}
+
+func (ws *WebhookRepository) CreateSubscription(s *models.WebhookSubscription) error {
+	if err := ctxErr(ws.ctx); err != nil {
+		return err
+	}
+	ws.store.mu.Lock()
+	defer ws.store.mu.Unlock()
+	ws.store.lastWebhookID++
+	s.ID = ws.store.lastWebhookID
+	s.CreatedAt = time.Now()
+	s.UpdatedAt = s.CreatedAt
+	ws.store.webhooks[s.ID] = *copySubscription(*s)
+	return nil
+}
+
+func (ws *WebhookRepository) UpdateSubscription(s *models.WebhookSubscription) error {
+	if err := ctxErr(ws.ctx); err != nil {
+		return err
+	}
+	ws.store.mu.Lock()
+	defer ws.store.mu.Unlock()
+	if _, ok := ws.store.webhooks[s.ID]; !ok {
+		return nil
+	}
+	s.UpdatedAt = time.Now()
+	ws.store.webhooks[s.ID] = *copySubscription(*s)
+	return nil
+}
+
+func (ws *WebhookRepository) DeleteSubscription(s *models.WebhookSubscription) error {
+	if err := ctxErr(ws.ctx); err != nil {
+		return err
+	}
+	ws.store.mu.Lock()
+	defer ws.store.mu.Unlock()
+	for id, d := range ws.store.deliveries {
+		if d.SubscriptionID == s.ID {
+			delete(ws.store.deliveries, id)
+		}
+	}
+	delete(ws.store.webhooks, s.ID)
+	return nil
+}
+
+func (ws *WebhookRepository) GetSubscription(id uint) (*models.WebhookSubscription, error) {
+	if err := ctxErr(ws.ctx); err != nil {
+		return nil, err
+	}
+	ws.store.mu.RLock()
+	defer ws.store.mu.RUnlock()
+	s, ok := ws.store.webhooks[id]
+	if !ok {
+		return nil, nil
+	}
+	return copySubscription(s), nil
+}
+
+func (ws *WebhookRepository) ListSubscriptions(userID uint, offset, limit int) ([]models.WebhookSubscription, int, error) {
+	all, err := ws.subscriptions(func(s *models.WebhookSubscription) bool { return s.UserID == userID })
+	if err != nil {
+		return nil, 0, err
+	}
+	subscriptions := make([]models.WebhookSubscription, 0)
+	for _, i := range pageIndexes(len(all), offset, limit) {
+		subscriptions = append(subscriptions, all[i])
+	}
+	return subscriptions, len(all), nil
+}
+
+func (ws *WebhookRepository) ListActiveSubscriptions() ([]models.WebhookSubscription, error) {
+	return ws.subscriptions(func(s *models.WebhookSubscription) bool { return s.Active })
+}
+
+// subscriptions returns the subscriptions matching keep, oldest first.
+func (ws *WebhookRepository) subscriptions(keep func(*models.WebhookSubscription) bool) ([]models.WebhookSubscription, error) {
+	if err := ctxErr(ws.ctx); err != nil {
+		return nil, err
+	}
+	ws.store.mu.RLock()
+	defer ws.store.mu.RUnlock()
+	all := make([]models.WebhookSubscription, 0)
+	for _, s := range ws.store.webhooks {
+		if keep(&s) {
+			all = append(all, *copySubscription(s))
+		}
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].ID < all[j].ID })
+	return all, nil
+}
+
+func (ws *WebhookRepository) CreateDelivery(d *models.WebhookDelivery) error {
+	if err := ctxErr(ws.ctx); err != nil {
+		return err
+	}
+	ws.store.mu.Lock()
+	defer ws.store.mu.Unlock()
+	ws.store.lastDeliveryID++
+	d.ID = ws.store.lastDeliveryID
+	d.CreatedAt = time.Now()
+	d.UpdatedAt = d.CreatedAt
+	ws.store.deliveries[d.ID] = *copyDelivery(*d)
+	return nil
+}
+
+func (ws *WebhookRepository) UpdateDelivery(d *models.WebhookDelivery) error {
+	if err := ctxErr(ws.ctx); err != nil {
+		return err
+	}
+	ws.store.mu.Lock()
+	defer ws.store.mu.Unlock()
+	if _, ok := ws.store.deliveries[d.ID]; !ok {
+		return nil
+	}
+	d.UpdatedAt = time.Now()
+	ws.store.deliveries[d.ID] = *copyDelivery(*d)
+	return nil
+}
+
+func (ws *WebhookRepository) GetDelivery(id uint) (*models.WebhookDelivery, error) {
+	if err := ctxErr(ws.ctx); err != nil {
+		return nil, err
+	}
+	ws.store.mu.RLock()
+	defer ws.store.mu.RUnlock()
+	d, ok := ws.store.deliveries[id]
+	if !ok {
+		return nil, nil
+	}
+	return copyDelivery(d), nil
+}
+
+func (ws *WebhookRepository) ListDeliveries(subscriptionID uint, offset, limit int) ([]models.WebhookDelivery, int, error) {
+	if err := ctxErr(ws.ctx); err != nil {
+		return nil, 0, err
+	}
+	ws.store.mu.RLock()
+	defer ws.store.mu.RUnlock()
+	all := make([]models.WebhookDelivery, 0)
+	for _, d := range ws.store.deliveries {
+		if d.SubscriptionID == subscriptionID {
+			all = append(all, d)
+		}
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].ID > all[j].ID })
+	deliveries := make([]models.WebhookDelivery, 0)
+	for _, i := range pageIndexes(len(all), offset, limit) {
+		deliveries = append(deliveries, *copyDelivery(all[i]))
+	}
+	return deliveries, len(all), nil
+}
+
+func (ws *WebhookRepository) ListDueDeliveries(t time.Time, limit int) ([]models.WebhookDelivery, error) {
+	if err := ctxErr(ws.ctx); err != nil {
+		return nil, err
+	}
+	ws.store.mu.RLock()
+	defer ws.store.mu.RUnlock()
+	due := make([]models.WebhookDelivery, 0)
+	for _, d := range ws.store.deliveries {
+		if d.Status == models.DeliveryPending && !d.NextAttemptAt.After(t) {
+			due = append(due, *copyDelivery(d))
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		if due[i].NextAttemptAt.Equal(due[j].NextAttemptAt) {
+			return due[i].ID < due[j].ID
+		}
+		return due[i].NextAttemptAt.Before(due[j].NextAttemptAt)
+	})
+	if limit >= 0 && len(due) > limit {
+		due = due[:limit]
+	}
+	return due, nil
+}
+
+func copySubscription(s models.WebhookSubscription) *models.WebhookSubscription {
+	s.DisabledAt = copyTime(s.DisabledAt)
+	return &s
+}
+
+func copyDelivery(d models.WebhookDelivery) *models.WebhookDelivery {
+	d.Payload = append([]byte(nil), d.Payload...)
+	d.DeliveredAt = copyTime(d.DeliveredAt)
+	if d.ReplayOf != nil {
+		id := *d.ReplayOf
+		d.ReplayOf = &id
+	}
+	return &d
+}
+
+func copyTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	c := *t
+	return &c
+}
@@ -0,0 +1,47 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/sumitalp/productcatalog/db"
+	"github.com/sumitalp/productcatalog/models"
+	"github.com/sumitalp/productcatalog/outbox"
+)
+
+type OutboxRepository struct {
+	db *gorm.DB
+}
+
+func NewOutboxRepository(db *gorm.DB) *OutboxRepository {
+	return &OutboxRepository{
+		db: db,
+	}
+}
+
+func (ob *OutboxRepository) WithContext(ctx context.Context) outbox.RepositoryInterface {
+	return &OutboxRepository{
+		db: db.WithContext(ctx, ob.db),
+	}
+}
+
+func (ob *OutboxRepository) Create(e *models.OutboxEvent) error {
+	return translateError(ob.db.Create(e).Error)
+}
+
+func (ob *OutboxRepository) Update(e *models.OutboxEvent) error {
+	return ob.db.Save(e).Error
+}
+
+func (ob *OutboxRepository) ListPending(t time.Time, limit int) ([]models.OutboxEvent, error) {
+	var events []models.OutboxEvent
+	err := ob.db.Where("dispatched_at IS NULL AND dead_at IS NULL AND next_attempt_at <= ?", t).
+		Order("id").Limit(limit).Find(&events).Error
+	return events, err
+}
+
+func (ob *OutboxRepository) DeleteDispatched(t time.Time) (int, error) {
+	res := ob.db.Where("dispatched_at < ?", t).Delete(&models.OutboxEvent{})
+	return int(res.RowsAffected), res.Error
+}
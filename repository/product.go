@@ -1,8 +1,15 @@
 package repository
 
 import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+
 	"github.com/jinzhu/gorm"
+	"github.com/sumitalp/productcatalog/db"
 	"github.com/sumitalp/productcatalog/models"
+	"github.com/sumitalp/productcatalog/product"
 )
 
 type ProductRepository struct {
@@ -15,6 +22,12 @@ func NewProductRepository(db *gorm.DB) *ProductRepository {
 	}
 }
 
+func (as *ProductRepository) WithContext(ctx context.Context) product.RepositoryInterface {
+	return &ProductRepository{
+		db: db.WithContext(ctx, as.db),
+	}
+}
+
 func (as *ProductRepository) GetBySlug(s string) (*models.Product, error) {
 	var m models.Product
 	err := as.db.Where(&models.Product{Slug: s}).Preload("Categories").Preload("Owner").Find(&m).Error
@@ -29,7 +42,7 @@ func (as *ProductRepository) GetBySlug(s string) (*models.Product, error) {
 
 func (as *ProductRepository) GetUserProductBySlug(userID uint, slug string) (*models.Product, error) {
 	var m models.Product
-	err := as.db.Where(&models.Product{Slug: slug, OwnerID: userID}).Find(&m).Error
+	err := as.db.Where(&models.Product{Slug: slug, OwnerID: userID}).Preload("Categories").Preload("Owner").Find(&m).Error
 	if err != nil {
 		if gorm.IsRecordNotFoundError(err) {
 			return nil, nil
@@ -40,58 +53,55 @@ func (as *ProductRepository) GetUserProductBySlug(userID uint, slug string) (*mo
 }
 
 func (as *ProductRepository) CreateProduct(a *models.Product) error {
-	categories := a.Categories
-	tx := as.db.Begin()
-	if err := tx.Create(&a).Error; err != nil {
-		return err
-	}
-	for _, t := range a.Categories {
-		err := tx.Where(&models.Category{Category: t.Category}).First(&t).Error
-		if err != nil && !gorm.IsRecordNotFoundError(err) {
-			tx.Rollback()
-			return err
+	return transaction(as.db, func(tx *gorm.DB) error {
+		if err := tx.Create(&a).Error; err != nil {
+			return translateError(err)
 		}
-		if err := tx.Model(&a).Association("Categories").Append(t).Error; err != nil {
-			tx.Rollback()
-			return err
+		for _, t := range a.Categories {
+			err := tx.Where(&models.Category{Category: t.Category}).First(&t).Error
+			if err != nil && !gorm.IsRecordNotFoundError(err) {
+				return err
+			}
+			if err := tx.Model(&a).Association("Categories").Append(t).Error; err != nil {
+				return err
+			}
 		}
-	}
-	if err := tx.Where(a.ID).Preload("Categories").Preload("Owner").Find(&a).Error; err != nil {
-		tx.Rollback()
-		return err
-	}
-	a.Categories = categories
-	return tx.Commit().Error
+		// Preloading appends to the categories given, which lack the ids
+		// and versions of the stored ones.
+		a.Categories = nil
+		return tx.Where(a.ID).Preload("Categories").Preload("Owner").Find(&a).Error
+	})
 }
 
 func (as *ProductRepository) UpdateProduct(a *models.Product, categoryList []string) error {
-	tx := as.db.Begin()
-	if err := tx.Model(a).Update(a).Error; err != nil {
-		return err
-	}
-	categories := make([]models.Category, 0)
-	for _, t := range categoryList {
-		category := models.Category{Category: t}
-		err := tx.Where(&category).First(&category).Error
-		if err != nil && !gorm.IsRecordNotFoundError(err) {
-			tx.Rollback()
+	return transaction(as.db, func(tx *gorm.DB) error {
+		err := updateVersioned(tx, a, &a.ModelBase, map[string]interface{}{
+			"slug":        a.Slug,
+			"title":       a.Title,
+			"description": a.Description,
+			"image":       a.Image,
+		})
+		if err != nil {
 			return err
 		}
-		categories = append(categories, category)
-	}
-	if err := tx.Model(a).Association("Categories").Replace(categories).Error; err != nil {
-		tx.Rollback()
-		return err
-	}
-	if err := tx.Where(a.ID).Preload("Categories").Preload("Owner").Find(a).Error; err != nil {
-		tx.Rollback()
-		return err
-	}
-	return tx.Commit().Error
+		categories := make([]models.Category, 0)
+		for _, t := range categoryList {
+			category := models.Category{Category: t}
+			err := tx.Where(&category).First(&category).Error
+			if err != nil && !gorm.IsRecordNotFoundError(err) {
+				return err
+			}
+			categories = append(categories, category)
+		}
+		if err := tx.Model(a).Association("Categories").Replace(categories).Error; err != nil {
+			return err
+		}
+		return tx.Where(a.ID).Preload("Categories").Preload("Owner").Find(a).Error
+	})
 }
 
 func (as *ProductRepository) DeleteProduct(a *models.Product) error {
-	return as.db.Delete(a).Error
+	return deleteVersioned(as.db, a, &a.ModelBase)
 }
 
 func (as *ProductRepository) List(offset, limit int) ([]models.Product, int, error) {
@@ -99,8 +109,13 @@ func (as *ProductRepository) List(offset, limit int) ([]models.Product, int, err
 		products []models.Product
 		count    int
 	)
-	as.db.Model(&products).Count(&count)
-	as.db.Preload("Categories").Preload("Owner").Offset(offset).Limit(limit).Order("created_at desc").Find(&products)
+	if err := as.db.Model(&products).Count(&count).Error; err != nil {
+		return nil, 0, err
+	}
+	err := as.db.Preload("Categories").Preload("Owner").Offset(offset).Limit(limit).Order("created_at desc").Find(&products).Error
+	if err != nil {
+		return nil, 0, err
+	}
 	return products, count, nil
 }
 
@@ -111,11 +126,22 @@ func (as *ProductRepository) ListByCategory(category string, offset, limit int)
 		count    int
 	)
 	err := as.db.Where(&models.Category{Category: category}).First(&t).Error
+	if err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return products, 0, nil
+		}
+		return nil, 0, err
+	}
+	q := as.db.Model(&models.Product{}).
+		Joins("JOIN product_categories ON product_categories.product_id = products.id").
+		Where("product_categories.category_id = ?", t.ID)
+	if err := q.Count(&count).Error; err != nil {
+		return nil, 0, err
+	}
+	err = q.Preload("Categories").Preload("Owner").Offset(offset).Limit(limit).Order("products.created_at desc").Find(&products).Error
 	if err != nil {
 		return nil, 0, err
 	}
-	as.db.Model(&t).Preload("Categories").Preload("Owner").Offset(offset).Limit(limit).Order("created_at desc").Association("Categories").Find(&products)
-	count = as.db.Model(&t).Association("Categories").Count()
 	return products, count, nil
 }
 
@@ -127,55 +153,176 @@ func (as *ProductRepository) ListByOwner(username string, offset, limit int) ([]
 	)
 	err := as.db.Where(&models.User{Username: username}).First(&u).Error
 	if err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return products, 0, nil
+		}
+		return nil, 0, err
+	}
+	err = as.db.Where(&models.Product{OwnerID: u.ID}).Preload("Categories").Preload("Owner").Offset(offset).Limit(limit).Order("created_at desc").Find(&products).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := as.db.Where(&models.Product{OwnerID: u.ID}).Model(&models.Product{}).Count(&count).Error; err != nil {
 		return nil, 0, err
 	}
-	as.db.Where(&models.Product{OwnerID: u.ID}).Preload("Categories").Preload("Owner").Offset(offset).Limit(limit).Order("created_at desc").Find(&products)
-	as.db.Where(&models.Product{OwnerID: u.ID}).Model(&models.Product{}).Count(&count)
 
 	return products, count, nil
 }
 
-func (as *ProductRepository) ListCategories(offset, limit int) ([]models.Category, int, error) {
+func (as *ProductRepository) Search(query string, offset, limit int) ([]models.Product, int, error) {
 	var (
-		categories []models.Category
-		count int
+		products []models.Product
+		count    int
 	)
-
-	as.db.Model(&categories).Count(&count)
-	as.db.Offset(offset).Limit(limit).Order("created_at desc").Find(&categories)
-	
-	return categories, count, nil
+	pattern := "%" + escapeLike(strings.ToLower(query)) + "%"
+	q := as.db.Model(&models.Product{}).
+		Where(`LOWER(title) LIKE ? ESCAPE '\' OR LOWER(description) LIKE ? ESCAPE '\'`, pattern, pattern)
+	if err := q.Count(&count).Error; err != nil {
+		return nil, 0, err
+	}
+	err := q.Preload("Categories").Preload("Owner").Offset(offset).Limit(limit).Order("created_at desc").Find(&products).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	return products, count, nil
 }
 
-func (as *ProductRepository) CreateCategory(c *models.Category) error {
-	tx := as.db.Begin()
-	if err := tx.Create(&c).Error; err != nil {
-		return err
+func (as *ProductRepository) ListByCategoryIDs(ids []uint, limit int) (map[uint][]models.Product, error) {
+	var links []struct {
+		ProductID  uint
+		CategoryID uint
 	}
+	// The newest products of each category are numbered first, so that
+	// the limit applies to each category rather than to the batch.
+	ranked := as.db.Table("product_categories").
+		Select("product_categories.product_id, product_categories.category_id, "+
+			"ROW_NUMBER() OVER (PARTITION BY product_categories.category_id ORDER BY products.created_at DESC, products.id DESC) AS n").
+		Joins("JOIN products ON products.id = product_categories.product_id").
+		Where("product_categories.category_id IN (?)", ids).SubQuery()
+	if err := as.db.Raw("SELECT product_id, category_id FROM ? AS ranked"+rankWithin(limit), ranked).Scan(&links).Error; err != nil {
+		return nil, err
+	}
+	productIDs := make([]uint, 0, len(links))
+	for _, l := range links {
+		productIDs = append(productIDs, l.ProductID)
+	}
+	products, err := as.productsByID(productIDs)
+	if err != nil {
+		return nil, err
+	}
+	byCategory := make(map[uint][]models.Product)
+	for _, id := range ids {
+		byCategory[id] = make([]models.Product, 0)
+	}
+	for _, l := range links {
+		byCategory[l.CategoryID] = append(byCategory[l.CategoryID], products[l.ProductID])
+	}
+	for _, list := range byCategory {
+		sortNewestFirst(list)
+	}
+	return byCategory, nil
+}
 
-	if err := tx.Where(c.ID).Find(&c).Error; err != nil {
-		tx.Rollback()
-		return err
+func (as *ProductRepository) ListByOwnerIDs(ids []uint, limit int) (map[uint][]models.Product, error) {
+	var productIDs []uint
+	ranked := as.db.Table("products").
+		Select("id, ROW_NUMBER() OVER (PARTITION BY owner_id ORDER BY created_at DESC, id DESC) AS n").
+		Where("owner_id IN (?)", ids).SubQuery()
+	if err := as.db.Raw("SELECT id FROM ? AS ranked"+rankWithin(limit), ranked).Pluck("id", &productIDs).Error; err != nil {
+		return nil, err
+	}
+	products, err := as.productsByID(productIDs)
+	if err != nil {
+		return nil, err
+	}
+	byOwner := make(map[uint][]models.Product)
+	for _, id := range ids {
+		byOwner[id] = make([]models.Product, 0)
 	}
+	for _, id := range productIDs {
+		p := products[id]
+		byOwner[p.OwnerID] = append(byOwner[p.OwnerID], p)
+	}
+	for _, list := range byOwner {
+		sortNewestFirst(list)
+	}
+	return byOwner, nil
+}
 
-	return tx.Commit().Error
+// rankWithin is the condition keeping the rows ranked up to limit, none
+// when limit is negative.
+func rankWithin(limit int) string {
+	if limit < 0 {
+		return ""
+	}
+	return " WHERE n <= " + strconv.Itoa(limit)
 }
 
-func (as *ProductRepository) UpdateCategory(c *models.Category) error {
-	tx := as.db.Begin()
-	if err := tx.Model(c).Update(c).Error; err != nil {
-		return err
+// productsByID loads the products with the given ids, keyed by id.
+func (as *ProductRepository) productsByID(ids []uint) (map[uint]models.Product, error) {
+	byID := make(map[uint]models.Product, len(ids))
+	if len(ids) == 0 {
+		return byID, nil
+	}
+	var products []models.Product
+	if err := as.db.Where("id IN (?)", ids).Preload("Categories").Preload("Owner").Find(&products).Error; err != nil {
+		return nil, err
+	}
+	for _, p := range products {
+		byID[p.ID] = p
 	}
+	return byID, nil
+}
 
-	if err := tx.Where(c.ID).Find(c).Error; err != nil {
-		tx.Rollback()
-		return err
+// sortNewestFirst orders products as the listings do.
+func sortNewestFirst(products []models.Product) {
+	sort.Slice(products, func(i, j int) bool {
+		if !products[i].CreatedAt.Equal(products[j].CreatedAt) {
+			return products[i].CreatedAt.After(products[j].CreatedAt)
+		}
+		return products[i].ID > products[j].ID
+	})
+}
+
+func (as *ProductRepository) ListCategories(offset, limit int) ([]models.Category, int, error) {
+	var (
+		categories []models.Category
+		count      int
+	)
+
+	if err := as.db.Model(&categories).Count(&count).Error; err != nil {
+		return nil, 0, err
 	}
-	return tx.Commit().Error
+	if err := as.db.Offset(offset).Limit(limit).Order("created_at desc").Find(&categories).Error; err != nil {
+		return nil, 0, err
+	}
+	return categories, count, nil
+}
+
+func (as *ProductRepository) CreateCategory(c *models.Category) error {
+	return transaction(as.db, func(tx *gorm.DB) error {
+		if err := tx.Create(&c).Error; err != nil {
+			return translateError(err)
+		}
+		return tx.Where(c.ID).Find(&c).Error
+	})
+}
+
+func (as *ProductRepository) UpdateCategory(c *models.Category) error {
+	return transaction(as.db, func(tx *gorm.DB) error {
+		err := updateVersioned(tx, c, &c.ModelBase, map[string]interface{}{
+			"category":    c.Category,
+			"description": c.Description,
+		})
+		if err != nil {
+			return err
+		}
+		return tx.Where(c.ID).Find(c).Error
+	})
 }
 
 func (as *ProductRepository) DeleteCategory(c *models.Category) error {
-	return as.db.Delete(c).Error
+	return deleteVersioned(as.db, c, &c.ModelBase)
 }
 
 func (as *ProductRepository) GetCategoryByID(id uint) (*models.Category, error) {
@@ -190,3 +337,8 @@ func (as *ProductRepository) GetCategoryByID(id uint) (*models.Category, error)
 	}
 	return &c, err
 }
+
+// escapeLike escapes the LIKE wildcards in s.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
@@ -0,0 +1,995 @@
+// Package repositorytest holds the behaviour every implementation of the
+// repository interfaces must share.
+package repositorytest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/sumitalp/productcatalog/idempotency"
+	"github.com/sumitalp/productcatalog/models"
+	"github.com/sumitalp/productcatalog/outbox"
+	"github.com/sumitalp/productcatalog/product"
+	"github.com/sumitalp/productcatalog/uow"
+	"github.com/sumitalp/productcatalog/user"
+	"github.com/sumitalp/productcatalog/utils"
+	"github.com/sumitalp/productcatalog/webhook"
+)
+
+// Repositories is one fresh, empty set of repositories under test.
+type Repositories struct {
+	Users           user.RepositoryInterface
+	Products        product.RepositoryInterface
+	IdempotencyKeys idempotency.RepositoryInterface
+	Webhooks        webhook.RepositoryInterface
+	Outbox          outbox.RepositoryInterface
+	UnitOfWork      uow.UnitOfWork
+}
+
+// Factory returns empty repositories and a function releasing them.
+type Factory func(t *testing.T) (Repositories, func())
+
+// Run runs the contract suite against the repositories built by newRepos.
+func Run(t *testing.T, newRepos Factory) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, r Repositories)
+	}{
+		{"UserCreateAndGet", testUserCreateAndGet},
+		{"UserDuplicate", testUserDuplicate},
+		{"UserUpdate", testUserUpdate},
+		{"UserEmailVerified", testUserEmailVerified},
+		{"UserTokens", testUserTokens},
+		{"UserTwoFactor", testUserTwoFactor},
+		{"UserRecoveryCodes", testUserRecoveryCodes},
+		{"ProductCreateAndGet", testProductCreateAndGet},
+		{"ProductDuplicateSlug", testProductDuplicateSlug},
+		{"ProductCreatesMissingCategories", testProductCreatesMissingCategories},
+		{"ProductUpdate", testProductUpdate},
+		{"ProductUpdateClearsFields", testProductUpdateClearsFields},
+		{"ProductDelete", testProductDelete},
+		{"ProductStaleVersion", testProductStaleVersion},
+		{"ProductList", testProductList},
+		{"ProductListByCategory", testProductListByCategory},
+		{"ProductListByOwner", testProductListByOwner},
+		{"ProductSearch", testProductSearch},
+		{"ProductListByIDs", testProductListByIDs},
+		{"CategoryCRUD", testCategoryCRUD},
+		{"CategoryDuplicate", testCategoryDuplicate},
+		{"CategoryList", testCategoryList},
+		{"CategoryClearDescription", testCategoryClearDescription},
+		{"CategoryStaleVersion", testCategoryStaleVersion},
+		{"IdempotencyKeyLifecycle", testIdempotencyKeyLifecycle},
+		{"IdempotencyKeyDeleteExpired", testIdempotencyKeyDeleteExpired},
+		{"WebhookSubscriptions", testWebhookSubscriptions},
+		{"WebhookDeliveries", testWebhookDeliveries},
+		{"OutboxLifecycle", testOutboxLifecycle},
+		{"OutboxRollback", testOutboxRollback},
+		{"ContextCancelled", testContextCancelled},
+		{"UnitOfWorkCommit", testUnitOfWorkCommit},
+		{"UnitOfWorkRollback", testUnitOfWorkRollback},
+		{"UnitOfWorkAfterCommit", testUnitOfWorkAfterCommit},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			r, teardown := newRepos(t)
+			defer teardown()
+			tt.fn(t, r)
+		})
+	}
+}
+
+func createUser(t *testing.T, r Repositories, name string) *models.User {
+	u := &models.User{Username: name, Email: name + "@email.io", Password: "hash"}
+	require.NoError(t, r.Users.Create(u))
+	require.NotZero(t, u.ID)
+	return u
+}
+
+func createCategory(t *testing.T, r Repositories, name string) *models.Category {
+	c := &models.Category{Category: name, Description: name + " description"}
+	require.NoError(t, r.Products.CreateCategory(c))
+	require.NotZero(t, c.ID)
+	return c
+}
+
+func createProduct(t *testing.T, r Repositories, owner *models.User, slug string, categories ...string) *models.Product {
+	p := &models.Product{Slug: slug, Title: slug + " title", Description: slug + " description", OwnerID: owner.ID}
+	for _, c := range categories {
+		p.Categories = append(p.Categories, models.Category{Category: c})
+	}
+	require.NoError(t, r.Products.CreateProduct(p))
+	require.NotZero(t, p.ID)
+	// Creation timestamps order listings; keep them distinct.
+	time.Sleep(2 * time.Millisecond)
+	return p
+}
+
+// assertLoaded checks that the owner and the stored categories of p were
+// loaded along with it, as every product read must.
+func assertLoaded(t *testing.T, p models.Product, owner string, categories ...string) {
+	t.Helper()
+	assert.Equal(t, owner, p.Owner.Username, "owner of %s", p.Slug)
+	assert.NotZero(t, p.Owner.ID, "owner of %s", p.Slug)
+	assert.ElementsMatch(t, categories, categoryNames(p.Categories), "categories of %s", p.Slug)
+	for _, c := range p.Categories {
+		assert.NotZero(t, c.ID, "category %s of %s", c.Category, p.Slug)
+		assert.NotZero(t, c.Version, "category %s of %s", c.Category, p.Slug)
+	}
+}
+
+func slugs(products []models.Product) []string {
+	s := make([]string, 0, len(products))
+	for _, p := range products {
+		s = append(s, p.Slug)
+	}
+	return s
+}
+
+func categoryNames(categories []models.Category) []string {
+	s := make([]string, 0, len(categories))
+	for _, c := range categories {
+		s = append(s, c.Category)
+	}
+	return s
+}
+
+func testUserCreateAndGet(t *testing.T, r Repositories) {
+	u := createUser(t, r, "alice")
+
+	got, err := r.Users.GetByID(u.ID)
+	require.NoError(t, err)
+	require.NotNil(t, got)
+	assert.Equal(t, "alice", got.Username)
+	assert.False(t, got.CreatedAt.IsZero())
+
+	got, err = r.Users.GetByEmail("alice@email.io")
+	require.NoError(t, err)
+	require.NotNil(t, got)
+	assert.Equal(t, u.ID, got.ID)
+
+	got, err = r.Users.GetByUsername("alice")
+	require.NoError(t, err)
+	require.NotNil(t, got)
+	assert.Equal(t, u.ID, got.ID)
+
+	got, err = r.Users.GetByID(u.ID + 100)
+	assert.NoError(t, err)
+	assert.Nil(t, got)
+	got, err = r.Users.GetByEmail("nobody@email.io")
+	assert.NoError(t, err)
+	assert.Nil(t, got)
+	got, err = r.Users.GetByUsername("nobody")
+	assert.NoError(t, err)
+	assert.Nil(t, got)
+}
+
+func testUserDuplicate(t *testing.T, r Repositories) {
+	createUser(t, r, "alice")
+	err := r.Users.Create(&models.User{Username: "alice", Email: "other@email.io", Password: "hash"})
+	assert.True(t, errors.Is(err, utils.ErrDuplicate), "duplicate username: %v", err)
+	err = r.Users.Create(&models.User{Username: "other", Email: "alice@email.io", Password: "hash"})
+	assert.True(t, errors.Is(err, utils.ErrDuplicate), "duplicate email: %v", err)
+}
+
+func testUserUpdate(t *testing.T, r Repositories) {
+	u := createUser(t, r, "alice")
+	createUser(t, r, "bob")
+
+	bio := "bio"
+	u.Bio = &bio
+	u.Email = "alice@example.com"
+	u.TokenGeneration = 2
+	require.NoError(t, r.Users.Update(u))
+	got, err := r.Users.GetByID(u.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "alice@example.com", got.Email)
+	assert.Equal(t, uint(2), got.TokenGeneration)
+	if assert.NotNil(t, got.Bio) {
+		assert.Equal(t, "bio", *got.Bio)
+	}
+
+	got.Username = "bob"
+	err = r.Users.Update(got)
+	assert.True(t, errors.Is(err, utils.ErrDuplicate), "duplicate username: %v", err)
+}
+
+func testUserEmailVerified(t *testing.T, r Repositories) {
+	u := createUser(t, r, "alice")
+	assert.Nil(t, u.EmailVerifiedAt)
+
+	at := time.Now().Truncate(time.Second)
+	require.NoError(t, r.Users.SetEmailVerified(u.ID, &at))
+	got, err := r.Users.GetByID(u.ID)
+	require.NoError(t, err)
+	if assert.NotNil(t, got.EmailVerifiedAt) {
+		assert.True(t, at.Equal(*got.EmailVerifiedAt))
+	}
+
+	require.NoError(t, r.Users.SetEmailVerified(u.ID, nil))
+	got, err = r.Users.GetByID(u.ID)
+	require.NoError(t, err)
+	assert.Nil(t, got.EmailVerifiedAt)
+}
+
+func testUserTokens(t *testing.T, r Repositories) {
+	now := time.Now()
+	reset := &models.UserToken{UserID: 1, Purpose: models.TokenResetPassword, Hash: "h1", ExpiresAt: now.Add(time.Hour)}
+	require.NoError(t, r.Users.CreateToken(reset))
+	require.NotZero(t, reset.ID)
+	err := r.Users.CreateToken(&models.UserToken{UserID: 2, Purpose: models.TokenResetPassword, Hash: "h1", ExpiresAt: now.Add(time.Hour)})
+	assert.True(t, errors.Is(err, utils.ErrDuplicate), "duplicate hash: %v", err)
+	require.NoError(t, r.Users.CreateToken(&models.UserToken{UserID: 1, Purpose: models.TokenResetPassword, Hash: "h2", ExpiresAt: now.Add(time.Hour)}))
+	require.NoError(t, r.Users.CreateToken(&models.UserToken{UserID: 1, Purpose: models.TokenVerifyEmail, Hash: "h3", Email: "alice@email.io", ExpiresAt: now.Add(time.Hour)}))
+	require.NoError(t, r.Users.CreateToken(&models.UserToken{UserID: 1, Purpose: models.TokenVerifyEmail, Hash: "old", ExpiresAt: now.Add(-time.Minute)}))
+
+	got, err := r.Users.ConsumeToken(models.TokenVerifyEmail, "h1", now)
+	assert.NoError(t, err)
+	assert.Nil(t, got, "tokens only serve their purpose")
+	got, err = r.Users.ConsumeToken(models.TokenResetPassword, "h1", now)
+	require.NoError(t, err)
+	require.NotNil(t, got)
+	assert.Equal(t, uint(1), got.UserID)
+	assert.NotNil(t, got.UsedAt)
+	got, err = r.Users.ConsumeToken(models.TokenResetPassword, "h1", now)
+	assert.NoError(t, err)
+	assert.Nil(t, got, "tokens are single-use")
+	got, err = r.Users.ConsumeToken(models.TokenVerifyEmail, "old", now)
+	assert.NoError(t, err)
+	assert.Nil(t, got, "expired")
+	got, err = r.Users.ConsumeToken(models.TokenResetPassword, "nope", now)
+	assert.NoError(t, err)
+	assert.Nil(t, got)
+
+	require.NoError(t, r.Users.RevokeTokens(1, models.TokenResetPassword))
+	got, err = r.Users.ConsumeToken(models.TokenResetPassword, "h2", now)
+	assert.NoError(t, err)
+	assert.Nil(t, got, "revoked")
+
+	n, err := r.Users.DeleteExpiredTokens(now)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	got, err = r.Users.ConsumeToken(models.TokenVerifyEmail, "h3", now)
+	require.NoError(t, err)
+	require.NotNil(t, got)
+	assert.Equal(t, "alice@email.io", got.Email)
+}
+
+func testUserTwoFactor(t *testing.T, r Repositories) {
+	u := createUser(t, r, "alice")
+	require.NoError(t, r.Users.SetTwoFactor(u.ID, "SECRET", nil))
+	got, err := r.Users.GetByID(u.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "SECRET", got.TOTPSecret)
+	assert.False(t, got.TwoFactorEnabled(), "pending")
+
+	at := time.Now()
+	require.NoError(t, r.Users.SetTwoFactor(u.ID, "SECRET", &at))
+	ok, err := r.Users.UseTOTPStep(u.ID, 10)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	for _, step := range []int64{10, 9} {
+		ok, err = r.Users.UseTOTPStep(u.ID, step)
+		require.NoError(t, err)
+		assert.False(t, ok, "step %d replayed", step)
+	}
+
+	got, err = r.Users.GetByID(u.ID)
+	require.NoError(t, err)
+	assert.True(t, got.TwoFactorEnabled())
+	assert.Equal(t, int64(10), got.TOTPLastStep)
+	got.TOTPSecret = "OTHER"
+	got.TOTPLastStep = 1
+	got.Bio = new(string)
+	require.NoError(t, r.Users.Update(got))
+	got, err = r.Users.GetByID(u.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "SECRET", got.TOTPSecret, "left alone by Update")
+	assert.Equal(t, int64(10), got.TOTPLastStep)
+
+	require.NoError(t, r.Users.SetTwoFactor(u.ID, "", nil))
+	got, err = r.Users.GetByID(u.ID)
+	require.NoError(t, err)
+	assert.False(t, got.TwoFactorEnabled())
+	assert.Empty(t, got.TOTPSecret)
+	assert.Zero(t, got.TOTPLastStep)
+}
+
+func testUserRecoveryCodes(t *testing.T, r Repositories) {
+	now := time.Now()
+	require.NoError(t, r.Users.ReplaceRecoveryCodes(1, []string{"a1", "a2", "a3"}))
+	require.NoError(t, r.Users.ReplaceRecoveryCodes(2, []string{"b1"}))
+	n, err := r.Users.CountRecoveryCodes(1)
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+
+	ok, err := r.Users.UseRecoveryCode(1, "b1", now)
+	require.NoError(t, err)
+	assert.False(t, ok, "codes of other users")
+	ok, err = r.Users.UseRecoveryCode(1, "a1", now)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = r.Users.UseRecoveryCode(1, "a1", now)
+	require.NoError(t, err)
+	assert.False(t, ok, "single-use")
+	n, err = r.Users.CountRecoveryCodes(1)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	require.NoError(t, r.Users.ReplaceRecoveryCodes(1, []string{"a4"}))
+	ok, err = r.Users.UseRecoveryCode(1, "a2", now)
+	require.NoError(t, err)
+	assert.False(t, ok, "replaced")
+	require.NoError(t, r.Users.ReplaceRecoveryCodes(1, nil))
+	n, err = r.Users.CountRecoveryCodes(1)
+	require.NoError(t, err)
+	assert.Zero(t, n)
+	n, err = r.Users.CountRecoveryCodes(2)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+}
+
+func testProductCreateAndGet(t *testing.T, r Repositories) {
+	owner := createUser(t, r, "alice")
+	createCategory(t, r, "books")
+	createCategory(t, r, "music")
+	p := createProduct(t, r, owner, "p1", "books", "music")
+	assertLoaded(t, *p, "alice", "books", "music")
+
+	got, err := r.Products.GetBySlug("p1")
+	require.NoError(t, err)
+	require.NotNil(t, got)
+	assert.Equal(t, p.ID, got.ID)
+	assert.Equal(t, "p1 title", got.Title)
+	assertLoaded(t, *got, "alice", "books", "music")
+	assert.False(t, got.CreatedAt.IsZero())
+
+	got, err = r.Products.GetUserProductBySlug(owner.ID, "p1")
+	require.NoError(t, err)
+	require.NotNil(t, got)
+	assert.Equal(t, p.ID, got.ID)
+	assertLoaded(t, *got, "alice", "books", "music")
+
+	got, err = r.Products.GetUserProductBySlug(owner.ID+1, "p1")
+	assert.NoError(t, err)
+	assert.Nil(t, got)
+	got, err = r.Products.GetBySlug("missing")
+	assert.NoError(t, err)
+	assert.Nil(t, got)
+}
+
+func testProductDuplicateSlug(t *testing.T, r Repositories) {
+	owner := createUser(t, r, "alice")
+	createProduct(t, r, owner, "p1")
+	err := r.Products.CreateProduct(&models.Product{Slug: "p1", Title: "again", OwnerID: owner.ID})
+	assert.True(t, errors.Is(err, utils.ErrDuplicate), "duplicate slug: %v", err)
+}
+
+func testProductCreatesMissingCategories(t *testing.T, r Repositories) {
+	owner := createUser(t, r, "alice")
+	createProduct(t, r, owner, "p1", "books")
+
+	p, err := r.Products.GetUserProductBySlug(owner.ID, "p1")
+	require.NoError(t, err)
+	require.NoError(t, r.Products.UpdateProduct(p, []string{"books", "music"}))
+
+	categories, count, err := r.Products.ListCategories(0, 20)
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+	assert.ElementsMatch(t, []string{"books", "music"}, categoryNames(categories))
+}
+
+func testProductUpdate(t *testing.T, r Repositories) {
+	owner := createUser(t, r, "alice")
+	createCategory(t, r, "books")
+	createCategory(t, r, "music")
+	createProduct(t, r, owner, "p1", "books")
+
+	p, err := r.Products.GetUserProductBySlug(owner.ID, "p1")
+	require.NoError(t, err)
+	p.Title = "new title"
+	p.Slug = "new-title"
+	require.NoError(t, r.Products.UpdateProduct(p, []string{"music"}))
+	assertLoaded(t, *p, "alice", "music")
+
+	got, err := r.Products.GetBySlug("new-title")
+	require.NoError(t, err)
+	require.NotNil(t, got)
+	assert.Equal(t, "new title", got.Title)
+	assert.Equal(t, "p1 description", got.Description)
+	assertLoaded(t, *got, "alice", "music")
+
+	got, err = r.Products.GetBySlug("p1")
+	assert.NoError(t, err)
+	assert.Nil(t, got)
+}
+
+// testProductUpdateClearsFields applies a patch that removes the image and
+// description, as a merge patch with null values would.
+func testProductUpdateClearsFields(t *testing.T, r Repositories) {
+	owner := createUser(t, r, "alice")
+	p := createProduct(t, r, owner, "p1")
+	p.Image = "p1.png"
+	require.NoError(t, r.Products.UpdateProduct(p, nil))
+
+	p, err := r.Products.GetUserProductBySlug(owner.ID, "p1")
+	require.NoError(t, err)
+	require.Equal(t, "p1.png", p.Image)
+	p.Image = ""
+	p.Description = ""
+	require.NoError(t, r.Products.UpdateProduct(p, nil))
+
+	got, err := r.Products.GetBySlug("p1")
+	require.NoError(t, err)
+	require.NotNil(t, got)
+	assert.Equal(t, "", got.Image)
+	assert.Equal(t, "", got.Description)
+	assert.Equal(t, "p1 title", got.Title)
+	assert.Equal(t, uint(3), got.Version)
+}
+
+func testProductStaleVersion(t *testing.T, r Repositories) {
+	owner := createUser(t, r, "alice")
+	p := createProduct(t, r, owner, "p1")
+	assert.Equal(t, uint(1), p.Version)
+
+	first, err := r.Products.GetUserProductBySlug(owner.ID, "p1")
+	require.NoError(t, err)
+	second, err := r.Products.GetUserProductBySlug(owner.ID, "p1")
+	require.NoError(t, err)
+
+	first.Title = "first"
+	require.NoError(t, r.Products.UpdateProduct(first, nil))
+	assert.Equal(t, uint(2), first.Version)
+
+	second.Title = "second"
+	err = r.Products.UpdateProduct(second, nil)
+	assert.True(t, errors.Is(err, utils.ErrStale), "stale update: %v", err)
+	err = r.Products.DeleteProduct(second)
+	assert.True(t, errors.Is(err, utils.ErrStale), "stale delete: %v", err)
+
+	got, err := r.Products.GetBySlug("p1")
+	require.NoError(t, err)
+	assert.Equal(t, "first", got.Title)
+	assert.Equal(t, uint(2), got.Version)
+}
+
+func testProductDelete(t *testing.T, r Repositories) {
+	owner := createUser(t, r, "alice")
+	p := createProduct(t, r, owner, "p1")
+	require.NoError(t, r.Products.DeleteProduct(p))
+	got, err := r.Products.GetBySlug("p1")
+	assert.NoError(t, err)
+	assert.Nil(t, got)
+}
+
+func testProductList(t *testing.T, r Repositories) {
+	owner := createUser(t, r, "alice")
+	createProduct(t, r, owner, "p1")
+	createProduct(t, r, owner, "p2", "books")
+	createProduct(t, r, owner, "p3")
+
+	products, count, err := r.Products.List(0, 20)
+	require.NoError(t, err)
+	assert.Equal(t, 3, count)
+	assert.Equal(t, []string{"p3", "p2", "p1"}, slugs(products))
+	assertLoaded(t, products[0], "alice")
+	assertLoaded(t, products[1], "alice", "books")
+
+	products, count, err = r.Products.List(1, 1)
+	require.NoError(t, err)
+	assert.Equal(t, 3, count)
+	assert.Equal(t, []string{"p2"}, slugs(products))
+}
+
+func testProductListByCategory(t *testing.T, r Repositories) {
+	owner := createUser(t, r, "alice")
+	createCategory(t, r, "books")
+	createCategory(t, r, "music")
+	createProduct(t, r, owner, "p1", "books")
+	createProduct(t, r, owner, "p2", "music")
+	createProduct(t, r, owner, "p3", "books", "music")
+
+	products, count, err := r.Products.ListByCategory("books", 0, 20)
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+	assert.Equal(t, []string{"p3", "p1"}, slugs(products))
+	assertLoaded(t, products[0], "alice", "books", "music")
+	assertLoaded(t, products[1], "alice", "books")
+
+	products, count, err = r.Products.ListByCategory("books", 1, 20)
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+	assert.Equal(t, []string{"p1"}, slugs(products))
+
+	products, count, err = r.Products.ListByCategory("missing", 0, 20)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+	assert.Empty(t, products)
+}
+
+func testProductListByOwner(t *testing.T, r Repositories) {
+	alice := createUser(t, r, "alice")
+	bob := createUser(t, r, "bob")
+	createProduct(t, r, alice, "p1", "books")
+	createProduct(t, r, bob, "p2")
+	createProduct(t, r, alice, "p3")
+
+	products, count, err := r.Products.ListByOwner("alice", 0, 20)
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+	assert.Equal(t, []string{"p3", "p1"}, slugs(products))
+	assertLoaded(t, products[0], "alice")
+	assertLoaded(t, products[1], "alice", "books")
+
+	products, count, err = r.Products.ListByOwner("nobody", 0, 20)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+	assert.Empty(t, products)
+}
+
+func testProductSearch(t *testing.T, r Repositories) {
+	owner := createUser(t, r, "alice")
+	p1 := createProduct(t, r, owner, "p1")
+	p2 := createProduct(t, r, owner, "p2")
+	p3 := createProduct(t, r, owner, "p3")
+	p1.Title = "Red Bicycle"
+	p2.Description = "a bicycle bell"
+	p3.Title = "50% off_sale"
+	for _, p := range []*models.Product{p1, p2, p3} {
+		p.Categories = nil
+		require.NoError(t, r.Products.UpdateProduct(p, nil))
+	}
+	require.NoError(t, r.Products.UpdateProduct(p1, []string{"bikes"}))
+
+	products, count, err := r.Products.Search("BICYCLE", 0, 20)
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+	assert.Equal(t, []string{"p2", "p1"}, slugs(products))
+	assertLoaded(t, products[0], "alice")
+	assertLoaded(t, products[1], "alice", "bikes")
+
+	products, count, err = r.Products.Search("bicycle", 1, 20)
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+	assert.Equal(t, []string{"p1"}, slugs(products))
+
+	// LIKE wildcards in the query match literally.
+	products, _, err = r.Products.Search("0% off_", 0, 20)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"p3"}, slugs(products))
+	products, count, err = r.Products.Search("_", 0, 20)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+	assert.Equal(t, []string{"p3"}, slugs(products))
+}
+
+func testProductListByIDs(t *testing.T, r Repositories) {
+	alice := createUser(t, r, "alice")
+	bob := createUser(t, r, "bob")
+	books := createCategory(t, r, "books")
+	music := createCategory(t, r, "music")
+	createProduct(t, r, alice, "p1", "books")
+	createProduct(t, r, bob, "p2", "books", "music")
+	createProduct(t, r, alice, "p3", "music")
+
+	byCategory, err := r.Products.ListByCategoryIDs([]uint{books.ID, music.ID, music.ID + 100}, -1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"p2", "p1"}, slugs(byCategory[books.ID]))
+	assert.Equal(t, []string{"p3", "p2"}, slugs(byCategory[music.ID]))
+	assert.Empty(t, byCategory[music.ID+100])
+	assertLoaded(t, byCategory[books.ID][0], "bob", "books", "music")
+	assertLoaded(t, byCategory[books.ID][1], "alice", "books")
+
+	byOwner, err := r.Products.ListByOwnerIDs([]uint{alice.ID, bob.ID, bob.ID + 100}, -1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"p3", "p1"}, slugs(byOwner[alice.ID]))
+	assert.Equal(t, []string{"p2"}, slugs(byOwner[bob.ID]))
+	assert.Empty(t, byOwner[bob.ID+100])
+	assertLoaded(t, byOwner[bob.ID][0], "bob", "books", "music")
+	assertLoaded(t, byOwner[alice.ID][0], "alice", "music")
+
+	// The limit applies to each key.
+	byCategory, err = r.Products.ListByCategoryIDs([]uint{books.ID, music.ID}, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"p2"}, slugs(byCategory[books.ID]))
+	assert.Equal(t, []string{"p3"}, slugs(byCategory[music.ID]))
+	assertLoaded(t, byCategory[books.ID][0], "bob", "books", "music")
+	byOwner, err = r.Products.ListByOwnerIDs([]uint{alice.ID, bob.ID}, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"p3"}, slugs(byOwner[alice.ID]))
+	assert.Equal(t, []string{"p2"}, slugs(byOwner[bob.ID]))
+	byOwner, err = r.Products.ListByOwnerIDs([]uint{alice.ID}, 0)
+	require.NoError(t, err)
+	assert.Empty(t, byOwner[alice.ID])
+}
+
+func testCategoryCRUD(t *testing.T, r Repositories) {
+	c := createCategory(t, r, "books")
+
+	got, err := r.Products.GetCategoryByID(c.ID)
+	require.NoError(t, err)
+	require.NotNil(t, got)
+	assert.Equal(t, "books", got.Category)
+	assert.Equal(t, "books description", got.Description)
+
+	got.Category = "novels"
+	require.NoError(t, r.Products.UpdateCategory(got))
+	got, err = r.Products.GetCategoryByID(c.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "novels", got.Category)
+
+	require.NoError(t, r.Products.DeleteCategory(got))
+	got, err = r.Products.GetCategoryByID(c.ID)
+	assert.NoError(t, err)
+	assert.Nil(t, got)
+}
+
+func testCategoryDuplicate(t *testing.T, r Repositories) {
+	createCategory(t, r, "books")
+	err := r.Products.CreateCategory(&models.Category{Category: "books"})
+	assert.True(t, errors.Is(err, utils.ErrDuplicate), "duplicate category: %v", err)
+}
+
+func testCategoryClearDescription(t *testing.T, r Repositories) {
+	c := createCategory(t, r, "books")
+	c.Description = ""
+	require.NoError(t, r.Products.UpdateCategory(c))
+
+	got, err := r.Products.GetCategoryByID(c.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "", got.Description)
+	assert.Equal(t, uint(2), got.Version)
+}
+
+func testCategoryStaleVersion(t *testing.T, r Repositories) {
+	c := createCategory(t, r, "books")
+	assert.Equal(t, uint(1), c.Version)
+	stale := *c
+
+	c.Description = "updated"
+	require.NoError(t, r.Products.UpdateCategory(c))
+	assert.Equal(t, uint(2), c.Version)
+
+	stale.Description = "lost"
+	err := r.Products.UpdateCategory(&stale)
+	assert.True(t, errors.Is(err, utils.ErrStale), "stale update: %v", err)
+	err = r.Products.DeleteCategory(&stale)
+	assert.True(t, errors.Is(err, utils.ErrStale), "stale delete: %v", err)
+	require.NoError(t, r.Products.DeleteCategory(c))
+}
+
+func testCategoryList(t *testing.T, r Repositories) {
+	createCategory(t, r, "books")
+	time.Sleep(2 * time.Millisecond)
+	createCategory(t, r, "music")
+
+	categories, count, err := r.Products.ListCategories(0, 20)
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+	assert.Equal(t, []string{"music", "books"}, categoryNames(categories))
+}
+
+func testIdempotencyKeyLifecycle(t *testing.T, r Repositories) {
+	k := &models.IdempotencyKey{UserID: 1, Key: "k1", Fingerprint: "f1", ExpiresAt: time.Now().Add(time.Hour)}
+	require.NoError(t, r.IdempotencyKeys.Create(k))
+	require.NotZero(t, k.ID)
+
+	err := r.IdempotencyKeys.Create(&models.IdempotencyKey{UserID: 1, Key: "k1", Fingerprint: "f2", ExpiresAt: k.ExpiresAt})
+	assert.True(t, errors.Is(err, utils.ErrDuplicate), "duplicate key: %v", err)
+	require.NoError(t, r.IdempotencyKeys.Create(&models.IdempotencyKey{UserID: 2, Key: "k1", Fingerprint: "f2", ExpiresAt: k.ExpiresAt}))
+
+	got, err := r.IdempotencyKeys.Get(1, "k1")
+	require.NoError(t, err)
+	require.NotNil(t, got)
+	assert.Equal(t, "f1", got.Fingerprint)
+	assert.False(t, got.Completed())
+
+	k.StatusCode = 201
+	k.ContentType = "application/json"
+	k.Body = []byte(`{"ok":true}`)
+	k.ExpiresAt = time.Now().Add(24 * time.Hour)
+	require.NoError(t, r.IdempotencyKeys.Complete(k))
+	got, err = r.IdempotencyKeys.Get(1, "k1")
+	require.NoError(t, err)
+	assert.True(t, got.Completed())
+	assert.WithinDuration(t, k.ExpiresAt, got.ExpiresAt, time.Second)
+	assert.Equal(t, 201, got.StatusCode)
+	assert.Equal(t, "application/json", got.ContentType)
+	assert.Equal(t, `{"ok":true}`, string(got.Body))
+
+	require.NoError(t, r.IdempotencyKeys.Delete(got))
+	got, err = r.IdempotencyKeys.Get(1, "k1")
+	assert.NoError(t, err)
+	assert.Nil(t, got)
+	got, err = r.IdempotencyKeys.Get(2, "k1")
+	assert.NoError(t, err)
+	assert.NotNil(t, got)
+}
+
+func testIdempotencyKeyDeleteExpired(t *testing.T, r Repositories) {
+	now := time.Now()
+	require.NoError(t, r.IdempotencyKeys.Create(&models.IdempotencyKey{Key: "old", Fingerprint: "f", ExpiresAt: now.Add(-time.Minute)}))
+	require.NoError(t, r.IdempotencyKeys.Create(&models.IdempotencyKey{Key: "new", Fingerprint: "f", ExpiresAt: now.Add(time.Minute)}))
+	n, err := r.IdempotencyKeys.DeleteExpired(now)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	got, err := r.IdempotencyKeys.Get(0, "old")
+	assert.NoError(t, err)
+	assert.Nil(t, got)
+	got, err = r.IdempotencyKeys.Get(0, "new")
+	assert.NoError(t, err)
+	assert.NotNil(t, got)
+}
+
+func testWebhookSubscriptions(t *testing.T, r Repositories) {
+	s1 := &models.WebhookSubscription{UserID: 1, URL: "http://a.example", Secret: "s", Events: "product.*", Active: true}
+	s2 := &models.WebhookSubscription{UserID: 1, URL: "http://b.example", Secret: "s", Events: "*", Active: true}
+	s3 := &models.WebhookSubscription{UserID: 2, URL: "http://c.example", Secret: "s", Events: "*", Active: true}
+	for _, s := range []*models.WebhookSubscription{s1, s2, s3} {
+		require.NoError(t, r.Webhooks.CreateSubscription(s))
+		require.NotZero(t, s.ID)
+	}
+
+	got, err := r.Webhooks.GetSubscription(s1.ID)
+	require.NoError(t, err)
+	require.NotNil(t, got)
+	assert.Equal(t, "http://a.example", got.URL)
+	assert.True(t, got.Active)
+	got, err = r.Webhooks.GetSubscription(s3.ID + 1)
+	assert.NoError(t, err)
+	assert.Nil(t, got)
+
+	list, count, err := r.Webhooks.ListSubscriptions(1, 0, 1)
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+	require.Len(t, list, 1)
+	assert.Equal(t, s1.ID, list[0].ID)
+
+	now := time.Now()
+	s2.Active = false
+	s2.ConsecutiveFailures = 5
+	s2.DisabledAt = &now
+	require.NoError(t, r.Webhooks.UpdateSubscription(s2))
+	got, err = r.Webhooks.GetSubscription(s2.ID)
+	require.NoError(t, err)
+	assert.False(t, got.Active)
+	assert.Equal(t, 5, got.ConsecutiveFailures)
+	assert.NotNil(t, got.DisabledAt)
+
+	active, err := r.Webhooks.ListActiveSubscriptions()
+	require.NoError(t, err)
+	require.Len(t, active, 2)
+	assert.Equal(t, s1.ID, active[0].ID)
+	assert.Equal(t, s3.ID, active[1].ID)
+}
+
+func testWebhookDeliveries(t *testing.T, r Repositories) {
+	s := &models.WebhookSubscription{UserID: 1, URL: "http://a.example", Secret: "s", Events: "*", Active: true}
+	require.NoError(t, r.Webhooks.CreateSubscription(s))
+	now := time.Now()
+	newDelivery := func(next time.Time) *models.WebhookDelivery {
+		d := &models.WebhookDelivery{
+			SubscriptionID: s.ID,
+			EventID:        "e",
+			EventType:      "product.created",
+			Payload:        []byte(`{"id":"e"}`),
+			Status:         models.DeliveryPending,
+			NextAttemptAt:  next,
+		}
+		require.NoError(t, r.Webhooks.CreateDelivery(d))
+		require.NotZero(t, d.ID)
+		return d
+	}
+	d1 := newDelivery(now.Add(-time.Minute))
+	d2 := newDelivery(now.Add(-2 * time.Minute))
+	d3 := newDelivery(now.Add(time.Minute))
+
+	due, err := r.Webhooks.ListDueDeliveries(now, 10)
+	require.NoError(t, err)
+	require.Len(t, due, 2)
+	assert.Equal(t, d2.ID, due[0].ID)
+	assert.Equal(t, d1.ID, due[1].ID)
+	assert.Equal(t, `{"id":"e"}`, string(due[0].Payload))
+
+	d2.Status = models.DeliverySucceeded
+	d2.Attempts = 1
+	d2.LastStatusCode = 204
+	d2.DeliveredAt = &now
+	require.NoError(t, r.Webhooks.UpdateDelivery(d2))
+	got, err := r.Webhooks.GetDelivery(d2.ID)
+	require.NoError(t, err)
+	require.NotNil(t, got)
+	assert.Equal(t, models.DeliverySucceeded, got.Status)
+	assert.Equal(t, 204, got.LastStatusCode)
+	assert.NotNil(t, got.DeliveredAt)
+	due, err = r.Webhooks.ListDueDeliveries(now, 10)
+	require.NoError(t, err)
+	require.Len(t, due, 1)
+	assert.Equal(t, d1.ID, due[0].ID)
+
+	list, count, err := r.Webhooks.ListDeliveries(s.ID, 0, 2)
+	require.NoError(t, err)
+	assert.Equal(t, 3, count)
+	require.Len(t, list, 2)
+	assert.Equal(t, d3.ID, list[0].ID)
+	assert.Equal(t, d2.ID, list[1].ID)
+
+	require.NoError(t, r.Webhooks.DeleteSubscription(s))
+	got, err = r.Webhooks.GetDelivery(d1.ID)
+	assert.NoError(t, err)
+	assert.Nil(t, got)
+	sub, err := r.Webhooks.GetSubscription(s.ID)
+	assert.NoError(t, err)
+	assert.Nil(t, sub)
+}
+
+func testOutboxLifecycle(t *testing.T, r Repositories) {
+	now := time.Now()
+	newEvent := func(id string, next time.Time) *models.OutboxEvent {
+		e := &models.OutboxEvent{EventID: id, Type: "product.created", OccurredAt: now, Payload: []byte(`{"id":"` + id + `"}`), NextAttemptAt: next}
+		require.NoError(t, r.Outbox.Create(e))
+		require.NotZero(t, e.ID)
+		return e
+	}
+	e1 := newEvent("e1", now.Add(-time.Minute))
+	e2 := newEvent("e2", now.Add(time.Minute))
+	e3 := newEvent("e3", now)
+	err := r.Outbox.Create(&models.OutboxEvent{EventID: "e1", Type: "product.created", NextAttemptAt: now})
+	assert.True(t, errors.Is(err, utils.ErrDuplicate), "duplicate event: %v", err)
+
+	pending, err := r.Outbox.ListPending(now, 10)
+	require.NoError(t, err)
+	require.Len(t, pending, 2)
+	assert.Equal(t, e1.ID, pending[0].ID)
+	assert.Equal(t, e3.ID, pending[1].ID)
+	assert.Equal(t, `{"id":"e1"}`, string(pending[0].Payload))
+	pending, err = r.Outbox.ListPending(now.Add(time.Hour), 1)
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	assert.Equal(t, e1.ID, pending[0].ID)
+
+	e1.MarkHandled("webhooks")
+	e1.Attempts = 1
+	e1.LastError = "search: down"
+	e1.NextAttemptAt = now.Add(time.Hour)
+	require.NoError(t, r.Outbox.Update(e1))
+	dispatched := now.Add(-time.Second)
+	e3.Attempts = 1
+	e3.DispatchedAt = &dispatched
+	require.NoError(t, r.Outbox.Update(e3))
+	e4 := newEvent("e4", now)
+	e4.DeadAt = &dispatched
+	require.NoError(t, r.Outbox.Update(e4))
+	pending, err = r.Outbox.ListPending(now.Add(time.Hour), 10)
+	require.NoError(t, err)
+	require.Len(t, pending, 2)
+	assert.Equal(t, e1.ID, pending[0].ID)
+	assert.True(t, pending[0].HandledBy("webhooks"))
+	assert.Equal(t, "search: down", pending[0].LastError)
+	assert.Equal(t, e2.ID, pending[1].ID)
+
+	n, err := r.Outbox.DeleteDispatched(now)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	n, err = r.Outbox.DeleteDispatched(now)
+	require.NoError(t, err)
+	assert.Zero(t, n)
+}
+
+func testOutboxRollback(t *testing.T, r Repositories) {
+	err := r.UnitOfWork.Do(context.Background(), func(ctx context.Context) error {
+		if err := r.Products.WithContext(ctx).CreateCategory(&models.Category{Category: "books"}); err != nil {
+			return err
+		}
+		if err := r.Outbox.WithContext(ctx).Create(&models.OutboxEvent{EventID: "e1", Type: "category.created", NextAttemptAt: time.Now()}); err != nil {
+			return err
+		}
+		return utils.ErrNotFound
+	})
+	assert.True(t, errors.Is(err, utils.ErrNotFound))
+	pending, err := r.Outbox.ListPending(time.Now(), 10)
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+}
+
+func testContextCancelled(t *testing.T, r Repositories) {
+	owner := createUser(t, r, "alice")
+	createProduct(t, r, owner, "p1")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := r.Products.WithContext(ctx).GetBySlug("p1")
+	assert.True(t, errors.Is(err, context.Canceled), "GetBySlug: %v", err)
+	_, _, err = r.Products.WithContext(ctx).List(0, 20)
+	assert.True(t, errors.Is(err, context.Canceled), "List: %v", err)
+	_, err = r.Users.WithContext(ctx).GetByID(owner.ID)
+	assert.True(t, errors.Is(err, context.Canceled), "GetByID: %v", err)
+	err = r.Products.WithContext(ctx).CreateCategory(&models.Category{Category: "books"})
+	assert.True(t, errors.Is(err, context.Canceled), "CreateCategory: %v", err)
+}
+
+func testUnitOfWorkCommit(t *testing.T, r Repositories) {
+	err := r.UnitOfWork.Do(context.Background(), func(ctx context.Context) error {
+		if err := r.Users.WithContext(ctx).Create(&models.User{Username: "alice", Email: "alice@email.io", Password: "hash"}); err != nil {
+			return err
+		}
+		return r.Products.WithContext(ctx).CreateCategory(&models.Category{Category: "books"})
+	})
+	require.NoError(t, err)
+	u, err := r.Users.GetByUsername("alice")
+	assert.NoError(t, err)
+	assert.NotNil(t, u)
+	_, count, err := r.Products.ListCategories(0, 20)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+}
+
+func testUnitOfWorkRollback(t *testing.T, r Repositories) {
+	owner := createUser(t, r, "alice")
+	createProduct(t, r, owner, "p1")
+	err := r.UnitOfWork.Do(context.Background(), func(ctx context.Context) error {
+		if err := r.Products.WithContext(ctx).CreateCategory(&models.Category{Category: "books"}); err != nil {
+			return err
+		}
+		if err := r.Users.WithContext(ctx).Create(&models.User{Username: "bob", Email: "bob@email.io", Password: "hash"}); err != nil {
+			return err
+		}
+		return r.Products.WithContext(ctx).CreateProduct(&models.Product{Slug: "p1", Title: "again", OwnerID: owner.ID})
+	})
+	assert.True(t, errors.Is(err, utils.ErrDuplicate), "rollback cause: %v", err)
+	u, err := r.Users.GetByUsername("bob")
+	assert.NoError(t, err)
+	assert.Nil(t, u)
+	_, count, err := r.Products.ListCategories(0, 20)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+	assert.Panics(t, func() {
+		r.UnitOfWork.Do(context.Background(), func(ctx context.Context) error {
+			if err := r.Products.WithContext(ctx).CreateCategory(&models.Category{Category: "music"}); err != nil {
+				return err
+			}
+			panic("boom")
+		})
+	})
+	_, count, err = r.Products.ListCategories(0, 20)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+}
+
+func testUnitOfWorkAfterCommit(t *testing.T, r Repositories) {
+	var ran []string
+	err := r.UnitOfWork.Do(context.Background(), func(ctx context.Context) error {
+		uow.AfterCommit(ctx, func() {
+			// The changes of the unit of work are visible to hooks.
+			_, count, err := r.Products.ListCategories(0, 20)
+			assert.NoError(t, err)
+			assert.Equal(t, 1, count)
+			ran = append(ran, "outer")
+		})
+		return r.UnitOfWork.Do(ctx, func(ctx context.Context) error {
+			uow.AfterCommit(ctx, func() { ran = append(ran, "nested") })
+			assert.Empty(t, ran)
+			return r.Products.WithContext(ctx).CreateCategory(&models.Category{Category: "books"})
+		})
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"outer", "nested"}, ran)
+
+	ran = nil
+	err = r.UnitOfWork.Do(context.Background(), func(ctx context.Context) error {
+		uow.AfterCommit(ctx, func() { ran = append(ran, "rolled back") })
+		return utils.ErrNotFound
+	})
+	assert.True(t, errors.Is(err, utils.ErrNotFound))
+	assert.Empty(t, ran)
+
+	uow.AfterCommit(context.Background(), func() { ran = append(ran, "no unit of work") })
+	assert.Equal(t, []string{"no unit of work"}, ran)
+}
@@ -0,0 +1,58 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/jinzhu/gorm"
+	"github.com/sumitalp/productcatalog/db"
+	"github.com/sumitalp/productcatalog/uow"
+)
+
+type UnitOfWork struct {
+	db *gorm.DB
+}
+
+func NewUnitOfWork(db *gorm.DB) *UnitOfWork {
+	return &UnitOfWork{
+		db: db,
+	}
+}
+
+func (u *UnitOfWork) Do(ctx context.Context, fn func(ctx context.Context) error) error {
+	if _, ok := db.TxFromContext(ctx); ok {
+		return fn(ctx)
+	}
+	ctx, runHooks := uow.WithCommitHooks(ctx)
+	err := transaction(db.WithContext(ctx, u.db), func(tx *gorm.DB) error {
+		return fn(db.ContextWithTx(ctx, tx))
+	})
+	if err == nil {
+		runHooks()
+	}
+	return err
+}
+
+// transaction runs fn in a transaction on d, or directly on d when d is
+// already part of one. The transaction is rolled back if fn returns an
+// error or panics, and committed otherwise.
+func transaction(d *gorm.DB, fn func(tx *gorm.DB) error) (err error) {
+	if db.InTransaction(d) {
+		return fn(d)
+	}
+	tx := db.Begin(d)
+	if tx.Error != nil {
+		return tx.Error
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+		err = tx.Commit().Error
+	}()
+	return fn(tx)
+}
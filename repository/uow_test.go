@@ -0,0 +1,138 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	"github.com/stretchr/testify/assert"
+	"github.com/sumitalp/productcatalog/db"
+	"github.com/sumitalp/productcatalog/models"
+	"github.com/sumitalp/productcatalog/utils"
+)
+
+func newTestDB(t *testing.T) (*gorm.DB, func()) {
+	dir, err := ioutil.TempDir("", "productcatalog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := db.Open(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.AutoMigrate(d)
+	return d, func() {
+		d.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func countCategories(t *testing.T, d *gorm.DB) int {
+	var n int
+	assert.NoError(t, d.Model(&models.Category{}).Count(&n).Error)
+	return n
+}
+
+func TestUnitOfWorkCommit(t *testing.T) {
+	d, teardown := newTestDB(t)
+	defer teardown()
+	ps := NewProductRepository(d)
+	us := NewUserRepository(d)
+
+	err := NewUnitOfWork(d).Do(context.Background(), func(ctx context.Context) error {
+		if err := us.WithContext(ctx).Create(&models.User{Username: "alice", Email: "alice@email.io", Password: "x"}); err != nil {
+			return err
+		}
+		return ps.WithContext(ctx).CreateCategory(&models.Category{Category: "books"})
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, countCategories(t, d))
+	u, err := us.GetByUsername("alice")
+	assert.NoError(t, err)
+	assert.NotNil(t, u)
+}
+
+func TestUnitOfWorkRollbackOnError(t *testing.T) {
+	d, teardown := newTestDB(t)
+	defer teardown()
+	ps := NewProductRepository(d)
+	assert.NoError(t, ps.CreateProduct(&models.Product{Slug: "p1", Title: "p1"}))
+
+	err := NewUnitOfWork(d).Do(context.Background(), func(ctx context.Context) error {
+		repo := ps.WithContext(ctx)
+		if err := repo.CreateCategory(&models.Category{Category: "books"}); err != nil {
+			return err
+		}
+		return repo.CreateProduct(&models.Product{Slug: "p1", Title: "again"})
+	})
+	assert.True(t, errors.Is(err, utils.ErrDuplicate))
+	assert.Equal(t, 0, countCategories(t, d))
+}
+
+func TestUnitOfWorkRollbackOnPanic(t *testing.T) {
+	d, teardown := newTestDB(t)
+	defer teardown()
+	ps := NewProductRepository(d)
+
+	assert.Panics(t, func() {
+		NewUnitOfWork(d).Do(context.Background(), func(ctx context.Context) error {
+			if err := ps.WithContext(ctx).CreateCategory(&models.Category{Category: "books"}); err != nil {
+				return err
+			}
+			panic("boom")
+		})
+	})
+	assert.Equal(t, 0, countCategories(t, d))
+	assert.NoError(t, ps.CreateCategory(&models.Category{Category: "music"}))
+}
+
+func TestUnitOfWorkNested(t *testing.T) {
+	d, teardown := newTestDB(t)
+	defer teardown()
+	ps := NewProductRepository(d)
+	u := NewUnitOfWork(d)
+	injected := errors.New("injected")
+
+	err := u.Do(context.Background(), func(ctx context.Context) error {
+		if err := ps.WithContext(ctx).CreateCategory(&models.Category{Category: "books"}); err != nil {
+			return err
+		}
+		return u.Do(ctx, func(ctx context.Context) error {
+			if err := ps.WithContext(ctx).CreateCategory(&models.Category{Category: "music"}); err != nil {
+				return err
+			}
+			return injected
+		})
+	})
+	assert.Equal(t, injected, err)
+	assert.Equal(t, 0, countCategories(t, d))
+}
+
+func TestCreateProductRollsBackInjectedFailure(t *testing.T) {
+	d, teardown := newTestDB(t)
+	defer teardown()
+	d.Callback().Create().After("gorm:create").Register("test:inject_failure", func(scope *gorm.Scope) {
+		if scope.TableName() == "products" {
+			scope.Err(errors.New("injected"))
+		}
+	})
+	ps := NewProductRepository(d)
+	assert.NoError(t, ps.CreateCategory(&models.Category{Category: "books"}))
+
+	err := ps.CreateProduct(&models.Product{
+		Slug:       "p1",
+		Title:      "p1",
+		Categories: []models.Category{{Category: "books"}},
+	})
+	assert.EqualError(t, err, "injected")
+
+	var n int
+	assert.NoError(t, d.Model(&models.Product{}).Count(&n).Error)
+	assert.Equal(t, 0, n)
+	// A leaked transaction would keep SQLite locked for this write.
+	assert.NoError(t, ps.CreateCategory(&models.Category{Category: "music"}))
+}
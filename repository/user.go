@@ -1,8 +1,13 @@
 package repository
 
 import (
+	"context"
+	"time"
+
 	"github.com/jinzhu/gorm"
+	"github.com/sumitalp/productcatalog/db"
 	"github.com/sumitalp/productcatalog/models"
+	"github.com/sumitalp/productcatalog/user"
 )
 
 type UserRepository struct {
@@ -15,6 +20,12 @@ func NewUserRepository(db *gorm.DB) *UserRepository {
 	}
 }
 
+func (us *UserRepository) WithContext(ctx context.Context) user.RepositoryInterface {
+	return &UserRepository{
+		db: db.WithContext(ctx, us.db),
+	}
+}
+
 func (us *UserRepository) GetByID(id uint) (*models.User, error) {
 	var m models.User
 	if err := us.db.First(&m, id).Error; err != nil {
@@ -49,9 +60,81 @@ func (us *UserRepository) GetByUsername(username string) (*models.User, error) {
 }
 
 func (us *UserRepository) Create(u *models.User) (err error) {
-	return us.db.Create(u).Error
+	return translateError(us.db.Create(u).Error)
 }
 
 func (us *UserRepository) Update(u *models.User) error {
-	return us.db.Model(u).Update(u).Error
+	return translateError(us.db.Model(u).Omit(twoFactorColumns...).Update(u).Error)
+}
+
+// twoFactorColumns are only written by SetTwoFactor and UseTOTPStep.
+var twoFactorColumns = []string{"totp_secret", "two_factor_enabled_at", "totp_last_step"}
+
+func (us *UserRepository) SetEmailVerified(id uint, at *time.Time) error {
+	return us.db.Model(&models.User{}).Where("id = ?", id).Update("email_verified_at", at).Error
+}
+
+func (us *UserRepository) CreateToken(t *models.UserToken) error {
+	return translateError(us.db.Create(t).Error)
+}
+
+func (us *UserRepository) ConsumeToken(purpose, hash string, now time.Time) (*models.UserToken, error) {
+	res := us.db.Model(&models.UserToken{}).
+		Where("purpose = ? AND hash = ? AND used_at IS NULL AND expires_at > ?", purpose, hash, now).
+		Update("used_at", now)
+	if res.Error != nil || res.RowsAffected == 0 {
+		return nil, res.Error
+	}
+	var m models.UserToken
+	if err := us.db.Where("hash = ?", hash).First(&m).Error; err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+func (us *UserRepository) RevokeTokens(userID uint, purpose string) error {
+	return us.db.Where("user_id = ? AND purpose = ?", userID, purpose).Delete(&models.UserToken{}).Error
+}
+
+func (us *UserRepository) DeleteExpiredTokens(t time.Time) (int, error) {
+	res := us.db.Where("expires_at < ?", t).Delete(&models.UserToken{})
+	return int(res.RowsAffected), res.Error
+}
+
+func (us *UserRepository) SetTwoFactor(id uint, secret string, enabledAt *time.Time) error {
+	return us.db.Model(&models.User{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"totp_secret":           secret,
+		"two_factor_enabled_at": enabledAt,
+		"totp_last_step":        0,
+	}).Error
+}
+
+func (us *UserRepository) UseTOTPStep(id uint, step int64) (bool, error) {
+	res := us.db.Model(&models.User{}).Where("id = ? AND totp_last_step < ?", id, step).UpdateColumn("totp_last_step", step)
+	return res.RowsAffected == 1, res.Error
+}
+
+func (us *UserRepository) ReplaceRecoveryCodes(userID uint, hashes []string) error {
+	if err := us.db.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return err
+	}
+	for _, h := range hashes {
+		if err := us.db.Create(&models.RecoveryCode{UserID: userID, Hash: h}).Error; err != nil {
+			return translateError(err)
+		}
+	}
+	return nil
+}
+
+func (us *UserRepository) UseRecoveryCode(userID uint, hash string, now time.Time) (bool, error) {
+	res := us.db.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND hash = ? AND used_at IS NULL", userID, hash).
+		Update("used_at", now)
+	return res.RowsAffected == 1, res.Error
+}
+
+func (us *UserRepository) CountRecoveryCodes(userID uint) (int, error) {
+	var n int
+	err := us.db.Model(&models.RecoveryCode{}).Where("user_id = ? AND used_at IS NULL", userID).Count(&n).Error
+	return n, err
 }
@@ -0,0 +1,40 @@
+package repository
+
+import (
+	"github.com/jinzhu/gorm"
+	"github.com/sumitalp/productcatalog/models"
+	"github.com/sumitalp/productcatalog/utils"
+)
+
+// updateVersioned saves columns of value, whose embedded ModelBase is base,
+// provided the stored row still has base.Version. The columns are written
+// even when empty, so an update can clear them. The version is incremented
+// on success; utils.ErrStale is returned when another write got there first.
+func updateVersioned(tx *gorm.DB, value interface{}, base *models.ModelBase, columns map[string]interface{}) error {
+	version := base.Version
+	base.Version = version + 1
+	columns["version"] = base.Version
+	res := tx.Model(value).Where("version = ?", version).Updates(columns)
+	if res.Error != nil {
+		base.Version = version
+		return translateError(res.Error)
+	}
+	if res.RowsAffected == 0 {
+		base.Version = version
+		return utils.ErrStale
+	}
+	return nil
+}
+
+// deleteVersioned deletes value provided the stored row still has
+// base.Version.
+func deleteVersioned(d *gorm.DB, value interface{}, base *models.ModelBase) error {
+	res := d.Where("version = ?", base.Version).Delete(value)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return utils.ErrStale
+	}
+	return nil
+}
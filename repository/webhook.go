@@ -0,0 +1,117 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/sumitalp/productcatalog/db"
+	"github.com/sumitalp/productcatalog/models"
+	"github.com/sumitalp/productcatalog/webhook"
+)
+
+type WebhookRepository struct {
+	db *gorm.DB
+}
+
+func NewWebhookRepository(db *gorm.DB) *WebhookRepository {
+	return &WebhookRepository{
+		db: db,
+	}
+}
+
+func (ws *WebhookRepository) WithContext(ctx context.Context) webhook.RepositoryInterface {
+	return &WebhookRepository{
+		db: db.WithContext(ctx, ws.db),
+	}
+}
+
+func (ws *WebhookRepository) CreateSubscription(s *models.WebhookSubscription) error {
+	return translateError(ws.db.Create(s).Error)
+}
+
+func (ws *WebhookRepository) UpdateSubscription(s *models.WebhookSubscription) error {
+	return translateError(ws.db.Save(s).Error)
+}
+
+func (ws *WebhookRepository) DeleteSubscription(s *models.WebhookSubscription) error {
+	return transaction(ws.db, func(tx *gorm.DB) error {
+		if err := tx.Where("subscription_id = ?", s.ID).Delete(&models.WebhookDelivery{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(s).Error
+	})
+}
+
+func (ws *WebhookRepository) GetSubscription(id uint) (*models.WebhookSubscription, error) {
+	var m models.WebhookSubscription
+	if err := ws.db.First(&m, id).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &m, nil
+}
+
+func (ws *WebhookRepository) ListSubscriptions(userID uint, offset, limit int) ([]models.WebhookSubscription, int, error) {
+	var (
+		subscriptions []models.WebhookSubscription
+		count         int
+	)
+	q := ws.db.Model(&models.WebhookSubscription{}).Where("user_id = ?", userID)
+	if err := q.Count(&count).Error; err != nil {
+		return nil, 0, err
+	}
+	if err := q.Offset(offset).Limit(limit).Order("id").Find(&subscriptions).Error; err != nil {
+		return nil, 0, err
+	}
+	return subscriptions, count, nil
+}
+
+func (ws *WebhookRepository) ListActiveSubscriptions() ([]models.WebhookSubscription, error) {
+	var subscriptions []models.WebhookSubscription
+	err := ws.db.Where("active = ?", true).Order("id").Find(&subscriptions).Error
+	return subscriptions, err
+}
+
+func (ws *WebhookRepository) CreateDelivery(d *models.WebhookDelivery) error {
+	return translateError(ws.db.Create(d).Error)
+}
+
+func (ws *WebhookRepository) UpdateDelivery(d *models.WebhookDelivery) error {
+	return ws.db.Save(d).Error
+}
+
+func (ws *WebhookRepository) GetDelivery(id uint) (*models.WebhookDelivery, error) {
+	var m models.WebhookDelivery
+	if err := ws.db.First(&m, id).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &m, nil
+}
+
+func (ws *WebhookRepository) ListDeliveries(subscriptionID uint, offset, limit int) ([]models.WebhookDelivery, int, error) {
+	var (
+		deliveries []models.WebhookDelivery
+		count      int
+	)
+	q := ws.db.Model(&models.WebhookDelivery{}).Where("subscription_id = ?", subscriptionID)
+	if err := q.Count(&count).Error; err != nil {
+		return nil, 0, err
+	}
+	if err := q.Offset(offset).Limit(limit).Order("id desc").Find(&deliveries).Error; err != nil {
+		return nil, 0, err
+	}
+	return deliveries, count, nil
+}
+
+func (ws *WebhookRepository) ListDueDeliveries(t time.Time, limit int) ([]models.WebhookDelivery, error) {
+	var deliveries []models.WebhookDelivery
+	err := ws.db.Where("status = ? AND next_attempt_at <= ?", models.DeliveryPending, t).
+		Order("next_attempt_at, id").Limit(limit).Find(&deliveries).Error
+	return deliveries, err
+}
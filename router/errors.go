@@ -0,0 +1,63 @@
+package router
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sumitalp/productcatalog/logging"
+	mw "github.com/sumitalp/productcatalog/router/middleware"
+	"github.com/sumitalp/productcatalog/utils"
+	"gopkg.in/go-playground/validator.v9"
+)
+
+const (
+	HeaderAcceptLanguage  = "Accept-Language"
+	HeaderContentLanguage = "Content-Language"
+)
+
+// HTTPErrorHandler renders every error returned by handlers and middleware
+// as an application/problem+json document.
+func HTTPErrorHandler(err error, c echo.Context) {
+	if c.Response().Committed {
+		return
+	}
+	var p utils.Error
+	var ve validator.ValidationErrors
+	if v, ok := c.Echo().Validator.(*Validator); ok && errors.As(err, &ve) {
+		trans := v.Translator(c.Request().Header.Get(HeaderAcceptLanguage))
+		c.Response().Header().Set(HeaderContentLanguage, strings.Replace(trans.Locale(), "_", "-", -1))
+		p = *utils.NewValidatorError(ve, trans)
+	} else {
+		p = *utils.NewError(err)
+	}
+	if ctxErr := c.Request().Context().Err(); ctxErr != nil && p.Status == http.StatusInternalServerError {
+		// Drivers report interrupted statements in their own words; surface
+		// the expired deadline or the disconnect instead.
+		p = *utils.NewError(ctxErr)
+	}
+	if p.Instance == "" {
+		p.Instance = c.Request().URL.Path
+	}
+	if d := p.RetryAfter(); d > 0 {
+		// Whole seconds, rounded up so that the retry is not early.
+		c.Response().Header().Set(mw.HeaderRetryAfter, strconv.Itoa(int((d+time.Second-1)/time.Second)))
+	}
+	if p.Status >= http.StatusInternalServerError {
+		c.Logger().Errorj(logging.ErrorFields(c.Request().Context(), "request failed", err))
+	} else if errors.Is(err, utils.ErrDuplicate) {
+		c.Logger().Infoj(logging.ErrorFields(c.Request().Context(), "write conflict", err))
+	}
+	if c.Request().Method == http.MethodHead {
+		err = c.NoContent(p.Status)
+	} else {
+		c.Response().Header().Set(echo.HeaderContentType, utils.MIMEApplicationProblemJSON)
+		err = c.JSON(p.Status, p)
+	}
+	if err != nil {
+		c.Logger().Errorj(logging.ErrorFields(c.Request().Context(), "writing error response", err))
+	}
+}
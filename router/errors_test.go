@@ -0,0 +1,23 @@
+package router
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/sumitalp/productcatalog/utils"
+)
+
+func TestHTTPErrorHandlerHidesDuplicateCause(t *testing.T) {
+	e := New()
+	rec := httptest.NewRecorder()
+	c := e.NewContext(httptest.NewRequest(echo.POST, "/api/categories", nil), rec)
+	HTTPErrorHandler(fmt.Errorf("%w: UNIQUE constraint failed: categories.category", utils.ErrDuplicate), c)
+
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"detail":"resource already exists"`)
+	assert.NotContains(t, rec.Body.String(), "categories.category")
+}
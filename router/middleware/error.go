@@ -0,0 +1,22 @@
+package middleware
+
+import "github.com/labstack/echo/v4"
+
+// handlerErrorKey stores the error the handler of the request failed with.
+const handlerErrorKey = "handlerError"
+
+// RenderError is for the middleware observing the outcome of requests,
+// given the error returned by the next handler. It lets the error handler
+// write the response to the error, so that the status of the response is
+// the final one, and returns the error. The first middleware to do so
+// renders it and returns nil up the chain; the ones around it get the
+// error back from RenderError all the same.
+func RenderError(c echo.Context, err error) error {
+	if err == nil {
+		err, _ = c.Get(handlerErrorKey).(error)
+		return err
+	}
+	c.Error(err)
+	c.Set(handlerErrorKey, err)
+	return err
+}
@@ -0,0 +1,39 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderError(t *testing.T) {
+	e := echo.New()
+	rendered := 0
+	e.HTTPErrorHandler = func(err error, c echo.Context) {
+		rendered++
+		e.DefaultHTTPErrorHandler(err, c)
+	}
+	var seen []error
+	observe := func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			err := RenderError(c, next(c))
+			seen = append(seen, err)
+			assert.Equal(t, http.StatusTeapot, c.Response().Status)
+			return nil
+		}
+	}
+	e.Use(observe, observe)
+	boom := echo.NewHTTPError(http.StatusTeapot, "boom")
+	e.GET("/", func(echo.Context) error { return boom })
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(echo.GET, "/", nil))
+	assert.Equal(t, http.StatusTeapot, rec.Code)
+	assert.Equal(t, 1, rendered)
+	assert.Equal(t, []error{boom, boom}, seen, "the outer middleware sees the error too")
+	assert.True(t, errors.Is(seen[1], boom))
+}
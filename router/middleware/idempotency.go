@@ -0,0 +1,194 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sumitalp/productcatalog/idempotency"
+	"github.com/sumitalp/productcatalog/logging"
+	"github.com/sumitalp/productcatalog/models"
+	"github.com/sumitalp/productcatalog/uow"
+	"github.com/sumitalp/productcatalog/utils"
+)
+
+const (
+	HeaderIdempotencyKey     = "Idempotency-Key"
+	HeaderIdempotentReplayed = "Idempotent-Replayed"
+
+	// DefaultIdempotencyTTL is how long the response to a request is
+	// replayed after it completed.
+	DefaultIdempotencyTTL = 24 * time.Hour
+	// DefaultIdempotencyLease is how long a key stays claimed by a request
+	// still being processed. It must outlast the slowest route, including
+	// its timeout, or a retry may run the request a second time.
+	DefaultIdempotencyLease = 2 * time.Minute
+
+	maxIdempotencyKeyLength = 255
+)
+
+type IdempotencyConfig struct {
+	Skipper Skipper
+	Keys    idempotency.RepositoryInterface
+	TTL     time.Duration
+	Lease   time.Duration
+}
+
+func Idempotency(keys idempotency.RepositoryInterface) echo.MiddlewareFunc {
+	return IdempotencyWithConfig(IdempotencyConfig{Keys: keys})
+}
+
+// IdempotencyWithConfig makes POST requests carrying an Idempotency-Key
+// header safe to retry. The first request with a key runs normally and its
+// successful response is stored; repeats of it within the TTL get that
+// response back without running the handler again. A key reused with a
+// different request is rejected with 422, and a repeat arriving while the
+// first request is still running with 409. Keys are scoped to the
+// authenticated user, so JWT must run first on authenticated routes.
+//
+// Failed requests release their key, so the client can retry them. A key
+// whose request never finished, because the process stopped, is freed
+// once its lease ends.
+//
+// Inside a unit of work, such as a transactional batch, the key is
+// claimed, completed and released in its transaction, so it is only kept
+// if the transaction commits.
+func IdempotencyWithConfig(config IdempotencyConfig) echo.MiddlewareFunc {
+	if config.TTL == 0 {
+		config.TTL = DefaultIdempotencyTTL
+	}
+	if config.Lease == 0 {
+		config.Lease = DefaultIdempotencyLease
+	}
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			key := req.Header.Get(HeaderIdempotencyKey)
+			if key == "" || req.Method != http.MethodPost {
+				return next(c)
+			}
+			if config.Skipper != nil && config.Skipper(c) {
+				return next(c)
+			}
+			if len(key) > maxIdempotencyKeyLength {
+				return utils.BadRequest("the Idempotency-Key header is too long")
+			}
+			body, err := ioutil.ReadAll(req.Body)
+			if err != nil {
+				return err
+			}
+			req.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+			userID, _ := c.Get("user").(uint)
+			k := &models.IdempotencyKey{
+				UserID:      userID,
+				Key:         key,
+				Fingerprint: fingerprint(req, body),
+				ExpiresAt:   time.Now().Add(config.Lease),
+			}
+			existing, err := claimKey(config.Keys.WithContext(req.Context()), k)
+			if err != nil {
+				return err
+			}
+			if existing != nil {
+				return replay(c, existing, k.Fingerprint)
+			}
+
+			rec := &bodyRecorder{ResponseWriter: c.Response().Writer}
+			c.Response().Writer = rec
+			err = next(c)
+
+			// The request context may be done by now, but the key must not
+			// stay claimed. Within a unit of work it has to be, as another
+			// connection would wait for the transaction holding the claim.
+			ctx := context.Background()
+			if uow.Active(req.Context()) {
+				ctx = req.Context()
+			}
+			keys := config.Keys.WithContext(ctx)
+			res := c.Response()
+			if err != nil || !res.Committed || res.Status >= http.StatusInternalServerError {
+				if derr := keys.Delete(k); derr != nil {
+					c.Logger().Errorj(logging.ErrorFields(c.Request().Context(), "releasing idempotency key", derr))
+				}
+				return err
+			}
+			k.StatusCode = res.Status
+			k.ContentType = res.Header().Get(echo.HeaderContentType)
+			k.Body = rec.body.Bytes()
+			k.ExpiresAt = time.Now().Add(config.TTL)
+			if err := keys.Complete(k); err != nil {
+				c.Logger().Errorj(logging.ErrorFields(c.Request().Context(), "storing idempotent response", err))
+			}
+			return nil
+		}
+	}
+}
+
+// claimKey stores k unless a live key with the same name exists, which it
+// then returns instead. Expired keys are replaced.
+func claimKey(keys idempotency.RepositoryInterface, k *models.IdempotencyKey) (*models.IdempotencyKey, error) {
+	for attempt := 0; attempt < 3; attempt++ {
+		err := keys.Create(k)
+		if !errors.Is(err, utils.ErrDuplicate) {
+			return nil, err
+		}
+		existing, err := keys.Get(k.UserID, k.Key)
+		if err != nil {
+			return nil, err
+		}
+		if existing == nil {
+			// Released in the meantime.
+			continue
+		}
+		if time.Now().Before(existing.ExpiresAt) {
+			return existing, nil
+		}
+		if err := keys.Delete(existing); err != nil {
+			return nil, err
+		}
+	}
+	return nil, keyInUse()
+}
+
+func replay(c echo.Context, k *models.IdempotencyKey, fingerprint string) error {
+	if k.Fingerprint != fingerprint {
+		return utils.NewProblem(http.StatusUnprocessableEntity, utils.CodeIdempotencyReuse,
+			"the Idempotency-Key was already used with a different request")
+	}
+	if !k.Completed() {
+		return keyInUse()
+	}
+	c.Response().Header().Set(HeaderIdempotentReplayed, "true")
+	return c.Blob(k.StatusCode, k.ContentType, k.Body)
+}
+
+func keyInUse() error {
+	return utils.NewProblem(http.StatusConflict, utils.CodeIdempotencyBusy,
+		"a request with the same Idempotency-Key is still being processed")
+}
+
+// fingerprint identifies a request by its method, target and body.
+func fingerprint(req *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(req.Method + " " + req.URL.RequestURI() + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// bodyRecorder keeps a copy of the response body written through it.
+type bodyRecorder struct {
+	http.ResponseWriter
+	body bytes.Buffer
+}
+
+func (r *bodyRecorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
@@ -0,0 +1,177 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/sumitalp/productcatalog/models"
+	"github.com/sumitalp/productcatalog/repository/memory"
+	"github.com/sumitalp/productcatalog/utils"
+)
+
+// post runs h behind mw for a POST to /items made by userID.
+func post(e *echo.Echo, mw echo.MiddlewareFunc, h echo.HandlerFunc, userID uint, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(echo.POST, "/items", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	if key != "" {
+		req.Header.Set(HeaderIdempotencyKey, key)
+	}
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	if userID != 0 {
+		c.Set("user", userID)
+	}
+	if err := mw(h)(c); err != nil {
+		e.HTTPErrorHandler(err, c)
+	}
+	return rec
+}
+
+func newEcho() *echo.Echo {
+	e := echo.New()
+	e.HTTPErrorHandler = func(err error, c echo.Context) {
+		p := utils.NewError(err)
+		c.JSON(p.Status, p)
+	}
+	return e
+}
+
+func counting(calls *int32) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		n := atomic.AddInt32(calls, 1)
+		return c.JSON(http.StatusCreated, map[string]int32{"n": n})
+	}
+}
+
+func TestIdempotencyReplay(t *testing.T) {
+	e := newEcho()
+	mw := Idempotency(memory.NewIdempotencyRepository(memory.NewStore()))
+	var calls int32
+	h := counting(&calls)
+
+	first := post(e, mw, h, 1, "k1", `{"a":1}`)
+	assert.Equal(t, http.StatusCreated, first.Code)
+	assert.Empty(t, first.Header().Get(HeaderIdempotentReplayed))
+
+	second := post(e, mw, h, 1, "k1", `{"a":1}`)
+	assert.Equal(t, http.StatusCreated, second.Code)
+	assert.Equal(t, "true", second.Header().Get(HeaderIdempotentReplayed))
+	assert.Equal(t, first.Body.String(), second.Body.String())
+	assert.Equal(t, echo.MIMEApplicationJSONCharsetUTF8, second.Header().Get(echo.HeaderContentType))
+	assert.EqualValues(t, 1, atomic.LoadInt32(&calls))
+
+	// Keys are scoped to the user, and requests without one always run.
+	assert.Equal(t, http.StatusCreated, post(e, mw, h, 2, "k1", `{"a":1}`).Code)
+	assert.Equal(t, http.StatusCreated, post(e, mw, h, 1, "", `{"a":1}`).Code)
+	assert.EqualValues(t, 3, atomic.LoadInt32(&calls))
+}
+
+func TestIdempotencyMismatch(t *testing.T) {
+	e := newEcho()
+	mw := Idempotency(memory.NewIdempotencyRepository(memory.NewStore()))
+	var calls int32
+	h := counting(&calls)
+
+	assert.Equal(t, http.StatusCreated, post(e, mw, h, 1, "k1", `{"a":1}`).Code)
+	rec := post(e, mw, h, 1, "k1", `{"a":2}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	assert.Contains(t, rec.Body.String(), utils.CodeIdempotencyReuse)
+	assert.EqualValues(t, 1, atomic.LoadInt32(&calls))
+}
+
+func TestIdempotencyFailureReleasesKey(t *testing.T) {
+	e := newEcho()
+	mw := Idempotency(memory.NewIdempotencyRepository(memory.NewStore()))
+	fail := true
+	h := func(c echo.Context) error {
+		if fail {
+			return errors.New("boom")
+		}
+		return c.JSON(http.StatusCreated, map[string]string{"ok": "yes"})
+	}
+
+	assert.Equal(t, http.StatusInternalServerError, post(e, mw, h, 1, "k1", `{}`).Code)
+	fail = false
+	rec := post(e, mw, h, 1, "k1", `{}`)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	assert.Empty(t, rec.Header().Get(HeaderIdempotentReplayed))
+}
+
+func TestIdempotencyInFlight(t *testing.T) {
+	e := newEcho()
+	mw := Idempotency(memory.NewIdempotencyRepository(memory.NewStore()))
+	started := make(chan struct{})
+	release := make(chan struct{})
+	h := func(c echo.Context) error {
+		close(started)
+		<-release
+		return c.JSON(http.StatusCreated, map[string]string{"ok": "yes"})
+	}
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		done <- post(e, mw, h, 1, "k1", `{}`)
+	}()
+	<-started
+	rec := post(e, mw, h, 1, "k1", `{}`)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	assert.Contains(t, rec.Body.String(), utils.CodeIdempotencyBusy)
+
+	close(release)
+	assert.Equal(t, http.StatusCreated, (<-done).Code)
+	rec = post(e, mw, h, 1, "k1", `{}`)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	assert.Equal(t, "true", rec.Header().Get(HeaderIdempotentReplayed))
+}
+
+func TestIdempotencyExpired(t *testing.T) {
+	e := newEcho()
+	mw := IdempotencyWithConfig(IdempotencyConfig{
+		Keys: memory.NewIdempotencyRepository(memory.NewStore()),
+		TTL:  -time.Second,
+	})
+	var calls int32
+	h := counting(&calls)
+
+	assert.Equal(t, http.StatusCreated, post(e, mw, h, 1, "k1", `{"a":1}`).Code)
+	rec := post(e, mw, h, 1, "k1", `{"a":2}`)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	assert.Empty(t, rec.Header().Get(HeaderIdempotentReplayed))
+	assert.EqualValues(t, 2, atomic.LoadInt32(&calls))
+}
+
+func TestIdempotencyLease(t *testing.T) {
+	e := newEcho()
+	keys := memory.NewIdempotencyRepository(memory.NewStore())
+	mw := IdempotencyWithConfig(IdempotencyConfig{Keys: keys, Lease: time.Minute})
+	var claim *models.IdempotencyKey
+	h := func(c echo.Context) error {
+		claim, _ = keys.Get(1, "k1")
+		return c.JSON(http.StatusCreated, map[string]string{"ok": "yes"})
+	}
+
+	// A claim left behind by a request that never finished is freed once
+	// its lease ends, long before responses expire.
+	assert.NoError(t, keys.Create(&models.IdempotencyKey{
+		UserID: 1, Key: "k1", Fingerprint: "f", ExpiresAt: time.Now().Add(-time.Second),
+	}))
+	rec := post(e, mw, h, 1, "k1", `{}`)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	assert.Empty(t, rec.Header().Get(HeaderIdempotentReplayed))
+
+	if assert.NotNil(t, claim) {
+		assert.WithinDuration(t, time.Now().Add(time.Minute), claim.ExpiresAt, 5*time.Second)
+	}
+	k, err := keys.Get(1, "k1")
+	if assert.NoError(t, err) && assert.NotNil(t, k) {
+		assert.True(t, k.Completed())
+		assert.WithinDuration(t, time.Now().Add(DefaultIdempotencyTTL), k.ExpiresAt, 5*time.Second)
+	}
+}
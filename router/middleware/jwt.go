@@ -1,23 +1,47 @@
 package middleware
 
 import (
+	"context"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/labstack/echo/v4"
-	"github.com/sumitalp/productcatalog/utils"
 )
 
 type (
 	JWTConfig struct {
 		Skipper    Skipper
 		SigningKey interface{}
+		// QueryParam, when set, names a query parameter the token may be
+		// passed in instead of the Authorization header, for clients such
+		// as EventSource which cannot set headers.
+		QueryParam string
+		// Validator, when set, is asked whether the tokens of a user are
+		// still valid, so that they can be revoked before they expire.
+		Validator TokenValidator
+	}
+	Skipper func(c echo.Context) bool
+	// TokenValidator reports whether the tokens issued to the user with
+	// the given id at the given token generation are still valid.
+	TokenValidator func(ctx context.Context, userID, generation uint) (bool, error)
+	// Token holds the claims of a token.
+	Token struct {
+		UserID     uint
+		Generation uint
+		// Expiry is the zero time for tokens which do not expire.
+		Expiry time.Time
 	}
-	Skipper      func(c echo.Context) bool
 	jwtExtractor func(echo.Context) (string, error)
 )
 
+// authScheme prefixes the token in the Authorization header.
+const authScheme = "Token"
+
+// tokenKey stores the token of the request.
+const tokenKey = "token"
+
 var (
 	ErrJWTMissing = echo.NewHTTPError(http.StatusUnauthorized, "missing or malformed jwt")
 	ErrJWTInvalid = echo.NewHTTPError(http.StatusForbidden, "invalid or expired jwt")
@@ -30,7 +54,10 @@ func JWT(key interface{}) echo.MiddlewareFunc {
 }
 
 func JWTWithConfig(config JWTConfig) echo.MiddlewareFunc {
-	extractor := jwtFromHeader("Authorization", "Token")
+	extractor := jwtFromHeader("Authorization")
+	if config.QueryParam != "" {
+		extractor = jwtFromHeaderOrQuery("Authorization", config.QueryParam)
+	}
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
 			auth, err := extractor(c)
@@ -40,34 +67,107 @@ func JWTWithConfig(config JWTConfig) echo.MiddlewareFunc {
 						return next(c)
 					}
 				}
-				return c.JSON(http.StatusUnauthorized, utils.NewError(err))
+				return err
 			}
-			token, err := jwt.Parse(auth, func(token *jwt.Token) (interface{}, error) {
-				if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-					return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
-				}
-				return config.SigningKey, nil
-			})
+			t, err := ParseToken(auth, config.SigningKey)
 			if err != nil {
-				return c.JSON(http.StatusForbidden, utils.NewError(ErrJWTInvalid))
+				return err
 			}
-			if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-				userID := uint(claims["id"].(float64))
-				c.Set("user", userID)
-				return next(c)
+			if err := t.Validate(c.Request().Context(), config.Validator); err != nil {
+				return err
 			}
-			return c.JSON(http.StatusForbidden, utils.NewError(ErrJWTInvalid))
+			c.Set("user", t.UserID)
+			c.Set(tokenKey, t)
+			return next(c)
 		}
 	}
 }
 
+// ParseToken checks the signature and expiry of a token and returns its
+// claims. It fails with ErrJWTInvalid.
+func ParseToken(auth string, key interface{}) (*Token, error) {
+	token, err := jwt.Parse(auth, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
+		}
+		return key, nil
+	})
+	if err != nil {
+		return nil, ErrJWTInvalid
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, ErrJWTInvalid
+	}
+	id, ok := claims["id"].(float64)
+	if !ok {
+		return nil, ErrJWTInvalid
+	}
+	t := &Token{UserID: uint(id)}
+	// Tokens issued before generations were introduced belong to the
+	// first one.
+	if gen, ok := claims["gen"].(float64); ok {
+		t.Generation = uint(gen)
+	}
+	if exp, ok := claims["exp"].(float64); ok {
+		t.Expiry = time.Unix(int64(exp), 0)
+	}
+	return t, nil
+}
+
+// Validate asks validator, if any, whether t is still valid. It fails
+// with ErrJWTInvalid when it is not.
+func (t *Token) Validate(ctx context.Context, validator TokenValidator) error {
+	if validator == nil {
+		return nil
+	}
+	valid, err := validator(ctx, t.UserID, t.Generation)
+	if err != nil {
+		return err
+	}
+	if !valid {
+		return ErrJWTInvalid
+	}
+	return nil
+}
+
+// RequestToken returns the token which authenticated the request, if any,
+// for long-lived requests to check it again.
+func RequestToken(c echo.Context) (*Token, bool) {
+	t, ok := c.Get(tokenKey).(*Token)
+	return t, ok
+}
+
+// TokenFromAuthorization extracts the token of an Authorization header
+// value using the Token scheme.
+func TokenFromAuthorization(auth string) (string, bool) {
+	l := len(authScheme)
+	if len(auth) > l+1 && auth[:l] == authScheme {
+		return auth[l+1:], true
+	}
+	return "", false
+}
+
 // jwtFromHeader returns a `jwtExtractor` that extracts token from the request header.
-func jwtFromHeader(header string, authScheme string) jwtExtractor {
+func jwtFromHeader(header string) jwtExtractor {
 	return func(c echo.Context) (string, error) {
-		auth := c.Request().Header.Get(header)
-		l := len(authScheme)
-		if len(auth) > l+1 && auth[:l] == authScheme {
-			return auth[l+1:], nil
+		if token, ok := TokenFromAuthorization(c.Request().Header.Get(header)); ok {
+			return token, nil
+		}
+		return "", ErrJWTMissing
+	}
+}
+
+// jwtFromHeaderOrQuery returns a `jwtExtractor` that extracts token from the
+// request header, or else from the query parameter param.
+func jwtFromHeaderOrQuery(header, param string) jwtExtractor {
+	fromHeader := jwtFromHeader(header)
+	return func(c echo.Context) (string, error) {
+		if token, err := fromHeader(c); err == nil {
+			return token, nil
+		}
+		if token := c.QueryParam(param); token != "" {
+			return token, nil
 		}
 		return "", ErrJWTMissing
 	}
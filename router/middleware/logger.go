@@ -0,0 +1,91 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+	"github.com/sumitalp/productcatalog/logging"
+)
+
+// maxRequestIDLength bounds the request IDs accepted from clients.
+const maxRequestIDLength = 128
+
+// RequestID tags every request with an ID, taken from the X-Request-ID
+// header when the client or a proxy set a sensible one and generated
+// otherwise. The ID is echoed in the response and carried by the request
+// context, so that every log line written for the request has it.
+func RequestID() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			id := req.Header.Get(echo.HeaderXRequestID)
+			if !validRequestID(id) {
+				id = newRequestID()
+			}
+			c.Response().Header().Set(echo.HeaderXRequestID, id)
+			c.SetRequest(req.WithContext(logging.WithRequestID(req.Context(), id)))
+			return next(c)
+		}
+	}
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// Logger writes an access log line for every request, as an error for
+// server errors. Secrets passed in the query are redacted.
+func Logger() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			start := time.Now()
+			err := RenderError(c, next(c))
+			req, res := c.Request(), c.Response()
+			fields := log.JSON{
+				"message":    "request",
+				"remote_ip":  c.RealIP(),
+				"method":     req.Method,
+				"uri":        logging.RedactURI(req.RequestURI),
+				"route":      Route(c),
+				"status":     res.Status,
+				"latency_ms": float64(time.Since(start).Microseconds()) / 1000,
+				"bytes_in":   req.Header.Get(echo.HeaderContentLength),
+				"bytes_out":  strconv.FormatInt(res.Size, 10),
+			}
+			if id, ok := c.Get("user").(uint); ok {
+				fields["user_id"] = id
+			}
+			if err != nil {
+				fields["error"] = err.Error()
+			}
+			fields = logging.Fields(req.Context(), fields)
+			if res.Status >= http.StatusInternalServerError {
+				c.Logger().Errorj(fields)
+			} else {
+				c.Logger().Infoj(fields)
+			}
+			return nil
+		}
+	}
+}
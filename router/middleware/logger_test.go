@@ -0,0 +1,80 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/sumitalp/productcatalog/logging"
+)
+
+func TestRequestID(t *testing.T) {
+	e := echo.New()
+	e.Pre(RequestID())
+	var seen string
+	e.GET("/", func(c echo.Context) error {
+		seen = logging.RequestID(c.Request().Context())
+		return c.NoContent(http.StatusOK)
+	})
+
+	serve := func(id string) string {
+		req := httptest.NewRequest(echo.GET, "/", nil)
+		if id != "" {
+			req.Header.Set(echo.HeaderXRequestID, id)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		assert.Equal(t, seen, rec.Header().Get(echo.HeaderXRequestID))
+		return seen
+	}
+	assert.Equal(t, "from-proxy", serve("from-proxy"))
+	assert.Len(t, serve(""), 32)
+	assert.Len(t, serve("has spaces"), 32)
+	assert.Len(t, serve(strings.Repeat("x", 200)), 32)
+	assert.NotEqual(t, serve(""), serve(""))
+}
+
+func TestLogger(t *testing.T) {
+	e := echo.New()
+	var buf bytes.Buffer
+	e.Logger.SetOutput(&buf)
+	e.Logger.SetLevel(log.INFO)
+	e.Pre(RequestID())
+	e.Use(Logger())
+	e.GET("/stream", func(c echo.Context) error {
+		c.Set("user", uint(3))
+		return c.NoContent(http.StatusOK)
+	})
+	e.GET("/broken", func(c echo.Context) error {
+		return errors.New("boom")
+	})
+
+	req := httptest.NewRequest(echo.GET, "/stream?token=secret", nil)
+	req.Header.Set(echo.HeaderXRequestID, "req-1")
+	e.ServeHTTP(httptest.NewRecorder(), req)
+	var line map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &line))
+	assert.Equal(t, "INFO", line["level"])
+	assert.Equal(t, "req-1", line["request_id"])
+	assert.Equal(t, "/stream", line["route"])
+	assert.Equal(t, "/stream?token=REDACTED", line["uri"])
+	assert.Equal(t, float64(200), line["status"])
+	assert.Equal(t, float64(3), line["user_id"])
+	assert.NotContains(t, buf.String(), "secret")
+
+	buf.Reset()
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(echo.GET, "/broken", nil))
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &line))
+	assert.Equal(t, "ERROR", line["level"])
+	assert.Equal(t, "boom", line["error"])
+}
@@ -0,0 +1,117 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sumitalp/productcatalog/ratelimit"
+	"github.com/sumitalp/productcatalog/utils"
+)
+
+// Headers of rate limited responses, after the IETF draft
+// "RateLimit header fields for HTTP".
+const (
+	HeaderRateLimitLimit     = "RateLimit-Limit"
+	HeaderRateLimitRemaining = "RateLimit-Remaining"
+	HeaderRateLimitReset     = "RateLimit-Reset"
+	HeaderRateLimitPolicy    = "RateLimit-Policy"
+
+	HeaderRetryAfter = "Retry-After"
+)
+
+// RateLimitKey identifies the client a request is counted against.
+type RateLimitKey func(c echo.Context) string
+
+type RateLimitConfig struct {
+	Skipper Skipper
+	Limiter *ratelimit.Limiter
+	Key     RateLimitKey
+}
+
+// RateLimit limits the requests of each client, as identified by key, to
+// policy.
+func RateLimit(policy ratelimit.Policy, key RateLimitKey) echo.MiddlewareFunc {
+	return RateLimitWithConfig(RateLimitConfig{Limiter: ratelimit.NewLimiter(policy), Key: key})
+}
+
+// RateLimitWithConfig answers 429 Too Many Requests, with a Retry-After
+// header, to the requests exceeding the policy of the limiter. Every
+// response carries the RateLimit-* headers describing the quota left.
+// Routes sharing a limiter share the quota.
+func RateLimitWithConfig(config RateLimitConfig) echo.MiddlewareFunc {
+	if config.Key == nil {
+		config.Key = KeyByIP
+	}
+	policy := config.Limiter.Policy()
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		if !policy.Enabled() {
+			return next
+		}
+		return func(c echo.Context) error {
+			if config.Skipper != nil && config.Skipper(c) {
+				return next(c)
+			}
+			r := config.Limiter.Allow(config.Key(c))
+			header := c.Response().Header()
+			header.Set(HeaderRateLimitLimit, strconv.Itoa(r.Limit))
+			header.Set(HeaderRateLimitRemaining, strconv.Itoa(r.Remaining))
+			header.Set(HeaderRateLimitReset, strconv.Itoa(ceilSeconds(r.Reset)))
+			header.Set(HeaderRateLimitPolicy, strconv.Itoa(policy.Limit)+";w="+strconv.Itoa(ceilSeconds(policy.Period)))
+			if !r.Allowed {
+				return utils.TooManyRequests("rate limit exceeded", r.RetryAfter)
+			}
+			return next(c)
+		}
+	}
+}
+
+// Clients are identified by their address or their user. The service has no
+// API keys to count requests against: the other APIs authenticate with the
+// JWT of a user as well.
+
+// KeyByIP counts requests against the address of the client.
+func KeyByIP(c echo.Context) string {
+	return "ip:" + ClientIP(c.Request().RemoteAddr, c.Request().Header)
+}
+
+// KeyByUser counts requests against the authenticated user, or the address
+// of the client for anonymous requests. JWT must run first.
+func KeyByUser(c echo.Context) string {
+	if id, ok := c.Get("user").(uint); ok && id != 0 {
+		return "user:" + strconv.FormatUint(uint64(id), 10)
+	}
+	return KeyByIP(c)
+}
+
+// ClientIP returns the address of the client of a request received from
+// remoteAddr with header. The address forwarded by a proxy is trusted when
+// the proxy runs on the same host, as the service listens on the loopback
+// interface only; it is the last of X-Forwarded-For, the one the proxy
+// added, as clients may send the header too.
+func ClientIP(remoteAddr string, header http.Header) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		return host
+	}
+	if v := header[echo.HeaderXForwardedFor]; len(v) > 0 {
+		forwarded := strings.Split(v[len(v)-1], ",")
+		if ip := strings.TrimSpace(forwarded[len(forwarded)-1]); ip != "" {
+			return ip
+		}
+	}
+	if ip := header.Get(echo.HeaderXRealIP); ip != "" {
+		return ip
+	}
+	return host
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
+}
@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/sumitalp/productcatalog/ratelimit"
+	"github.com/sumitalp/productcatalog/utils"
+)
+
+func TestRateLimit(t *testing.T) {
+	e := newEcho()
+	var calls int32
+	mw := RateLimit(ratelimit.Policy{Limit: 2, Period: time.Minute}, KeyByUser)
+
+	rec := post(e, mw, counting(&calls), 1, "", "")
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	assert.Equal(t, "2", rec.Header().Get(HeaderRateLimitLimit))
+	assert.Equal(t, "1", rec.Header().Get(HeaderRateLimitRemaining))
+	assert.Equal(t, "30", rec.Header().Get(HeaderRateLimitReset))
+	assert.Equal(t, "2;w=60", rec.Header().Get(HeaderRateLimitPolicy))
+	assert.Equal(t, http.StatusCreated, post(e, mw, counting(&calls), 1, "", "").Code)
+
+	rec = post(e, mw, counting(&calls), 1, "", "")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "0", rec.Header().Get(HeaderRateLimitRemaining))
+	assert.Contains(t, rec.Body.String(), utils.CodeTooManyRequests)
+	assert.Equal(t, int32(2), calls)
+
+	assert.Equal(t, http.StatusCreated, post(e, mw, counting(&calls), 2, "", "").Code, "users have their own quota")
+	assert.Equal(t, http.StatusCreated, post(e, mw, counting(&calls), 0, "", "").Code, "guests are counted by address")
+
+	off := RateLimit(ratelimit.Policy{}, KeyByIP)
+	for i := 0; i < 5; i++ {
+		rec = post(e, off, counting(&calls), 0, "", "")
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		assert.Empty(t, rec.Header().Get(HeaderRateLimitLimit))
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	forwarded := http.Header{"X-Forwarded-For": {"10.0.0.1, 192.0.2.7"}}
+	assert.Equal(t, "192.0.2.7", ClientIP("127.0.0.1:4000", forwarded), "the address added by the proxy")
+	assert.Equal(t, "198.51.100.1", ClientIP("198.51.100.1:4000", forwarded), "only local proxies are trusted")
+	assert.Equal(t, "192.0.2.8", ClientIP("[::1]:4000", http.Header{"X-Real-Ip": {"192.0.2.8"}}))
+	assert.Equal(t, "127.0.0.1", ClientIP("127.0.0.1:4000", http.Header{}))
+}
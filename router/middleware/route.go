@@ -0,0 +1,19 @@
+package middleware
+
+import (
+	"reflect"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Route returns the template of the route the request of c matched, such
+// as /api/products/:slug, or "" when it matched none. For those requests
+// echo leaves the raw request path in c.Path.
+func Route(c echo.Context) string {
+	h := reflect.ValueOf(c.Handler()).Pointer()
+	if h == reflect.ValueOf(echo.NotFoundHandler).Pointer() ||
+		h == reflect.ValueOf(echo.MethodNotAllowedHandler).Pointer() {
+		return ""
+	}
+	return c.Path()
+}
@@ -0,0 +1,26 @@
+package middleware
+
+import (
+	"context"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Timeout bounds the request context with a deadline of d. Repository
+// calls made with that context are interrupted when it expires, and the
+// deadline error is returned if the handler did not write a response.
+func Timeout(d time.Duration) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			ctx, cancel := context.WithTimeout(c.Request().Context(), d)
+			defer cancel()
+			c.SetRequest(c.Request().WithContext(ctx))
+			err := next(c)
+			if err == nil && !c.Response().Committed && ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sumitalp/productcatalog/logging"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/sumitalp/productcatalog/router/middleware"
+
+// Tracing starts a server span for every request, continuing the trace
+// of the incoming W3C traceparent header if any. The span is bound to the
+// request context, so that the spans of the database statements issued
+// for the request are its children. The route template and the id of the
+// authenticated user are attached to it.
+func Tracing(service string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			ctx := otel.GetTextMapPropagator().Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+			route := Route(c)
+			name := route
+			if name == "" {
+				name = "HTTP " + req.Method
+			}
+			ctx, span := otel.Tracer(tracerName).Start(ctx, name,
+				trace.WithSpanKind(trace.SpanKindServer),
+				trace.WithAttributes(serverAttributes(service, route, req)...),
+			)
+			defer span.End()
+			c.SetRequest(req.WithContext(ctx))
+
+			if err := RenderError(c, next(c)); err != nil {
+				span.RecordError(err)
+			}
+			if id, ok := c.Get("user").(uint); ok {
+				span.SetAttributes(semconv.EnduserIDKey.String(strconv.FormatUint(uint64(id), 10)))
+			}
+			status := c.Response().Status
+			span.SetAttributes(semconv.HTTPAttributesFromHTTPStatusCode(status)...)
+			// Client errors are the client's; only server errors fail the
+			// span.
+			if status >= http.StatusInternalServerError {
+				span.SetStatus(codes.Error, http.StatusText(status))
+			}
+			return nil
+		}
+	}
+}
+
+// serverAttributes are the semantic convention attributes of req, with
+// the secrets of its query string, such as the stream token, redacted from
+// the target as they are in the logs.
+func serverAttributes(service, route string, req *http.Request) []attribute.KeyValue {
+	attrs := semconv.HTTPServerAttributesFromHTTPRequest(service, route, req)
+	for i, kv := range attrs {
+		if kv.Key == semconv.HTTPTargetKey {
+			attrs[i] = semconv.HTTPTargetKey.String(logging.RedactURI(req.RequestURI))
+		}
+	}
+	return attrs
+}
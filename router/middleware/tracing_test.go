@@ -0,0 +1,110 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// recordSpans installs a tracer provider recording the spans ended during
+// the test.
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	sr := tracetest.NewSpanRecorder()
+	tp, propagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(tp)
+		otel.SetTextMapPropagator(propagator)
+	})
+	return sr
+}
+
+func attributes(s sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	m := make(map[attribute.Key]attribute.Value)
+	for _, kv := range s.Attributes() {
+		m[kv.Key] = kv.Value
+	}
+	return m
+}
+
+func TestTracing(t *testing.T) {
+	sr := recordSpans(t)
+	e := echo.New()
+	e.Use(Tracing("catalog"))
+	var handlerSpan trace.SpanContext
+	e.GET("/products/:slug", func(c echo.Context) error {
+		handlerSpan = trace.SpanContextFromContext(c.Request().Context())
+		c.Set("user", uint(7))
+		if c.Param("slug") == "broken" {
+			return errors.New("boom")
+		}
+		return c.NoContent(http.StatusOK)
+	})
+
+	req := httptest.NewRequest(echo.GET, "/products/first", nil)
+	req.Header.Set("traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	spans := sr.Ended()
+	require.Len(t, spans, 1)
+	s := spans[0]
+	assert.Equal(t, "/products/:slug", s.Name())
+	assert.Equal(t, trace.SpanKindServer, s.SpanKind())
+	assert.Equal(t, "0af7651916cd43dd8448eb211c80319c", s.SpanContext().TraceID().String())
+	assert.Equal(t, "b7ad6b7169203331", s.Parent().SpanID().String())
+	assert.Equal(t, s.SpanContext().SpanID(), handlerSpan.SpanID(), "the span is bound to the request context")
+	attrs := attributes(s)
+	assert.Equal(t, "/products/:slug", attrs["http.route"].AsString())
+	assert.Equal(t, "7", attrs["enduser.id"].AsString())
+	assert.Equal(t, int64(200), attrs["http.status_code"].AsInt64())
+
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(echo.GET, "/products/broken", nil))
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	s = sr.Ended()[1]
+	assert.Equal(t, codes.Error, s.Status().Code)
+	assert.False(t, s.Parent().IsValid(), "requests without traceparent start a trace")
+
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(echo.GET, "/nowhere", nil))
+	s = sr.Ended()[2]
+	assert.Equal(t, "HTTP GET", s.Name())
+	assert.Equal(t, codes.Unset, s.Status().Code, "client errors do not fail the span")
+}
+
+func TestTracingRedactsTarget(t *testing.T) {
+	sr := recordSpans(t)
+	e := echo.New()
+	e.Use(Tracing("catalog"))
+	e.GET("/stream", func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	})
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(echo.GET, "/stream?token=eyJhbGciOiJIUzI1NiJ9.secret&since=5", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	spans := sr.Ended()
+	require.Len(t, spans, 1)
+	for _, kv := range spans[0].Attributes() {
+		assert.NotContains(t, kv.Value.Emit(), "eyJhbGciOiJIUzI1NiJ9", "attribute %s", kv.Key)
+	}
+	target := attributes(spans[0])["http.target"].AsString()
+	assert.Contains(t, target, "/stream?")
+	assert.Contains(t, target, "since=5")
+}
@@ -4,18 +4,22 @@ import (
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	"github.com/labstack/gommon/log"
+	mw "github.com/sumitalp/productcatalog/router/middleware"
 )
 
 func New() *echo.Echo {
 	e := echo.New()
-	e.Logger.SetLevel(log.DEBUG)
+	e.Logger.SetLevel(log.INFO)
 	e.Pre(middleware.RemoveTrailingSlash())
-	e.Use(middleware.Logger())
+	e.Pre(mw.RequestID())
+	e.Use(mw.Logger())
 	e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
-		AllowOrigins: []string{"*"},
-		AllowHeaders: []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept, echo.HeaderAuthorization},
-		AllowMethods: []string{echo.GET, echo.HEAD, echo.PUT, echo.PATCH, echo.POST, echo.DELETE},
+		AllowOrigins:  []string{"*"},
+		AllowHeaders:  []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept, echo.HeaderAuthorization, "If-Match", "If-None-Match", "Idempotency-Key", "Last-Event-ID", "traceparent", "tracestate", echo.HeaderXRequestID},
+		ExposeHeaders: []string{"ETag", "Idempotent-Replayed", echo.HeaderXRequestID, mw.HeaderRateLimitLimit, mw.HeaderRateLimitRemaining, mw.HeaderRateLimitReset, mw.HeaderRateLimitPolicy, mw.HeaderRetryAfter},
+		AllowMethods:  []string{echo.GET, echo.HEAD, echo.PUT, echo.PATCH, echo.POST, echo.DELETE},
 	}))
 	e.Validator = NewValidator()
+	e.HTTPErrorHandler = HTTPErrorHandler
 	return e
 }
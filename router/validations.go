@@ -0,0 +1,119 @@
+package router
+
+import (
+	"regexp"
+	"strings"
+
+	ut "github.com/go-playground/universal-translator"
+	"gopkg.in/go-playground/validator.v9"
+)
+
+var (
+	slugRegex = regexp.MustCompile(`^[a-z0-9]+(?:-[a-z0-9]+)*$`)
+	skuRegex  = regexp.MustCompile(`^[A-Z0-9]+(?:[-_][A-Z0-9]+)*$`)
+)
+
+// iso4217 lists the active ISO 4217 currency codes.
+var iso4217 = map[string]bool{}
+
+func init() {
+	for _, code := range strings.Fields(`
+		AED AFN ALL AMD ANG AOA ARS AUD AWG AZN BAM BBD BDT BGN BHD BIF BMD BND
+		BOB BOV BRL BSD BTN BWP BYN BZD CAD CDF CHE CHF CHW CLF CLP CNY COP COU
+		CRC CUC CUP CVE CZK DJF DKK DOP DZD EGP ERN ETB EUR FJD FKP GBP GEL GHS
+		GIP GMD GNF GTQ GYD HKD HNL HRK HTG HUF IDR ILS INR IQD IRR ISK JMD JOD
+		JPY KES KGS KHR KMF KPW KRW KWD KYD KZT LAK LBP LKR LRD LSL LYD MAD MDL
+		MGA MKD MMK MNT MOP MRU MUR MVR MWK MXN MXV MYR MZN NAD NGN NIO NOK NPR
+		NZD OMR PAB PEN PGK PHP PKR PLN PYG QAR RON RSD RUB RWF SAR SBD SCR SDG
+		SEK SGD SHP SLL SOS SRD SSP STN SVC SYP SZL THB TJS TMT TND TOP TRY TTD
+		TWD TZS UAH UGX USD USN UYI UYU UYW UZS VES VND VUV WST XAF XAG XAU XBA
+		XBB XBC XBD XCD XDR XOF XPD XPF XPT XSU XTS XUA XXX YER ZAR ZMW ZWL`) {
+		iso4217[code] = true
+	}
+}
+
+func registerValidations(v *validator.Validate) {
+	v.RegisterValidation("slug", func(fl validator.FieldLevel) bool {
+		return slugRegex.MatchString(fl.Field().String())
+	})
+	v.RegisterValidation("sku", func(fl validator.FieldLevel) bool {
+		s := fl.Field().String()
+		return len(s) <= 32 && skuRegex.MatchString(s)
+	})
+	v.RegisterValidation("currency", func(fl validator.FieldLevel) bool {
+		return iso4217[fl.Field().String()]
+	})
+	v.RegisterValidation("gtin", func(fl validator.FieldLevel) bool {
+		return isGTIN(fl.Field().String())
+	})
+}
+
+// isGTIN reports whether s is a GTIN-8, GTIN-12, GTIN-13 or GTIN-14 with a
+// valid check digit.
+func isGTIN(s string) bool {
+	switch len(s) {
+	case 8, 12, 13, 14:
+	default:
+		return false
+	}
+	sum := 0
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			return false
+		}
+		d := int(c - '0')
+		// Weights alternate 1, 3, 1, ... starting from the check digit.
+		if (len(s)-1-i)%2 == 1 {
+			d *= 3
+		}
+		sum += d
+	}
+	return sum%10 == 0
+}
+
+var customTranslations = map[string]map[string]string{
+	"en": {
+		"slug":     "{0} must be a valid slug",
+		"sku":      "{0} must be a valid SKU",
+		"currency": "{0} must be a valid ISO 4217 currency code",
+		"gtin":     "{0} must be a valid GTIN",
+	},
+	"fr": {
+		"slug":     "{0} doit être un slug valide",
+		"sku":      "{0} doit être un SKU valide",
+		"currency": "{0} doit être un code de devise ISO 4217 valide",
+		"gtin":     "{0} doit être un GTIN valide",
+	},
+	"nl": {
+		"slug":     "{0} moet een geldige slug zijn",
+		"sku":      "{0} moet een geldige SKU zijn",
+		"currency": "{0} moet een geldige ISO 4217-valutacode zijn",
+		"gtin":     "{0} moet een geldige GTIN zijn",
+	},
+	"pt_BR": {
+		"slug":     "{0} deve ser um slug válido",
+		"sku":      "{0} deve ser um SKU válido",
+		"currency": "{0} deve ser um código de moeda ISO 4217 válido",
+		"gtin":     "{0} deve ser um GTIN válido",
+	},
+}
+
+func registerTranslations(v *validator.Validate, trans ut.Translator, locale string) error {
+	for tag, text := range customTranslations[locale] {
+		tag, text := tag, text
+		err := v.RegisterTranslation(tag, trans,
+			func(ut ut.Translator) error {
+				return ut.Add(tag, text, true)
+			},
+			func(ut ut.Translator, fe validator.FieldError) string {
+				t, _ := ut.T(tag, fe.Field())
+				return t
+			},
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
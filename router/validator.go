@@ -1,17 +1,121 @@
 package router
 
-import "gopkg.in/go-playground/validator.v9"
+import (
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-playground/locales/en"
+	"github.com/go-playground/locales/fr"
+	"github.com/go-playground/locales/nl"
+	"github.com/go-playground/locales/pt_BR"
+	ut "github.com/go-playground/universal-translator"
+	"gopkg.in/go-playground/validator.v9"
+	en_translations "gopkg.in/go-playground/validator.v9/translations/en"
+	fr_translations "gopkg.in/go-playground/validator.v9/translations/fr"
+	nl_translations "gopkg.in/go-playground/validator.v9/translations/nl"
+	pt_BR_translations "gopkg.in/go-playground/validator.v9/translations/pt_BR"
+)
 
 func NewValidator() *Validator {
+	v := validator.New()
+	v.RegisterTagNameFunc(jsonTagName)
+	registerValidations(v)
+
+	enLocale := en.New()
+	uni := ut.New(enLocale, enLocale, fr.New(), nl.New(), pt_BR.New())
+	for locale, register := range map[string]func(*validator.Validate, ut.Translator) error{
+		"en":    en_translations.RegisterDefaultTranslations,
+		"fr":    fr_translations.RegisterDefaultTranslations,
+		"nl":    nl_translations.RegisterDefaultTranslations,
+		"pt_BR": pt_BR_translations.RegisterDefaultTranslations,
+	} {
+		trans, _ := uni.GetTranslator(locale)
+		if err := register(v, trans); err != nil {
+			panic(err)
+		}
+		if err := registerTranslations(v, trans, locale); err != nil {
+			panic(err)
+		}
+	}
 	return &Validator{
-		validator: validator.New(),
+		validator: v,
+		uni:       uni,
 	}
 }
 
 type Validator struct {
 	validator *validator.Validate
+	uni       *ut.UniversalTranslator
 }
 
 func (v *Validator) Validate(i interface{}) error {
 	return v.validator.Struct(i)
 }
+
+// Translator picks the best supported translator for an Accept-Language
+// header value, falling back to English.
+func (v *Validator) Translator(acceptLanguage string) ut.Translator {
+	trans, _ := v.uni.FindTranslator(parseAcceptLanguage(acceptLanguage)...)
+	return trans
+}
+
+// jsonTagName reports fields by their JSON name so error paths match the
+// request document rather than the Go struct.
+func jsonTagName(f reflect.StructField) string {
+	name := strings.SplitN(f.Tag.Get("json"), ",", 2)[0]
+	if name == "-" {
+		return ""
+	}
+	if name == "" {
+		return f.Name
+	}
+	return name
+}
+
+// parseAcceptLanguage returns the locales of an Accept-Language header in
+// preference order, using the underscore form of go-playground/locales.
+// A regional tag such as fr-CH is followed by its base language.
+func parseAcceptLanguage(header string) []string {
+	type lang struct {
+		tag string
+		q   float64
+	}
+	var langs []lang
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		tag := strings.TrimSpace(fields[0])
+		if tag == "" || tag == "*" {
+			continue
+		}
+		q := 1.0
+		for _, p := range fields[1:] {
+			p = strings.TrimSpace(p)
+			if strings.HasPrefix(p, "q=") {
+				if f, err := strconv.ParseFloat(p[2:], 64); err == nil {
+					q = f
+				}
+			}
+		}
+		if q <= 0 {
+			continue
+		}
+		langs = append(langs, lang{tag: tag, q: q})
+	}
+	sort.SliceStable(langs, func(i, j int) bool { return langs[i].q > langs[j].q })
+
+	locales := make([]string, 0, len(langs)*2)
+	for _, l := range langs {
+		parts := strings.Split(l.tag, "-")
+		parts[0] = strings.ToLower(parts[0])
+		for i := 1; i < len(parts); i++ {
+			parts[i] = strings.ToUpper(parts[i])
+		}
+		locales = append(locales, strings.Join(parts, "_"))
+		if len(parts) > 1 {
+			locales = append(locales, parts[0])
+		}
+	}
+	return locales
+}
@@ -0,0 +1,64 @@
+package router
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/sumitalp/productcatalog/utils"
+)
+
+type catalogItem struct {
+	Item struct {
+		Slug     string `json:"slug" validate:"slug"`
+		SKU      string `json:"sku" validate:"sku"`
+		Currency string `json:"currency" validate:"currency"`
+		GTIN     string `json:"gtin" validate:"gtin"`
+	} `json:"item"`
+}
+
+func TestValidatorCustomTags(t *testing.T) {
+	v := NewValidator()
+	var valid catalogItem
+	valid.Item.Slug = "blue-widget-2"
+	valid.Item.SKU = "WID-0042"
+	valid.Item.Currency = "EUR"
+	valid.Item.GTIN = "4006381333931"
+	assert.NoError(t, v.Validate(&valid))
+
+	var invalid catalogItem
+	invalid.Item.Slug = "Blue Widget"
+	invalid.Item.SKU = "wid 42"
+	invalid.Item.Currency = "EURO"
+	invalid.Item.GTIN = "4006381333932"
+	err := v.Validate(&invalid)
+	if assert.Error(t, err) {
+		p := utils.NewValidatorError(err, v.Translator("en"))
+		fields := map[string]string{}
+		for _, fe := range p.Errors {
+			fields[fe.Field] = fe.Message
+		}
+		assert.Equal(t, "slug must be a valid slug", fields["item.slug"])
+		assert.Equal(t, "sku must be a valid SKU", fields["item.sku"])
+		assert.Equal(t, "currency must be a valid ISO 4217 currency code", fields["item.currency"])
+		assert.Equal(t, "gtin must be a valid GTIN", fields["item.gtin"])
+	}
+}
+
+func TestIsGTIN(t *testing.T) {
+	assert.True(t, isGTIN("96385074"))
+	assert.True(t, isGTIN("036000291452"))
+	assert.True(t, isGTIN("4006381333931"))
+	assert.True(t, isGTIN("10614141000415"))
+	assert.False(t, isGTIN("036000291453"))
+	assert.False(t, isGTIN("12345"))
+	assert.False(t, isGTIN("40063813339A1"))
+}
+
+func TestValidatorTranslator(t *testing.T) {
+	v := NewValidator()
+	assert.Equal(t, "fr", v.Translator("fr-CH, fr;q=0.9, en;q=0.8").Locale())
+	assert.Equal(t, "pt_BR", v.Translator("pt-BR").Locale())
+	assert.Equal(t, "nl", v.Translator("de;q=0.9, nl;q=0.5").Locale())
+	assert.Equal(t, "en", v.Translator("").Locale())
+	assert.Equal(t, "en", v.Translator("de").Locale())
+}
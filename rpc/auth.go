@@ -0,0 +1,82 @@
+package rpc
+
+import (
+	"context"
+
+	"github.com/labstack/gommon/log"
+	"github.com/sumitalp/productcatalog/router/middleware"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type userIDKey struct{}
+
+// UnaryAuth authenticates unary calls with the JWT in their authorization
+// metadata, which holds "Token <jwt>" as the HTTP header does. Calls
+// without one go through anonymously; an invalid token, or one validator
+// rejects, is rejected.
+func UnaryAuth(key interface{}, validator middleware.TokenValidator) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := authenticate(ctx, key, validator)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamAuth is the streaming counterpart of UnaryAuth.
+func StreamAuth(key interface{}, validator middleware.TokenValidator) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := authenticate(ss.Context(), key, validator)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &authenticatedStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+func authenticate(ctx context.Context, key interface{}, validator middleware.TokenValidator) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get("authorization")
+	if len(values) == 0 {
+		return ctx, nil
+	}
+	token, ok := middleware.TokenFromAuthorization(values[0])
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "missing or malformed jwt")
+	}
+	t, err := middleware.ParseToken(token, key)
+	if err == nil {
+		err = t.Validate(ctx, validator)
+	}
+	if err == middleware.ErrJWTInvalid {
+		return nil, status.Error(codes.Unauthenticated, "invalid or expired jwt")
+	}
+	if err != nil {
+		log.Error(err)
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return context.WithValue(ctx, userIDKey{}, t.UserID), nil
+}
+
+// userID returns the id of the authenticated caller, or fails with
+// Unauthenticated.
+func userID(ctx context.Context) (uint, error) {
+	id, ok := ctx.Value(userIDKey{}).(uint)
+	if !ok {
+		return 0, status.Error(codes.Unauthenticated, "authentication required")
+	}
+	return id, nil
+}
+
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,389 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sumitalp/productcatalog/models"
+	"github.com/sumitalp/productcatalog/product"
+	"github.com/sumitalp/productcatalog/rpc/catalogpb"
+	"github.com/sumitalp/productcatalog/utils"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+// Requests are validated with the rules of the REST API. Failed fields are
+// reported by their protobuf names.
+
+type productCreateRequest struct {
+	Product struct {
+		Title       string   `json:"title" validate:"required"`
+		Description string   `json:"description" validate:"required"`
+		Image       string   `json:"image"`
+		Categories  []string `json:"categories" validate:"dive,required"`
+	} `json:"product"`
+}
+
+type productUpdateRequest struct {
+	Product struct {
+		Title       string   `json:"title" validate:"required"`
+		Description string   `json:"description"`
+		Image       string   `json:"image"`
+		Categories  []string `json:"categories" validate:"dive,required"`
+	} `json:"product"`
+}
+
+type categoryRequest struct {
+	Category struct {
+		Title       string `json:"title" validate:"required"`
+		Description string `json:"description"`
+	} `json:"category"`
+}
+
+// Products
+
+func (s *CatalogServer) GetProduct(ctx context.Context, req *catalogpb.GetProductRequest) (*catalogpb.Product, error) {
+	p, err := s.productService.Get(ctx, req.GetSlug())
+	if err != nil {
+		return nil, s.status(ctx, err)
+	}
+	return newProduct(p), nil
+}
+
+func (s *CatalogServer) ListProducts(ctx context.Context, req *catalogpb.ListProductsRequest) (*catalogpb.ListProductsResponse, error) {
+	f, err := listFilter(req)
+	if err != nil {
+		return nil, s.status(ctx, err)
+	}
+	if f.Limit == 0 {
+		f.Limit = defaultLimit
+	}
+	products, count, err := s.productService.List(ctx, f)
+	if err != nil {
+		return nil, s.status(ctx, err)
+	}
+	res := &catalogpb.ListProductsResponse{
+		Products:   make([]*catalogpb.Product, 0, len(products)),
+		TotalCount: int32(count),
+	}
+	for i := range products {
+		res.Products = append(res.Products, newProduct(&products[i]))
+	}
+	return res, nil
+}
+
+func (s *CatalogServer) StreamProducts(req *catalogpb.ListProductsRequest, stream catalogpb.CatalogService_StreamProductsServer) error {
+	ctx := stream.Context()
+	f, err := listFilter(req)
+	if err != nil {
+		return s.status(ctx, err)
+	}
+	err = paginate(f.Offset, f.Limit, func(offset, limit int) (int, error) {
+		f.Offset, f.Limit = offset, limit
+		products, _, err := s.productService.List(ctx, f)
+		if err != nil {
+			return 0, err
+		}
+		for i := range products {
+			if err := stream.Send(newProduct(&products[i])); err != nil {
+				return 0, err
+			}
+		}
+		return len(products), nil
+	})
+	if err != nil {
+		return s.status(ctx, err)
+	}
+	return nil
+}
+
+func (s *CatalogServer) CreateProduct(ctx context.Context, req *catalogpb.CreateProductRequest) (*catalogpb.Product, error) {
+	id, err := userID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	in := req.GetProduct()
+	r := &productCreateRequest{}
+	r.Product.Title = in.GetTitle()
+	r.Product.Description = in.GetDescription()
+	r.Product.Image = in.GetImage()
+	r.Product.Categories = in.GetCategories()
+	if err := s.validator.Validate(r); err != nil {
+		return nil, s.status(ctx, err)
+	}
+	p := &models.Product{
+		Title:       r.Product.Title,
+		Description: r.Product.Description,
+		Image:       r.Product.Image,
+		Categories:  categories(r.Product.Categories),
+	}
+	if err := s.productService.Create(ctx, id, p); err != nil {
+		return nil, s.status(ctx, err)
+	}
+	return newProduct(p), nil
+}
+
+func (s *CatalogServer) UpdateProduct(ctx context.Context, req *catalogpb.UpdateProductRequest) (*catalogpb.Product, error) {
+	id, err := userID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	paths, err := maskPaths(req.GetUpdateMask(), "title", "description", "image", "categories")
+	if err != nil {
+		return nil, s.status(ctx, err)
+	}
+	p, err := s.productService.Update(ctx, id, req.GetSlug(), func(p *models.Product) error {
+		if err := checkVersion(p.ModelBase, req.GetVersion()); err != nil {
+			return err
+		}
+		r := &productUpdateRequest{}
+		r.Product.Title = p.Title
+		r.Product.Description = p.Description
+		r.Product.Image = p.Image
+		for _, c := range p.Categories {
+			r.Product.Categories = append(r.Product.Categories, c.Category)
+		}
+		in := req.GetProduct()
+		for _, path := range paths {
+			switch path {
+			case "title":
+				r.Product.Title = in.GetTitle()
+			case "description":
+				r.Product.Description = in.GetDescription()
+			case "image":
+				r.Product.Image = in.GetImage()
+			case "categories":
+				r.Product.Categories = in.GetCategories()
+			}
+		}
+		if err := s.validator.Validate(r); err != nil {
+			return err
+		}
+		p.Title = r.Product.Title
+		p.Description = r.Product.Description
+		p.Image = r.Product.Image
+		p.Categories = categories(r.Product.Categories)
+		return nil
+	})
+	if err != nil {
+		return nil, s.status(ctx, err)
+	}
+	return newProduct(p), nil
+}
+
+func (s *CatalogServer) DeleteProduct(ctx context.Context, req *catalogpb.DeleteProductRequest) (*emptypb.Empty, error) {
+	id, err := userID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	err = s.productService.Delete(ctx, id, req.GetSlug(), func(p *models.Product) error {
+		return checkVersion(p.ModelBase, req.GetVersion())
+	})
+	if err != nil {
+		return nil, s.status(ctx, err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// Categories
+
+func (s *CatalogServer) GetCategory(ctx context.Context, req *catalogpb.GetCategoryRequest) (*catalogpb.Category, error) {
+	c, err := s.productService.GetCategory(ctx, uint(req.GetId()))
+	if err != nil {
+		return nil, s.status(ctx, err)
+	}
+	return newCategory(c), nil
+}
+
+func (s *CatalogServer) ListCategories(ctx context.Context, req *catalogpb.ListCategoriesRequest) (*catalogpb.ListCategoriesResponse, error) {
+	if err := checkPage(req.GetOffset(), req.GetLimit()); err != nil {
+		return nil, s.status(ctx, err)
+	}
+	limit := int(req.GetLimit())
+	if limit == 0 {
+		limit = defaultLimit
+	}
+	categories, count, err := s.productService.ListCategories(ctx, int(req.GetOffset()), limit)
+	if err != nil {
+		return nil, s.status(ctx, err)
+	}
+	res := &catalogpb.ListCategoriesResponse{
+		Categories: make([]*catalogpb.Category, 0, len(categories)),
+		TotalCount: int32(count),
+	}
+	for i := range categories {
+		res.Categories = append(res.Categories, newCategory(&categories[i]))
+	}
+	return res, nil
+}
+
+func (s *CatalogServer) StreamCategories(req *catalogpb.ListCategoriesRequest, stream catalogpb.CatalogService_StreamCategoriesServer) error {
+	ctx := stream.Context()
+	if err := checkPage(req.GetOffset(), req.GetLimit()); err != nil {
+		return s.status(ctx, err)
+	}
+	err := paginate(int(req.GetOffset()), int(req.GetLimit()), func(offset, limit int) (int, error) {
+		categories, _, err := s.productService.ListCategories(ctx, offset, limit)
+		if err != nil {
+			return 0, err
+		}
+		for i := range categories {
+			if err := stream.Send(newCategory(&categories[i])); err != nil {
+				return 0, err
+			}
+		}
+		return len(categories), nil
+	})
+	if err != nil {
+		return s.status(ctx, err)
+	}
+	return nil
+}
+
+func (s *CatalogServer) CreateCategory(ctx context.Context, req *catalogpb.CreateCategoryRequest) (*catalogpb.Category, error) {
+	if _, err := userID(ctx); err != nil {
+		return nil, err
+	}
+	r := &categoryRequest{}
+	r.Category.Title = req.GetCategory().GetTitle()
+	r.Category.Description = req.GetCategory().GetDescription()
+	if err := s.validator.Validate(r); err != nil {
+		return nil, s.status(ctx, err)
+	}
+	c := &models.Category{
+		Category:    r.Category.Title,
+		Description: r.Category.Description,
+	}
+	if err := s.productService.CreateCategory(ctx, c); err != nil {
+		return nil, s.status(ctx, err)
+	}
+	return newCategory(c), nil
+}
+
+func (s *CatalogServer) UpdateCategory(ctx context.Context, req *catalogpb.UpdateCategoryRequest) (*catalogpb.Category, error) {
+	if _, err := userID(ctx); err != nil {
+		return nil, err
+	}
+	paths, err := maskPaths(req.GetUpdateMask(), "title", "description")
+	if err != nil {
+		return nil, s.status(ctx, err)
+	}
+	c, err := s.productService.UpdateCategory(ctx, uint(req.GetId()), func(c *models.Category) error {
+		if err := checkVersion(c.ModelBase, req.GetVersion()); err != nil {
+			return err
+		}
+		r := &categoryRequest{}
+		r.Category.Title = c.Category
+		r.Category.Description = c.Description
+		for _, path := range paths {
+			switch path {
+			case "title":
+				r.Category.Title = req.GetCategory().GetTitle()
+			case "description":
+				r.Category.Description = req.GetCategory().GetDescription()
+			}
+		}
+		if err := s.validator.Validate(r); err != nil {
+			return err
+		}
+		c.Category = r.Category.Title
+		c.Description = r.Category.Description
+		return nil
+	})
+	if err != nil {
+		return nil, s.status(ctx, err)
+	}
+	return newCategory(c), nil
+}
+
+func (s *CatalogServer) DeleteCategory(ctx context.Context, req *catalogpb.DeleteCategoryRequest) (*emptypb.Empty, error) {
+	if _, err := userID(ctx); err != nil {
+		return nil, err
+	}
+	err := s.productService.DeleteCategory(ctx, uint(req.GetId()), func(c *models.Category) error {
+		return checkVersion(c.ModelBase, req.GetVersion())
+	})
+	if err != nil {
+		return nil, s.status(ctx, err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func listFilter(req *catalogpb.ListProductsRequest) (product.ListFilter, error) {
+	if err := checkPage(req.GetOffset(), req.GetLimit()); err != nil {
+		return product.ListFilter{}, err
+	}
+	return product.ListFilter{
+		Category: req.GetCategory(),
+		Owner:    req.GetOwner(),
+		Search:   req.GetSearch(),
+		Offset:   int(req.GetOffset()),
+		Limit:    int(req.GetLimit()),
+	}, nil
+}
+
+func checkPage(offset, limit int32) error {
+	if offset < 0 || limit < 0 {
+		return utils.BadRequest("offset and limit must not be negative")
+	}
+	return nil
+}
+
+// paginate calls list with pages of at most streamPageSize rows from
+// offset on, until it returned limit rows in total or a short page. A zero
+// limit reads to the end.
+func paginate(offset, limit int, list func(offset, limit int) (int, error)) error {
+	for {
+		size := streamPageSize
+		if limit > 0 && limit < size {
+			size = limit
+		}
+		n, err := list(offset, size)
+		if err != nil {
+			return err
+		}
+		offset += n
+		if limit > 0 {
+			limit -= n
+			if limit == 0 {
+				return nil
+			}
+		}
+		if n < size {
+			return nil
+		}
+	}
+}
+
+// maskPaths returns the paths of mask, or every allowed path when it is
+// empty.
+func maskPaths(mask *fieldmaskpb.FieldMask, allowed ...string) ([]string, error) {
+	paths := mask.GetPaths()
+	if len(paths) == 0 {
+		return allowed, nil
+	}
+	for _, path := range paths {
+		if !contains(allowed, path) {
+			return nil, utils.BadRequest(fmt.Sprintf("update_mask: unknown field %q", path))
+		}
+	}
+	return paths, nil
+}
+
+func contains(values []string, v string) bool {
+	for _, s := range values {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// checkVersion fails with utils.ErrStale when version is set and is not
+// the version of m.
+func checkVersion(m models.ModelBase, version uint32) error {
+	if version != 0 && uint(version) != m.Version {
+		return utils.ErrStale
+	}
+	return nil
+}
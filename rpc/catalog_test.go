@@ -0,0 +1,335 @@
+package rpc
+
+import (
+	"context"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/sumitalp/productcatalog/models"
+	"github.com/sumitalp/productcatalog/product"
+	"github.com/sumitalp/productcatalog/ratelimit"
+	"github.com/sumitalp/productcatalog/repository/memory"
+	"github.com/sumitalp/productcatalog/rpc/catalogpb"
+	"github.com/sumitalp/productcatalog/user"
+	"github.com/sumitalp/productcatalog/utils"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+func setup(t *testing.T, opts ...grpc.ServerOption) catalogpb.CatalogServiceClient {
+	s := memory.NewStore()
+	us := memory.NewUserRepository(s)
+	as := memory.NewProductRepository(s)
+	for _, name := range []string{"user1", "user2"} {
+		require.NoError(t, us.Create(&models.User{Username: name, Email: name + "@email.io", Password: "hash"}))
+	}
+	for i := 1; i <= 5; i++ {
+		n := strconv.Itoa(i)
+		require.NoError(t, as.CreateProduct(&models.Product{
+			Slug:        "p" + n,
+			Title:       "p" + n + " title",
+			Description: "p" + n + " description",
+			OwnerID:     uint(i%2 + 1),
+			Categories:  []models.Category{{Category: "category" + strconv.Itoa(i%2+1)}},
+		}))
+	}
+
+	tx := memory.NewUnitOfWork(s)
+	gs := NewServer(user.NewService(us, tx).TokenValid, opts...)
+	NewCatalogServer(product.NewService(as, tx)).Register(gs)
+	l := bufconn.Listen(1 << 20)
+	go gs.Serve(l)
+	t.Cleanup(gs.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+			return l.Dial()
+		}),
+		grpc.WithInsecure(),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return catalogpb.NewCatalogServiceClient(conn)
+}
+
+func as(userID uint) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Token "+utils.GenerateJWT(userID, 0))
+}
+
+func reason(t *testing.T, err error) string {
+	for _, d := range status.Convert(err).Details() {
+		if info, ok := d.(*errdetails.ErrorInfo); ok {
+			return info.Reason
+		}
+	}
+	t.Errorf("no ErrorInfo in %v", err)
+	return ""
+}
+
+func TestGetProduct(t *testing.T) {
+	t.Parallel()
+	c := setup(t)
+	p, err := c.GetProduct(context.Background(), &catalogpb.GetProductRequest{Slug: "p1"})
+	if assert.NoError(t, err) {
+		assert.Equal(t, "p1 title", p.Title)
+		assert.Equal(t, "user2", p.Owner.Username)
+		if assert.Len(t, p.Categories, 1) {
+			assert.Equal(t, "category2", p.Categories[0].Title)
+		}
+		assert.EqualValues(t, 1, p.Version)
+	}
+
+	_, err = c.GetProduct(context.Background(), &catalogpb.GetProductRequest{Slug: "missing"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	assert.Equal(t, utils.CodeNotFound, reason(t, err))
+}
+
+func TestListProducts(t *testing.T) {
+	t.Parallel()
+	c := setup(t)
+	res, err := c.ListProducts(context.Background(), &catalogpb.ListProductsRequest{Owner: "user1", Limit: 1})
+	if assert.NoError(t, err) {
+		assert.EqualValues(t, 2, res.TotalCount)
+		if assert.Len(t, res.Products, 1) {
+			assert.Equal(t, "p4", res.Products[0].Slug)
+		}
+	}
+
+	_, err = c.ListProducts(context.Background(), &catalogpb.ListProductsRequest{Offset: -1})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestStreamProducts(t *testing.T) {
+	t.Parallel()
+	c := setup(t)
+	for _, tc := range []struct {
+		req   *catalogpb.ListProductsRequest
+		slugs []string
+	}{
+		{&catalogpb.ListProductsRequest{}, []string{"p5", "p4", "p3", "p2", "p1"}},
+		{&catalogpb.ListProductsRequest{Offset: 1, Limit: 2}, []string{"p4", "p3"}},
+		{&catalogpb.ListProductsRequest{Category: "category2"}, []string{"p5", "p3", "p1"}},
+	} {
+		stream, err := c.StreamProducts(context.Background(), tc.req)
+		require.NoError(t, err)
+		var slugs []string
+		for {
+			p, err := stream.Recv()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+			slugs = append(slugs, p.Slug)
+		}
+		assert.Equal(t, tc.slugs, slugs)
+	}
+}
+
+func TestPaginate(t *testing.T) {
+	var pages [][2]int
+	err := paginate(10, 250, func(offset, limit int) (int, error) {
+		pages = append(pages, [2]int{offset, limit})
+		return limit, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, [][2]int{{10, 100}, {110, 100}, {210, 50}}, pages)
+
+	pages = nil
+	err = paginate(0, 0, func(offset, limit int) (int, error) {
+		pages = append(pages, [2]int{offset, limit})
+		if offset == 0 {
+			return limit, nil
+		}
+		return 3, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, [][2]int{{0, 100}, {100, 100}}, pages)
+}
+
+func TestProductWritesRequireAuthentication(t *testing.T) {
+	t.Parallel()
+	c := setup(t)
+	_, err := c.CreateProduct(context.Background(), &catalogpb.CreateProductRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Token invalid")
+	_, err = c.GetProduct(ctx, &catalogpb.GetProductRequest{Slug: "p1"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// Tokens of another generation were revoked by a password change.
+	ctx = metadata.AppendToOutgoingContext(context.Background(), "authorization", "Token "+utils.GenerateJWT(1, 1))
+	_, err = c.GetProduct(ctx, &catalogpb.GetProductRequest{Slug: "p1"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestCreateProduct(t *testing.T) {
+	t.Parallel()
+	c := setup(t)
+	p, err := c.CreateProduct(as(1), &catalogpb.CreateProductRequest{Product: &catalogpb.ProductInput{
+		Title:       "New Product",
+		Description: "d",
+		Categories:  []string{"category1"},
+	}})
+	if assert.NoError(t, err) {
+		assert.Equal(t, "new-product", p.Slug)
+		assert.Equal(t, "user1", p.Owner.Username)
+	}
+
+	ctx := metadata.AppendToOutgoingContext(as(1), "accept-language", "fr")
+	_, err = c.CreateProduct(ctx, &catalogpb.CreateProductRequest{Product: &catalogpb.ProductInput{
+		Categories: []string{""},
+	}})
+	if assert.Equal(t, codes.InvalidArgument, status.Code(err)) {
+		assert.Equal(t, utils.CodeValidationFailed, reason(t, err))
+		var fields []string
+		for _, d := range status.Convert(err).Details() {
+			if br, ok := d.(*errdetails.BadRequest); ok {
+				for _, v := range br.FieldViolations {
+					fields = append(fields, v.Field)
+					assert.NotContains(t, v.Description, "rule")
+				}
+			}
+		}
+		assert.Equal(t, []string{"product.title", "product.description", "product.categories[0]"}, fields)
+	}
+}
+
+func TestUpdateProduct(t *testing.T) {
+	t.Parallel()
+	c := setup(t)
+	p, err := c.UpdateProduct(as(2), &catalogpb.UpdateProductRequest{
+		Slug:       "p1",
+		Product:    &catalogpb.ProductInput{Description: "changed", Title: "ignored"},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"description"}},
+		Version:    1,
+	})
+	if assert.NoError(t, err) {
+		assert.Equal(t, "p1-title", p.Slug)
+		assert.Equal(t, "p1 title", p.Title)
+		assert.Equal(t, "changed", p.Description)
+		assert.Len(t, p.Categories, 1)
+		assert.EqualValues(t, 2, p.Version)
+	}
+
+	_, err = c.UpdateProduct(as(2), &catalogpb.UpdateProductRequest{Slug: "p1-title", Version: 1})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	_, err = c.UpdateProduct(as(1), &catalogpb.UpdateProductRequest{Slug: "p1-title"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = c.UpdateProduct(as(2), &catalogpb.UpdateProductRequest{
+		Slug:       "p1-title",
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"owner"}},
+	})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// Without a mask every field is replaced.
+	_, err = c.UpdateProduct(as(2), &catalogpb.UpdateProductRequest{Slug: "p1-title"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestDeleteProduct(t *testing.T) {
+	t.Parallel()
+	c := setup(t)
+	_, err := c.DeleteProduct(as(2), &catalogpb.DeleteProductRequest{Slug: "p1"})
+	assert.NoError(t, err)
+	_, err = c.GetProduct(context.Background(), &catalogpb.GetProductRequest{Slug: "p1"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestWritesRateLimited(t *testing.T) {
+	t.Parallel()
+	limiter := ratelimit.NewLimiter(ratelimit.Policy{Limit: 1, Period: time.Minute})
+	c := setup(t, grpc.ChainUnaryInterceptor(UnaryRateLimit(limiter)))
+	_, err := c.CreateCategory(as(1), &catalogpb.CreateCategoryRequest{Category: &catalogpb.CategoryInput{Title: "category3"}})
+	require.NoError(t, err)
+	_, err = c.DeleteProduct(as(1), &catalogpb.DeleteProductRequest{Slug: "p2"})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Equal(t, utils.CodeTooManyRequests, reason(t, err))
+	for _, d := range status.Convert(err).Details() {
+		if info, ok := d.(*errdetails.RetryInfo); ok {
+			assert.Greater(t, info.RetryDelay.AsDuration(), time.Duration(0))
+		}
+	}
+
+	// Reads are not counted, and other users have a quota of their own.
+	_, err = c.GetProduct(as(1), &catalogpb.GetProductRequest{Slug: "p2"})
+	assert.NoError(t, err)
+	_, err = c.DeleteProduct(as(2), &catalogpb.DeleteProductRequest{Slug: "p1"})
+	assert.NoError(t, err)
+}
+
+func TestCallsTimeOut(t *testing.T) {
+	t.Parallel()
+	var deadline time.Time
+	c := setup(t, grpc.ChainUnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		deadline, _ = ctx.Deadline()
+		return handler(ctx, req)
+	}))
+	start := time.Now()
+	_, err := c.GetProduct(context.Background(), &catalogpb.GetProductRequest{Slug: "p1"})
+	require.NoError(t, err)
+	assert.WithinDuration(t, start.Add(callTimeout), deadline, time.Second)
+
+	// A shorter deadline of the client is kept.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err = c.GetProduct(ctx, &catalogpb.GetProductRequest{Slug: "p1"})
+	require.NoError(t, err)
+	assert.WithinDuration(t, start.Add(time.Second), deadline, time.Second)
+}
+
+func TestCategories(t *testing.T) {
+	t.Parallel()
+	c := setup(t)
+	created, err := c.CreateCategory(as(1), &catalogpb.CreateCategoryRequest{Category: &catalogpb.CategoryInput{Title: "category3"}})
+	require.NoError(t, err)
+
+	_, err = c.CreateCategory(as(1), &catalogpb.CreateCategoryRequest{Category: &catalogpb.CategoryInput{Title: "category3"}})
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+
+	updated, err := c.UpdateCategory(as(1), &catalogpb.UpdateCategoryRequest{
+		Id:         created.Id,
+		Category:   &catalogpb.CategoryInput{Description: "described"},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"description"}},
+	})
+	if assert.NoError(t, err) {
+		assert.Equal(t, "category3", updated.Title)
+		assert.Equal(t, "described", updated.Description)
+	}
+
+	res, err := c.ListCategories(context.Background(), &catalogpb.ListCategoriesRequest{})
+	if assert.NoError(t, err) {
+		assert.EqualValues(t, 3, res.TotalCount)
+	}
+
+	stream, err := c.StreamCategories(context.Background(), &catalogpb.ListCategoriesRequest{Limit: 2})
+	require.NoError(t, err)
+	n := 0
+	for {
+		_, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		n++
+	}
+	assert.Equal(t, 2, n)
+
+	_, err = c.DeleteCategory(as(1), &catalogpb.DeleteCategoryRequest{Id: created.Id, Version: 1})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	_, err = c.DeleteCategory(as(1), &catalogpb.DeleteCategoryRequest{Id: created.Id})
+	assert.NoError(t, err)
+	_, err = c.GetCategory(context.Background(), &catalogpb.GetCategoryRequest{Id: created.Id})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
@@ -0,0 +1,52 @@
+package rpc
+
+import (
+	"github.com/sumitalp/productcatalog/models"
+	"github.com/sumitalp/productcatalog/rpc/catalogpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func newProduct(p *models.Product) *catalogpb.Product {
+	pb := &catalogpb.Product{
+		Slug:        p.Slug,
+		Title:       p.Title,
+		Description: p.Description,
+		Image:       p.Image,
+		Owner: &catalogpb.Owner{
+			Username: p.Owner.Username,
+		},
+		Categories: make([]*catalogpb.Category, 0, len(p.Categories)),
+		Version:    uint32(p.Version),
+		CreatedAt:  timestamppb.New(p.CreatedAt),
+		UpdatedAt:  timestamppb.New(p.UpdatedAt),
+	}
+	if p.Owner.Bio != nil {
+		pb.Owner.Bio = *p.Owner.Bio
+	}
+	if p.Owner.Image != nil {
+		pb.Owner.Image = *p.Owner.Image
+	}
+	for i := range p.Categories {
+		pb.Categories = append(pb.Categories, newCategory(&p.Categories[i]))
+	}
+	return pb
+}
+
+func newCategory(c *models.Category) *catalogpb.Category {
+	return &catalogpb.Category{
+		Id:          uint32(c.ID),
+		Title:       c.Category,
+		Description: c.Description,
+		Version:     uint32(c.Version),
+		CreatedAt:   timestamppb.New(c.CreatedAt),
+		UpdatedAt:   timestamppb.New(c.UpdatedAt),
+	}
+}
+
+func categories(names []string) []models.Category {
+	categories := make([]models.Category, 0, len(names))
+	for _, name := range names {
+		categories = append(categories, models.Category{Category: name})
+	}
+	return categories
+}
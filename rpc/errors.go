@@ -0,0 +1,96 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/labstack/gommon/log"
+	"github.com/sumitalp/productcatalog/utils"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"gopkg.in/go-playground/validator.v9"
+)
+
+// errorDomain names the service in the ErrorInfo details of a status.
+const errorDomain = "productcatalog"
+
+// status converts err into a gRPC status carrying the problem code the
+// REST API would have answered with as ErrorInfo reason, and the failed
+// fields of validation errors as BadRequest details.
+func (s *CatalogServer) status(ctx context.Context, err error) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	p := utils.NewError(err)
+	var ve validator.ValidationErrors
+	if errors.As(err, &ve) {
+		p = utils.NewValidatorError(ve, s.validator.Translator(acceptLanguage(ctx)))
+	}
+	if p.Status >= http.StatusInternalServerError {
+		log.Error(err)
+	}
+
+	msg := p.Detail
+	if msg == "" || p.Status >= http.StatusInternalServerError {
+		msg = p.Title
+	}
+	st := status.New(codeForStatus(p.Status), msg)
+	details := []proto.Message{&errdetails.ErrorInfo{Reason: p.Code, Domain: errorDomain}}
+	if len(p.Errors) > 0 {
+		br := &errdetails.BadRequest{}
+		for _, e := range p.Errors {
+			br.FieldViolations = append(br.FieldViolations, &errdetails.BadRequest_FieldViolation{
+				Field:       e.Field,
+				Description: e.Message,
+			})
+		}
+		details = append(details, br)
+	}
+	if withDetails, err := st.WithDetails(details...); err == nil {
+		st = withDetails
+	}
+	return st.Err()
+}
+
+func codeForStatus(s int) codes.Code {
+	switch s {
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusConflict:
+		return codes.AlreadyExists
+	case http.StatusPreconditionFailed, http.StatusPreconditionRequired:
+		return codes.FailedPrecondition
+	case http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case utils.StatusClientClosedRequest:
+		return codes.Canceled
+	case http.StatusGatewayTimeout:
+		return codes.DeadlineExceeded
+	case http.StatusServiceUnavailable:
+		return codes.Unavailable
+	}
+	if s >= http.StatusInternalServerError {
+		return codes.Internal
+	}
+	return codes.Unknown
+}
+
+// acceptLanguage returns the accept-language metadata of the call, used
+// to translate validation messages.
+func acceptLanguage(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if v := md.Get("accept-language"); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
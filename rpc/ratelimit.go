@@ -0,0 +1,67 @@
+package rpc
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/sumitalp/productcatalog/ratelimit"
+	"github.com/sumitalp/productcatalog/router/middleware"
+	"github.com/sumitalp/productcatalog/utils"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// UnaryRateLimit counts the calls creating, updating or deleting records
+// against l, per caller as middleware.KeyByUser counts the writes of the
+// REST API, so that the APIs given the same limiter share the quota. Calls
+// over it fail with ResourceExhausted, carrying the delay to wait in
+// RetryInfo. UnaryAuth must run first.
+func UnaryRateLimit(l *ratelimit.Limiter) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if !isWrite(info.FullMethod) {
+			return handler(ctx, req)
+		}
+		r := l.Allow(rateLimitKey(ctx))
+		if r.Allowed {
+			return handler(ctx, req)
+		}
+		st := status.New(codes.ResourceExhausted, "rate limit exceeded")
+		if withDetails, err := st.WithDetails(
+			&errdetails.ErrorInfo{Reason: utils.CodeTooManyRequests, Domain: errorDomain},
+			&errdetails.RetryInfo{RetryDelay: durationpb.New(r.RetryAfter)},
+		); err == nil {
+			st = withDetails
+		}
+		return nil, st.Err()
+	}
+}
+
+// isWrite reports whether the method named "/<service>/<method>" creates,
+// updates or deletes records.
+func isWrite(fullMethod string) bool {
+	method := fullMethod[strings.LastIndex(fullMethod, "/")+1:]
+	for _, prefix := range []string{"Create", "Update", "Delete"} {
+		if strings.HasPrefix(method, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// rateLimitKey identifies the caller with the keys of
+// middleware.KeyByUser: the authenticated user, or the address of the peer
+// for anonymous calls.
+func rateLimitKey(ctx context.Context) string {
+	if id, ok := ctx.Value(userIDKey{}).(uint); ok && id != 0 {
+		return "user:" + strconv.FormatUint(uint64(id), 10)
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		return "ip:" + middleware.ClientIP(p.Addr.String(), nil)
+	}
+	return "ip:"
+}
@@ -0,0 +1,72 @@
+// Package rpc serves the catalog over gRPC for internal services, on top of
+// the same services as the REST and GraphQL APIs.
+package rpc
+
+import (
+	"context"
+	"time"
+
+	"github.com/sumitalp/productcatalog/product"
+	"github.com/sumitalp/productcatalog/router"
+	"github.com/sumitalp/productcatalog/router/middleware"
+	"github.com/sumitalp/productcatalog/rpc/catalogpb"
+	"github.com/sumitalp/productcatalog/utils"
+	"google.golang.org/grpc"
+)
+
+const (
+	defaultLimit = 20
+	// streamPageSize is the number of rows a streaming call loads at once.
+	streamPageSize = 100
+	// callTimeout bounds unary calls which the client gave no shorter
+	// deadline, like the writes of the REST API.
+	callTimeout = 10 * time.Second
+)
+
+// NewServer returns a gRPC server which authenticates calls with the JWT
+// of the REST API, checked by validator if it is not nil. Unary calls time
+// out after callTimeout, token validation included.
+func NewServer(validator middleware.TokenValidator, opts ...grpc.ServerOption) *grpc.Server {
+	opts = append([]grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(UnaryTimeout(callTimeout), UnaryAuth(utils.JWTSecret, validator)),
+		grpc.ChainStreamInterceptor(StreamAuth(utils.JWTSecret, validator)),
+	}, opts...)
+	return grpc.NewServer(opts...)
+}
+
+// CatalogServer implements catalogpb.CatalogServiceServer.
+type CatalogServer struct {
+	catalogpb.UnimplementedCatalogServiceServer
+
+	productService *product.Service
+	validator      *router.Validator
+}
+
+func NewCatalogServer(ps *product.Service) *CatalogServer {
+	return &CatalogServer{
+		productService: ps,
+		validator:      router.NewValidator(),
+	}
+}
+
+// Register adds the catalog service to s.
+func (s *CatalogServer) Register(gs *grpc.Server) {
+	catalogpb.RegisterCatalogServiceServer(gs, s)
+}
+
+// Shutdown stops s gracefully, letting the running calls finish, and
+// stops it hard once ctx is done.
+func Shutdown(ctx context.Context, s *grpc.Server) error {
+	done := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.Stop()
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,20 @@
+package rpc
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// UnaryTimeout bounds the context of unary calls with a deadline of d, as
+// middleware.Timeout does for the REST API. A shorter deadline set by the
+// client still applies; repository calls are interrupted when it expires
+// and the call fails with DeadlineExceeded.
+func UnaryTimeout(d time.Duration) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, cancel := context.WithTimeout(ctx, d)
+		defer cancel()
+		return handler(ctx, req)
+	}
+}
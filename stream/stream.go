@@ -0,0 +1,228 @@
+// Package stream fans the change events of the catalog out to live
+// clients, keeping a bounded log of recent events so that clients which
+// reconnect can resume where they left off.
+package stream
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sumitalp/productcatalog/event"
+)
+
+// Config tunes a Broker.
+type Config struct {
+	// LogSize is the number of recent events kept for resuming clients.
+	LogSize int
+	// Buffer is the number of events a subscriber may lag behind before
+	// it is dropped.
+	Buffer int
+}
+
+var DefaultConfig = Config{
+	LogSize: 1000,
+	Buffer:  64,
+}
+
+// Message is an event as numbered by a Broker.
+type Message struct {
+	// ID identifies the message for resuming, as the SSE event id.
+	ID    string
+	Event *event.Event
+
+	seq        uint64
+	categories []string
+	owner      string
+}
+
+// Filter selects the messages a subscriber receives. Empty fields match
+// everything.
+type Filter struct {
+	// Category selects the events of the category with this title and of
+	// the products in it.
+	Category string
+	// Owner selects the events of the products of the user with this
+	// username. Category events have no owner.
+	Owner string
+}
+
+// Match reports whether m passes f.
+func (f Filter) Match(m *Message) bool {
+	if f.Owner != "" && m.owner != f.Owner {
+		return false
+	}
+	if f.Category != "" {
+		for _, c := range m.categories {
+			if c == f.Category {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// Broker is an event.Publisher handing events to its subscribers.
+type Broker struct {
+	config Config
+	// epoch prefixes message ids so that ids issued before a restart are
+	// recognised as unknown.
+	epoch string
+
+	mu          sync.Mutex
+	seq         uint64
+	log         []*Message
+	seen        map[string]bool
+	subscribers map[*Subscription]bool
+	closed      bool
+}
+
+func NewBroker() *Broker {
+	return NewBrokerWithConfig(DefaultConfig)
+}
+
+func NewBrokerWithConfig(config Config) *Broker {
+	if config.LogSize <= 0 {
+		config.LogSize = DefaultConfig.LogSize
+	}
+	if config.Buffer <= 0 {
+		config.Buffer = DefaultConfig.Buffer
+	}
+	return &Broker{
+		config:      config,
+		epoch:       strconv.FormatInt(time.Now().UnixNano(), 36),
+		seen:        make(map[string]bool),
+		subscribers: make(map[*Subscription]bool),
+	}
+}
+
+// Publish logs e and hands it to the matching subscribers. Events already
+// in the log are ignored, since the outbox may deliver an event twice.
+// Subscribers too slow to keep up are dropped.
+func (b *Broker) Publish(ctx context.Context, e *event.Event) error {
+	var data struct {
+		Title        string   `json:"title"`
+		CategoryList []string `json:"categoryList"`
+		Owner        string   `json:"owner"`
+	}
+	if err := e.Decode(&data); err != nil {
+		return err
+	}
+	m := &Message{Event: e, owner: data.Owner, categories: data.CategoryList}
+	if strings.HasPrefix(e.Type, "category.") {
+		m.categories = []string{data.Title}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.seen[e.ID] {
+		return nil
+	}
+	b.seq++
+	m.seq = b.seq
+	m.ID = fmt.Sprintf("%s-%d", b.epoch, m.seq)
+	b.log = append(b.log, m)
+	b.seen[e.ID] = true
+	if len(b.log) > b.config.LogSize {
+		delete(b.seen, b.log[0].Event.ID)
+		b.log[0] = nil
+		b.log = b.log[1:]
+	}
+	for s := range b.subscribers {
+		if !s.filter.Match(m) {
+			continue
+		}
+		select {
+		case s.c <- m:
+		default:
+			b.drop(s)
+		}
+	}
+	return nil
+}
+
+// Subscription receives the messages matching its filter on C, which is
+// closed when the subscription is dropped for falling behind, or closed
+// itself or with its broker.
+type Subscription struct {
+	C <-chan *Message
+	// Start is the id of the last message logged when the subscription
+	// started, if any.
+	Start  string
+	c      chan *Message
+	filter Filter
+	broker *Broker
+}
+
+// Subscribe starts a subscription. When lastID is not empty the messages
+// logged after it are returned too, to be sent before those arriving on
+// the subscription; complete is false when some of them are gone, because
+// lastID is too old or comes from another process, and then none are
+// returned.
+func (b *Broker) Subscribe(filter Filter, lastID string) (s *Subscription, backlog []*Message, complete bool) {
+	c := make(chan *Message, b.config.Buffer)
+	s = &Subscription{C: c, c: c, filter: filter, broker: b}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		close(c)
+		return s, nil, true
+	}
+	b.subscribers[s] = true
+	if len(b.log) > 0 {
+		s.Start = b.log[len(b.log)-1].ID
+	}
+	if lastID == "" {
+		return s, nil, true
+	}
+	seq, ok := b.parseID(lastID)
+	if !ok || seq > b.seq || (len(b.log) > 0 && seq+1 < b.log[0].seq) {
+		return s, nil, false
+	}
+	for _, m := range b.log {
+		if m.seq > seq && filter.Match(m) {
+			backlog = append(backlog, m)
+		}
+	}
+	return s, backlog, true
+}
+
+func (b *Broker) parseID(id string) (uint64, bool) {
+	i := strings.LastIndex(id, "-")
+	if i < 0 || id[:i] != b.epoch {
+		return 0, false
+	}
+	seq, err := strconv.ParseUint(id[i+1:], 10, 64)
+	return seq, err == nil
+}
+
+// Close ends the subscription.
+func (s *Subscription) Close() {
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+	s.broker.drop(s)
+}
+
+// Close ends every subscription, and those started afterwards right away,
+// so that the clients of streams disconnect when the process shuts down.
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for s := range b.subscribers {
+		b.drop(s)
+	}
+}
+
+// drop removes s, closing its channel. Callers hold b.mu.
+func (b *Broker) drop(s *Subscription) {
+	if b.subscribers[s] {
+		delete(b.subscribers, s)
+		close(s.c)
+	}
+}
@@ -0,0 +1,136 @@
+package stream_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/sumitalp/productcatalog/event"
+	"github.com/sumitalp/productcatalog/stream"
+)
+
+func productEvent(typ, owner string, categories ...string) *event.Event {
+	return event.New(typ, &event.Product{Slug: "p", Owner: owner, CategoryList: categories})
+}
+
+func categoryEvent(typ, title string) *event.Event {
+	return event.New(typ, &event.Category{Title: title})
+}
+
+func publish(t *testing.T, b *stream.Broker, events ...*event.Event) {
+	for _, e := range events {
+		require.NoError(t, b.Publish(context.Background(), e))
+	}
+}
+
+// receive returns the events waiting on s.
+func receive(s *stream.Subscription) []*event.Event {
+	var events []*event.Event
+	for {
+		select {
+		case m, ok := <-s.C:
+			if !ok {
+				return events
+			}
+			events = append(events, m.Event)
+		default:
+			return events
+		}
+	}
+}
+
+func TestFilters(t *testing.T) {
+	b := stream.NewBroker()
+	all, _, _ := b.Subscribe(stream.Filter{}, "")
+	books, _, _ := b.Subscribe(stream.Filter{Category: "books"}, "")
+	alice, _, _ := b.Subscribe(stream.Filter{Owner: "alice"}, "")
+	aliceBooks, _, _ := b.Subscribe(stream.Filter{Owner: "alice", Category: "books"}, "")
+
+	e1 := productEvent(event.ProductCreated, "alice", "books", "music")
+	e2 := productEvent(event.ProductCreated, "bob", "books")
+	e3 := productEvent(event.ProductUpdated, "alice")
+	e4 := categoryEvent(event.CategoryDeleted, "books")
+	publish(t, b, e1, e2, e3, e4)
+
+	assert.Equal(t, []*event.Event{e1, e2, e3, e4}, receive(all))
+	assert.Equal(t, []*event.Event{e1, e2, e4}, receive(books))
+	assert.Equal(t, []*event.Event{e1, e3}, receive(alice))
+	assert.Equal(t, []*event.Event{e1}, receive(aliceBooks))
+}
+
+func TestResume(t *testing.T) {
+	b := stream.NewBrokerWithConfig(stream.Config{LogSize: 3})
+	s, _, _ := b.Subscribe(stream.Filter{}, "")
+	events := []*event.Event{
+		productEvent(event.ProductCreated, "alice", "books"),
+		productEvent(event.ProductCreated, "bob"),
+		productEvent(event.ProductCreated, "alice"),
+	}
+	publish(t, b, events...)
+	var ids []string
+	for range events {
+		ids = append(ids, (<-s.C).ID)
+	}
+	s.Close()
+
+	s, backlog, complete := b.Subscribe(stream.Filter{Owner: "alice"}, ids[0])
+	assert.True(t, complete)
+	require.Len(t, backlog, 1)
+	assert.Equal(t, events[2], backlog[0].Event)
+	assert.Equal(t, ids[2], s.Start)
+	s.Close()
+
+	_, backlog, complete = b.Subscribe(stream.Filter{}, ids[2])
+	assert.True(t, complete)
+	assert.Empty(t, backlog)
+
+	// Once the log has moved past an id, or for ids of another broker,
+	// the client has to start over.
+	publish(t, b, productEvent(event.ProductDeleted, "alice"), productEvent(event.ProductDeleted, "bob"))
+	_, backlog, complete = b.Subscribe(stream.Filter{}, ids[0])
+	assert.False(t, complete)
+	assert.Empty(t, backlog)
+	_, backlog, complete = b.Subscribe(stream.Filter{}, ids[1])
+	assert.True(t, complete)
+	assert.Len(t, backlog, 3)
+	_, _, complete = b.Subscribe(stream.Filter{}, "0-1")
+	assert.False(t, complete)
+	_, _, complete = b.Subscribe(stream.Filter{}, "garbage")
+	assert.False(t, complete)
+}
+
+func TestDuplicatesAreIgnored(t *testing.T) {
+	b := stream.NewBroker()
+	s, _, _ := b.Subscribe(stream.Filter{}, "")
+	e := productEvent(event.ProductCreated, "alice")
+	publish(t, b, e, e)
+	assert.Len(t, receive(s), 1)
+}
+
+func TestSlowSubscribersAreDropped(t *testing.T) {
+	b := stream.NewBrokerWithConfig(stream.Config{Buffer: 2})
+	slow, _, _ := b.Subscribe(stream.Filter{}, "")
+	for i := 0; i < 3; i++ {
+		publish(t, b, productEvent(event.ProductCreated, "alice"))
+	}
+	assert.Len(t, receive(slow), 2)
+	_, ok := <-slow.C
+	assert.False(t, ok, "the channel is closed")
+	slow.Close()
+}
+
+func TestClose(t *testing.T) {
+	b := stream.NewBroker()
+	s, _, _ := b.Subscribe(stream.Filter{}, "")
+	b.Close()
+	_, ok := <-s.C
+	assert.False(t, ok, "the channel is closed")
+	s.Close()
+
+	s, _, _ = b.Subscribe(stream.Filter{}, "")
+	_, ok = <-s.C
+	assert.False(t, ok, "subscriptions started after Close end right away")
+	s.Close()
+	publish(t, b, productEvent(event.ProductCreated, "alice"))
+}
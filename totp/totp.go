@@ -0,0 +1,114 @@
+// Package totp implements the time-based one-time passwords of RFC 6238,
+// as generated by authenticator apps: six digits from HMAC-SHA1 over
+// 30-second steps.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// Period is the time a code is valid for.
+	Period = 30 * time.Second
+	// Digits is the length of a code.
+	Digits = 6
+	// Skew is the number of steps a code may be early or late by, for
+	// clocks out of sync and codes typed at the end of their step.
+	Skew = 1
+)
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret returns a new random secret, base32 encoded as
+// authenticator apps expect it.
+func GenerateSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(b), nil
+}
+
+// Step returns the step t falls in.
+func Step(t time.Time) int64 {
+	return t.Unix() / int64(Period/time.Second)
+}
+
+// Code returns the code of secret at t.
+func Code(secret string, t time.Time) (string, error) {
+	key, err := decode(secret)
+	if err != nil {
+		return "", err
+	}
+	return code(key, uint64(Step(t)), Digits), nil
+}
+
+// Validate checks code against secret at t, within Skew steps. Only steps
+// after last are accepted, so that a code cannot be replayed once the
+// step it matched is recorded. It returns the step matched.
+func Validate(secret, code string, t time.Time, last int64) (int64, bool) {
+	key, err := decode(secret)
+	if err != nil || len(code) != Digits {
+		return 0, false
+	}
+	now := Step(t)
+	for step := now - Skew; step <= now+Skew; step++ {
+		if step <= last {
+			continue
+		}
+		want := codeAt(key, step)
+		if subtle.ConstantTimeCompare([]byte(want), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// URI returns the otpauth URI provisioning secret for account in an
+// authenticator app, usually shown as a QR code.
+func URI(issuer, account, secret string) string {
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	q := url.Values{}
+	q.Set("secret", secret)
+	q.Set("issuer", issuer)
+	q.Set("algorithm", "SHA1")
+	q.Set("digits", fmt.Sprint(Digits))
+	q.Set("period", fmt.Sprint(int(Period/time.Second)))
+	return "otpauth://totp/" + label + "?" + q.Encode()
+}
+
+func decode(secret string) ([]byte, error) {
+	return encoding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+}
+
+func codeAt(key []byte, step int64) string {
+	if step < 0 {
+		return ""
+	}
+	return code(key, uint64(step), Digits)
+}
+
+// code is the HOTP of RFC 4226 for counter.
+func code(key []byte, counter uint64, digits int) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], counter)
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0xf
+	n := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	mod := uint32(1)
+	for i := 0; i < digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", digits, n%mod)
+}
@@ -0,0 +1,61 @@
+package totp
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCodeRFC6238(t *testing.T) {
+	key := []byte("12345678901234567890")
+	for unix, want := range map[int64]string{
+		59:          "94287082",
+		1111111109:  "07081804",
+		1111111111:  "14050471",
+		1234567890:  "89005924",
+		2000000000:  "69279037",
+		20000000000: "65353130",
+	} {
+		assert.Equal(t, want, code(key, uint64(Step(time.Unix(unix, 0))), 8), unix)
+	}
+	secret := encoding.EncodeToString(key)
+	c, err := Code(secret, time.Unix(59, 0))
+	require.NoError(t, err)
+	assert.Equal(t, "287082", c)
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := GenerateSecret()
+	require.NoError(t, err)
+	now := time.Now()
+	c, err := Code(secret, now)
+	require.NoError(t, err)
+
+	step, ok := Validate(secret, c, now, 0)
+	assert.True(t, ok)
+	assert.Equal(t, Step(now), step)
+	_, ok = Validate(secret, c, now, step)
+	assert.False(t, ok, "replayed")
+	_, ok = Validate(secret, c, now.Add(Period), 0)
+	assert.True(t, ok, "a step late")
+	_, ok = Validate(secret, c, now.Add(3*Period), 0)
+	assert.False(t, ok, "expired")
+	_, ok = Validate(secret, "000000x", now, 0)
+	assert.False(t, ok)
+	_, ok = Validate("not base32!", c, now, 0)
+	assert.False(t, ok)
+}
+
+func TestURI(t *testing.T) {
+	u, err := url.Parse(URI("Product Catalog", "alice@email.io", "JBSWY3DPEHPK3PXP"))
+	require.NoError(t, err)
+	assert.Equal(t, "otpauth", u.Scheme)
+	assert.Equal(t, "totp", u.Host)
+	assert.Equal(t, "/Product Catalog:alice@email.io", u.Path)
+	assert.Equal(t, "JBSWY3DPEHPK3PXP", u.Query().Get("secret"))
+	assert.Equal(t, "Product Catalog", u.Query().Get("issuer"))
+	assert.Equal(t, "6", u.Query().Get("digits"))
+}
@@ -0,0 +1,85 @@
+// Package tracing sets up the OpenTelemetry tracer provider the HTTP
+// middleware and the database layer report their spans to.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+)
+
+// Exporters accepted by Config.
+const (
+	ExporterNone   = "none"
+	ExporterOTLP   = "otlp"
+	ExporterStdout = "stdout"
+)
+
+// Config selects where spans are exported.
+type Config struct {
+	// Exporter is ExporterOTLP, ExporterStdout, or ExporterNone (or empty)
+	// to disable tracing.
+	Exporter string
+	// ServiceName names the service in the exported spans.
+	ServiceName string
+	// Writer receives the spans of the stdout exporter, os.Stdout if nil.
+	Writer io.Writer
+}
+
+// ConfigFromEnv reads the exporter from OTEL_TRACES_EXPORTER. The OTLP
+// exporter itself reads its endpoint and options from the standard
+// OTEL_EXPORTER_OTLP_* variables.
+func ConfigFromEnv(serviceName string) Config {
+	return Config{
+		Exporter:    os.Getenv("OTEL_TRACES_EXPORTER"),
+		ServiceName: serviceName,
+	}
+}
+
+// Setup installs the W3C trace-context propagator and, unless tracing is
+// disabled, a tracer provider exporting as configured. The returned
+// function flushes the pending spans and stops the provider.
+func Setup(ctx context.Context, config Config) (shutdown func(context.Context) error, err error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	switch config.Exporter {
+	case "", ExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case ExporterOTLP:
+		exporter, err = otlptracegrpc.New(ctx)
+	case ExporterStdout:
+		w := config.Writer
+		if w == nil {
+			w = os.Stdout
+		}
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(w))
+	default:
+		return nil, fmt.Errorf("tracing: unknown exporter %q", config.Exporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceNameKey.String(config.ServiceName),
+	))
+	if err != nil {
+		return nil, err
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(tp)
+	return tp.Shutdown, nil
+}
@@ -0,0 +1,37 @@
+package tracing_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/sumitalp/productcatalog/tracing"
+	"go.opentelemetry.io/otel"
+)
+
+func TestSetup(t *testing.T) {
+	tp := otel.GetTracerProvider()
+	defer otel.SetTracerProvider(tp)
+
+	var buf bytes.Buffer
+	shutdown, err := tracing.Setup(context.Background(), tracing.Config{
+		Exporter:    tracing.ExporterStdout,
+		ServiceName: "catalog",
+		Writer:      &buf,
+	})
+	require.NoError(t, err)
+	_, span := otel.Tracer("test").Start(context.Background(), "work")
+	span.End()
+	require.NoError(t, shutdown(context.Background()))
+	assert.Contains(t, buf.String(), `"Name":"work"`)
+	assert.Contains(t, buf.String(), "catalog")
+
+	shutdown, err = tracing.Setup(context.Background(), tracing.Config{})
+	require.NoError(t, err)
+	assert.NoError(t, shutdown(context.Background()))
+
+	_, err = tracing.Setup(context.Background(), tracing.Config{Exporter: "jaeger"})
+	assert.EqualError(t, err, `tracing: unknown exporter "jaeger"`)
+}
@@ -0,0 +1,51 @@
+package uow
+
+import (
+	"context"
+)
+
+// UnitOfWork groups repository operations so they succeed or fail together.
+type UnitOfWork interface {
+	// Do runs fn inside a transaction. Repositories bound to the context
+	// passed to fn (through WithContext) take part in the transaction,
+	// which is committed when fn returns nil and rolled back when it
+	// returns an error or panics. Nested calls join the outer transaction.
+	Do(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+type hooksKey struct{}
+
+type hooks struct {
+	fns []func()
+}
+
+// AfterCommit arranges for fn to run once the unit of work ctx belongs to
+// has committed. It is dropped if the unit of work rolls back. Outside of
+// a unit of work fn runs right away.
+func AfterCommit(ctx context.Context, fn func()) {
+	if h, ok := ctx.Value(hooksKey{}).(*hooks); ok {
+		h.fns = append(h.fns, fn)
+		return
+	}
+	fn()
+}
+
+// Active reports whether ctx is the context of a unit of work, whose
+// writes other connections may not see yet.
+func Active(ctx context.Context) bool {
+	_, ok := ctx.Value(hooksKey{}).(*hooks)
+	return ok
+}
+
+// WithCommitHooks returns a copy of ctx collecting the functions given to
+// AfterCommit, and a function running them. Implementations of UnitOfWork
+// call it when they start an outermost transaction and run the hooks once
+// it has committed.
+func WithCommitHooks(ctx context.Context) (context.Context, func()) {
+	h := &hooks{}
+	return context.WithValue(ctx, hooksKey{}, h), func() {
+		for _, fn := range h.fns {
+			fn()
+		}
+	}
+}
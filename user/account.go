@@ -0,0 +1,211 @@
+package user
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/labstack/gommon/log"
+	"github.com/sumitalp/productcatalog/mail"
+	"github.com/sumitalp/productcatalog/models"
+	"github.com/sumitalp/productcatalog/uow"
+	"github.com/sumitalp/productcatalog/utils"
+)
+
+// Lifetimes of the tokens mailed to users.
+const (
+	VerifyEmailTTL   = 48 * time.Hour
+	ResetPasswordTTL = time.Hour
+)
+
+// Pages of the front end the mailed links open, with the token in their
+// query string.
+const (
+	VerifyEmailPath   = "/verify-email"
+	ResetPasswordPath = "/reset-password"
+)
+
+func errInvalidToken() error {
+	return utils.NewProblem(http.StatusBadRequest, utils.CodeInvalidToken, "the token is invalid, expired or already used")
+}
+
+// VerifyEmail marks the email address the token was mailed to verified.
+func (s *Service) VerifyEmail(ctx context.Context, token string) error {
+	return s.uow.Do(ctx, func(ctx context.Context) error {
+		repo := s.users.WithContext(ctx)
+		now := time.Now()
+		t, err := repo.ConsumeToken(models.TokenVerifyEmail, hashToken(token), now)
+		if err != nil {
+			return err
+		}
+		if t == nil {
+			return errInvalidToken()
+		}
+		u, err := repo.GetByID(t.UserID)
+		if err != nil {
+			return err
+		}
+		if u == nil || u.Email != t.Email {
+			return errInvalidToken()
+		}
+		if err := repo.RevokeTokens(u.ID, models.TokenVerifyEmail); err != nil {
+			return err
+		}
+		return repo.SetEmailVerified(u.ID, &now)
+	})
+}
+
+// ResendVerification mails the user with the given id a new link to
+// verify their email address, and revokes the previous ones.
+func (s *Service) ResendVerification(ctx context.Context, id uint) error {
+	return s.uow.Do(ctx, func(ctx context.Context) error {
+		u, err := s.Get(ctx, id)
+		if err != nil {
+			return err
+		}
+		if u.EmailVerifiedAt != nil {
+			return utils.NewProblem(http.StatusConflict, utils.CodeConflict, "the email address is already verified")
+		}
+		if err := s.users.WithContext(ctx).RevokeTokens(u.ID, models.TokenVerifyEmail); err != nil {
+			return err
+		}
+		return s.sendVerification(ctx, u)
+	})
+}
+
+// ForgotPassword mails the user with the given email address a link to
+// reset their password, and revokes the previous ones. It succeeds
+// whether the account exists or not, so that it cannot be used to find
+// accounts.
+func (s *Service) ForgotPassword(ctx context.Context, email string) error {
+	return s.uow.Do(ctx, func(ctx context.Context) error {
+		repo := s.users.WithContext(ctx)
+		u, err := repo.GetByEmail(email)
+		if err != nil || u == nil {
+			return err
+		}
+		if err := repo.RevokeTokens(u.ID, models.TokenResetPassword); err != nil {
+			return err
+		}
+		token, err := s.createToken(ctx, u, models.TokenResetPassword, ResetPasswordTTL)
+		if err != nil {
+			return err
+		}
+		return s.send(ctx, mail.ResetPassword, u, ResetPasswordPath, token, ResetPasswordTTL)
+	})
+}
+
+// ResetPassword sets the password of the user the token was mailed to.
+// The other reset links and the sessions of the user are revoked, failed
+// logins forgotten, and the email address is verified if it is still the
+// one the token was mailed to.
+func (s *Service) ResetPassword(ctx context.Context, token, password string) error {
+	var u *models.User
+	err := s.uow.Do(ctx, func(ctx context.Context) error {
+		repo := s.users.WithContext(ctx)
+		now := time.Now()
+		t, err := repo.ConsumeToken(models.TokenResetPassword, hashToken(token), now)
+		if err != nil {
+			return err
+		}
+		if t == nil {
+			return errInvalidToken()
+		}
+		if u, err = repo.GetByID(t.UserID); err != nil {
+			return err
+		}
+		if u == nil {
+			return errInvalidToken()
+		}
+		if u.Password, err = u.HashPassword(password); err != nil {
+			return err
+		}
+		u.TokenGeneration++
+		if err := repo.Update(u); err != nil {
+			return err
+		}
+		if err := repo.RevokeTokens(u.ID, models.TokenResetPassword); err != nil {
+			return err
+		}
+		if u.EmailVerifiedAt == nil && u.Email == t.Email {
+			return repo.SetEmailVerified(u.ID, &now)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.lockout.Succeed(lockoutKey(u.Email))
+	return nil
+}
+
+// sendVerification mails u a link to verify their email address once ctx
+// commits.
+func (s *Service) sendVerification(ctx context.Context, u *models.User) error {
+	token, err := s.createToken(ctx, u, models.TokenVerifyEmail, VerifyEmailTTL)
+	if err != nil {
+		return err
+	}
+	return s.send(ctx, mail.VerifyEmail, u, VerifyEmailPath, token, VerifyEmailTTL)
+}
+
+// createToken stores a new token of u for purpose and returns its secret.
+func (s *Service) createToken(ctx context.Context, u *models.User, purpose string, ttl time.Duration) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	err := s.users.WithContext(ctx).CreateToken(&models.UserToken{
+		UserID:    u.ID,
+		Purpose:   purpose,
+		Hash:      hashToken(token),
+		Email:     u.Email,
+		ExpiresAt: time.Now().Add(ttl),
+	})
+	return token, err
+}
+
+// send renders t for u with a link to path carrying token, and sends it
+// once ctx commits. Failures to send are logged: the user can ask again.
+func (s *Service) send(ctx context.Context, t *mail.Template, u *models.User, path, token string, ttl time.Duration) error {
+	m, err := t.Render(u.Email, mail.LinkData{
+		Username: u.Username,
+		Link:     s.baseURL + path + "?token=" + url.QueryEscape(token),
+		ValidFor: describe(ttl),
+	})
+	if err != nil {
+		return err
+	}
+	uow.AfterCommit(ctx, func() {
+		if err := s.mailer.Send(context.Background(), m); err != nil {
+			log.Errorj(log.JSON{"message": "sending mail", "subject": m.Subject, "error": err.Error()})
+		}
+	})
+	return nil
+}
+
+// hashToken returns what is stored of a token: a leaked table does not
+// let anyone use the tokens it holds.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// describe writes d in whole hours or minutes for the emails.
+func describe(d time.Duration) string {
+	n, unit := int(d/time.Minute), "minute"
+	if d >= time.Hour && d%time.Hour == 0 {
+		n, unit = int(d/time.Hour), "hour"
+	}
+	if n != 1 {
+		unit += "s"
+	}
+	return fmt.Sprintf("%d %s", n, unit)
+}
@@ -0,0 +1,239 @@
+package user
+
+import (
+	"context"
+	"os"
+	"strings"
+
+	"github.com/sumitalp/productcatalog/mail"
+	"github.com/sumitalp/productcatalog/models"
+	"github.com/sumitalp/productcatalog/ratelimit"
+	"github.com/sumitalp/productcatalog/uow"
+	"github.com/sumitalp/productcatalog/utils"
+)
+
+// Service implements the account rules shared by every front end.
+type Service struct {
+	users    RepositoryInterface
+	uow      uow.UnitOfWork
+	onSignUp []func(*models.User)
+	lockout  *ratelimit.Lockout
+	mailer   mail.Mailer
+	baseURL  string
+	// twoFactorRoles are the roles whose users must enable two-factor
+	// authentication.
+	twoFactorRoles []string
+}
+
+func NewService(users RepositoryInterface, uow uow.UnitOfWork) *Service {
+	return &Service{
+		users:          users,
+		uow:            uow,
+		lockout:        ratelimit.NewLockout(),
+		mailer:         mail.NewLog(),
+		baseURL:        mail.DefaultConfig.BaseURL,
+		twoFactorRoles: DefaultTwoFactorRoles,
+	}
+}
+
+// DefaultTwoFactorRoles are the roles whose users must enable two-factor
+// authentication unless RequireTwoFactor says otherwise.
+var DefaultTwoFactorRoles = []string{models.RoleAdmin}
+
+// TwoFactorRolesFromEnv returns the roles listed, separated by commas, in
+// TWO_FACTOR_REQUIRED_ROLES, and whether it is set.
+func TwoFactorRolesFromEnv() ([]string, bool) {
+	v, ok := os.LookupEnv("TWO_FACTOR_REQUIRED_ROLES")
+	if !ok {
+		return nil, false
+	}
+	var roles []string
+	for _, r := range strings.Split(v, ",") {
+		if r = strings.TrimSpace(r); r != "" {
+			roles = append(roles, r)
+		}
+	}
+	return roles, true
+}
+
+// MailWith makes the service send its emails with m, linking to pages
+// under baseURL. By default they are dropped, as mail.Log does.
+func (s *Service) MailWith(m mail.Mailer, baseURL string) {
+	s.mailer = m
+	s.baseURL = baseURL
+}
+
+// LimitLogins replaces the lockout slowing down and then refusing the
+// logins to an account after failed attempts.
+func (s *Service) LimitLogins(l *ratelimit.Lockout) {
+	s.lockout = l
+}
+
+// RequireTwoFactor replaces the roles whose users must enable two-factor
+// authentication: they cannot log in, nor use the tokens issued to them,
+// until they do.
+func (s *Service) RequireTwoFactor(roles ...string) {
+	s.twoFactorRoles = roles
+}
+
+// twoFactorRequired reports whether u must enable two-factor
+// authentication before logging in.
+func (s *Service) twoFactorRequired(u *models.User) bool {
+	if u.Role == "" {
+		return false
+	}
+	for _, r := range s.twoFactorRoles {
+		if r == u.Role {
+			return true
+		}
+	}
+	return false
+}
+
+// OnSignUp makes SignUp call fn with every user created, once the user is
+// committed.
+func (s *Service) OnSignUp(fn func(*models.User)) {
+	s.onSignUp = append(s.onSignUp, fn)
+}
+
+// SignUp creates u with password stored as a bcrypt hash, and mails u a
+// link to verify their email address.
+func (s *Service) SignUp(ctx context.Context, u *models.User, password string) error {
+	h, err := u.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	u.Password = h
+	return s.uow.Do(ctx, func(ctx context.Context) error {
+		if err := s.users.WithContext(ctx).Create(u); err != nil {
+			return err
+		}
+		if err := s.sendVerification(ctx, u); err != nil {
+			return err
+		}
+		for _, fn := range s.onSignUp {
+			fn := fn
+			uow.AfterCommit(ctx, func() { fn(u) })
+		}
+		return nil
+	})
+}
+
+// Login returns the user identified by email and password, or
+// utils.ErrInvalidCredentials. After failed attempts, further ones are
+// refused for a while with a 429 problem, whether the account exists or
+// not. For users with two-factor authentication enabled, Login returns no
+// user but a challenge to pass to LoginTwoFactor with a code. Users who
+// must enable it and have not are refused with a 403 problem.
+func (s *Service) Login(ctx context.Context, email, password string) (*models.User, string, error) {
+	u, err := s.checkPassword(ctx, email, password)
+	if err != nil {
+		return nil, "", err
+	}
+	if u.TwoFactorEnabled() {
+		challenge, err := s.createToken(ctx, u, models.TokenLoginChallenge, LoginChallengeTTL)
+		return nil, challenge, err
+	}
+	if s.twoFactorRequired(u) {
+		return nil, "", errTwoFactorRequired()
+	}
+	s.lockout.Succeed(lockoutKey(email))
+	return u, "", nil
+}
+
+// checkPassword returns the user identified by email and password, or
+// utils.ErrInvalidCredentials, counting failures against the lockout.
+func (s *Service) checkPassword(ctx context.Context, email, password string) (*models.User, error) {
+	key := lockoutKey(email)
+	if wait := s.lockout.Wait(key); wait > 0 {
+		return nil, utils.TooManyRequests("too many failed login attempts", wait)
+	}
+	u, err := s.users.WithContext(ctx).GetByEmail(email)
+	if err != nil {
+		return nil, err
+	}
+	if u == nil || !u.CheckPassword(password) {
+		s.lockout.Fail(key)
+		return nil, utils.ErrInvalidCredentials
+	}
+	return u, nil
+}
+
+// lockoutKey returns the key failed logins to the account with the given
+// email address are counted under.
+func lockoutKey(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// Get returns the user with the given id, or utils.ErrNotFound.
+func (s *Service) Get(ctx context.Context, id uint) (*models.User, error) {
+	u, err := s.users.WithContext(ctx).GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if u == nil {
+		return nil, utils.ErrNotFound
+	}
+	return u, nil
+}
+
+// TokenValid reports whether the tokens issued to the user with the given
+// id at the given token generation are still valid: the user must still
+// exist, not have changed their password since, and have enabled
+// two-factor authentication if they must. It is a
+// middleware.TokenValidator.
+func (s *Service) TokenValid(ctx context.Context, id, generation uint) (bool, error) {
+	u, err := s.users.WithContext(ctx).GetByID(id)
+	if err != nil || u == nil {
+		return false, err
+	}
+	if s.twoFactorRequired(u) && !u.TwoFactorEnabled() {
+		return false, nil
+	}
+	return u.TokenGeneration == generation, nil
+}
+
+// Update loads the user with the given id, lets apply change it and saves
+// the result. A new password set by apply is hashed before saving, and
+// revokes the tokens issued before. A changed email address is no longer verified,
+// and is mailed a verification link.
+func (s *Service) Update(ctx context.Context, id uint, apply func(*models.User) error) (*models.User, error) {
+	var u *models.User
+	err := s.uow.Do(ctx, func(ctx context.Context) error {
+		var err error
+		if u, err = s.Get(ctx, id); err != nil {
+			return err
+		}
+		hash, email := u.Password, u.Email
+		if err := apply(u); err != nil {
+			return err
+		}
+		if u.Password != hash {
+			// Documents replacing the user carry the current password
+			// again, which changes nothing.
+			if (&models.User{Password: hash}).CheckPassword(u.Password) {
+				u.Password = hash
+			} else {
+				if u.Password, err = u.HashPassword(u.Password); err != nil {
+					return err
+				}
+				u.TokenGeneration++
+			}
+		}
+		if err := s.users.WithContext(ctx).Update(u); err != nil {
+			return err
+		}
+		if u.Email == email {
+			return nil
+		}
+		u.EmailVerifiedAt = nil
+		if err := s.users.WithContext(ctx).SetEmailVerified(u.ID, nil); err != nil {
+			return err
+		}
+		return s.sendVerification(ctx, u)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
@@ -0,0 +1,267 @@
+package user
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sumitalp/productcatalog/models"
+	"github.com/sumitalp/productcatalog/totp"
+	"github.com/sumitalp/productcatalog/utils"
+)
+
+const (
+	// TOTPIssuer names the service in authenticator apps.
+	TOTPIssuer = "Product Catalog"
+	// LoginChallengeTTL is the time users have to give their code after
+	// their password.
+	LoginChallengeTTL = 5 * time.Minute
+	// RecoveryCodes is the number of recovery codes given on enabling
+	// two-factor authentication.
+	RecoveryCodes = 10
+)
+
+func errInvalidCode() error {
+	return utils.NewProblem(http.StatusBadRequest, utils.CodeInvalidCode, "the code is invalid or was already used")
+}
+
+func errTwoFactorRequired() error {
+	return utils.NewProblem(http.StatusForbidden, utils.CodeTwoFactorReq, "this account must enable two-factor authentication before logging in")
+}
+
+// LoginTwoFactor completes the login which returned challenge, with a code
+// of the authenticator app of the user or one of their recovery codes. A
+// challenge is single-use: after a wrong code the user logs in again.
+// Wrong codes count as failed logins.
+func (s *Service) LoginTwoFactor(ctx context.Context, challenge, code string) (*models.User, error) {
+	repo := s.users.WithContext(ctx)
+	t, err := repo.ConsumeToken(models.TokenLoginChallenge, hashToken(challenge), time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if t == nil {
+		return nil, errInvalidToken()
+	}
+	u, err := repo.GetByID(t.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if u == nil || !u.TwoFactorEnabled() {
+		return nil, errInvalidToken()
+	}
+	key := lockoutKey(u.Email)
+	if wait := s.lockout.Wait(key); wait > 0 {
+		return nil, utils.TooManyRequests("too many failed login attempts", wait)
+	}
+	ok, err := s.checkCode(ctx, u, code)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		s.lockout.Fail(key)
+		return nil, utils.ErrInvalidCredentials
+	}
+	s.lockout.Succeed(key)
+	return u, nil
+}
+
+// EnrollTwoFactor gives the user with the given id a new TOTP secret, and
+// returns it with its provisioning URI. Two-factor authentication is only
+// enabled once EnableTwoFactor confirms the app was set up.
+func (s *Service) EnrollTwoFactor(ctx context.Context, id uint) (secret, uri string, err error) {
+	u, err := s.Get(ctx, id)
+	if err != nil {
+		return "", "", err
+	}
+	if u.TwoFactorEnabled() {
+		return "", "", utils.NewProblem(http.StatusConflict, utils.CodeConflict, "two-factor authentication is already enabled")
+	}
+	if secret, err = totp.GenerateSecret(); err != nil {
+		return "", "", err
+	}
+	if err := s.users.WithContext(ctx).SetTwoFactor(id, secret, nil); err != nil {
+		return "", "", err
+	}
+	return secret, totp.URI(TOTPIssuer, u.Email, secret), nil
+}
+
+// EnableTwoFactor enables two-factor authentication for the user with the
+// given id, once code proves their app has the secret of the enrollment.
+// It returns the recovery codes of the user.
+func (s *Service) EnableTwoFactor(ctx context.Context, id uint, code string) ([]string, error) {
+	var codes []string
+	err := s.uow.Do(ctx, func(ctx context.Context) error {
+		u, err := s.Get(ctx, id)
+		if err != nil {
+			return err
+		}
+		if u.TwoFactorEnabled() {
+			return utils.NewProblem(http.StatusConflict, utils.CodeConflict, "two-factor authentication is already enabled")
+		}
+		if u.TOTPSecret == "" {
+			return utils.NewProblem(http.StatusConflict, utils.CodeConflict, "two-factor authentication is not enrolled")
+		}
+		step, ok := totp.Validate(u.TOTPSecret, code, time.Now(), 0)
+		if !ok {
+			return errInvalidCode()
+		}
+		repo := s.users.WithContext(ctx)
+		now := time.Now()
+		if err := repo.SetTwoFactor(id, u.TOTPSecret, &now); err != nil {
+			return err
+		}
+		if _, err := repo.UseTOTPStep(id, step); err != nil {
+			return err
+		}
+		codes, err = s.replaceRecoveryCodes(ctx, id)
+		return err
+	})
+	return codes, err
+}
+
+// EnrollRequiredTwoFactor is EnrollTwoFactor for the users who must enable
+// two-factor authentication before they can log in. They identify with
+// their email address and password instead of a token.
+func (s *Service) EnrollRequiredTwoFactor(ctx context.Context, email, password string) (secret, uri string, err error) {
+	u, err := s.mustEnable(ctx, email, password)
+	if err != nil {
+		return "", "", err
+	}
+	return s.EnrollTwoFactor(ctx, u.ID)
+}
+
+// EnableRequiredTwoFactor is EnableTwoFactor for the users who must enable
+// two-factor authentication before they can log in, identified as by
+// EnrollRequiredTwoFactor.
+func (s *Service) EnableRequiredTwoFactor(ctx context.Context, email, password, code string) ([]string, error) {
+	u, err := s.mustEnable(ctx, email, password)
+	if err != nil {
+		return nil, err
+	}
+	return s.EnableTwoFactor(ctx, u.ID, code)
+}
+
+// mustEnable returns the user identified by email and password after
+// checking that they must enable two-factor authentication and have not.
+// The others manage it with a token.
+func (s *Service) mustEnable(ctx context.Context, email, password string) (*models.User, error) {
+	u, err := s.checkPassword(ctx, email, password)
+	if err != nil {
+		return nil, err
+	}
+	if !s.twoFactorRequired(u) || u.TwoFactorEnabled() {
+		return nil, utils.NewProblem(http.StatusForbidden, utils.CodeAccessForbidden, "log in to manage two-factor authentication")
+	}
+	return u, nil
+}
+
+// DisableTwoFactor disables two-factor authentication for the user with
+// the given id, who confirms with their password and a code. Users who
+// must have it enabled cannot.
+func (s *Service) DisableTwoFactor(ctx context.Context, id uint, password, code string) error {
+	return s.uow.Do(ctx, func(ctx context.Context) error {
+		u, err := s.enabled(ctx, id, code)
+		if err != nil {
+			return err
+		}
+		if !u.CheckPassword(password) {
+			return utils.ErrInvalidCredentials
+		}
+		if s.twoFactorRequired(u) {
+			return utils.NewProblem(http.StatusForbidden, utils.CodeTwoFactorReq, "two-factor authentication is required for this account")
+		}
+		repo := s.users.WithContext(ctx)
+		if err := repo.SetTwoFactor(id, "", nil); err != nil {
+			return err
+		}
+		return repo.ReplaceRecoveryCodes(id, nil)
+	})
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of the user with the
+// given id, who confirms with a code, and returns the new ones.
+func (s *Service) RegenerateRecoveryCodes(ctx context.Context, id uint, code string) ([]string, error) {
+	var codes []string
+	err := s.uow.Do(ctx, func(ctx context.Context) error {
+		if _, err := s.enabled(ctx, id, code); err != nil {
+			return err
+		}
+		var err error
+		codes, err = s.replaceRecoveryCodes(ctx, id)
+		return err
+	})
+	return codes, err
+}
+
+// enabled returns the user with the given id after checking that they
+// enabled two-factor authentication and that code is theirs.
+func (s *Service) enabled(ctx context.Context, id uint, code string) (*models.User, error) {
+	u, err := s.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !u.TwoFactorEnabled() {
+		return nil, utils.NewProblem(http.StatusConflict, utils.CodeConflict, "two-factor authentication is not enabled")
+	}
+	ok, err := s.checkCode(ctx, u, code)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errInvalidCode()
+	}
+	return u, nil
+}
+
+// checkCode reports whether code is a code of the authenticator app of u
+// not used yet, or one of their unused recovery codes, and uses it.
+func (s *Service) checkCode(ctx context.Context, u *models.User, code string) (bool, error) {
+	code = normalizeCode(code)
+	repo := s.users.WithContext(ctx)
+	if len(code) == totp.Digits && strings.Trim(code, "0123456789") == "" {
+		step, ok := totp.Validate(u.TOTPSecret, code, time.Now(), u.TOTPLastStep)
+		if !ok {
+			return false, nil
+		}
+		return repo.UseTOTPStep(u.ID, step)
+	}
+	return repo.UseRecoveryCode(u.ID, hashRecoveryCode(u.ID, code), time.Now())
+}
+
+// replaceRecoveryCodes stores new recovery codes for the user with the
+// given id and returns them.
+func (s *Service) replaceRecoveryCodes(ctx context.Context, id uint) ([]string, error) {
+	codes := make([]string, RecoveryCodes)
+	hashes := make([]string, RecoveryCodes)
+	b := make([]byte, 10)
+	for i := range codes {
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		c := base32.StdEncoding.EncodeToString(b)
+		codes[i] = c[:4] + "-" + c[4:8] + "-" + c[8:12] + "-" + c[12:]
+		hashes[i] = hashRecoveryCode(id, c)
+	}
+	if err := s.users.WithContext(ctx).ReplaceRecoveryCodes(id, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// normalizeCode drops the separators users may type in a code.
+func normalizeCode(code string) string {
+	return strings.ToUpper(strings.NewReplacer(" ", "", "-", "").Replace(code))
+}
+
+// hashRecoveryCode returns what is stored of a recovery code. It is salted
+// with the user, so that a code only works for the user it was given to.
+func hashRecoveryCode(userID uint, code string) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%d:%s", userID, code)))
+	return hex.EncodeToString(sum[:])
+}
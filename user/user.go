@@ -1,13 +1,52 @@
 package user
 
 import (
+	"context"
+	"time"
+
 	"github.com/sumitalp/productcatalog/models"
 )
 
 type RepositoryInterface interface {
+	// WithContext returns a copy of the repository whose operations are
+	// bound to ctx and abort once it is done.
+	WithContext(ctx context.Context) RepositoryInterface
+
 	GetByID(uint) (*models.User, error)
 	GetByEmail(string) (*models.User, error)
 	GetByUsername(string) (*models.User, error)
 	Create(*models.User) error
 	Update(*models.User) error
+	// SetEmailVerified sets when the email of the user was verified, nil
+	// for not verified.
+	SetEmailVerified(id uint, at *time.Time) error
+
+	CreateToken(*models.UserToken) error
+	// ConsumeToken marks the token with the given purpose and hash used
+	// and returns it, or nil if there is no such token or it was used or
+	// expired at now. A token is consumed once even by concurrent calls.
+	ConsumeToken(purpose, hash string, now time.Time) (*models.UserToken, error)
+	// RevokeTokens deletes the tokens of the user with the given purpose.
+	RevokeTokens(userID uint, purpose string) error
+	// DeleteExpiredTokens removes the tokens which expired before t.
+	DeleteExpiredTokens(t time.Time) (int, error)
+
+	// SetTwoFactor sets the TOTP secret of the user and when two-factor
+	// authentication was enabled, nil while enrollment is pending. An empty
+	// secret disables it. The last step used is forgotten. Update leaves
+	// these fields alone.
+	SetTwoFactor(id uint, secret string, enabledAt *time.Time) error
+	// UseTOTPStep records that a code of the user matched step. It reports
+	// false when a code matched that step or a later one already, which
+	// makes it safe against concurrent replays.
+	UseTOTPStep(id uint, step int64) (bool, error)
+	// ReplaceRecoveryCodes replaces the recovery codes of the user with
+	// codes of the given hashes.
+	ReplaceRecoveryCodes(userID uint, hashes []string) error
+	// UseRecoveryCode marks the unused recovery code of the user with the
+	// given hash used, and reports whether there was one.
+	UseRecoveryCode(userID uint, hash string, now time.Time) (bool, error)
+	// CountRecoveryCodes returns the number of unused recovery codes of the
+	// user.
+	CountRecoveryCodes(userID uint) (int, error)
 }
@@ -1,48 +1,254 @@
 package utils
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net/http"
+	"strings"
+	"time"
 
+	ut "github.com/go-playground/universal-translator"
 	"github.com/labstack/echo/v4"
 	"gopkg.in/go-playground/validator.v9"
 )
 
+// MIMEApplicationProblemJSON is the media type of RFC 7807 problem documents.
+const MIMEApplicationProblemJSON = "application/problem+json"
+
+// ProblemTypeBase prefixes the code of a problem to build its type URI.
+const ProblemTypeBase = "/problems/"
+
+// Stable, machine-readable error codes. Clients may switch on these, so
+// existing values must never change.
+const (
+	CodeBadRequest       = "bad_request"
+	CodeUnauthorized     = "unauthorized"
+	CodeAccessForbidden  = "access_forbidden"
+	CodeNotFound         = "not_found"
+	CodeMethodNotAllowed = "method_not_allowed"
+	CodeConflict         = "conflict"
+	CodePrecondition     = "precondition_failed"
+	CodePreconditionReq  = "precondition_required"
+	CodeUnsupportedMedia = "unsupported_media_type"
+	CodeValidationFailed = "validation_failed"
+	CodeIdempotencyReuse = "idempotency_key_reused"
+	CodeIdempotencyBusy  = "idempotency_key_in_use"
+	CodeFailedDependency = "failed_dependency"
+	CodeClientClosed     = "client_closed_request"
+	CodeInternal         = "internal_error"
+	CodeTimeout          = "timeout"
+	CodeTooManyRequests  = "too_many_requests"
+	CodeInvalidToken     = "invalid_token"
+	CodeInvalidCode      = "invalid_code"
+	CodeTwoFactorReq     = "two_factor_required"
+)
+
+// StatusClientClosedRequest is the non-standard status, borrowed from
+// nginx, reported when the client went away before a response was written.
+const StatusClientClosedRequest = 499
+
+var (
+	// ErrDuplicate is returned by repositories when a write violates a
+	// uniqueness constraint.
+	ErrDuplicate = errors.New("resource already exists")
+	// ErrNotFound is returned by services when the requested resource does
+	// not exist or is not visible to the caller.
+	ErrNotFound = errors.New("resource not found")
+	// ErrStale is returned when a write was based on a version of the
+	// resource that is no longer current.
+	ErrStale = errors.New("resource was modified by another request")
+	// ErrInvalidCredentials is returned when an email and password pair
+	// does not match any account.
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+// Error is an RFC 7807 problem details object.
 type Error struct {
-	Errors map[string]interface{} `json:"errors" xml:"errors"`
+	Type     string       `json:"type" xml:"type"`
+	Title    string       `json:"title" xml:"title"`
+	Status   int          `json:"status" xml:"status"`
+	Detail   string       `json:"detail,omitempty" xml:"detail,omitempty"`
+	Instance string       `json:"instance,omitempty" xml:"instance,omitempty"`
+	Code     string       `json:"code" xml:"code"`
+	Errors   []FieldError `json:"errors,omitempty" xml:"errors>error,omitempty"`
+
+	cause      error
+	retryAfter time.Duration
 }
 
-func NewError(err error) Error {
-	e := Error{}
-	e.Errors = make(map[string]interface{})
-	switch v := err.(type) {
-	case *echo.HTTPError:
-		e.Errors["body"] = v.Message
-	default:
-		e.Errors["body"] = v.Error()
+// FieldError describes why a single request field was rejected.
+type FieldError struct {
+	Field   string `json:"field" xml:"field"`
+	Code    string `json:"code" xml:"code"`
+	Message string `json:"message" xml:"message"`
+}
+
+func (e *Error) Error() string {
+	if e.Detail != "" {
+		return fmt.Sprintf("%s: %s", e.Code, e.Detail)
 	}
-	return e
+	return e.Code
 }
 
-func NewValidatorError(err error) Error {
-	e := Error{}
-	e.Errors = make(map[string]interface{})
+func (e *Error) Unwrap() error {
+	return e.cause
+}
+
+// RetryAfter returns the time after which the request may succeed, zero
+// when unknown.
+func (e *Error) RetryAfter() time.Duration {
+	return e.retryAfter
+}
+
+// NewProblem builds a problem with the given status, code and detail.
+func NewProblem(status int, code, detail string) *Error {
+	return &Error{
+		Type:   ProblemTypeBase + code,
+		Title:  http.StatusText(status),
+		Status: status,
+		Code:   code,
+		Detail: detail,
+	}
+}
+
+// NewError converts any error into a problem. Errors which are already
+// problems are returned unchanged.
+func NewError(err error) *Error {
+	var pe *Error
+	if errors.As(err, &pe) {
+		return pe
+	}
+	var he *echo.HTTPError
+	if errors.As(err, &he) {
+		p := NewProblem(he.Code, codeForStatus(he.Code), fmt.Sprintf("%v", he.Message))
+		p.cause = err
+		return p
+	}
+	var ve validator.ValidationErrors
+	if errors.As(err, &ve) {
+		return NewValidatorError(ve, nil)
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		p := NewProblem(http.StatusGatewayTimeout, CodeTimeout, "the request took too long to complete")
+		p.cause = err
+		return p
+	}
+	if errors.Is(err, context.Canceled) {
+		p := NewProblem(StatusClientClosedRequest, CodeClientClosed, "the request was cancelled by the client")
+		p.Title = "Client Closed Request"
+		p.cause = err
+		return p
+	}
+	if errors.Is(err, ErrNotFound) {
+		p := NotFound()
+		p.cause = err
+		return p
+	}
+	if errors.Is(err, ErrInvalidCredentials) {
+		p := AccessForbidden()
+		p.cause = err
+		return p
+	}
+	if errors.Is(err, ErrStale) {
+		p := NewProblem(http.StatusPreconditionFailed, CodePrecondition, err.Error())
+		p.cause = err
+		return p
+	}
+	if errors.Is(err, ErrDuplicate) {
+		// The cause may quote the driver, naming tables and columns.
+		p := NewProblem(http.StatusConflict, CodeConflict, ErrDuplicate.Error())
+		p.cause = err
+		return p
+	}
+	p := NewProblem(http.StatusInternalServerError, CodeInternal, "")
+	p.cause = err
+	return p
+}
+
+// NewValidatorError builds a 422 problem listing every failed field by its
+// JSON path. Messages are translated with trans, or name the failed rule
+// when trans is nil.
+func NewValidatorError(err error, trans ut.Translator) *Error {
+	e := NewProblem(http.StatusUnprocessableEntity, CodeValidationFailed, "request validation failed")
+	e.cause = err
 	errs := err.(validator.ValidationErrors)
 	for _, v := range errs {
-		e.Errors[v.Field()] = fmt.Sprintf("%v", v.Tag())
+		msg := fmt.Sprintf("failed on the '%s' rule", v.Tag())
+		if trans != nil {
+			msg = v.Translate(trans)
+		}
+		e.Errors = append(e.Errors, FieldError{
+			Field:   fieldPath(v.Namespace()),
+			Code:    v.Tag(),
+			Message: msg,
+		})
 	}
 	return e
 }
 
-func AccessForbidden() Error {
-	e := Error{}
-	e.Errors = make(map[string]interface{})
-	e.Errors["body"] = "Access Forbidden"
-	return e
+// fieldPath drops the request struct name from a validator namespace,
+// turning "userRegisterRequest.user.email" into "user.email".
+func fieldPath(namespace string) string {
+	if i := strings.Index(namespace, "."); i >= 0 {
+		return namespace[i+1:]
+	}
+	return namespace
 }
 
-func NotFound() Error {
-	e := Error{}
-	e.Errors = make(map[string]interface{})
-	e.Errors["body"] = "Not Found"
+func BadRequest(detail string) *Error {
+	return NewProblem(http.StatusBadRequest, CodeBadRequest, detail)
+}
+
+func AccessForbidden() *Error {
+	return NewProblem(http.StatusForbidden, CodeAccessForbidden, "Access Forbidden")
+}
+
+// TooManyRequests builds a 429 problem for a request which may be retried
+// after retryAfter.
+func TooManyRequests(detail string, retryAfter time.Duration) *Error {
+	e := NewProblem(http.StatusTooManyRequests, CodeTooManyRequests, detail)
+	e.retryAfter = retryAfter
 	return e
 }
+
+func NotFound() *Error {
+	return NewProblem(http.StatusNotFound, CodeNotFound, "Not Found")
+}
+
+func codeForStatus(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return CodeBadRequest
+	case http.StatusUnauthorized:
+		return CodeUnauthorized
+	case http.StatusForbidden:
+		return CodeAccessForbidden
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusMethodNotAllowed:
+		return CodeMethodNotAllowed
+	case http.StatusConflict:
+		return CodeConflict
+	case http.StatusPreconditionFailed:
+		return CodePrecondition
+	case http.StatusPreconditionRequired:
+		return CodePreconditionReq
+	case http.StatusUnsupportedMediaType:
+		return CodeUnsupportedMedia
+	case http.StatusUnprocessableEntity:
+		return CodeValidationFailed
+	case http.StatusFailedDependency:
+		return CodeFailedDependency
+	case StatusClientClosedRequest:
+		return CodeClientClosed
+	case http.StatusGatewayTimeout:
+		return CodeTimeout
+	case http.StatusTooManyRequests:
+		return CodeTooManyRequests
+	}
+	if status >= http.StatusInternalServerError {
+		return CodeInternal
+	}
+	return CodeBadRequest
+}
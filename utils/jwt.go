@@ -8,10 +8,13 @@ import (
 
 var JWTSecret = []byte("!!ADCASHSECRET!!")
 
-func GenerateJWT(id uint) string {
+// GenerateJWT returns a token for the user with the given id, valid until
+// their token generation is raised past generation.
+func GenerateJWT(id, generation uint) string {
 	token := jwt.New(jwt.SigningMethodHS256)
 	claims := token.Claims.(jwt.MapClaims)
 	claims["id"] = id
+	claims["gen"] = generation
 	claims["exp"] = time.Now().Add(time.Hour * 72).Unix()
 	t, _ := token.SignedString(JWTSecret)
 	return t
@@ -0,0 +1,447 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/labstack/gommon/log"
+	"github.com/sumitalp/productcatalog/event"
+	"github.com/sumitalp/productcatalog/health"
+	"github.com/sumitalp/productcatalog/models"
+	"github.com/sumitalp/productcatalog/uow"
+	"github.com/sumitalp/productcatalog/utils"
+)
+
+// Config tunes the delivery of webhooks.
+type Config struct {
+	// Client sends the deliveries. Its timeout bounds each attempt. The
+	// default one only connects to public addresses and does not follow
+	// redirects; a replacement has to take care of that itself.
+	Client *http.Client
+	// AllowPrivateNetworks lets webhooks target this host and private
+	// networks, for development.
+	AllowPrivateNetworks bool
+	// MaxAttempts is the number of times a delivery is tried before it
+	// fails for good.
+	MaxAttempts int
+	// BaseBackoff is the wait after the first failed attempt. It doubles
+	// with every further attempt, up to MaxBackoff.
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+	// DisableAfter is the number of consecutive deliveries which may fail
+	// for good before the subscription is disabled.
+	DisableAfter int
+	// PollInterval is how often Run looks for due deliveries when it is
+	// not woken up by a new event.
+	PollInterval time.Duration
+	// BatchSize is the number of deliveries sent per poll.
+	BatchSize int
+	// Now returns the current time.
+	Now func() time.Time
+}
+
+// ConfigFromEnv returns DefaultConfig, letting webhooks target private
+// networks when WEBHOOK_ALLOW_PRIVATE_NETWORKS is true.
+func ConfigFromEnv() (Config, error) {
+	config := DefaultConfig
+	if v := os.Getenv("WEBHOOK_ALLOW_PRIVATE_NETWORKS"); v != "" {
+		allow, err := strconv.ParseBool(v)
+		if err != nil {
+			return config, fmt.Errorf("WEBHOOK_ALLOW_PRIVATE_NETWORKS: %v", err)
+		}
+		config.AllowPrivateNetworks = allow
+	}
+	return config, nil
+}
+
+// DefaultConfig retries a delivery for about a day.
+var DefaultConfig = Config{
+	MaxAttempts:  10,
+	BaseBackoff:  30 * time.Second,
+	MaxBackoff:   6 * time.Hour,
+	DisableAfter: 5,
+	PollInterval: 5 * time.Second,
+	BatchSize:    50,
+	Now:          time.Now,
+}
+
+// Service manages webhook subscriptions and delivers their events. It is
+// an event.Publisher.
+type Service struct {
+	webhooks RepositoryInterface
+	uow      uow.UnitOfWork
+	config   Config
+	wake     chan struct{}
+	worker   health.Worker
+}
+
+func NewService(webhooks RepositoryInterface, uow uow.UnitOfWork) *Service {
+	return NewServiceWithConfig(webhooks, uow, DefaultConfig)
+}
+
+func NewServiceWithConfig(webhooks RepositoryInterface, uow uow.UnitOfWork, config Config) *Service {
+	if config.Client == nil {
+		config.Client = newClient(10*time.Second, config.AllowPrivateNetworks)
+	}
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = DefaultConfig.MaxAttempts
+	}
+	if config.BaseBackoff <= 0 {
+		config.BaseBackoff = DefaultConfig.BaseBackoff
+	}
+	if config.MaxBackoff <= 0 {
+		config.MaxBackoff = DefaultConfig.MaxBackoff
+	}
+	if config.DisableAfter <= 0 {
+		config.DisableAfter = DefaultConfig.DisableAfter
+	}
+	if config.PollInterval <= 0 {
+		config.PollInterval = DefaultConfig.PollInterval
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = DefaultConfig.BatchSize
+	}
+	if config.Now == nil {
+		config.Now = DefaultConfig.Now
+	}
+	return &Service{
+		webhooks: webhooks,
+		uow:      uow,
+		config:   config,
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// Subscriptions
+
+// Subscribe stores s on behalf of userID, generating its secret unless it
+// has one. URLs of this host or of private networks are rejected.
+func (svc *Service) Subscribe(ctx context.Context, userID uint, s *models.WebhookSubscription) error {
+	if err := svc.checkTarget(ctx, s.URL); err != nil {
+		return err
+	}
+	s.UserID = userID
+	s.Active = true
+	if s.Secret == "" {
+		s.Secret = newSecret()
+	}
+	return svc.webhooks.WithContext(ctx).CreateSubscription(s)
+}
+
+// Get returns the subscription with the given id owned by userID. Those of
+// other users are reported as utils.ErrNotFound.
+func (svc *Service) Get(ctx context.Context, userID, id uint) (*models.WebhookSubscription, error) {
+	s, err := svc.webhooks.WithContext(ctx).GetSubscription(id)
+	if err != nil {
+		return nil, err
+	}
+	if s == nil || s.UserID != userID {
+		return nil, utils.ErrNotFound
+	}
+	return s, nil
+}
+
+// List returns a page of the subscriptions of userID and their count.
+func (svc *Service) List(ctx context.Context, userID uint, offset, limit int) ([]models.WebhookSubscription, int, error) {
+	return svc.webhooks.WithContext(ctx).ListSubscriptions(userID, offset, limit)
+}
+
+// Update lets apply change a subscription of userID and saves it.
+// Reactivating a disabled subscription clears its failures.
+func (svc *Service) Update(ctx context.Context, userID, id uint, apply func(*models.WebhookSubscription) error) (*models.WebhookSubscription, error) {
+	var s *models.WebhookSubscription
+	err := svc.uow.Do(ctx, func(ctx context.Context) error {
+		var err error
+		if s, err = svc.Get(ctx, userID, id); err != nil {
+			return err
+		}
+		wasActive := s.Active
+		if err := apply(s); err != nil {
+			return err
+		}
+		if err := svc.checkTarget(ctx, s.URL); err != nil {
+			return err
+		}
+		if s.Active && !wasActive {
+			s.ConsecutiveFailures = 0
+			s.DisabledAt = nil
+		}
+		return svc.webhooks.WithContext(ctx).UpdateSubscription(s)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Delete removes a subscription of userID and its delivery log.
+func (svc *Service) Delete(ctx context.Context, userID, id uint) error {
+	return svc.uow.Do(ctx, func(ctx context.Context) error {
+		s, err := svc.Get(ctx, userID, id)
+		if err != nil {
+			return err
+		}
+		return svc.webhooks.WithContext(ctx).DeleteSubscription(s)
+	})
+}
+
+// Deliveries returns a page of the delivery log of a subscription of
+// userID, newest first.
+func (svc *Service) Deliveries(ctx context.Context, userID, id uint, offset, limit int) ([]models.WebhookDelivery, int, error) {
+	if _, err := svc.Get(ctx, userID, id); err != nil {
+		return nil, 0, err
+	}
+	return svc.webhooks.WithContext(ctx).ListDeliveries(id, offset, limit)
+}
+
+// Replay queues the payload of a past delivery of a subscription of userID
+// again, as a new delivery.
+func (svc *Service) Replay(ctx context.Context, userID, id, deliveryID uint) (*models.WebhookDelivery, error) {
+	var d *models.WebhookDelivery
+	err := svc.uow.Do(ctx, func(ctx context.Context) error {
+		s, err := svc.Get(ctx, userID, id)
+		if err != nil {
+			return err
+		}
+		if !s.Active {
+			return utils.NewProblem(http.StatusConflict, utils.CodeConflict, "the webhook is disabled")
+		}
+		repo := svc.webhooks.WithContext(ctx)
+		orig, err := repo.GetDelivery(deliveryID)
+		if err != nil {
+			return err
+		}
+		if orig == nil || orig.SubscriptionID != s.ID {
+			return utils.ErrNotFound
+		}
+		d = svc.newDelivery(s, orig.EventID, orig.EventType, orig.Payload)
+		d.ReplayOf = &orig.ID
+		return repo.CreateDelivery(d)
+	})
+	if err != nil {
+		return nil, err
+	}
+	svc.notify()
+	return d, nil
+}
+
+// Publish queues a delivery of e for every active subscription matching
+// its type. The deliveries are stored through ctx, so they are only sent
+// once the unit of work of ctx, if any, commits.
+func (svc *Service) Publish(ctx context.Context, e *event.Event) error {
+	return svc.uow.Do(ctx, func(ctx context.Context) error {
+		repo := svc.webhooks.WithContext(ctx)
+		subscriptions, err := repo.ListActiveSubscriptions()
+		if err != nil {
+			return err
+		}
+		var payload []byte
+		for i := range subscriptions {
+			s := &subscriptions[i]
+			if !s.Matches(e.Type) {
+				continue
+			}
+			if payload == nil {
+				if payload, err = json.Marshal(e); err != nil {
+					return err
+				}
+			}
+			if err := repo.CreateDelivery(svc.newDelivery(s, e.ID, e.Type, payload)); err != nil {
+				return err
+			}
+		}
+		if payload != nil {
+			uow.AfterCommit(ctx, svc.notify)
+		}
+		return nil
+	})
+}
+
+func (svc *Service) newDelivery(s *models.WebhookSubscription, eventID, eventType string, payload []byte) *models.WebhookDelivery {
+	return &models.WebhookDelivery{
+		SubscriptionID: s.ID,
+		EventID:        eventID,
+		EventType:      eventType,
+		Payload:        payload,
+		Status:         models.DeliveryPending,
+		NextAttemptAt:  svc.config.Now(),
+	}
+}
+
+func (svc *Service) notify() {
+	select {
+	case svc.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Delivery
+
+// Run sends due deliveries until ctx is done, whenever an event is
+// published and every PollInterval.
+func (svc *Service) Run(ctx context.Context) {
+	svc.worker.Start()
+	defer svc.worker.Stop()
+	ticker := time.NewTicker(svc.config.PollInterval)
+	defer ticker.Stop()
+	for {
+		for {
+			n, err := svc.DeliverDue(ctx)
+			if ctx.Err() == nil {
+				svc.worker.Ran(err)
+			}
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Errorf("webhook delivery: %v", err)
+				}
+				break
+			}
+			if n < svc.config.BatchSize {
+				break
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-svc.wake:
+		}
+	}
+}
+
+// Status reports the state of the Run loop.
+func (svc *Service) Status() health.WorkerStatus {
+	return svc.worker.Status()
+}
+
+// DeliverDue makes one attempt at up to BatchSize due deliveries and
+// returns how many it attempted.
+func (svc *Service) DeliverDue(ctx context.Context) (int, error) {
+	due, err := svc.webhooks.WithContext(ctx).ListDueDeliveries(svc.config.Now(), svc.config.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+	for i := range due {
+		if err := svc.attempt(ctx, &due[i]); err != nil {
+			return i, err
+		}
+	}
+	return len(due), nil
+}
+
+// attempt sends d once and records the outcome on d and its subscription.
+func (svc *Service) attempt(ctx context.Context, d *models.WebhookDelivery) error {
+	s, err := svc.webhooks.WithContext(ctx).GetSubscription(d.SubscriptionID)
+	if err != nil {
+		return err
+	}
+	var status int
+	if s == nil || !s.Active {
+		err = fmt.Errorf("the webhook is disabled")
+	} else {
+		status, err = svc.send(ctx, s, d)
+	}
+	if ctx.Err() != nil {
+		// Shutting down: leave the delivery for the next run.
+		return ctx.Err()
+	}
+
+	return svc.uow.Do(ctx, func(ctx context.Context) error {
+		repo := svc.webhooks.WithContext(ctx)
+		now := svc.config.Now()
+		d.Attempts++
+		d.LastStatusCode = status
+		d.LastError = ""
+		if err != nil {
+			d.LastError = err.Error()
+		}
+		// Reload the subscription, which may have changed meanwhile.
+		s, serr := repo.GetSubscription(d.SubscriptionID)
+		if serr != nil {
+			return serr
+		}
+		switch {
+		case err == nil:
+			d.Status = models.DeliverySucceeded
+			d.DeliveredAt = &now
+			if s != nil && s.ConsecutiveFailures > 0 {
+				s.ConsecutiveFailures = 0
+				if serr := repo.UpdateSubscription(s); serr != nil {
+					return serr
+				}
+			}
+		case d.Attempts < svc.config.MaxAttempts && s != nil && s.Active:
+			d.NextAttemptAt = now.Add(svc.backoff(d.Attempts))
+		default:
+			d.Status = models.DeliveryFailed
+			if s != nil && s.Active {
+				s.ConsecutiveFailures++
+				if s.ConsecutiveFailures >= svc.config.DisableAfter {
+					s.Active = false
+					s.DisabledAt = &now
+				}
+				if serr := repo.UpdateSubscription(s); serr != nil {
+					return serr
+				}
+			}
+		}
+		return repo.UpdateDelivery(d)
+	})
+}
+
+// send posts the payload of d to s and returns the response status. Any
+// status other than 2xx is an error.
+func (svc *Service) send(ctx context.Context, s *models.WebhookSubscription, d *models.WebhookDelivery) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, s.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req = req.WithContext(ctx)
+	ts := svc.config.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "productcatalog-webhooks/1.0")
+	req.Header.Set(HeaderEvent, d.EventType)
+	req.Header.Set(HeaderDelivery, fmt.Sprint(d.ID))
+	req.Header.Set(HeaderTimestamp, fmt.Sprint(ts))
+	req.Header.Set(HeaderSignature, Sign(s.Secret, ts, d.Payload))
+
+	res, err := svc.config.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	// Drain a little of the body so the connection can be reused.
+	io.Copy(ioutil.Discard, io.LimitReader(res.Body, 64<<10))
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, fmt.Errorf("endpoint answered %s", res.Status)
+	}
+	return res.StatusCode, nil
+}
+
+// backoff returns the wait after the given number of failed attempts.
+func (svc *Service) backoff(attempts int) time.Duration {
+	d := svc.config.BaseBackoff
+	for i := 1; i < attempts; i++ {
+		d *= 2
+		if d >= svc.config.MaxBackoff {
+			return svc.config.MaxBackoff
+		}
+	}
+	return d
+}
+
+func newSecret() string {
+	b := make([]byte, 24)
+	rand.Read(b)
+	return "whsec_" + hex.EncodeToString(b)
+}
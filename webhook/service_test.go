@@ -0,0 +1,396 @@
+package webhook_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/sumitalp/productcatalog/event"
+	"github.com/sumitalp/productcatalog/models"
+	"github.com/sumitalp/productcatalog/product"
+	"github.com/sumitalp/productcatalog/repository/memory"
+	"github.com/sumitalp/productcatalog/utils"
+	"github.com/sumitalp/productcatalog/webhook"
+)
+
+const secret = "0123456789abcdef0123456789abcdef"
+
+// receiver is a webhook endpoint which checks signatures and answers with
+// the queued statuses, then 204.
+type receiver struct {
+	*httptest.Server
+	mu       sync.Mutex
+	statuses []int
+	events   []event.Event
+	headers  []http.Header
+	invalid  int
+}
+
+func newReceiver(t *testing.T, now func() time.Time, statuses ...int) *receiver {
+	r := &receiver{statuses: statuses}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if !webhook.Verify(secret, req.Header.Get(webhook.HeaderTimestamp), req.Header.Get(webhook.HeaderSignature), body, 5*time.Minute, now()) {
+			r.invalid++
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var e event.Event
+		assert.NoError(t, json.Unmarshal(body, &e))
+		r.events = append(r.events, e)
+		r.headers = append(r.headers, req.Header)
+		status := http.StatusNoContent
+		if len(r.statuses) > 0 {
+			status, r.statuses = r.statuses[0], r.statuses[1:]
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func (r *receiver) received() []event.Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]event.Event(nil), r.events...)
+}
+
+// clock is a settable time source.
+type clock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func (c *clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+func (c *clock) Add(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.t = c.t.Add(d)
+}
+
+type fixture struct {
+	store    *memory.Store
+	webhooks *webhook.Service
+	products *product.Service
+	clock    *clock
+	owner    uint
+}
+
+func setup(t *testing.T, config webhook.Config) *fixture {
+	s := memory.NewStore()
+	tx := memory.NewUnitOfWork(s)
+	u := &models.User{Username: "alice", Email: "alice@email.io", Password: "hash"}
+	require.NoError(t, memory.NewUserRepository(s).Create(u))
+	f := &fixture{store: s, clock: &clock{t: time.Now()}, owner: u.ID}
+	config.Now = f.clock.Now
+	// The receivers listen on the loopback interface.
+	config.AllowPrivateNetworks = true
+	f.webhooks = webhook.NewServiceWithConfig(memory.NewWebhookRepository(s), tx, config)
+	f.products = product.NewService(memory.NewProductRepository(s), tx)
+	f.products.PublishTo(f.webhooks)
+	return f
+}
+
+func (f *fixture) subscribe(t *testing.T, url, events string) *models.WebhookSubscription {
+	s := &models.WebhookSubscription{URL: url, Events: events, Secret: secret}
+	require.NoError(t, f.webhooks.Subscribe(context.Background(), f.owner, s))
+	return s
+}
+
+func (f *fixture) createProduct(t *testing.T, title string) *models.Product {
+	p := &models.Product{Title: title, Description: title}
+	require.NoError(t, f.products.Create(context.Background(), f.owner, p))
+	return p
+}
+
+func (f *fixture) deliverDue(t *testing.T) int {
+	n, err := f.webhooks.DeliverDue(context.Background())
+	require.NoError(t, err)
+	return n
+}
+
+func (f *fixture) deliveries(t *testing.T, s *models.WebhookSubscription) []models.WebhookDelivery {
+	d, _, err := f.webhooks.Deliveries(context.Background(), f.owner, s.ID, 0, 100)
+	require.NoError(t, err)
+	return d
+}
+
+func TestDeliverSignedEvents(t *testing.T) {
+	f := setup(t, webhook.Config{})
+	r := newReceiver(t, f.clock.Now)
+	s := f.subscribe(t, r.URL, "product.*")
+	f.subscribe(t, r.URL, "category.deleted")
+
+	f.createProduct(t, "First product")
+	_, err := f.products.Update(context.Background(), f.owner, "first-product", func(p *models.Product) error {
+		p.Description = "changed"
+		return nil
+	})
+	require.NoError(t, err)
+	require.NoError(t, f.products.CreateCategory(context.Background(), &models.Category{Category: "books"}))
+
+	assert.Equal(t, 2, f.deliverDue(t))
+	assert.Zero(t, f.deliverDue(t))
+	events := r.received()
+	require.Len(t, events, 2)
+	assert.Zero(t, r.invalid)
+	assert.Equal(t, event.ProductCreated, events[0].Type)
+	assert.Equal(t, event.ProductUpdated, events[1].Type)
+	data := events[1].Data.(map[string]interface{})
+	assert.Equal(t, "first-product", data["slug"])
+	assert.Equal(t, "changed", data["description"])
+	assert.Equal(t, event.ProductUpdated, r.headers[1].Get(webhook.HeaderEvent))
+
+	deliveries := f.deliveries(t, s)
+	require.Len(t, deliveries, 2)
+	for _, d := range deliveries {
+		assert.Equal(t, models.DeliverySucceeded, d.Status)
+		assert.Equal(t, 1, d.Attempts)
+		assert.Equal(t, http.StatusNoContent, d.LastStatusCode)
+		assert.NotNil(t, d.DeliveredAt)
+	}
+}
+
+func TestRolledBackChangesAreNotDelivered(t *testing.T) {
+	f := setup(t, webhook.Config{})
+	r := newReceiver(t, f.clock.Now)
+	s := f.subscribe(t, r.URL, "*")
+	f.createProduct(t, "First product")
+
+	err := f.products.Create(context.Background(), f.owner, &models.Product{Title: "First product"})
+	assert.True(t, errors.Is(err, utils.ErrDuplicate), "%v", err)
+	_, err = f.products.Update(context.Background(), f.owner, "first-product", func(p *models.Product) error {
+		return utils.BadRequest("no")
+	})
+	assert.Error(t, err)
+
+	assert.Len(t, f.deliveries(t, s), 1)
+}
+
+func TestRetryWithBackoff(t *testing.T) {
+	f := setup(t, webhook.Config{BaseBackoff: time.Minute, MaxBackoff: 3 * time.Minute})
+	r := newReceiver(t, f.clock.Now, 500, 500, 503, 200)
+	s := f.subscribe(t, r.URL, "*")
+	f.createProduct(t, "First product")
+
+	start := f.clock.Now()
+	for i, wait := range []time.Duration{time.Minute, 2 * time.Minute, 3 * time.Minute} {
+		assert.Equal(t, 1, f.deliverDue(t), "attempt %d", i+1)
+		d := f.deliveries(t, s)[0]
+		assert.Equal(t, models.DeliveryPending, d.Status)
+		assert.Equal(t, i+1, d.Attempts)
+		assert.NotZero(t, d.LastStatusCode)
+		assert.NotEmpty(t, d.LastError)
+		assert.Equal(t, f.clock.Now().Add(wait), d.NextAttemptAt)
+
+		// Nothing is due before the backoff elapses.
+		f.clock.Add(wait - time.Second)
+		assert.Zero(t, f.deliverDue(t))
+		f.clock.Add(time.Second)
+	}
+	assert.Equal(t, 1, f.deliverDue(t))
+	d := f.deliveries(t, s)[0]
+	assert.Equal(t, models.DeliverySucceeded, d.Status)
+	assert.Equal(t, 4, d.Attempts)
+	assert.Equal(t, start.Add(6*time.Minute), *d.DeliveredAt)
+	assert.Len(t, r.received(), 4)
+}
+
+func TestDisableAfterRepeatedFailures(t *testing.T) {
+	f := setup(t, webhook.Config{MaxAttempts: 2, DisableAfter: 2, BaseBackoff: time.Second})
+	r := newReceiver(t, f.clock.Now, 500, 500, 500, 500)
+	s := f.subscribe(t, r.URL, "*")
+	f.createProduct(t, "First product")
+	f.createProduct(t, "Second product")
+
+	assert.Equal(t, 2, f.deliverDue(t))
+	f.clock.Add(time.Second)
+	assert.Equal(t, 2, f.deliverDue(t))
+
+	got, err := f.webhooks.Get(context.Background(), f.owner, s.ID)
+	require.NoError(t, err)
+	assert.False(t, got.Active)
+	assert.Equal(t, 2, got.ConsecutiveFailures)
+	assert.NotNil(t, got.DisabledAt)
+	for _, d := range f.deliveries(t, s) {
+		assert.Equal(t, models.DeliveryFailed, d.Status)
+		assert.Equal(t, 2, d.Attempts)
+	}
+
+	// Disabled webhooks get no new deliveries and cannot replay.
+	f.createProduct(t, "Third product")
+	assert.Len(t, f.deliveries(t, s), 2)
+	_, err = f.webhooks.Replay(context.Background(), f.owner, s.ID, f.deliveries(t, s)[0].ID)
+	assert.Equal(t, http.StatusConflict, utils.NewError(err).Status)
+
+	// Reactivating resets the failure count.
+	got, err = f.webhooks.Update(context.Background(), f.owner, s.ID, func(s *models.WebhookSubscription) error {
+		s.Active = true
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Zero(t, got.ConsecutiveFailures)
+	assert.Nil(t, got.DisabledAt)
+}
+
+func TestSuccessResetsFailures(t *testing.T) {
+	f := setup(t, webhook.Config{MaxAttempts: 1, DisableAfter: 2})
+	r := newReceiver(t, f.clock.Now, 500, 200, 500)
+	s := f.subscribe(t, r.URL, "*")
+	for _, title := range []string{"one", "two", "three"} {
+		f.createProduct(t, title)
+		f.deliverDue(t)
+	}
+	got, err := f.webhooks.Get(context.Background(), f.owner, s.ID)
+	require.NoError(t, err)
+	assert.True(t, got.Active)
+	assert.Equal(t, 1, got.ConsecutiveFailures)
+}
+
+func TestReplay(t *testing.T) {
+	f := setup(t, webhook.Config{})
+	r := newReceiver(t, f.clock.Now)
+	s := f.subscribe(t, r.URL, "*")
+	f.createProduct(t, "First product")
+	f.deliverDue(t)
+	orig := f.deliveries(t, s)[0]
+
+	d, err := f.webhooks.Replay(context.Background(), f.owner, s.ID, orig.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.DeliveryPending, d.Status)
+	require.NotNil(t, d.ReplayOf)
+	assert.Equal(t, orig.ID, *d.ReplayOf)
+	assert.Equal(t, 1, f.deliverDue(t))
+
+	events := r.received()
+	require.Len(t, events, 2)
+	assert.Equal(t, events[0].ID, events[1].ID)
+	assert.NotEqual(t, r.headers[0].Get(webhook.HeaderDelivery), r.headers[1].Get(webhook.HeaderDelivery))
+
+	_, err = f.webhooks.Replay(context.Background(), f.owner+1, s.ID, orig.ID)
+	assert.True(t, errors.Is(err, utils.ErrNotFound), "%v", err)
+	_, err = f.webhooks.Replay(context.Background(), f.owner, s.ID, d.ID+1)
+	assert.True(t, errors.Is(err, utils.ErrNotFound), "%v", err)
+}
+
+func TestRejectPrivateTargets(t *testing.T) {
+	ctx := context.Background()
+	s := memory.NewStore()
+	svc := webhook.NewService(memory.NewWebhookRepository(s), memory.NewUnitOfWork(s))
+	for _, url := range []string{
+		"http://127.0.0.1:8080/hook",
+		"http://localhost/hook",
+		"http://10.0.0.5/hook",
+		"http://192.168.1.10/hook",
+		"http://169.254.169.254/latest/meta-data/",
+		"http://100.100.100.200/latest/meta-data/",
+		"http://[::1]/hook",
+		"http://[fd00:ec2::254]/latest/meta-data/",
+		"http://[::ffff:127.0.0.1]/hook",
+		"http://0.0.0.0/hook",
+		"ftp://203.0.113.10/hook",
+	} {
+		err := svc.Subscribe(ctx, 1, &models.WebhookSubscription{URL: url, Events: "*"})
+		var p *utils.Error
+		if assert.True(t, errors.As(err, &p), "%s: %v", url, err) {
+			assert.Equal(t, http.StatusUnprocessableEntity, p.Status, url)
+		}
+	}
+
+	sub := &models.WebhookSubscription{URL: "https://203.0.113.10/hook", Events: "*"}
+	require.NoError(t, svc.Subscribe(ctx, 1, sub))
+	_, err := svc.Update(ctx, 1, sub.ID, func(s *models.WebhookSubscription) error {
+		s.URL = "http://172.16.0.1/hook"
+		return nil
+	})
+	var p *utils.Error
+	if assert.True(t, errors.As(err, &p), "%v", err) {
+		assert.Equal(t, http.StatusUnprocessableEntity, p.Status)
+	}
+	got, err := svc.Get(ctx, 1, sub.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "https://203.0.113.10/hook", got.URL)
+}
+
+// TestDeliverOnlyToPublicAddresses covers a subscription whose host only
+// resolves to a private address after it was checked.
+func TestDeliverOnlyToPublicAddresses(t *testing.T) {
+	ctx := context.Background()
+	s := memory.NewStore()
+	repo := memory.NewWebhookRepository(s)
+	svc := webhook.NewService(repo, memory.NewUnitOfWork(s))
+	r := newReceiver(t, time.Now)
+	sub := &models.WebhookSubscription{UserID: 1, URL: r.URL, Events: "*", Secret: secret, Active: true}
+	require.NoError(t, repo.CreateSubscription(sub))
+
+	require.NoError(t, svc.Publish(ctx, event.New(event.CategoryCreated, nil)))
+	n, err := svc.DeliverDue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Empty(t, r.received())
+	deliveries, _, err := svc.Deliveries(ctx, 1, sub.ID, 0, 10)
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1)
+	assert.Equal(t, models.DeliveryPending, deliveries[0].Status)
+	assert.Contains(t, deliveries[0].LastError, "not a public address")
+}
+
+func TestRedirectsAreNotFollowed(t *testing.T) {
+	f := setup(t, webhook.Config{})
+	target := newReceiver(t, f.clock.Now)
+	redirect := httptest.NewServer(http.RedirectHandler(target.URL, http.StatusTemporaryRedirect))
+	t.Cleanup(redirect.Close)
+	s := f.subscribe(t, redirect.URL, "*")
+
+	f.createProduct(t, "First product")
+	assert.Equal(t, 1, f.deliverDue(t))
+	assert.Empty(t, target.received())
+	d := f.deliveries(t, s)[0]
+	assert.Equal(t, models.DeliveryPending, d.Status)
+	assert.Equal(t, http.StatusTemporaryRedirect, d.LastStatusCode)
+}
+
+func TestRun(t *testing.T) {
+	f := setup(t, webhook.Config{PollInterval: time.Hour})
+	r := newReceiver(t, f.clock.Now)
+	f.subscribe(t, r.URL, "*")
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		f.webhooks.Run(ctx)
+		close(done)
+	}()
+
+	// Publishing wakes the worker up before the next poll.
+	f.createProduct(t, "First product")
+	assert.Eventually(t, func() bool { return len(r.received()) == 1 }, 5*time.Second, 10*time.Millisecond)
+	cancel()
+	<-done
+}
+
+func TestSignature(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	payload := []byte(`{"id":"1"}`)
+	sig := webhook.Sign(secret, now.Unix(), payload)
+	ts := "1600000000"
+	assert.True(t, webhook.Verify(secret, ts, sig, payload, time.Minute, now))
+	assert.False(t, webhook.Verify("other", ts, sig, payload, time.Minute, now))
+	assert.False(t, webhook.Verify(secret, ts, sig, []byte(`{"id":"2"}`), time.Minute, now))
+	assert.False(t, webhook.Verify(secret, "1600000001", sig, payload, time.Minute, now))
+	assert.False(t, webhook.Verify(secret, ts, sig, payload, time.Minute, now.Add(2*time.Minute)))
+}
@@ -0,0 +1,48 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Headers sent with every delivery.
+const (
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderDelivery  = "X-Webhook-Delivery"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+const signaturePrefix = "sha256="
+
+// Sign returns the signature header of a payload sent at timestamp (in
+// Unix seconds): the hex encoded HMAC-SHA256, keyed with the subscription
+// secret, of the timestamp, a dot and the payload.
+func Sign(secret string, timestamp int64, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the signature and timestamp headers of a received payload.
+// Payloads signed more than tolerance away from now are rejected, so a
+// captured delivery cannot be replayed later on.
+func Verify(secret, timestamp, signature string, payload []byte, tolerance time.Duration, now time.Time) bool {
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	if d := now.Sub(time.Unix(ts, 0)); d > tolerance || d < -tolerance {
+		return false
+	}
+	if !strings.HasPrefix(signature, signaturePrefix) {
+		return false
+	}
+	return hmac.Equal([]byte(signature), []byte(Sign(secret, ts, payload)))
+}
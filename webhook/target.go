@@ -0,0 +1,116 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/sumitalp/productcatalog/utils"
+)
+
+// reservedNetworks are the address ranges outside of the loopback, private
+// and link-local ones which must not be reached either: shared address
+// space, where some clouds serve instance metadata, and special-purpose
+// blocks.
+var reservedNetworks = func() []*net.IPNet {
+	var nets []*net.IPNet
+	for _, cidr := range []string{
+		"0.0.0.0/8",
+		"100.64.0.0/10",
+		"192.0.0.0/24",
+		"198.18.0.0/15",
+		"240.0.0.0/4",
+	} {
+		_, n, _ := net.ParseCIDR(cidr)
+		nets = append(nets, n)
+	}
+	return nets
+}()
+
+// publicIP reports whether ip may receive deliveries: it must not belong
+// to this host, a private network or a cloud metadata service.
+func publicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	for _, n := range reservedNetworks {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkTarget rejects webhook URLs which are not http(s) or whose host is,
+// or resolves to, an address publicIP refuses. A host which cannot be
+// resolved now is accepted; the dialer checks every connection anyway, as
+// the addresses of a name may change after this check.
+func (svc *Service) checkTarget(ctx context.Context, rawURL string) error {
+	if svc.config.AllowPrivateNetworks {
+		return nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return forbiddenTarget("the webhook URL must be an http or https URL")
+	}
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") || host == "metadata.google.internal" {
+		return forbiddenTarget("the webhook URL must not point to this host or a private network")
+	}
+	var ips []net.IP
+	if ip := net.ParseIP(host); ip != nil {
+		ips = []net.IP{ip}
+	} else if addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host); err == nil {
+		for _, a := range addrs {
+			ips = append(ips, a.IP)
+		}
+	}
+	for _, ip := range ips {
+		if !publicIP(ip) {
+			return forbiddenTarget("the webhook URL must not point to this host or a private network")
+		}
+	}
+	return nil
+}
+
+func forbiddenTarget(detail string) error {
+	return utils.NewProblem(http.StatusUnprocessableEntity, utils.CodeValidationFailed, detail)
+}
+
+// newClient returns the client sending deliveries. Unless private networks
+// are allowed, it refuses to connect to the addresses publicIP rejects,
+// whatever the name of the host resolved to, and ignores proxies, which
+// would be connected to instead. Redirects are never followed: the
+// response to a delivery is the one of the subscribed URL.
+func newClient(timeout time.Duration, allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if !allowPrivate {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+				return fmt.Errorf("refusing to connect to %s: not a public address", host)
+			}
+			return nil
+		}
+		transport.Proxy = nil
+	}
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Transport: transport,
+		Timeout:   timeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
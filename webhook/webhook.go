@@ -0,0 +1,38 @@
+// Package webhook posts the change events of the catalog to the URLs users
+// subscribed, signing each payload and retrying failed deliveries.
+package webhook
+
+import (
+	"context"
+	"time"
+
+	"github.com/sumitalp/productcatalog/models"
+)
+
+type RepositoryInterface interface {
+	// WithContext returns a copy of the repository whose operations are
+	// bound to ctx and abort once it is done.
+	WithContext(ctx context.Context) RepositoryInterface
+
+	CreateSubscription(*models.WebhookSubscription) error
+	UpdateSubscription(*models.WebhookSubscription) error
+	// DeleteSubscription removes the subscription and its deliveries.
+	DeleteSubscription(*models.WebhookSubscription) error
+	// GetSubscription returns the subscription with the given id, or nil.
+	GetSubscription(id uint) (*models.WebhookSubscription, error)
+	// ListSubscriptions returns a page of the subscriptions of a user,
+	// oldest first, and their total count.
+	ListSubscriptions(userID uint, offset, limit int) ([]models.WebhookSubscription, int, error)
+	ListActiveSubscriptions() ([]models.WebhookSubscription, error)
+
+	CreateDelivery(*models.WebhookDelivery) error
+	UpdateDelivery(*models.WebhookDelivery) error
+	// GetDelivery returns the delivery with the given id, or nil.
+	GetDelivery(id uint) (*models.WebhookDelivery, error)
+	// ListDeliveries returns a page of the deliveries of a subscription,
+	// newest first, and their total count.
+	ListDeliveries(subscriptionID uint, offset, limit int) ([]models.WebhookDelivery, int, error)
+	// ListDueDeliveries returns up to limit pending deliveries whose next
+	// attempt is due at t, oldest first.
+	ListDueDeliveries(t time.Time, limit int) ([]models.WebhookDelivery, error)
+}